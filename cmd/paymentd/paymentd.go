@@ -8,11 +8,15 @@ import (
 
 	"github.com/fritzpay/paymentd/pkg/config"
 	"github.com/fritzpay/paymentd/pkg/env"
+	"github.com/fritzpay/paymentd/pkg/secret"
 	"github.com/fritzpay/paymentd/pkg/server"
 	"github.com/fritzpay/paymentd/pkg/service"
 	"github.com/fritzpay/paymentd/pkg/service/api"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
 	"github.com/fritzpay/paymentd/pkg/service/web"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
 	"golang.org/x/net/context"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -59,6 +63,9 @@ func main() {
 	log.Info("loading config...")
 	loadConfig()
 
+	log.Info("loading secret master key...")
+	loadKeyring()
+
 	// initialize root context
 	ctx, cancel = context.WithCancel(context.Background())
 	ctx = context.WithValue(ctx, "log", log)
@@ -92,38 +99,70 @@ func main() {
 		os.Exit(1)
 	}
 
-	// API handler
-	if cfg.API.Active {
-		log.Info("enabling API service...")
-		apiHandler, err := api.NewHandler(serviceCtx)
+	// the provider drivers are attached to the web service. The API and the payment
+	// workers use their capabilities for provider intents
+	var providerCapabilities paymentService.ProviderCapabilities
+	if cfg.Web.Active {
+		log.Info("enabling Web service...")
+		webHandler, err := web.NewHandler(serviceCtx)
 		if err != nil {
-			log.Crit("error initializing API service", log15.Ctx{"err": err})
+			log.Crit("error initializing Web service", log15.Ctx{"err": err})
 			log.Info("exiting...")
 			os.Exit(1)
 		}
-		err = srv.RegisterService(cfg.API.Service, apiHandler)
+		err = srv.RegisterService(cfg.Web.Service, webHandler)
 		if err != nil {
-			log.Crit("error registering API service", log15.Ctx{"err": err})
+			log.Crit("error registering Web service", log15.Ctx{"err": err})
+			log.Info("exiting...")
+			os.Exit(1)
+		}
+		providerCapabilities = webHandler.ProviderCapabilities()
+	} else {
+		log.Info("attaching provider drivers...")
+		providerService, err := provider.NewService(serviceCtx)
+		if err != nil {
+			log.Crit("error initializing provider service", log15.Ctx{"err": err})
 			log.Info("exiting...")
 			os.Exit(1)
 		}
+		// the driver endpoints will not be served without the web service
+		err = providerService.AttachDrivers(mux.NewRouter())
+		if err != nil {
+			log.Crit("error attaching provider drivers", log15.Ctx{"err": err})
+			log.Info("exiting...")
+			os.Exit(1)
+		}
+		providerCapabilities = providerService
 	}
-	if cfg.Web.Active {
-		log.Info("enabling Web service...")
-		webHandler, err := web.NewHandler(serviceCtx)
+	// API handler
+	if cfg.API.Active {
+		log.Info("enabling API service...")
+		apiHandler, err := api.NewHandler(serviceCtx, providerCapabilities)
 		if err != nil {
-			log.Crit("error initializing Web service", log15.Ctx{"err": err})
+			log.Crit("error initializing API service", log15.Ctx{"err": err})
 			log.Info("exiting...")
 			os.Exit(1)
 		}
-		err = srv.RegisterService(cfg.Web.Service, webHandler)
+		err = srv.RegisterService(cfg.API.Service, apiHandler)
 		if err != nil {
-			log.Crit("error registering Web service", log15.Ctx{"err": err})
+			log.Crit("error registering API service", log15.Ctx{"err": err})
 			log.Info("exiting...")
 			os.Exit(1)
 		}
 	}
 
+	log.Info("starting payment workers...")
+	workerService, err := paymentService.NewService(serviceCtx)
+	if err != nil {
+		log.Crit("error initializing payment service", log15.Ctx{"err": err})
+		log.Info("exiting...")
+		os.Exit(1)
+	}
+	// expired payments might be synced with the provider and stale provider intents
+	// will be performed again
+	workerService.RegisterProviderCapabilities(providerCapabilities)
+	workerService.StartWorkers()
+
 	log.Info("serving...")
 	err = srv.Serve()
 	if err != nil {
@@ -167,6 +206,20 @@ func loadConfig() {
 	}
 }
 
+func loadKeyring() {
+	keyring, err := secret.KeyringFromConfig(&cfg)
+	if err != nil {
+		log.Crit("error loading secret master key", log15.Ctx{"err": err})
+		log.Info("exiting...")
+		os.Exit(1)
+	}
+	if keyring == nil {
+		log.Warn("no secret master key configured. secrets will be stored unencrypted")
+		return
+	}
+	secret.SetKeyring(keyring)
+}
+
 func connectDB(ctx *service.Context) error {
 	if cfg.Database.Principal.Write == nil {
 		return errors.New("principal write DB config error")
@@ -23,6 +23,8 @@ func main() {
 
 	app.Commands = []cli.Command{
 		configCommand,
+		secretCommand,
+		templateCommand,
 	}
 
 	app.Flags = []cli.Flag{
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/secret"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+const secretCommandDescription = `This command manages the encryption of secrets at rest, i.e.
+provider credentials, project key secrets and the account numbers of SEPA mandates.
+
+To rotate the master key, generate a new key, set it as the Secret.MasterKeyFile and add
+the previous key file to the Secret.PreviousMasterKeyFiles. Then run the rotate command.
+Afterwards the previous key file can be removed from the config.`
+
+var secretCommand = cli.Command{
+	Name:        "secret",
+	Usage:       "Secret encryption related tools.",
+	Description: secretCommandDescription,
+	Subcommands: []cli.Command{
+		generateKeyCommand,
+		rotateSecretsCommand,
+	},
+}
+
+var generateKeyCommand = cli.Command{
+	Name:      "genkey",
+	ShortName: "g",
+	Usage:     "Will write a new random master key to the given output file.",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "Output file to write to.",
+		},
+	},
+	Action: generateKeyAction,
+}
+
+func generateKeyAction(c *cli.Context) {
+	keyFileName := c.String("output")
+	if keyFileName == "" {
+		fmt.Print("no output file name provided\n\n")
+		cli.ShowCommandHelp(c, "g")
+		return
+	}
+	key, err := secret.GenerateKey()
+	if err != nil {
+		fmt.Printf("error generating key: %v\n", err)
+		return
+	}
+	keyFile, err := os.OpenFile(keyFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		fmt.Printf("error opening key file %s for writing: %v\n", keyFileName, err)
+		return
+	}
+	defer keyFile.Close()
+	_, err = fmt.Fprintln(keyFile, hex.EncodeToString(key))
+	if err != nil {
+		fmt.Printf("error writing key file %s: %v\n", keyFileName, err)
+		return
+	}
+	fmt.Printf("key file %s written.\n", keyFileName)
+}
+
+var rotateSecretsCommand = cli.Command{
+	Name:      "rotate",
+	ShortName: "r",
+	Usage:     "Will (re-)encrypt all secrets with the current master key.",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "dry-run, n",
+			Usage: "Only report the number of secrets which would be encrypted.",
+		},
+	},
+	Action: rotateSecretsAction,
+}
+
+// secretColumns describes a table with encrypted columns
+type secretColumns struct {
+	table   string
+	keys    []string
+	columns []string
+}
+
+var paymentSecretColumns = []secretColumns{
+	{
+		table:   "provider_paypal_config",
+		keys:    []string{"project_id", "method_key", "created"},
+		columns: []string{"secret"},
+	},
+	{
+		table:   "provider_stripe_config",
+		keys:    []string{"project_id", "method_key", "created"},
+		columns: []string{"secure_key", "webhook_secret"},
+	},
+	{
+		table:   "provider_sepa_mandate",
+		keys:    []string{"project_id", "payment_id"},
+		columns: []string{"iban"},
+	},
+}
+
+var principalSecretColumns = []secretColumns{
+	{
+		table:   "project_key",
+		keys:    []string{"`key`", "timestamp"},
+		columns: []string{"secret"},
+	},
+}
+
+func rotateSecretsAction(c *cli.Context) {
+	if !readConfig(c) {
+		return
+	}
+	keyring, err := secret.KeyringFromConfig(&cfg)
+	if err != nil {
+		fmt.Printf("error loading master key: %v\n", err)
+		return
+	}
+	if keyring == nil {
+		fmt.Println("error: no master key configured. set Secret.MasterKey or Secret.MasterKeyFile.")
+		return
+	}
+	dryRun := c.Bool("dry-run")
+
+	if !rotateDBSecrets("payment", cfg.Database.Payment.Write, paymentSecretColumns, keyring, dryRun) {
+		return
+	}
+	if !rotateDBSecrets("principal", cfg.Database.Principal.Write, principalSecretColumns, keyring, dryRun) {
+		return
+	}
+	fmt.Println("rotation complete.")
+}
+
+func rotateDBSecrets(name string, dbCfg config.DatabaseConfig, tables []secretColumns, keyring *secret.Keyring, dryRun bool) bool {
+	if dbCfg == nil {
+		fmt.Printf("error: no %s database configured.\n", name)
+		return false
+	}
+	db, err := sql.Open(dbCfg.Type(), dbCfg.DSN())
+	if err != nil {
+		fmt.Printf("error opening %s database: %v\n", name, err)
+		return false
+	}
+	defer db.Close()
+	for _, t := range tables {
+		n, err := rotateTableSecrets(db, t, keyring, dryRun)
+		if err != nil {
+			fmt.Printf("error rotating secrets of %s: %v\n", t.table, err)
+			return false
+		}
+		if dryRun {
+			fmt.Printf("%s: %d secrets need to be encrypted.\n", t.table, n)
+		} else {
+			fmt.Printf("%s: %d secrets encrypted.\n", t.table, n)
+		}
+	}
+	return true
+}
+
+// rotateTableSecrets re-encrypts the secret columns of all rows in the table, which are
+// not encrypted with the current master key
+//
+// The rows will be updated in place. The re-encryption does not change the secrets.
+func rotateTableSecrets(db *sql.DB, t secretColumns, keyring *secret.Keyring, dryRun bool) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
+		}
+	}()
+	query := fmt.Sprintf("SELECT %s, %s FROM %s FOR UPDATE",
+		strings.Join(t.keys, ", "),
+		strings.Join(t.columns, ", "),
+		t.table,
+	)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		keys   []interface{}
+		values []string
+	}
+	var changed []row
+	for rows.Next() {
+		r := row{
+			keys:   make([]interface{}, len(t.keys)),
+			values: make([]string, len(t.columns)),
+		}
+		dest := make([]interface{}, 0, len(t.keys)+len(t.columns))
+		for i := range r.keys {
+			dest = append(dest, &r.keys[i])
+		}
+		for i := range r.values {
+			dest = append(dest, &r.values[i])
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		var rotate bool
+		for i, v := range r.values {
+			if !keyring.NeedsRotation(v) {
+				continue
+			}
+			rotate = true
+			r.values[i], err = keyring.Rotate(v)
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+		}
+		if rotate {
+			changed = append(changed, r)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+	if dryRun {
+		return len(changed), nil
+	}
+
+	set := make([]string, len(t.columns))
+	for i, col := range t.columns {
+		set[i] = col + " = ?"
+	}
+	where := make([]string, len(t.keys))
+	for i, key := range t.keys {
+		where[i] = key + " = ?"
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		t.table,
+		strings.Join(set, ", "),
+		strings.Join(where, " AND "),
+	))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, r := range changed {
+		args := make([]interface{}, 0, len(r.values)+len(r.keys))
+		for _, v := range r.values {
+			args = append(args, v)
+		}
+		args = append(args, r.keys...)
+		_, err = stmt.Exec(args...)
+		if err != nil {
+			return 0, err
+		}
+	}
+	commit = true
+	return len(changed), tx.Commit()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+	"github.com/fritzpay/paymentd/pkg/service/web"
+	tmpl "github.com/fritzpay/paymentd/pkg/template"
+)
+
+const templateCommandDescription = `This command checks the templates of the web service and the
+provider drivers.
+
+Templates are looked up in the locale directories of the Web.TemplateDir and the
+Provider.ProviderTemplateDir, i.e. en_US/. Projects can override single templates in
+projects/<projectID>/<locale>/. Missing templates fall back to the default locale.
+
+The templates of the registered provider drivers are looked up in the directory of
+the Provider.ProviderTemplateDir, which is named like the driver, i.e. stripe/.
+The command exits with a non-zero status if any issues are found.`
+
+var templateCommand = cli.Command{
+	Name:        "template",
+	Usage:       "Template related tools.",
+	Description: templateCommandDescription,
+	Subcommands: []cli.Command{
+		lintTemplatesCommand,
+	},
+}
+
+var lintTemplatesCommand = cli.Command{
+	Name:      "lint",
+	ShortName: "l",
+	Usage:     "Checks that all templates parse and every locale has the required templates.",
+	Action:    lintTemplatesAction,
+}
+
+func lintTemplatesAction(c *cli.Context) {
+	if !readConfig(c) {
+		os.Exit(1)
+	}
+	if cfg.Web.TemplateDir == "" {
+		fmt.Println("error: no template dir configured. set Web.TemplateDir.")
+		os.Exit(1)
+	}
+	if cfg.Provider.ProviderTemplateDir == "" {
+		fmt.Println("error: no provider template dir configured. set Provider.ProviderTemplateDir.")
+		os.Exit(1)
+	}
+	lintIssues, err := web.LintTemplates(cfg.Web.TemplateDir)
+	if err != nil {
+		fmt.Printf("web: error checking templates: %v\n", err)
+		os.Exit(1)
+	}
+	issues := printLintIssues("web", lintIssues)
+	for _, name := range provider.Drivers() {
+		lintIssues, err = provider.LintTemplates(cfg.Provider.ProviderTemplateDir, name)
+		if err == provider.ErrNoTemplates {
+			continue
+		}
+		if err != nil {
+			// drivers of providers which are not used might not have templates installed
+			if os.IsNotExist(err) {
+				fmt.Printf("%s: no templates found. skipping...\n", name)
+				continue
+			}
+			fmt.Printf("%s: error checking templates: %v\n", name, err)
+			os.Exit(1)
+		}
+		issues += printLintIssues(name, lintIssues)
+	}
+	fmt.Printf("\n\ntemplate check complete.\n%d issues.\n", issues)
+	if issues > 0 {
+		os.Exit(1)
+	}
+}
+
+func printLintIssues(name string, lintIssues []tmpl.LintIssue) int {
+	for _, issue := range lintIssues {
+		fmt.Printf("%s: %s\n", name, issue)
+	}
+	return len(lintIssues)
+}
@@ -58,6 +58,22 @@ type Config struct {
 		PaymentIDEncPrime int64
 		// XOR value to be applied to obfuscated primes
 		PaymentIDEncXOR int64
+		// Callback notification config
+		Notification struct {
+			// Number of concurrent delivery workers
+			Workers int
+			// Maximum number of delivery attempts before a notification is considered failed
+			MaxAttempts int
+			// Interval before the first retry. It will be doubled on each subsequent retry
+			RetryInterval Duration
+			// Maximum interval between retries
+			MaxRetryInterval Duration
+			// Interval in which the database is polled for due notifications
+			PollInterval Duration
+		}
+		// Interval in which expired payments will be cancelled. A duration of 0 will
+		// disable the automatic cancellation
+		ExpirySweepInterval Duration
 	}
 	// Database config
 	Database struct {
@@ -86,6 +102,11 @@ type Config struct {
 		Service ServiceConfig
 		// Service timeout
 		Timeout Duration
+		// Maximum accepted difference between the timestamp of a signed request and the
+		// server time. Nonces of signed requests are kept for this duration
+		RequestMaxSkew Duration
+		// Where nonces of signed requests are stored. Either "mysql" or "memory"
+		NonceStore string
 
 		// Should the API server provide administrative endpoints?
 		ServeAdmin bool
@@ -134,6 +155,25 @@ type Config struct {
 		URL string
 
 		ProviderTemplateDir string
+		// SEPA direct debit file exchange
+		SEPA struct {
+			// Directory for the files exchanged with the bank. Batches are written to the
+			// subdirectory "out", return files are read from the subdirectory "in".
+			// An empty value disables the file exchange
+			Dir string
+			// Interval in which batches are exported and return files are imported
+			Interval Duration
+		}
+	}
+	// Encryption of secrets at rest, i.e. provider credentials and project key secrets
+	Secret struct {
+		// Hex encoded 256 bit master key. Takes precedence over the MasterKeyFile
+		MasterKey string
+		// Name of a file containing the hex encoded master key
+		MasterKeyFile string
+		// Files containing previous master keys. They will only be used for decryption
+		// until all secrets are re-encrypted with the current master key
+		PreviousMasterKeyFiles []string
 	}
 }
 
@@ -142,6 +182,12 @@ func DefaultConfig() Config {
 	cfg := Config{}
 	cfg.Payment.PaymentIDEncPrime = 982450871
 	cfg.Payment.PaymentIDEncXOR = 123456789
+	cfg.Payment.Notification.Workers = 2
+	cfg.Payment.Notification.MaxAttempts = 10
+	cfg.Payment.Notification.RetryInterval = Duration("30s")
+	cfg.Payment.Notification.MaxRetryInterval = Duration("6h")
+	cfg.Payment.Notification.PollInterval = Duration("10s")
+	cfg.Payment.ExpirySweepInterval = Duration("1m")
 
 	cfg.Database.TransactionMaxRetries = 5
 	cfg.Database.MaxOpenConns = 10
@@ -160,6 +206,8 @@ func DefaultConfig() Config {
 	cfg.API.Service.ReadTimeout = Duration("10s")
 	cfg.API.Service.WriteTimeout = Duration("10s")
 	cfg.API.Timeout = Duration("5s")
+	cfg.API.RequestMaxSkew = Duration("10s")
+	cfg.API.NonceStore = "mysql"
 	cfg.API.ServeAdmin = false
 	cfg.API.AuthKeys = make([]string, 0)
 
@@ -175,6 +223,7 @@ func DefaultConfig() Config {
 	cfg.Web.Cookie.HTTPOnly = true
 
 	cfg.Provider.URL = "http://localhost:8443"
+	cfg.Provider.SEPA.Interval = Duration("10m")
 
 	return cfg
 }
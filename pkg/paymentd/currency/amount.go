@@ -0,0 +1,33 @@
+package currency
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	// ErrAmountPrecision will be returned if an amount can not be represented with the
+	// requested number of decimal places
+	ErrAmountPrecision = errors.New("amount cannot be represented")
+)
+
+// ScaleAmount converts an amount with the given number of subunits (decimal places)
+// into an amount with the given number of decimal places
+//
+// It will return an ErrAmountPrecision if the amount would have to be rounded or if it
+// overflows.
+func ScaleAmount(amount int64, subunits, decimals int) (int64, error) {
+	for ; subunits < decimals; subunits++ {
+		if amount > math.MaxInt64/10 || amount < math.MinInt64/10 {
+			return 0, ErrAmountPrecision
+		}
+		amount *= 10
+	}
+	for ; subunits > decimals; subunits-- {
+		if amount%10 != 0 {
+			return 0, ErrAmountPrecision
+		}
+		amount /= 10
+	}
+	return amount, nil
+}
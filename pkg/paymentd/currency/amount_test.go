@@ -0,0 +1,46 @@
+package currency
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScaleAmount(t *testing.T) {
+	Convey("Given an amount with 2 subunits", t, func() {
+		Convey("When scaling to the same number of decimal places", func() {
+			Convey("It should not change", func() {
+				a, err := ScaleAmount(1234, 2, 2)
+				So(err, ShouldBeNil)
+				So(a, ShouldEqual, 1234)
+			})
+		})
+		Convey("When scaling to more decimal places", func() {
+			Convey("It should be multiplied", func() {
+				a, err := ScaleAmount(1234, 2, 3)
+				So(err, ShouldBeNil)
+				So(a, ShouldEqual, 12340)
+			})
+		})
+		Convey("When scaling a whole amount to no decimal places", func() {
+			Convey("It should be divided", func() {
+				a, err := ScaleAmount(1200, 2, 0)
+				So(err, ShouldBeNil)
+				So(a, ShouldEqual, 12)
+			})
+		})
+		Convey("When scaling a fractional amount to no decimal places", func() {
+			Convey("It should fail", func() {
+				_, err := ScaleAmount(1234, 2, 0)
+				So(err, ShouldEqual, ErrAmountPrecision)
+			})
+		})
+		Convey("When the scaled amount overflows", func() {
+			Convey("It should fail", func() {
+				_, err := ScaleAmount(math.MaxInt64/2, 2, 4)
+				So(err, ShouldEqual, ErrAmountPrecision)
+			})
+		})
+	})
+}
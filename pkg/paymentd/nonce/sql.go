@@ -0,0 +1,78 @@
+package nonce
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const insertProjectKeyNonce = `
+INSERT INTO project_key_nonce
+(project_key, nonce, expires)
+VALUES
+(?, ?, ?)
+`
+
+// InsertProjectKeyNonceDB records the nonce for the project key
+//
+// If the nonce is already recorded, it will return ErrNonceUsed.
+func InsertProjectKeyNonceDB(db *sql.DB, projectKey, nonce string, expires time.Time) error {
+	stmt, err := db.Prepare(insertProjectKeyNonce)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(projectKey, nonce, expires.UnixNano())
+	stmt.Close()
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		// duplicate entry
+		if mysqlErr.Number == 1062 {
+			return ErrNonceUsed
+		}
+	}
+	return err
+}
+
+const selectProjectKeyNonceExpiry = `
+SELECT expires FROM project_key_nonce WHERE project_key = ? AND nonce = ?
+`
+
+// ProjectKeyNonceExpiryDB returns the expiry of the recorded nonce
+func ProjectKeyNonceExpiryDB(db *sql.DB, projectKey, nonce string) (time.Time, error) {
+	var exp int64
+	err := db.QueryRow(selectProjectKeyNonceExpiry, projectKey, nonce).Scan(&exp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, exp), nil
+}
+
+const deleteProjectKeyNonce = `
+DELETE FROM project_key_nonce WHERE project_key = ? AND nonce = ? AND expires = ?
+`
+
+// DeleteProjectKeyNonceDB removes the recorded nonce with the given expiry
+func DeleteProjectKeyNonceDB(db *sql.DB, projectKey, nonce string, expires time.Time) error {
+	stmt, err := db.Prepare(deleteProjectKeyNonce)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(projectKey, nonce, expires.UnixNano())
+	stmt.Close()
+	return err
+}
+
+const deleteExpiredProjectKeyNonces = `
+DELETE FROM project_key_nonce WHERE expires <= ?
+`
+
+// DeleteExpiredProjectKeyNoncesDB removes all nonces which expired at the given time
+func DeleteExpiredProjectKeyNoncesDB(db *sql.DB, t time.Time) error {
+	stmt, err := db.Prepare(deleteExpiredProjectKeyNonces)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(t.UnixNano())
+	stmt.Close()
+	return err
+}
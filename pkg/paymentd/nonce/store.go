@@ -0,0 +1,144 @@
+package nonce
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxLength is the maximum length of a nonce, which can be stored
+	MaxLength = 64
+)
+
+var (
+	// ErrNonceUsed is returned when a nonce was already used with the project key
+	ErrNonceUsed = errors.New("nonce already used")
+	// ErrNonceInvalid is returned when a nonce is empty or too long
+	ErrNonceInvalid = errors.New("invalid nonce")
+)
+
+// Store records used nonces per project key
+//
+// Nonces are kept until they expire. An expired nonce may be used again.
+type Store interface {
+	// Use records the nonce for the project key. If the nonce was already used and
+	// did not expire yet, it returns ErrNonceUsed.
+	Use(projectKey, nonce string, expires time.Time) error
+}
+
+func validNonce(nonce string) bool {
+	return nonce != "" && len(nonce) <= MaxLength
+}
+
+type memoryKey struct {
+	projectKey string
+	nonce      string
+}
+
+// MemoryStore is an in-memory nonce store
+//
+// It is suitable for single-node setups and tests.
+type MemoryStore struct {
+	m         sync.Mutex
+	nonces    map[memoryKey]time.Time
+	lastPurge time.Time
+	purge     time.Duration
+}
+
+// NewMemoryStore creates a new in-memory nonce store
+//
+// Expired nonces will be removed at most once per purge interval.
+func NewMemoryStore(purge time.Duration) *MemoryStore {
+	return &MemoryStore{
+		nonces:    make(map[memoryKey]time.Time),
+		lastPurge: time.Now(),
+		purge:     purge,
+	}
+}
+
+func (s *MemoryStore) Use(projectKey, nonce string, expires time.Time) error {
+	if !validNonce(nonce) {
+		return ErrNonceInvalid
+	}
+	now := time.Now()
+	k := memoryKey{projectKey: projectKey, nonce: nonce}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if now.Sub(s.lastPurge) >= s.purge {
+		for key, exp := range s.nonces {
+			if !exp.After(now) {
+				delete(s.nonces, key)
+			}
+		}
+		s.lastPurge = now
+	}
+	if exp, ok := s.nonces[k]; ok && exp.After(now) {
+		return ErrNonceUsed
+	}
+	s.nonces[k] = expires
+	return nil
+}
+
+// DBStore is a nonce store backed by the principal database
+type DBStore struct {
+	db *sql.DB
+
+	m         sync.Mutex
+	lastPurge time.Time
+	purge     time.Duration
+}
+
+// NewDBStore creates a new nonce store using the given database
+//
+// Expired nonces will be removed at most once per purge interval.
+func NewDBStore(db *sql.DB, purge time.Duration) *DBStore {
+	return &DBStore{
+		db:        db,
+		lastPurge: time.Now(),
+		purge:     purge,
+	}
+}
+
+func (s *DBStore) Use(projectKey, nonce string, expires time.Time) error {
+	if !validNonce(nonce) {
+		return ErrNonceInvalid
+	}
+	now := time.Now()
+	if s.purgeDue(now) {
+		err := DeleteExpiredProjectKeyNoncesDB(s.db, now)
+		if err != nil {
+			return err
+		}
+	}
+	err := InsertProjectKeyNonceDB(s.db, projectKey, nonce, expires)
+	if err != ErrNonceUsed {
+		return err
+	}
+	// the nonce might be expired, but not yet purged
+	exp, err := ProjectKeyNonceExpiryDB(s.db, projectKey, nonce)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil {
+		if exp.After(now) {
+			return ErrNonceUsed
+		}
+		err = DeleteProjectKeyNonceDB(s.db, projectKey, nonce, exp)
+		if err != nil {
+			return err
+		}
+	}
+	return InsertProjectKeyNonceDB(s.db, projectKey, nonce, expires)
+}
+
+func (s *DBStore) purgeDue(now time.Time) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if now.Sub(s.lastPurge) < s.purge {
+		return false
+	}
+	s.lastPurge = now
+	return true
+}
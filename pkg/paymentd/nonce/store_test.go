@@ -0,0 +1,45 @@
+package nonce_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryStore(t *testing.T) {
+	Convey("Given a memory store", t, func() {
+		s := nonce.NewMemoryStore(time.Minute)
+
+		Convey("When using a nonce", func() {
+			err := s.Use("testkey", "testnonce", time.Now().Add(time.Minute))
+			So(err, ShouldBeNil)
+
+			Convey("Using it again with the same project key should fail", func() {
+				err = s.Use("testkey", "testnonce", time.Now().Add(time.Minute))
+				So(err, ShouldEqual, nonce.ErrNonceUsed)
+			})
+
+			Convey("Using it with a different project key should succeed", func() {
+				err = s.Use("otherkey", "testnonce", time.Now().Add(time.Minute))
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When using an expired nonce", func() {
+			err := s.Use("testkey", "expired", time.Now().Add(-time.Second))
+			So(err, ShouldBeNil)
+
+			Convey("It can be used again", func() {
+				err = s.Use("testkey", "expired", time.Now().Add(time.Minute))
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("Using an empty nonce should fail", func() {
+			err := s.Use("testkey", "", time.Now().Add(time.Minute))
+			So(err, ShouldEqual, nonce.ErrNonceInvalid)
+		})
+	})
+}
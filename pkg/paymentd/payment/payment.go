@@ -92,6 +92,12 @@ func (p *Payment) HasTransaction() bool {
 	return true
 }
 
+// Expired returns true if the payment has an expiry time which is not after the given
+// time
+func (p *Payment) Expired(t time.Time) bool {
+	return p.Config.Expires != nil && !p.Config.Expires.After(t)
+}
+
 // NewTransaction creates a new payment transaction for this payment
 //
 // Its transaction fields will be populated with the copied values from the payment
@@ -0,0 +1,98 @@
+package payment
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// PaymentNotificationStatus is the delivery status of a callback notification
+type PaymentNotificationStatus string
+
+// Scan implements the Scanner interface for sql
+func (s *PaymentNotificationStatus) Scan(v interface{}) error {
+	switch src := v.(type) {
+	case []byte:
+		*s = PaymentNotificationStatus(string(src))
+		return nil
+	case string:
+		*s = PaymentNotificationStatus(src)
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into %T", v, s)
+}
+
+// Value implements the Valuer interface for sql
+func (s PaymentNotificationStatus) Value() (driver.Value, error) {
+	return driver.Value(string(s)), nil
+}
+
+func (s PaymentNotificationStatus) String() string {
+	return string(s)
+}
+
+const (
+	// the notification is waiting for (re-)delivery
+	PaymentNotificationStatusPending PaymentNotificationStatus = "pending"
+	// the notification was acknowledged by the callback receiver
+	PaymentNotificationStatusDelivered = "delivered"
+	// the notification could not be delivered within the maximum number of attempts
+	PaymentNotificationStatusFailed = "failed"
+)
+
+// PaymentNotification represents the delivery state of a callback notification for
+// a payment transaction
+//
+// Changes in the delivery state will be stored as new entries. The entry with the
+// latest Timestamp is the current state.
+type PaymentNotification struct {
+	PaymentID            PaymentID
+	TransactionTimestamp time.Time
+	Timestamp            time.Time
+
+	Status      PaymentNotificationStatus
+	Attempts    int
+	NextAttempt time.Time
+	Error       sql.NullString
+}
+
+// NewPaymentNotification creates a pending notification for the given payment transaction
+func NewPaymentNotification(paymentTx *PaymentTransaction) *PaymentNotification {
+	return &PaymentNotification{
+		PaymentID:            paymentTx.Payment.PaymentID(),
+		TransactionTimestamp: paymentTx.Timestamp,
+		Timestamp:            time.Now(),
+		Status:               PaymentNotificationStatusPending,
+		NextAttempt:          time.Now(),
+	}
+}
+
+// SetError sets the error of the last delivery attempt
+func (n *PaymentNotification) SetError(err string) {
+	n.Error.String, n.Error.Valid = err, err != ""
+}
+
+// PaymentNotificationAttempt represents a single delivery attempt of a callback
+// notification
+type PaymentNotificationAttempt struct {
+	PaymentID            PaymentID
+	TransactionTimestamp time.Time
+	Timestamp            time.Time
+
+	URL            string
+	APIVersion     string
+	HTTPStatusCode sql.NullInt64
+	Latency        time.Duration
+	ResponseBody   sql.NullString
+	Error          sql.NullString
+}
+
+// SetError sets the error of the delivery attempt
+func (a *PaymentNotificationAttempt) SetError(err error) {
+	if err == nil {
+		a.Error.String, a.Error.Valid = "", false
+		return
+	}
+	a.Error.String, a.Error.Valid = err.Error(), true
+}
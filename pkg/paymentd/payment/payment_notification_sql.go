@@ -0,0 +1,292 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrPaymentNotificationNotFound = errors.New("payment notification not found")
+)
+
+const insertPaymentNotification = `
+INSERT INTO payment_notification
+(project_id, payment_id, payment_tx_timestamp, timestamp, status, attempts, next_attempt, error)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+func doInsertPaymentNotification(stmt *sql.Stmt, n *PaymentNotification) error {
+	_, err := stmt.Exec(
+		n.PaymentID.ProjectID,
+		n.PaymentID.PaymentID,
+		n.TransactionTimestamp.UnixNano(),
+		n.Timestamp.UnixNano(),
+		n.Status,
+		n.Attempts,
+		n.NextAttempt.UnixNano(),
+		n.Error,
+	)
+	stmt.Close()
+	return err
+}
+
+func InsertPaymentNotificationTx(db *sql.Tx, n *PaymentNotification) error {
+	stmt, err := db.Prepare(insertPaymentNotification)
+	if err != nil {
+		return err
+	}
+	return doInsertPaymentNotification(stmt, n)
+}
+
+func InsertPaymentNotificationDB(db *sql.DB, n *PaymentNotification) error {
+	stmt, err := db.Prepare(insertPaymentNotification)
+	if err != nil {
+		return err
+	}
+	return doInsertPaymentNotification(stmt, n)
+}
+
+const selectPaymentNotification = `
+SELECT
+	n.project_id,
+	n.payment_id,
+	n.payment_tx_timestamp,
+	n.timestamp,
+	n.status,
+	n.attempts,
+	n.next_attempt,
+	n.error
+FROM payment_notification AS n
+`
+
+const currentPaymentNotification = `
+	n.timestamp = (
+		SELECT MAX(timestamp) FROM payment_notification
+		WHERE
+			project_id = n.project_id
+			AND
+			payment_id = n.payment_id
+			AND
+			payment_tx_timestamp = n.payment_tx_timestamp
+	)
+`
+
+const selectPaymentNotificationsDue = selectPaymentNotification + `
+WHERE
+	n.status = ?
+	AND
+	n.next_attempt <= ?
+	AND
+` + currentPaymentNotification + `
+ORDER BY n.next_attempt ASC
+LIMIT ?
+`
+
+const selectCurrentPaymentNotification = selectPaymentNotification + `
+WHERE
+	n.project_id = ?
+	AND
+	n.payment_id = ?
+	AND
+	n.payment_tx_timestamp = ?
+	AND
+` + currentPaymentNotification
+
+func scanPaymentNotification(r resultScanner, n *PaymentNotification) error {
+	var txTs, ts, next int64
+	err := r.Scan(
+		&n.PaymentID.ProjectID,
+		&n.PaymentID.PaymentID,
+		&txTs,
+		&ts,
+		&n.Status,
+		&n.Attempts,
+		&next,
+		&n.Error,
+	)
+	if err != nil {
+		return err
+	}
+	n.TransactionTimestamp = time.Unix(0, txTs)
+	n.Timestamp = time.Unix(0, ts)
+	n.NextAttempt = time.Unix(0, next)
+	return nil
+}
+
+// PaymentNotificationsDueDB returns up to limit pending notifications, which are due for
+// delivery at the given time
+//
+// The returned list will be sorted by the earliest next attempt first.
+func PaymentNotificationsDueDB(db *sql.DB, t time.Time, limit int) ([]*PaymentNotification, error) {
+	rows, err := db.Query(selectPaymentNotificationsDue, PaymentNotificationStatusPending, t.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	ns := make([]*PaymentNotification, 0, limit)
+	for rows.Next() {
+		n := &PaymentNotification{}
+		err = scanPaymentNotification(rows, n)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// PaymentNotificationCurrentForUpdateTx returns the current state of the notification
+// for the given payment transaction
+//
+// The payment will be locked for the remainder of the transaction, so concurrent
+// delivery workers will be serialized.
+func PaymentNotificationCurrentForUpdateTx(db *sql.Tx, id PaymentID, transactionTimestamp time.Time) (*PaymentNotification, error) {
+	err := LockPaymentTx(db, id)
+	if err != nil {
+		return nil, err
+	}
+	n := &PaymentNotification{}
+	row := db.QueryRow(selectCurrentPaymentNotification+"FOR UPDATE", id.ProjectID, id.PaymentID, transactionTimestamp.UnixNano())
+	err = scanPaymentNotification(row, n)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPaymentNotificationNotFound
+		}
+		return nil, err
+	}
+	return n, nil
+}
+
+const selectPaymentNotifications = selectPaymentNotification + `
+WHERE
+	n.project_id = ?
+	AND
+	n.payment_id = ?
+	AND
+` + currentPaymentNotification + `
+ORDER BY n.payment_tx_timestamp ASC
+`
+
+// PaymentNotificationsDB returns the current state of all notifications of the given
+// payment
+//
+// The list will be sorted by the earliest payment transaction first.
+func PaymentNotificationsDB(db *sql.DB, id PaymentID) ([]*PaymentNotification, error) {
+	rows, err := db.Query(selectPaymentNotifications, id.ProjectID, id.PaymentID)
+	if err != nil {
+		return nil, err
+	}
+	ns := make([]*PaymentNotification, 0, 8)
+	for rows.Next() {
+		n := &PaymentNotification{}
+		err = scanPaymentNotification(rows, n)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+const insertPaymentNotificationAttempt = `
+INSERT INTO payment_notification_attempt
+(project_id, payment_id, payment_tx_timestamp, timestamp, url, api_version, http_status_code, latency, response_body, error)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+func InsertPaymentNotificationAttemptDB(db *sql.DB, a *PaymentNotificationAttempt) error {
+	stmt, err := db.Prepare(insertPaymentNotificationAttempt)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		a.PaymentID.ProjectID,
+		a.PaymentID.PaymentID,
+		a.TransactionTimestamp.UnixNano(),
+		a.Timestamp.UnixNano(),
+		a.URL,
+		a.APIVersion,
+		a.HTTPStatusCode,
+		int64(a.Latency),
+		a.ResponseBody,
+		a.Error,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentNotificationAttempts = `
+SELECT
+	a.project_id,
+	a.payment_id,
+	a.payment_tx_timestamp,
+	a.timestamp,
+	a.url,
+	a.api_version,
+	a.http_status_code,
+	a.latency,
+	a.response_body,
+	a.error
+FROM payment_notification_attempt AS a
+WHERE
+	a.project_id = ?
+	AND
+	a.payment_id = ?
+ORDER BY a.timestamp ASC
+`
+
+// PaymentNotificationAttemptsDB returns all notification delivery attempts for the
+// given payment
+//
+// The list will be sorted by the earliest attempt first.
+func PaymentNotificationAttemptsDB(db *sql.DB, id PaymentID) ([]*PaymentNotificationAttempt, error) {
+	rows, err := db.Query(selectPaymentNotificationAttempts, id.ProjectID, id.PaymentID)
+	if err != nil {
+		return nil, err
+	}
+	as := make([]*PaymentNotificationAttempt, 0, 8)
+	var txTs, ts, latency int64
+	for rows.Next() {
+		a := &PaymentNotificationAttempt{}
+		err = rows.Scan(
+			&a.PaymentID.ProjectID,
+			&a.PaymentID.PaymentID,
+			&txTs,
+			&ts,
+			&a.URL,
+			&a.APIVersion,
+			&a.HTTPStatusCode,
+			&latency,
+			&a.ResponseBody,
+			&a.Error,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		a.TransactionTimestamp = time.Unix(0, txTs)
+		a.Timestamp = time.Unix(0, ts)
+		a.Latency = time.Duration(latency)
+		as = append(as, a)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return as, nil
+}
@@ -0,0 +1,131 @@
+package payment
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// PaymentProviderIntentStatus is the status of an intent performed by the payment provider
+type PaymentProviderIntentStatus string
+
+// Scan implements the Scanner interface for sql
+func (s *PaymentProviderIntentStatus) Scan(v interface{}) error {
+	switch src := v.(type) {
+	case []byte:
+		*s = PaymentProviderIntentStatus(string(src))
+		return nil
+	case string:
+		*s = PaymentProviderIntentStatus(src)
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into %T", v, s)
+}
+
+// Value implements the Valuer interface for sql
+func (s PaymentProviderIntentStatus) Value() (driver.Value, error) {
+	return driver.Value(string(s)), nil
+}
+
+func (s PaymentProviderIntentStatus) String() string {
+	return string(s)
+}
+
+const (
+	// the intent was recorded and the provider might be performing it
+	PaymentProviderIntentStatusPending PaymentProviderIntentStatus = "pending"
+	// the provider performed the intent
+	PaymentProviderIntentStatusDone = "done"
+	// the provider rejected the intent
+	PaymentProviderIntentStatusFailed = "failed"
+)
+
+// PaymentProviderIntent represents an intent which has to be performed by the payment
+// provider, i.e. a refund, capture or void
+//
+// It will be recorded as pending before the provider is requested, so concurrent intents
+// see the pending amount and the provider request can be identified by its idempotency
+// key. Changes in the status will be stored as new entries. The entry with the latest
+// Timestamp is the current state.
+type PaymentProviderIntent struct {
+	PaymentID PaymentID
+	Created   time.Time
+	Timestamp time.Time
+
+	// Intent is the status of the payment transaction, which will be set once the
+	// provider performed the intent
+	Intent PaymentTransactionStatus
+	Amount int64
+	Status PaymentProviderIntentStatus
+	Error  sql.NullString
+}
+
+// NewPaymentProviderIntent creates a pending provider intent for the given payment
+// transaction
+func NewPaymentProviderIntent(paymentTx *PaymentTransaction) *PaymentProviderIntent {
+	now := time.Now()
+	return &PaymentProviderIntent{
+		PaymentID: paymentTx.Payment.PaymentID(),
+		Created:   now,
+		Timestamp: now,
+		Intent:    paymentTx.Status,
+		Amount:    paymentTx.Amount,
+		Status:    PaymentProviderIntentStatusPending,
+	}
+}
+
+// IdempotencyKey returns the key which identifies the provider request of the intent
+//
+// Providers will perform requests with the same key only once.
+func (i *PaymentProviderIntent) IdempotencyKey() string {
+	return fmt.Sprintf("paymentd-%d-%d-%d", i.PaymentID.ProjectID, i.PaymentID.PaymentID, i.Created.UnixNano())
+}
+
+// WithStatus returns a new entry of the intent with the given status
+func (i *PaymentProviderIntent) WithStatus(s PaymentProviderIntentStatus, err string) *PaymentProviderIntent {
+	n := *i
+	n.Timestamp = time.Now()
+	n.Status = s
+	n.Error.String, n.Error.Valid = err, err != ""
+	return &n
+}
+
+// PaymentProviderIntentList is a list of provider intents of a payment
+type PaymentProviderIntentList []*PaymentProviderIntent
+
+// Amount returns the total amount of the intents resulting in the given payment
+// transaction status
+func (l PaymentProviderIntentList) Amount(intent PaymentTransactionStatus) int64 {
+	var amount int64
+	for _, i := range l {
+		if i.Intent == intent {
+			amount += i.Amount
+		}
+	}
+	return amount
+}
+
+// Pending returns the pending intent of the list, which was created at the same time as
+// the given intent
+//
+// The second return value will be false if the intent is not pending.
+func (l PaymentProviderIntentList) Pending(intent *PaymentProviderIntent) (*PaymentProviderIntent, bool) {
+	for _, i := range l {
+		if i.Created.Equal(intent.Created) && i.Status == PaymentProviderIntentStatusPending {
+			return i, true
+		}
+	}
+	return nil, false
+}
+
+// Has returns true if the list contains an intent resulting in the given payment
+// transaction status
+func (l PaymentProviderIntentList) Has(intent PaymentTransactionStatus) bool {
+	for _, i := range l {
+		if i.Intent == intent {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+)
+
+const insertPaymentProviderIntent = `
+INSERT INTO payment_provider_intent
+(project_id, payment_id, created, timestamp, intent, amount, status, error)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+func InsertPaymentProviderIntentTx(db *sql.Tx, i *PaymentProviderIntent) error {
+	stmt, err := db.Prepare(insertPaymentProviderIntent)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		i.PaymentID.ProjectID,
+		i.PaymentID.PaymentID,
+		i.Created.UnixNano(),
+		i.Timestamp.UnixNano(),
+		i.Intent,
+		i.Amount,
+		i.Status,
+		i.Error,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentProviderIntentsPending = `
+SELECT
+	i.project_id,
+	i.payment_id,
+	i.created,
+	i.timestamp,
+	i.intent,
+	i.amount,
+	i.status,
+	i.error
+FROM payment_provider_intent AS i
+WHERE
+	i.project_id = ?
+	AND
+	i.payment_id = ?
+	AND
+	i.status = ?
+	AND
+	i.timestamp = (
+		SELECT MAX(timestamp) FROM payment_provider_intent
+		WHERE
+			project_id = i.project_id
+			AND
+			payment_id = i.payment_id
+			AND
+			created = i.created
+	)
+ORDER BY i.created ASC
+`
+
+// PaymentProviderIntentsPendingTx returns the provider intents of the given payment,
+// which are currently pending
+//
+// The list will be sorted by the earliest intent first.
+func PaymentProviderIntentsPendingTx(db *sql.Tx, id PaymentID) (PaymentProviderIntentList, error) {
+	rows, err := db.Query(selectPaymentProviderIntentsPending, id.ProjectID, id.PaymentID, PaymentProviderIntentStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentProviderIntents(rows, 2)
+}
+
+const selectPaymentProviderIntentsStale = `
+SELECT
+	i.project_id,
+	i.payment_id,
+	i.created,
+	i.timestamp,
+	i.intent,
+	i.amount,
+	i.status,
+	i.error
+FROM payment_provider_intent AS i
+WHERE
+	i.status = ?
+	AND
+	i.created < ?
+	AND
+	i.timestamp = (
+		SELECT MAX(timestamp) FROM payment_provider_intent
+		WHERE
+			project_id = i.project_id
+			AND
+			payment_id = i.payment_id
+			AND
+			created = i.created
+	)
+ORDER BY i.created DESC
+LIMIT ?
+`
+
+// PaymentProviderIntentsStaleDB returns up to limit provider intents, which are still
+// pending and were created before the given time
+//
+// The list will be sorted by the latest intent first.
+func PaymentProviderIntentsStaleDB(db *sql.DB, t time.Time, limit int) (PaymentProviderIntentList, error) {
+	rows, err := db.Query(selectPaymentProviderIntentsStale, PaymentProviderIntentStatusPending, t.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentProviderIntents(rows, limit)
+}
+
+func scanPaymentProviderIntents(rows *sql.Rows, size int) (PaymentProviderIntentList, error) {
+	l := make(PaymentProviderIntentList, 0, size)
+	var created, ts int64
+	var err error
+	for rows.Next() {
+		i := &PaymentProviderIntent{}
+		err = rows.Scan(
+			&i.PaymentID.ProjectID,
+			&i.PaymentID.PaymentID,
+			&created,
+			&ts,
+			&i.Intent,
+			&i.Amount,
+			&i.Status,
+			&i.Error,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		i.Created = time.Unix(0, created)
+		i.Timestamp = time.Unix(0, ts)
+		l = append(l, i)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
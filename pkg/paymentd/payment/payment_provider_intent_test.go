@@ -0,0 +1,44 @@
+package payment_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPaymentProviderIntentList(t *testing.T) {
+	Convey("Given a list of pending provider intents", t, func() {
+		created := time.Unix(0, 1420070400000000000)
+		refund := &payment.PaymentProviderIntent{
+			Created: created,
+			Intent:  payment.PaymentStatusRefunded,
+			Amount:  100,
+			Status:  payment.PaymentProviderIntentStatusPending,
+		}
+		l := payment.PaymentProviderIntentList{refund}
+
+		Convey("When looking up the pending intent", func() {
+			i, ok := l.Pending(&payment.PaymentProviderIntent{Created: created})
+
+			Convey("It should be found by its created time", func() {
+				So(ok, ShouldBeTrue)
+				So(i, ShouldEqual, refund)
+			})
+		})
+		Convey("When looking up a completed intent", func() {
+			_, ok := l.Pending(&payment.PaymentProviderIntent{Created: created.Add(time.Nanosecond)})
+
+			Convey("It should not be found", func() {
+				So(ok, ShouldBeFalse)
+			})
+		})
+		Convey("When summing up the amounts", func() {
+			Convey("It should only count the given intent", func() {
+				So(l.Amount(payment.PaymentStatusRefunded), ShouldEqual, 100)
+				So(l.Amount(payment.PaymentStatusPaid), ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,147 @@
+package payment
+
+import (
+	"bytes"
+	"database/sql"
+	"time"
+)
+
+const (
+	// SearchLimitDefault is the default number of payments returned by a search
+	SearchLimitDefault = 50
+	// SearchLimitMax is the maximum number of payments returned by a search
+	SearchLimitMax = 500
+)
+
+// SearchFilter represents the criteria of a payment search
+//
+// Zero values will not be used as criteria. The results are ordered by the most recent
+// payment first.
+type SearchFilter struct {
+	ProjectID int64
+
+	Status          PaymentTransactionStatus
+	Currency        string
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+	PaymentMethodID int64
+	Country         string
+	MetadataKey     string
+	MetadataValue   string
+
+	// Cursor is the (decoded) id of the last payment of the previous page
+	Cursor int64
+	Limit  int
+}
+
+// metadata filter; only the current metadata will be matched
+const searchPaymentMetadata = `
+	EXISTS (
+		SELECT 1 FROM payment_metadata AS m
+		WHERE
+			m.project_id = p.project_id
+			AND
+			m.payment_id = p.id
+			AND
+			m.name = ?
+			AND
+			m.value = ?
+			AND
+			m.timestamp = (
+				SELECT MAX(timestamp) FROM payment_metadata
+				WHERE
+					project_id = m.project_id
+					AND
+					payment_id = m.payment_id
+			)
+	)
+`
+
+func (f *SearchFilter) query() (string, []interface{}) {
+	buf := bytes.NewBufferString(selectPayment)
+	buf.WriteString(`
+WHERE
+	p.project_id = ?
+`)
+	args := []interface{}{f.ProjectID}
+	cond := func(c string, arg ...interface{}) {
+		buf.WriteString("\tAND\n\t")
+		buf.WriteString(c)
+		buf.WriteString("\n")
+		args = append(args, arg...)
+	}
+	if f.Status == PaymentStatusNone {
+		cond("tx.status IS NULL")
+	} else if f.Status.Valid() {
+		cond("tx.status = ?", f.Status)
+	}
+	if f.Currency != "" {
+		cond("p.currency = ?", f.Currency)
+	}
+	if !f.CreatedFrom.IsZero() {
+		cond("p.created >= ?", f.CreatedFrom)
+	}
+	if !f.CreatedTo.IsZero() {
+		cond("p.created < ?", f.CreatedTo)
+	}
+	if f.PaymentMethodID != 0 {
+		cond("c.payment_method_id = ?", f.PaymentMethodID)
+	}
+	if f.Country != "" {
+		cond("c.country = ?", f.Country)
+	}
+	if f.MetadataKey != "" {
+		cond(searchPaymentMetadata, f.MetadataKey, f.MetadataValue)
+	}
+	if f.Cursor != 0 {
+		cond("p.id < ?", f.Cursor)
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = SearchLimitDefault
+	}
+	if limit > SearchLimitMax {
+		limit = SearchLimitMax
+	}
+	buf.WriteString("ORDER BY p.id DESC\nLIMIT ?\n")
+	args = append(args, limit)
+	return buf.String(), args
+}
+
+func scanSearchRows(rows *sql.Rows) ([]*Payment, error) {
+	ps := make([]*Payment, 0, SearchLimitDefault)
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	err := rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// PaymentsBySearchFilterTx returns the payments matching the search filter
+func PaymentsBySearchFilterTx(db *sql.Tx, f *SearchFilter) ([]*Payment, error) {
+	query, args := f.query()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSearchRows(rows)
+}
+
+// PaymentsBySearchFilterDB returns the payments matching the search filter
+func PaymentsBySearchFilterDB(db *sql.DB, f *SearchFilter) ([]*Payment, error) {
+	query, args := f.query()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSearchRows(rows)
+}
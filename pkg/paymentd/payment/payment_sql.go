@@ -104,7 +104,88 @@ WHERE
 	p.ident = ?
 `
 
-func scanSingleRow(row *sql.Row) (*Payment, error) {
+const lockPayment = `
+SELECT id FROM payment WHERE project_id = ? AND id = ? FOR UPDATE
+`
+
+// LockPaymentTx locks the payment with the given id for the remainder of the transaction
+func LockPaymentTx(db *sql.Tx, id PaymentID) error {
+	var lockedID int64
+	err := db.QueryRow(lockPayment, id.ProjectID, id.PaymentID).Scan(&lockedID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrPaymentNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+const selectExpiredPaymentIDs = `
+SELECT
+	p.project_id,
+	p.id
+FROM payment AS p
+INNER JOIN payment_config AS c ON
+	c.project_id = p.project_id
+	AND
+	c.payment_id = p.id
+	AND
+	c.timestamp = (
+		SELECT MAX(timestamp) FROM payment_config
+		WHERE
+			project_id = c.project_id
+			AND
+			payment_id = c.payment_id
+	)
+INNER JOIN payment_transaction AS tx ON
+	tx.project_id = p.project_id
+	AND
+	tx.payment_id = p.id
+	AND
+	tx.timestamp = (
+		SELECT MAX(timestamp) FROM payment_transaction
+		WHERE
+			project_id = tx.project_id
+			AND
+			payment_id = tx.payment_id
+	)
+WHERE
+	c.expires IS NOT NULL
+	AND
+	c.expires <= ?
+	AND
+	tx.status = ?
+ORDER BY c.expires ASC
+LIMIT ?
+`
+
+// ExpiredPaymentIDsDB returns up to limit ids of payments in the given status, which
+// expired at the given time
+func ExpiredPaymentIDsDB(db *sql.DB, t time.Time, status PaymentTransactionStatus, limit int) ([]PaymentID, error) {
+	rows, err := db.Query(selectExpiredPaymentIDs, t, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]PaymentID, 0, limit)
+	for rows.Next() {
+		var id PaymentID
+		err = rows.Scan(&id.ProjectID, &id.PaymentID)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func scanPayment(row resultScanner) (*Payment, error) {
 	p := &Payment{}
 	var ts, txTs sql.NullInt64
 	err := row.Scan(
@@ -144,22 +225,22 @@ func scanSingleRow(row *sql.Row) (*Payment, error) {
 
 func PaymentByIDTx(db *sql.Tx, id PaymentID) (*Payment, error) {
 	row := db.QueryRow(selectPaymentByProjectIDAndID, id.ProjectID, id.PaymentID)
-	return scanSingleRow(row)
+	return scanPayment(row)
 }
 
 func PaymentByIDDB(db *sql.DB, id PaymentID) (*Payment, error) {
 	row := db.QueryRow(selectPaymentByProjectIDAndID, id.ProjectID, id.PaymentID)
-	return scanSingleRow(row)
+	return scanPayment(row)
 }
 
 func PaymentByProjectIDAndIdentDB(db *sql.DB, projectID int64, ident string) (*Payment, error) {
 	row := db.QueryRow(selectPaymentByProjectIDAndIdent, projectID, ident)
-	return scanSingleRow(row)
+	return scanPayment(row)
 }
 
 func PaymentByProjectIDAndIdentTx(db *sql.Tx, projectID int64, ident string) (*Payment, error) {
 	row := db.QueryRow(selectPaymentByProjectIDAndIdent, projectID, ident)
-	return scanSingleRow(row)
+	return scanPayment(row)
 }
 
 const insertPaymentConfig = `
@@ -165,6 +165,128 @@ func TestTransactionListBalance(t *testing.T) {
 	})
 }
 
+func TestTransactionListRefunded(t *testing.T) {
+	Convey("Given a transaction list of a paid payment", t, func() {
+		tl := payment.PaymentTransactionList([]*payment.PaymentTransaction{
+			&payment.PaymentTransaction{
+				Amount: -1234,
+				Status: payment.PaymentStatusOpen,
+			},
+			&payment.PaymentTransaction{
+				Amount: 1234,
+				Status: payment.PaymentStatusPaid,
+			},
+		})
+
+		Convey("When retrieving the paid amount", func() {
+			Convey("It should sum the paid transactions", func() {
+				So(tl.Paid(), ShouldEqual, 1234)
+			})
+		})
+
+		Convey("When retrieving the refunded amount", func() {
+			Convey("It should be zero", func() {
+				So(tl.Refunded(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When there are partial refunds", func() {
+			tl = append(tl, &payment.PaymentTransaction{
+				Amount: 200,
+				Status: payment.PaymentStatusRefunded,
+			}, &payment.PaymentTransaction{
+				Amount: 34,
+				Status: payment.PaymentStatusRefunded,
+			})
+
+			Convey("It should sum the refunds", func() {
+				So(tl.Refunded(), ShouldEqual, 234)
+			})
+
+			Convey("When a refund is reversed", func() {
+				tl = append(tl, &payment.PaymentTransaction{
+					Amount: -34,
+					Status: payment.PaymentStatusRefundReversed,
+				})
+
+				Convey("It should subtract the reversal", func() {
+					So(tl.Refunded(), ShouldEqual, 200)
+				})
+			})
+
+			Convey("When the rest is charged back", func() {
+				tl = append(tl, &payment.PaymentTransaction{
+					Amount: 1000,
+					Status: payment.PaymentStatusChargeback,
+				})
+
+				Convey("It should sum the chargebacks", func() {
+					So(tl.ChargedBack(), ShouldEqual, 1000)
+					So(tl.Refunded(), ShouldEqual, 234)
+				})
+			})
+		})
+	})
+}
+
+func TestPaymentStatusTransition(t *testing.T) {
+	Convey("Given an uninitialized payment status", t, func() {
+		from := payment.PaymentStatusNone
+
+		Convey("When opening the payment", func() {
+			err := payment.ValidateTransition(from, payment.PaymentStatusOpen)
+			Convey("It should be allowed", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+		Convey("When setting the payment paid", func() {
+			err := payment.ValidateTransition(from, payment.PaymentStatusPaid)
+			Convey("It should return a transition error", func() {
+				So(err, ShouldNotBeNil)
+				transErr, ok := err.(*payment.TransitionError)
+				So(ok, ShouldBeTrue)
+				So(transErr.From, ShouldEqual, payment.PaymentStatusNone)
+				So(transErr.To, ShouldEqual, payment.PaymentStatusPaid)
+				Convey("The error should name the transition", func() {
+					So(err.Error(), ShouldContainSubstring, "uninitialized")
+					So(err.Error(), ShouldContainSubstring, "paid")
+				})
+			})
+		})
+	})
+	Convey("Given an empty payment status", t, func() {
+		var from payment.PaymentTransactionStatus
+
+		Convey("It should be treated as uninitialized", func() {
+			So(payment.ValidateTransition(from, payment.PaymentStatusOpen), ShouldBeNil)
+		})
+	})
+	Convey("Given a refunded payment status", t, func() {
+		var from payment.PaymentTransactionStatus = payment.PaymentStatusRefunded
+
+		Convey("When refunding again", func() {
+			err := payment.ValidateTransition(from, payment.PaymentStatusRefunded)
+			Convey("It should be allowed", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+		Convey("When setting the payment open", func() {
+			err := payment.ValidateTransition(from, payment.PaymentStatusOpen)
+			Convey("It should not be allowed", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+	Convey("Given a cancelled payment status", t, func() {
+		var from payment.PaymentTransactionStatus = payment.PaymentStatusCancelled
+
+		Convey("It should be final", func() {
+			So(from.IsFinal(), ShouldBeTrue)
+			So(payment.ValidateTransition(from, payment.PaymentStatusPaid), ShouldNotBeNil)
+		})
+	})
+}
+
 func TestPaymentSQL(t *testing.T) {
 	Convey("Given a payment DB", t, testutil.WithPaymentDB(t, func(db *sql.DB) {
 		Reset(func() {
@@ -335,3 +457,76 @@ func TestPaymentMetadata(t *testing.T) {
 		}))
 	}))
 }
+
+func TestPaymentSearch(t *testing.T) {
+	Convey("Given a payment DB", t, testutil.WithPaymentDB(t, func(db *sql.DB) {
+		Reset(func() {
+			db.Close()
+		})
+		Convey("Given a principal DB", testutil.WithPrincipalDB(t, func(prDB *sql.DB) {
+			Reset(func() {
+				prDB.Close()
+			})
+			Convey("Given a test project", WithTestProject(db, prDB, func(proj *project.Project) {
+				Convey("Given a transaction", func() {
+					tx, err := db.Begin()
+					So(err, ShouldBeNil)
+
+					Reset(func() {
+						err = tx.Rollback()
+						So(err, ShouldBeNil)
+					})
+
+					Convey("Given a test payment with metadata", WithTestPayment(tx, proj, func(p *payment.Payment) {
+						p.Metadata = map[string]string{"customer": "1234"}
+						err = payment.InsertPaymentMetadataTx(tx, p)
+						So(err, ShouldBeNil)
+
+						Convey("When searching by metadata", func() {
+							ps, err := payment.PaymentsBySearchFilterTx(tx, &payment.SearchFilter{
+								ProjectID:     proj.ID,
+								MetadataKey:   "customer",
+								MetadataValue: "1234",
+							})
+							So(err, ShouldBeNil)
+
+							Convey("It should return the payment", func() {
+								So(len(ps), ShouldEqual, 1)
+								So(ps[0].ID(), ShouldEqual, p.ID())
+							})
+						})
+
+						Convey("When searching by a different currency", func() {
+							ps, err := payment.PaymentsBySearchFilterTx(tx, &payment.SearchFilter{
+								ProjectID: proj.ID,
+								Currency:  "USD",
+								Cursor:    p.ID() + 1,
+							})
+							So(err, ShouldBeNil)
+
+							Convey("It should not return the payment", func() {
+								for _, found := range ps {
+									So(found.ID(), ShouldNotEqual, p.ID())
+								}
+							})
+						})
+
+						Convey("When searching with the payment as the cursor", func() {
+							ps, err := payment.PaymentsBySearchFilterTx(tx, &payment.SearchFilter{
+								ProjectID: proj.ID,
+								Cursor:    p.ID(),
+							})
+							So(err, ShouldBeNil)
+
+							Convey("It should only return older payments", func() {
+								for _, found := range ps {
+									So(found.ID(), ShouldBeLessThan, p.ID())
+								}
+							})
+						})
+					}))
+				})
+			}))
+		}))
+	}))
+}
@@ -78,7 +78,7 @@ WHERE
 
 func PaymentByTokenTx(db *sql.Tx, token string, tokenMaxAge time.Duration) (*Payment, error) {
 	row := db.QueryRow(selectPaymentByToken, token, time.Now().Add(tokenMaxAge*-1))
-	return scanSingleRow(row)
+	return scanPayment(row)
 }
 
 const deletePaymentToken = `
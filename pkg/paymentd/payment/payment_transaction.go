@@ -59,6 +59,27 @@ const (
 	PaymentStatusRefundReversed                          = "refund-reversed"
 )
 
+// Known returns true if the status is one of the defined payment statuses
+func (s PaymentTransactionStatus) Known() bool {
+	switch s {
+	case PaymentStatusNone,
+		PaymentStatusOpen,
+		PaymentStatusPending,
+		PaymentStatusPaid,
+		PaymentStatusSettled,
+		PaymentStatusAuthorized,
+		PaymentStatusError,
+		PaymentStatusCancelled,
+		PaymentStatusFailed,
+		PaymentStatusChargeback,
+		PaymentStatusRefunded,
+		PaymentStatusRefundReversed:
+		return true
+	default:
+		return false
+	}
+}
+
 // PaymentTransaction represents a transaction on a payment
 //
 // A transaction is any event/status change on a payment
@@ -82,6 +103,13 @@ func (p *PaymentTransaction) Decimal() *decimal.Decimal {
 	return &decimal.Decimal{Dec: *d}
 }
 
+// DecimalRound returns the amount rounded to the given scale
+func (p *PaymentTransaction) DecimalRound(scale int32) *decimal.Decimal {
+	d := &p.Decimal().Dec
+	d.Round(d, dec.Scale(scale), dec.RoundHalfUp)
+	return &decimal.Decimal{Dec: *d}
+}
+
 // Balance represents a balance which totals the ledger by currency
 type Balance map[string]*decimal.Decimal
 
@@ -130,3 +158,40 @@ func (p PaymentTransactionList) Balance() Balance {
 	}
 	return b
 }
+
+// Paid returns the total amount which was paid (or captured) on the payment
+func (p PaymentTransactionList) Paid() int64 {
+	var paid int64
+	for _, tx := range p {
+		if tx.Status == PaymentStatusPaid {
+			paid += tx.Amount
+		}
+	}
+	return paid
+}
+
+// Refunded returns the total amount which was refunded on the payment
+//
+// Refund reversals are expected to be recorded with a negative amount and will be
+// subtracted from the total.
+func (p PaymentTransactionList) Refunded() int64 {
+	var refunded int64
+	for _, tx := range p {
+		switch tx.Status {
+		case PaymentStatusRefunded, PaymentStatusRefundReversed:
+			refunded += tx.Amount
+		}
+	}
+	return refunded
+}
+
+// ChargedBack returns the total amount which was charged back on the payment
+func (p PaymentTransactionList) ChargedBack() int64 {
+	var chargedBack int64
+	for _, tx := range p {
+		if tx.Status == PaymentStatusChargeback {
+			chargedBack += tx.Amount
+		}
+	}
+	return chargedBack
+}
@@ -153,8 +153,8 @@ func PaymentTransactionsBeforeDB(db *sql.DB, paymentTx *PaymentTransaction) (Pay
 	return scanTransactions(query, paymentTx.Payment)
 }
 
-// PaymentTransactionsBeforeDB returns a PaymentTransactionList with all transactions
-// before and including the given payment transaction.
+// PaymentTransactionsBeforeTimestampDB returns a PaymentTransactionList with all
+// transactions before and including the given transaction timestamp.
 //
 // The list will be sorted by the earliest tx first.
 func PaymentTransactionsBeforeTimestampDB(db *sql.DB, p *Payment, transactionTimestamp time.Time) (PaymentTransactionList, error) {
@@ -169,3 +169,35 @@ func PaymentTransactionsBeforeTimestampDB(db *sql.DB, p *Payment, transactionTim
 	}
 	return scanTransactions(query, p)
 }
+
+const selectPaymentTransactions = selectPaymentTransaction + `
+WHERE
+	tx.project_id = ?
+	AND
+	tx.payment_id = ?
+ORDER BY tx.timestamp ASC
+`
+
+// PaymentTransactionsTx returns a PaymentTransactionList with all transactions of the
+// given payment.
+//
+// The list will be sorted by the earliest tx first.
+func PaymentTransactionsTx(db *sql.Tx, p *Payment) (PaymentTransactionList, error) {
+	query, err := db.Query(selectPaymentTransactions, p.ProjectID(), p.ID())
+	if err != nil {
+		return nil, err
+	}
+	return scanTransactions(query, p)
+}
+
+// PaymentTransactionsDB returns a PaymentTransactionList with all transactions of the
+// given payment.
+//
+// The list will be sorted by the earliest tx first.
+func PaymentTransactionsDB(db *sql.DB, p *Payment) (PaymentTransactionList, error) {
+	query, err := db.Query(selectPaymentTransactions, p.ProjectID(), p.ID())
+	if err != nil {
+		return nil, err
+	}
+	return scanTransactions(query, p)
+}
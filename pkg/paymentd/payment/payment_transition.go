@@ -0,0 +1,90 @@
+package payment
+
+import (
+	"fmt"
+)
+
+// paymentTransitions is the table of allowed payment status transitions
+//
+// The key denotes the current status of a payment, the value lists all statuses which
+// may follow. Statuses without an entry are final.
+var paymentTransitions = map[PaymentTransactionStatus][]PaymentTransactionStatus{
+	PaymentStatusNone: {
+		PaymentStatusOpen,
+	},
+	PaymentStatusOpen: {
+		PaymentStatusPending,
+		PaymentStatusPaid,
+		PaymentStatusAuthorized,
+		PaymentStatusCancelled,
+		PaymentStatusFailed,
+		PaymentStatusError,
+	},
+	PaymentStatusPending: {
+		PaymentStatusPaid,
+		PaymentStatusAuthorized,
+		PaymentStatusCancelled,
+		PaymentStatusFailed,
+		PaymentStatusError,
+	},
+	PaymentStatusAuthorized: {
+		PaymentStatusPaid,
+		PaymentStatusCancelled,
+		PaymentStatusFailed,
+		PaymentStatusError,
+	},
+	PaymentStatusPaid: {
+		PaymentStatusSettled,
+		PaymentStatusRefunded,
+		PaymentStatusChargeback,
+	},
+	PaymentStatusSettled: {
+		PaymentStatusRefunded,
+		PaymentStatusChargeback,
+	},
+	// multiple (partial) refunds are possible
+	PaymentStatusRefunded: {
+		PaymentStatusRefunded,
+		PaymentStatusRefundReversed,
+		PaymentStatusChargeback,
+	},
+	PaymentStatusRefundReversed: {
+		PaymentStatusRefunded,
+		PaymentStatusChargeback,
+	},
+	PaymentStatusError: {
+		PaymentStatusCancelled,
+		PaymentStatusFailed,
+	},
+}
+
+// TransitionError is returned when a payment status transition is not allowed
+type TransitionError struct {
+	From PaymentTransactionStatus
+	To   PaymentTransactionStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("payment status transition from %s to %s not allowed", e.From, e.To)
+}
+
+// ValidateTransition checks whether a payment in the status from may change to the
+// status to
+//
+// It will return a *TransitionError if the transition is not allowed.
+func ValidateTransition(from, to PaymentTransactionStatus) error {
+	if !from.Valid() {
+		from = PaymentStatusNone
+	}
+	for _, next := range paymentTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
+
+// IsFinal returns true if there are no transitions possible from the status
+func (s PaymentTransactionStatus) IsFinal() bool {
+	return len(paymentTransitions[s]) == 0
+}
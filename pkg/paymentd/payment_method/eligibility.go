@@ -0,0 +1,138 @@
+package payment_method
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCurrencyNotEligible = errors.New("currency not eligible")
+	ErrCountryNotEligible  = errors.New("country not eligible")
+	ErrAmountNotEligible   = errors.New("amount not eligible")
+)
+
+// maximum number of subunits of amount limits
+const maxSubunits = 8
+
+// Eligibility holds the rules which payments can be paid with a payment method
+//
+// Rules without currencies or without countries do not restrict the currency or the
+// country respectively.
+type Eligibility struct {
+	Created   time.Time
+	CreatedBy string
+
+	// Currencies lists the allowed currencies with their amount limits
+	Currencies []CurrencyRule
+	// Countries lists the allowed countries (ISO 3166-1 alpha-2)
+	Countries []string
+}
+
+// CurrencyRule allows payments in a currency within the amount limits
+//
+// The amounts are given in the smallest unit of the currency according to the
+// subunits, i.e. 1000 with 2 subunits is 10.00. An amount of 0 does not limit the
+// payment amount.
+type CurrencyRule struct {
+	Currency  string
+	Subunits  int8
+	MinAmount int64
+	MaxAmount int64
+}
+
+// Empty returns true if the rules do not restrict any payments
+func (e *Eligibility) Empty() bool {
+	return len(e.Currencies) == 0 && len(e.Countries) == 0
+}
+
+// Validate checks the rules for consistency
+func (e *Eligibility) Validate() error {
+	currencies := make(map[string]bool, len(e.Currencies))
+	for _, c := range e.Currencies {
+		if len(c.Currency) != 3 {
+			return fmt.Errorf("invalid currency %s", c.Currency)
+		}
+		if currencies[c.Currency] {
+			return fmt.Errorf("duplicate currency %s", c.Currency)
+		}
+		currencies[c.Currency] = true
+		if c.Subunits < 0 || c.Subunits > maxSubunits {
+			return fmt.Errorf("invalid subunits for currency %s", c.Currency)
+		}
+		if c.MinAmount < 0 || c.MaxAmount < 0 {
+			return fmt.Errorf("negative amount limit for currency %s", c.Currency)
+		}
+		if c.MaxAmount != 0 && c.MinAmount > c.MaxAmount {
+			return fmt.Errorf("minimum amount exceeds maximum amount for currency %s", c.Currency)
+		}
+	}
+	countries := make(map[string]bool, len(e.Countries))
+	for _, c := range e.Countries {
+		if len(c) != 2 || strings.ToUpper(c) != c {
+			return fmt.Errorf("invalid country %s", c)
+		}
+		if countries[c] {
+			return fmt.Errorf("duplicate country %s", c)
+		}
+		countries[c] = true
+	}
+	return nil
+}
+
+// Check returns nil if a payment with the given currency, country and amount can be
+// paid with the payment method
+//
+// Otherwise it will return one of ErrCurrencyNotEligible, ErrCountryNotEligible or
+// ErrAmountNotEligible.
+func (e *Eligibility) Check(currency, country string, amount int64, subunits int8) error {
+	if len(e.Countries) > 0 {
+		var found bool
+		for _, c := range e.Countries {
+			if strings.EqualFold(c, country) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrCountryNotEligible
+		}
+	}
+	if len(e.Currencies) == 0 {
+		return nil
+	}
+	for _, c := range e.Currencies {
+		if !strings.EqualFold(c.Currency, currency) {
+			continue
+		}
+		if c.MinAmount != 0 && compareAmount(amount, subunits, c.MinAmount, c.Subunits) < 0 {
+			return ErrAmountNotEligible
+		}
+		if c.MaxAmount != 0 && compareAmount(amount, subunits, c.MaxAmount, c.Subunits) > 0 {
+			return ErrAmountNotEligible
+		}
+		return nil
+	}
+	return ErrCurrencyNotEligible
+}
+
+// compareAmount compares the amounts a and b given in their respective subunits
+//
+// It will return -1 if a < b, 0 if a == b and 1 if a > b.
+func compareAmount(a int64, aSubunits int8, b int64, bSubunits int8) int {
+	for ; aSubunits < bSubunits; aSubunits++ {
+		a *= 10
+	}
+	for ; bSubunits < aSubunits; bSubunits++ {
+		b *= 10
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
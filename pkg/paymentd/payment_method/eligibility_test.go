@@ -0,0 +1,68 @@
+package payment_method
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEligibility(t *testing.T) {
+	Convey("Given empty eligibility rules", t, func() {
+		e := &Eligibility{}
+
+		Convey("They should be valid", func() {
+			So(e.Validate(), ShouldBeNil)
+			So(e.Empty(), ShouldBeTrue)
+		})
+		Convey("They should allow all payments", func() {
+			So(e.Check("EUR", "DE", 100, 2), ShouldBeNil)
+			So(e.Check("USD", "US", 100000000, 2), ShouldBeNil)
+		})
+	})
+
+	Convey("Given eligibility rules with currencies, countries and amount limits", t, func() {
+		e := &Eligibility{
+			Currencies: []CurrencyRule{
+				{Currency: "EUR", Subunits: 2, MinAmount: 100, MaxAmount: 100000},
+				{Currency: "USD", Subunits: 0, MaxAmount: 500},
+			},
+			Countries: []string{"DE", "AT"},
+		}
+
+		Convey("They should be valid", func() {
+			So(e.Validate(), ShouldBeNil)
+		})
+		Convey("They should allow payments within the limits", func() {
+			So(e.Check("EUR", "DE", 100, 2), ShouldBeNil)
+			So(e.Check("EUR", "AT", 1000, 2), ShouldBeNil)
+			So(e.Check("EUR", "DE", 1000000, 3), ShouldBeNil)
+			So(e.Check("USD", "DE", 50000, 2), ShouldBeNil)
+		})
+		Convey("They should reject other currencies", func() {
+			So(e.Check("GBP", "DE", 1000, 2), ShouldEqual, ErrCurrencyNotEligible)
+		})
+		Convey("They should reject other countries", func() {
+			So(e.Check("EUR", "CH", 1000, 2), ShouldEqual, ErrCountryNotEligible)
+		})
+		Convey("They should reject amounts out of the limits", func() {
+			So(e.Check("EUR", "DE", 99, 2), ShouldEqual, ErrAmountNotEligible)
+			So(e.Check("EUR", "DE", 100001, 2), ShouldEqual, ErrAmountNotEligible)
+			So(e.Check("USD", "DE", 50001, 2), ShouldEqual, ErrAmountNotEligible)
+		})
+	})
+
+	Convey("Given inconsistent eligibility rules", t, func() {
+		Convey("A minimum exceeding the maximum should be invalid", func() {
+			e := &Eligibility{Currencies: []CurrencyRule{{Currency: "EUR", MinAmount: 10, MaxAmount: 5}}}
+			So(e.Validate(), ShouldNotBeNil)
+		})
+		Convey("Duplicate currencies should be invalid", func() {
+			e := &Eligibility{Currencies: []CurrencyRule{{Currency: "EUR"}, {Currency: "EUR"}}}
+			So(e.Validate(), ShouldNotBeNil)
+		})
+		Convey("Invalid countries should be invalid", func() {
+			e := &Eligibility{Countries: []string{"de"}}
+			So(e.Validate(), ShouldNotBeNil)
+		})
+	})
+}
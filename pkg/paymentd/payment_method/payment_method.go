@@ -73,6 +73,8 @@ type Method struct {
 	StatusCreatedBy string
 
 	Metadata map[string]string
+	// Eligibility holds the eligibility rules of the method, if they were loaded
+	Eligibility *Eligibility `json:",omitempty"`
 }
 
 // Active returns true if the payment method is considered active
@@ -85,6 +87,32 @@ func (m *Method) Disabled() bool {
 	return m.Status == PaymentMethodStatusDisabled
 }
 
+// Metadata keys of payment methods, which are used on the payment method selection
+const (
+	// MetadataKeyDisplayName is the name of the payment method shown to customers
+	//
+	// It can be localized by appending the locale, i.e. "displayName_de_DE".
+	MetadataKeyDisplayName = "displayName"
+	// MetadataKeyLogo is the URL of the logo of the payment method
+	MetadataKeyLogo = "logo"
+)
+
+// DisplayName returns the name of the payment method shown to customers in the given
+// locale
+//
+// If no display name is set in the metadata, it will return the method key.
+func (m *Method) DisplayName(locale string) string {
+	if m.Metadata != nil {
+		if name := m.Metadata[MetadataKeyDisplayName+"_"+locale]; name != "" {
+			return name
+		}
+		if name := m.Metadata[MetadataKeyDisplayName]; name != "" {
+			return name
+		}
+	}
+	return m.MethodKey
+}
+
 const (
 	metadataTable        = "payment_method_metadata"
 	metadataPrimaryField = "payment_method_id"
@@ -31,3 +31,30 @@ func TestPaymentMethodStatus(t *testing.T) {
 		})
 	})
 }
+
+func TestPaymentMethodDisplayName(t *testing.T) {
+	Convey("Given a payment method without metadata", t, func() {
+		m := &Method{MethodKey: "cc"}
+
+		Convey("It should be displayed with the method key", func() {
+			So(m.DisplayName("de_DE"), ShouldEqual, "cc")
+		})
+	})
+
+	Convey("Given a payment method with display names", t, func() {
+		m := &Method{
+			MethodKey: "cc",
+			Metadata: map[string]string{
+				MetadataKeyDisplayName:            "Credit Card",
+				MetadataKeyDisplayName + "_de_DE": "Kreditkarte",
+			},
+		}
+
+		Convey("It should use the localized display name", func() {
+			So(m.DisplayName("de_DE"), ShouldEqual, "Kreditkarte")
+		})
+		Convey("It should fall back to the display name", func() {
+			So(m.DisplayName("en_US"), ShouldEqual, "Credit Card")
+		})
+	})
+}
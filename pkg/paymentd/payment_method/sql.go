@@ -50,7 +50,19 @@ AND
 	m.method_key = ?
 `
 
-func scanSinglePaymentMethod(row *sql.Row) (*Method, error) {
+const selectPaymentMethodByProjectIDAndStatus = selectPaymentMethod + `
+WHERE
+	m.project_id = ?
+AND
+	s.status = ?
+ORDER BY m.id
+`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSinglePaymentMethod(row scanner) (*Method, error) {
 	pm := &Method{}
 	var ts int64
 	err := row.Scan(
@@ -94,6 +106,57 @@ func PaymentMethodByIDTx(db *sql.Tx, id int64) (*Method, error) {
 	return scanSinglePaymentMethod(row)
 }
 
+const lockPaymentMethod = `
+SELECT id FROM payment_method WHERE id = ? FOR UPDATE
+`
+
+// LockPaymentMethodTx locks the payment method with the given id for the remainder of
+// the transaction
+func LockPaymentMethodTx(db *sql.Tx, id int64) error {
+	var lockedID int64
+	err := db.QueryRow(lockPaymentMethod, id).Scan(&lockedID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrPaymentMethodNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func scanPaymentMethods(rows *sql.Rows) ([]*Method, error) {
+	defer rows.Close()
+	methods := make([]*Method, 0, 8)
+	for rows.Next() {
+		pm, err := scanSinglePaymentMethod(rows)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, pm)
+	}
+	return methods, rows.Err()
+}
+
+// PaymentMethodsByProjectIDAndStatusDB returns all payment methods of the project in
+// the given status
+func PaymentMethodsByProjectIDAndStatusDB(db *sql.DB, projectID int64, status methodStatus) ([]*Method, error) {
+	rows, err := db.Query(selectPaymentMethodByProjectIDAndStatus, projectID, status)
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentMethods(rows)
+}
+
+// PaymentMethodsByProjectIDAndStatusTx returns all payment methods of the project in
+// the given status
+func PaymentMethodsByProjectIDAndStatusTx(db *sql.Tx, projectID int64, status methodStatus) ([]*Method, error) {
+	rows, err := db.Query(selectPaymentMethodByProjectIDAndStatus, projectID, status)
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentMethods(rows)
+}
+
 const insertPaymentMethod = `
 INSERT INTO payment_method
 (project_id, provider, method_key, created, created_by)
@@ -140,6 +203,17 @@ func InsertPaymentMethodMetadataTx(db *sql.Tx, pm *Method, createdBy string) err
 	return metadata.InsertMetadataTx(db, MetadataModel, pm.ID, m)
 }
 
+func PaymentMethodMetadataDB(db *sql.DB, pm *Method) (map[string]string, error) {
+	if pm.ID == 0 {
+		return nil, ErrPaymentMethodWithoutID
+	}
+	m, err := metadata.MetadataByPrimaryDB(db, MetadataModel, pm.ID)
+	if err != nil {
+		return nil, err
+	}
+	return m.Values(), nil
+}
+
 func PaymentMethodMetadataTx(db *sql.Tx, pm *Method) (map[string]string, error) {
 	if pm.ID == 0 {
 		return nil, ErrPaymentMethodWithoutID
@@ -150,3 +224,167 @@ func PaymentMethodMetadataTx(db *sql.Tx, pm *Method) (map[string]string, error)
 	}
 	return m.Values(), nil
 }
+
+const selectEligibilityByMethodID = `
+SELECT
+	e.timestamp,
+	e.created_by
+FROM payment_method_eligibility AS e
+WHERE
+	e.payment_method_id = ?
+	AND
+	e.timestamp = (
+		SELECT MAX(timestamp) FROM payment_method_eligibility
+		WHERE
+			payment_method_id = e.payment_method_id
+	)
+`
+
+const selectEligibilityCurrencies = `
+SELECT
+	currency,
+	subunits,
+	min_amount,
+	max_amount
+FROM payment_method_eligibility_currency
+WHERE
+	payment_method_id = ?
+	AND
+	timestamp = ?
+ORDER BY currency
+`
+
+const selectEligibilityCountries = `
+SELECT
+	country
+FROM payment_method_eligibility_country
+WHERE
+	payment_method_id = ?
+	AND
+	timestamp = ?
+ORDER BY country
+`
+
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func readEligibility(db querier, pm *Method) (*Eligibility, error) {
+	if pm.ID == 0 {
+		return nil, ErrPaymentMethodWithoutID
+	}
+	e := &Eligibility{}
+	var ts int64
+	err := db.QueryRow(selectEligibilityByMethodID, pm.ID).Scan(&ts, &e.CreatedBy)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// no rules set
+			return e, nil
+		}
+		return nil, err
+	}
+	e.Created = time.Unix(0, ts)
+
+	rows, err := db.Query(selectEligibilityCurrencies, pm.ID, ts)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		c := CurrencyRule{}
+		err = rows.Scan(&c.Currency, &c.Subunits, &c.MinAmount, &c.MaxAmount)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		e.Currencies = append(e.Currencies, c)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(selectEligibilityCountries, pm.ID, ts)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var country string
+		err = rows.Scan(&country)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		e.Countries = append(e.Countries, country)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// EligibilityByPaymentMethodDB returns the current eligibility rules of the payment
+// method
+//
+// If no rules were set, it will return empty rules.
+func EligibilityByPaymentMethodDB(db *sql.DB, pm *Method) (*Eligibility, error) {
+	return readEligibility(db, pm)
+}
+
+// EligibilityByPaymentMethodTx returns the current eligibility rules of the payment
+// method
+//
+// If no rules were set, it will return empty rules.
+func EligibilityByPaymentMethodTx(db *sql.Tx, pm *Method) (*Eligibility, error) {
+	return readEligibility(db, pm)
+}
+
+const insertEligibility = `
+INSERT INTO payment_method_eligibility
+(payment_method_id, timestamp, created_by)
+VALUES
+(?, ?, ?)
+`
+
+const insertEligibilityCurrency = `
+INSERT INTO payment_method_eligibility_currency
+(payment_method_id, timestamp, currency, subunits, min_amount, max_amount)
+VALUES
+(?, ?, ?, ?, ?, ?)
+`
+
+const insertEligibilityCountry = `
+INSERT INTO payment_method_eligibility_country
+(payment_method_id, timestamp, country)
+VALUES
+(?, ?, ?)
+`
+
+// InsertEligibilityTx saves a new version of the eligibility rules of the payment
+// method
+func InsertEligibilityTx(db *sql.Tx, pm *Method, e *Eligibility) error {
+	if pm.ID == 0 {
+		return ErrPaymentMethodWithoutID
+	}
+	ts := e.Created.UnixNano()
+	_, err := db.Exec(insertEligibility, pm.ID, ts, e.CreatedBy)
+	if err != nil {
+		return err
+	}
+	for _, c := range e.Currencies {
+		_, err = db.Exec(insertEligibilityCurrency, pm.ID, ts, c.Currency, c.Subunits, c.MinAmount, c.MaxAmount)
+		if err != nil {
+			return err
+		}
+	}
+	for _, country := range e.Countries {
+		_, err = db.Exec(insertEligibilityCountry, pm.ID, ts, country)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
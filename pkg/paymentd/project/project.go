@@ -3,6 +3,7 @@ package project
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -40,6 +41,8 @@ type Config struct {
 	CallbackAPIVersion sql.NullString
 	CallbackProjectKey sql.NullString
 	ReturnURL          sql.NullString
+	// default expiry of payments in seconds
+	PaymentExpiry sql.NullInt64
 }
 
 type ConfigJSON struct {
@@ -48,6 +51,8 @@ type ConfigJSON struct {
 	CallbackAPIVersion *string
 	CallbackProjectKey *string
 	ReturnURL          *string
+	// default expiry of payments as a duration string, i.e. "24h"
+	PaymentExpiry *string `json:",omitempty"`
 }
 
 // IsSet returns true if the config was set and stored
@@ -57,7 +62,7 @@ func (c Config) IsSet() bool {
 
 // HasValues returns true if the config has any values set
 func (c Config) HasValues() bool {
-	return c.WebURL.Valid || c.CallbackURL.Valid || c.CallbackAPIVersion.Valid || c.CallbackProjectKey.Valid || c.ReturnURL.Valid
+	return c.WebURL.Valid || c.CallbackURL.Valid || c.CallbackAPIVersion.Valid || c.CallbackProjectKey.Valid || c.ReturnURL.Valid || c.PaymentExpiry.Valid
 }
 
 func (c Config) HasCallback() bool {
@@ -88,6 +93,23 @@ func (c *Config) SetReturnURL(url string) {
 	c.ReturnURL.String, c.ReturnURL.Valid = url, true
 }
 
+// SetPaymentExpiry sets the default expiry of payments
+//
+// The expiry will be truncated to seconds.
+func (c *Config) SetPaymentExpiry(exp time.Duration) {
+	c.PaymentExpiry.Int64, c.PaymentExpiry.Valid = int64(exp/time.Second), true
+}
+
+// DefaultPaymentExpiry returns the default expiry of payments
+//
+// If no default expiry is set, it will return false.
+func (c Config) DefaultPaymentExpiry() (time.Duration, bool) {
+	if !c.PaymentExpiry.Valid || c.PaymentExpiry.Int64 <= 0 {
+		return 0, false
+	}
+	return time.Duration(c.PaymentExpiry.Int64) * time.Second, true
+}
+
 func (c *Config) UnmarshalJSON(p []byte) error {
 	cfg := &ConfigJSON{}
 	err := json.Unmarshal(p, cfg)
@@ -109,6 +131,16 @@ func (c *Config) UnmarshalJSON(p []byte) error {
 	if cfg.ReturnURL != nil {
 		c.SetReturnURL(*cfg.ReturnURL)
 	}
+	if cfg.PaymentExpiry != nil {
+		exp, err := time.ParseDuration(*cfg.PaymentExpiry)
+		if err != nil {
+			return fmt.Errorf("invalid PaymentExpiry: %v", err)
+		}
+		if exp < time.Second {
+			return fmt.Errorf("invalid PaymentExpiry: %s", *cfg.PaymentExpiry)
+		}
+		c.SetPaymentExpiry(exp)
+	}
 	return nil
 }
 
@@ -129,6 +161,10 @@ func (c Config) MarshalJSON() ([]byte, error) {
 	if c.ReturnURL.Valid {
 		cfg.ReturnURL = &c.ReturnURL.String
 	}
+	if exp, ok := c.DefaultPaymentExpiry(); ok {
+		expStr := exp.String()
+		cfg.PaymentExpiry = &expStr
+	}
 	return json.Marshal(cfg)
 }
 
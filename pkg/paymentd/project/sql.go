@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/fritzpay/paymentd/pkg/secret"
 )
 
 var (
@@ -57,9 +59,9 @@ func InsertProjectTx(db *sql.Tx, p *Project) error {
 
 const insertProjectConfig = `
 INSERT INTO project_config
-(project_id, timestamp, web_url, callback_url, callback_api_version, callback_project_key, return_url)
+(project_id, timestamp, web_url, callback_url, callback_api_version, callback_project_key, return_url, payment_expiry)
 VALUES
-(?, ?, ?, ?, ?, ?, ?)
+(?, ?, ?, ?, ?, ?, ?, ?)
 `
 
 func execInsertProjectConfig(insert *sql.Stmt, p *Project) error {
@@ -72,6 +74,7 @@ func execInsertProjectConfig(insert *sql.Stmt, p *Project) error {
 		p.Config.CallbackAPIVersion,
 		p.Config.CallbackProjectKey,
 		p.Config.ReturnURL,
+		p.Config.PaymentExpiry,
 	)
 	insert.Close()
 	return err
@@ -111,7 +114,8 @@ SELECT
 	c.callback_url,
 	c.callback_api_version,
 	c.callback_project_key,
-	c.return_url
+	c.return_url,
+	c.payment_expiry
 FROM project AS p
 LEFT JOIN project_config AS c ON
 	c.project_id = p.id
@@ -163,6 +167,7 @@ func scanProject(row *sql.Row) (*Project, error) {
 		&p.Config.CallbackAPIVersion,
 		&p.Config.CallbackProjectKey,
 		&p.Config.ReturnURL,
+		&p.Config.PaymentExpiry,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -209,6 +214,7 @@ func AllProjectsByPrincipalIDDB(db *sql.DB, principalID int64) ([]Project, error
 			&p.Config.CallbackAPIVersion,
 			&p.Config.CallbackProjectKey,
 			&p.Config.ReturnURL,
+			&p.Config.PaymentExpiry,
 		)
 		if err != nil {
 			rows.Close()
@@ -286,7 +292,8 @@ SELECT
 	c.callback_url,
 	c.callback_api_version,
 	c.callback_project_key,
-	c.return_url
+	c.return_url,
+	c.payment_expiry
 FROM project_key AS k
 INNER JOIN project AS p ON
 	p.id = k.project_id
@@ -331,6 +338,7 @@ func scanProjectKey(row *sql.Row) (*Projectkey, error) {
 		&pk.Project.Config.CallbackAPIVersion,
 		&pk.Project.Config.CallbackProjectKey,
 		&pk.Project.Config.ReturnURL,
+		&pk.Project.Config.PaymentExpiry,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -341,6 +349,10 @@ func scanProjectKey(row *sql.Row) (*Projectkey, error) {
 	if ts.Valid {
 		pk.Project.Config.Timestamp = time.Unix(ts.Int64, 0)
 	}
+	pk.Secret, err = secret.Decrypt(pk.Secret)
+	if err != nil {
+		return pk, err
+	}
 	return pk, nil
 }
 
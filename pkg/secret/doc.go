@@ -0,0 +1,28 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package secret provides envelope encryption of secrets at rest, i.e. provider
+credentials and project key secrets.
+
+Each value is encrypted with a random data key. The data key is encrypted with the
+master key and stored alongside the value. Encrypted values are prefixed, so values
+which were stored before encryption was enabled can still be read.
+
+The master key can be rotated by adding the new master key and keeping the previous
+master keys for decryption until all values are re-encrypted.
+*/
+package secret
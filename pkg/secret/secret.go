@@ -0,0 +1,282 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+)
+
+const (
+	// KeySize is the size of master keys and data keys in bytes (AES-256)
+	KeySize = 32
+
+	// prefix of encrypted values
+	encPrefix = "enc:v1:"
+	// length of the hex encoded key ID
+	keyIDLen = 8
+)
+
+var (
+	ErrKeySize       = errors.New("invalid key size")
+	ErrNoKey         = errors.New("no master key for encrypted value")
+	ErrInvalidFormat = errors.New("invalid encrypted value")
+)
+
+// Keyring holds the master key used for encryption and previous master keys
+// which are still used for decryption
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyring creates a new keyring with the given master key
+//
+// The previous keys will only be used for decrypting values.
+func NewKeyring(masterKey []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{
+		keys: make(map[string][]byte),
+	}
+	for _, key := range previous {
+		if err := k.addKey(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.addKey(masterKey); err != nil {
+		return nil, err
+	}
+	k.current = keyID(masterKey)
+	return k, nil
+}
+
+func (k *Keyring) addKey(key []byte) error {
+	if len(key) != KeySize {
+		return ErrKeySize
+	}
+	k.keys[keyID(key)] = key
+	return nil
+}
+
+// keyID identifies a master key without revealing it
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:keyIDLen/2])
+}
+
+// IsEncrypted returns true if the value is an encrypted value
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encPrefix)
+}
+
+// NeedsRotation returns true if the value is not encrypted with the current master key
+func (k *Keyring) NeedsRotation(value string) bool {
+	return value != "" && !strings.HasPrefix(value, encPrefix+k.current+":")
+}
+
+// Encrypt encrypts the value with a new data key
+//
+// The format of the result is enc:v1:<key ID>:<encrypted data key>:<encrypted value>
+// Empty values will not be encrypted.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	dataKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(k.keys[k.current], dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return encPrefix + k.current + ":" +
+		base64.StdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts the given value
+//
+// Values which are not encrypted will be returned as they are.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encPrefix), ":")
+	if len(parts) != 3 {
+		return "", ErrInvalidFormat
+	}
+	masterKey, ok := k.keys[parts[0]]
+	if !ok {
+		return "", ErrNoKey
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidFormat
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidFormat
+	}
+	dataKey, err := open(masterKey, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rotate re-encrypts the value with the current master key
+//
+// Values which are not encrypted will be encrypted.
+func (k *Keyring) Rotate(value string) (string, error) {
+	plaintext, err := k.Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return k.Encrypt(plaintext)
+}
+
+// seal encrypts with AES-GCM. The nonce is prepended to the result
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrInvalidFormat
+	}
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// GenerateKey creates a new random master key
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	_, err := io.ReadFull(rand.Reader, key)
+	return key, err
+}
+
+// ReadKey decodes a hex encoded master key
+func ReadKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	return key, nil
+}
+
+// ReadKeyFile reads a hex encoded master key from the given file
+func ReadKeyFile(fileName string) ([]byte, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ReadKey(string(b))
+}
+
+// KeyringFromConfig creates the keyring from the secret config
+//
+// It returns nil if no master key is configured.
+func KeyringFromConfig(cfg *config.Config) (*Keyring, error) {
+	var masterKey []byte
+	var err error
+	switch {
+	case cfg.Secret.MasterKey != "":
+		masterKey, err = ReadKey(cfg.Secret.MasterKey)
+	case cfg.Secret.MasterKeyFile != "":
+		masterKey, err = ReadKeyFile(cfg.Secret.MasterKeyFile)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	previous := make([][]byte, 0, len(cfg.Secret.PreviousMasterKeyFiles))
+	for _, fileName := range cfg.Secret.PreviousMasterKeyFiles {
+		key, err := ReadKeyFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	return NewKeyring(masterKey, previous...)
+}
+
+var (
+	mKeyring sync.RWMutex
+	keyring  *Keyring
+)
+
+// SetKeyring sets the keyring used by the package level Encrypt and Decrypt functions
+func SetKeyring(k *Keyring) {
+	mKeyring.Lock()
+	keyring = k
+	mKeyring.Unlock()
+}
+
+// Encrypt encrypts the value with the keyring set with SetKeyring
+//
+// If no keyring is set, the value will be returned as it is.
+func Encrypt(value string) (string, error) {
+	mKeyring.RLock()
+	k := keyring
+	mKeyring.RUnlock()
+	if k == nil {
+		return value, nil
+	}
+	return k.Encrypt(value)
+}
+
+// Decrypt decrypts the value with the keyring set with SetKeyring
+//
+// It returns an ErrNoKey if the value is encrypted and no keyring is set.
+func Decrypt(value string) (string, error) {
+	mKeyring.RLock()
+	k := keyring
+	mKeyring.RUnlock()
+	if k == nil {
+		if IsEncrypted(value) {
+			return "", ErrNoKey
+		}
+		return value, nil
+	}
+	return k.Decrypt(value)
+}
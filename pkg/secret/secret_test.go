@@ -0,0 +1,69 @@
+package secret
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyring(t *testing.T) {
+	Convey("Given a keyring", t, func() {
+		oldKey, err := GenerateKey()
+		So(err, ShouldBeNil)
+		k, err := NewKeyring(oldKey)
+		So(err, ShouldBeNil)
+
+		Convey("When encrypting a value", func() {
+			enc, err := k.Encrypt("s3cr3t")
+			So(err, ShouldBeNil)
+
+			Convey("It should not contain the value", func() {
+				So(IsEncrypted(enc), ShouldBeTrue)
+				So(enc, ShouldNotContainSubstring, "s3cr3t")
+				So(k.NeedsRotation(enc), ShouldBeFalse)
+			})
+
+			Convey("It should decrypt", func() {
+				dec, err := k.Decrypt(enc)
+				So(err, ShouldBeNil)
+				So(dec, ShouldEqual, "s3cr3t")
+			})
+
+			Convey("When the master key is rotated", func() {
+				newKey, err := GenerateKey()
+				So(err, ShouldBeNil)
+				rotated, err := NewKeyring(newKey, oldKey)
+				So(err, ShouldBeNil)
+
+				Convey("The value should need rotation", func() {
+					So(rotated.NeedsRotation(enc), ShouldBeTrue)
+				})
+
+				Convey("The rotated value should only be readable with the new key", func() {
+					enc2, err := rotated.Rotate(enc)
+					So(err, ShouldBeNil)
+					So(rotated.NeedsRotation(enc2), ShouldBeFalse)
+
+					newOnly, err := NewKeyring(newKey)
+					So(err, ShouldBeNil)
+					dec, err := newOnly.Decrypt(enc2)
+					So(err, ShouldBeNil)
+					So(dec, ShouldEqual, "s3cr3t")
+
+					_, err = k.Decrypt(enc2)
+					So(err, ShouldEqual, ErrNoKey)
+				})
+			})
+		})
+
+		Convey("When decrypting an unencrypted value", func() {
+			dec, err := k.Decrypt("plain")
+
+			Convey("It should return the value", func() {
+				So(err, ShouldBeNil)
+				So(dec, ShouldEqual, "plain")
+				So(k.NeedsRotation("plain"), ShouldBeTrue)
+			})
+		})
+	})
+}
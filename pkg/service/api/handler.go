@@ -8,6 +8,7 @@ import (
 
 	"github.com/fritzpay/paymentd/pkg/service"
 	"github.com/fritzpay/paymentd/pkg/service/api/v1"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"github.com/gorilla/mux"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -22,7 +23,10 @@ type Handler struct {
 }
 
 // NewHandler creates a new API Handler
-func NewHandler(ctx *service.Context) (*Handler, error) {
+//
+// The provider capabilities c will be used for provider intents (refund, capture, void).
+// They are provided by the service serving the provider drivers and can be nil.
+func NewHandler(ctx *service.Context, c paymentService.ProviderCapabilities) (*Handler, error) {
 	h := &Handler{
 		ctx: ctx,
 		log: ctx.Log().New(log15.Ctx{
@@ -56,7 +60,7 @@ func NewHandler(ctx *service.Context) (*Handler, error) {
 	}
 
 	h.log.Info("registering API service v1...")
-	v1.NewService(h.ctx, h.mux)
+	v1.NewService(h.ctx, h.mux, c)
 	v1.Log = h.log.New(log15.Ctx{
 		"pkg": "github.com/fritzpay/paymentd/pkg/service/api/v1",
 	})
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
@@ -25,6 +26,8 @@ const (
 type AdminAPI struct {
 	ctx *service.Context
 	log log15.Logger
+
+	paymentService *paymentService.Service
 }
 
 // type used for formated AdminAPI Responses
@@ -33,9 +36,13 @@ type AdminAPIResponse struct {
 }
 
 // NewAPI creates a new admin API
-func NewAdminAPI(ctx *service.Context) *AdminAPI {
+//
+// The payment service is used for payment related administrative tasks, i.e.
+// re-sending notifications.
+func NewAdminAPI(ctx *service.Context, paymentService *paymentService.Service) *AdminAPI {
 	a := &AdminAPI{
-		ctx: ctx,
+		ctx:            ctx,
+		paymentService: paymentService,
 		log: ctx.Log().New(log15.Ctx{
 			"pkg": "github.com/fritzpay/paymentd/pkg/service/api/v1",
 			"API": "AdminAPI",
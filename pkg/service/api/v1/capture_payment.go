@@ -0,0 +1,177 @@
+package v1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	jsonutil "github.com/fritzpay/paymentd/pkg/json"
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+)
+
+// CapturePaymentRequest is the request JSON struct for POST /payment/{paymentId}/capture
+type CapturePaymentRequest struct {
+	ProjectKey string
+	PaymentId  string `json:"-"`
+	paymentID  payment.PaymentID
+	Amount     jsonutil.RequiredInt64
+	Subunits   jsonutil.RequiredInt8
+	Currency   string
+
+	Timestamp int64 `json:",string"`
+	Nonce     string
+
+	HexSignature    string `json:"Signature"`
+	binarySignature []byte
+}
+
+// Validate input
+func (r *CapturePaymentRequest) Validate() error {
+	if r.ProjectKey == "" {
+		return fmt.Errorf("missing ProjectKey")
+	}
+	if r.PaymentId == "" {
+		return fmt.Errorf("missing PaymentId")
+	}
+	if !r.Amount.Set {
+		return fmt.Errorf("missing Amount")
+	}
+	if r.Amount.Int64 <= 0 {
+		return fmt.Errorf("invalid Amount: %d", r.Amount.Int64)
+	}
+	if !r.Subunits.Set {
+		return fmt.Errorf("missing Subunits")
+	}
+	if r.Currency == "" {
+		return fmt.Errorf("missing Currency")
+	}
+	if len(r.Currency) != 3 {
+		return fmt.Errorf("invalid Currency")
+	}
+	var err error
+	if r.HexSignature == "" {
+		return fmt.Errorf("missing Signature")
+	} else if r.binarySignature, err = hex.DecodeString(r.HexSignature); err != nil {
+		return fmt.Errorf("invalid Signature format")
+	}
+	if r.Timestamp == 0 {
+		return fmt.Errorf("missing Timestamp")
+	}
+	if r.Nonce == "" {
+		return fmt.Errorf("missing Nonce")
+	}
+	if len(r.Nonce) > nonce.NonceBytes {
+		return fmt.Errorf("invalid Nonce")
+	}
+	return nil
+}
+
+// Return the (binary) signature from the request
+//
+// implementing AuthenticatedRequest
+func (r *CapturePaymentRequest) Signature() ([]byte, error) {
+	return r.binarySignature, nil
+}
+
+// HashFunc returns the hash function used to generate a signature
+func (r *CapturePaymentRequest) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+// Return the signature base string (msg)
+func (r *CapturePaymentRequest) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	_, err = buf.WriteString(r.ProjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.PaymentId)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Amount.Int64, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(int64(r.Subunits.Int8), 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *CapturePaymentRequest) RequestProjectKey() string {
+	return r.ProjectKey
+}
+
+func (r *CapturePaymentRequest) RequestNonce() string {
+	return r.Nonce
+}
+
+func (r *CapturePaymentRequest) Time() time.Time {
+	return time.Unix(r.Timestamp, 0)
+}
+
+// ReadFromRequest reads the payment id from the request path and the JSON
+// request body
+func (r *CapturePaymentRequest) ReadFromRequest(req *http.Request) error {
+	var err error
+	r.PaymentId, r.paymentID, err = readPaymentIDVar(req)
+	if err != nil {
+		return err
+	}
+	return r.ReadJSON(req.Body)
+}
+
+func (r *CapturePaymentRequest) ReadJSON(rd io.Reader) error {
+	dec := json.NewDecoder(rd)
+	err := dec.Decode(r)
+	return err
+}
+
+// RequestPaymentID returns the (encoded) payment id of the request
+func (r *CapturePaymentRequest) RequestPaymentID() payment.PaymentID {
+	return r.paymentID
+}
+
+// CapturePayment returns the handler for capturing an authorized payment
+//
+// The amount may be less than the authorized amount (partial capture).
+func (a *PaymentAPI) CapturePayment() http.Handler {
+	newRequest := func() PaymentIntentRequest {
+		return &CapturePaymentRequest{}
+	}
+	intent := func(req PaymentIntentRequest) PaymentIntentFunc {
+		r := req.(*CapturePaymentRequest)
+		return func(tx *sql.Tx, p *payment.Payment) (*payment.PaymentTransaction, paymentService.CommitIntentFunc, error) {
+			if err := checkPaymentCurrency(p, r.Currency, r.Subunits.Int8); err != nil {
+				return nil, nil, err
+			}
+			return a.paymentService.IntentCapture(p, r.Amount.Int64, paymentIntentTimeout)
+		}
+	}
+	return a.paymentIntentHandler("CapturePayment", newRequest, intent, "payment captured")
+}
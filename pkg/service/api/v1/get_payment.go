@@ -74,6 +74,10 @@ func (r *GetPaymentRequest) RequestProjectKey() string {
 	return r.ProjectKey
 }
 
+func (r *GetPaymentRequest) RequestNonce() string {
+	return r.Nonce
+}
+
 func (r *GetPaymentRequest) Time() time.Time {
 	return time.Unix(r.Timestamp, 0)
 }
@@ -103,6 +107,7 @@ func (r *GetPaymentRequest) ReadFromRequest(req *http.Request) error {
 	if r.Nonce == "" {
 		return errors.New("no nonce")
 	}
+	r.hexSignature = q.Get("Signature")
 	return nil
 }
 
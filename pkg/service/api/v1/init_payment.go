@@ -229,6 +229,10 @@ func (r *InitPaymentRequest) RequestProjectKey() string {
 	return r.ProjectKey
 }
 
+func (r *InitPaymentRequest) RequestNonce() string {
+	return r.Nonce
+}
+
 func (r *InitPaymentRequest) Time() time.Time {
 	return time.Unix(r.Timestamp, 0)
 }
@@ -573,6 +577,12 @@ func (a *PaymentAPI) InitPayment() http.Handler {
 			case paymentService.ErrDuplicateIdent:
 				resp = ErrConflict
 				resp.Info = "your ident was already used"
+			case paymentService.ErrPaymentMethodNotFound,
+				paymentService.ErrPaymentMethodConflict,
+				paymentService.ErrPaymentMethodInactive,
+				paymentService.ErrPaymentMethodNotEligible:
+				resp = ErrInval
+				resp.Info = err.Error()
 			default:
 				resp = ErrSystem
 				log.Error("unknown error in payment service")
@@ -634,7 +644,6 @@ func (a *PaymentAPI) InitPayment() http.Handler {
 			resp = ErrSystem
 			return
 		}
-		// TODO save nonce
 		paymentResp.Nonce = n.Nonce
 		paymentResp.Timestamp = time.Now().Unix()
 
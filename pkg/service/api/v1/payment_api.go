@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
 	"github.com/fritzpay/paymentd/pkg/service"
 	"github.com/fritzpay/paymentd/pkg/service/payment"
@@ -12,7 +14,8 @@ import (
 )
 
 const (
-	requestTimestampMaxAge = 10 * time.Second
+	// interval in which expired nonces are removed from the nonce store
+	noncePurgeInterval = time.Minute
 )
 
 // API represents the payment API in the version 1.x
@@ -21,10 +24,17 @@ type PaymentAPI struct {
 	log log15.Logger
 
 	paymentService *payment.Service
+
+	requestMaxSkew time.Duration
+	nonceStore     nonce.Store
 }
 
 // NewAPI creates a new payment API
-func NewPaymentAPI(ctx *service.Context) (*PaymentAPI, error) {
+//
+// The provider capabilities are used for provider intents (refund, capture, void). They
+// are provided by the service which attached the provider drivers. If c is nil, provider
+// intents will not be possible.
+func NewPaymentAPI(ctx *service.Context, c payment.ProviderCapabilities) (*PaymentAPI, error) {
 	p := &PaymentAPI{
 		ctx: ctx,
 		log: ctx.Log().New(log15.Ctx{
@@ -33,16 +43,53 @@ func NewPaymentAPI(ctx *service.Context) (*PaymentAPI, error) {
 		}),
 	}
 	var err error
+	p.requestMaxSkew, p.nonceStore, err = readNonceConfig(ctx)
+	if err != nil {
+		p.log.Error("error reading nonce config", log15.Ctx{"err": err})
+		return nil, err
+	}
 	p.paymentService, err = payment.NewService(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if c == nil {
+		p.log.Warn("no provider capabilities. provider intents will not be available")
+	} else {
+		p.paymentService.RegisterProviderCapabilities(c)
+	}
 	return p, nil
 }
 
+func readNonceConfig(ctx *service.Context) (time.Duration, nonce.Store, error) {
+	cfg := ctx.Config().API
+	def := config.DefaultConfig().API
+	if cfg.RequestMaxSkew == "" {
+		cfg.RequestMaxSkew = def.RequestMaxSkew
+	}
+	if cfg.NonceStore == "" {
+		cfg.NonceStore = def.NonceStore
+	}
+	skew, err := cfg.RequestMaxSkew.Duration()
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid RequestMaxSkew: %v", err)
+	}
+	if skew <= 0 {
+		return 0, nil, fmt.Errorf("invalid RequestMaxSkew: %s", cfg.RequestMaxSkew)
+	}
+	switch cfg.NonceStore {
+	case "mysql":
+		return skew, nonce.NewDBStore(ctx.PrincipalDB(), noncePurgeInterval), nil
+	case "memory":
+		return skew, nonce.NewMemoryStore(noncePurgeInterval), nil
+	default:
+		return 0, nil, fmt.Errorf("invalid NonceStore: %s", cfg.NonceStore)
+	}
+}
+
 type ProjectKeyRequester interface {
 	service.Signed
 	RequestProjectKey() string
+	RequestNonce() string
 	Time() time.Time
 }
 
@@ -98,11 +145,23 @@ func (a *PaymentAPI) authenticateRequest(req ProjectKeyRequester, log log15.Logg
 			ErrUnauthorized.Write(w)
 			return nil
 		}
-		if time.Since(req.Time()) > requestTimestampMaxAge {
+		if skew := time.Since(req.Time()); skew > a.requestMaxSkew || skew < -a.requestMaxSkew {
+			log.Info("request timestamp outside of accepted skew", log15.Ctx{"skew": skew})
 			ErrUnauthorized.Write(w)
 			return nil
 		}
-		// TODO include nonce handling
+		// the nonce must not be reused as long as the request timestamp is accepted
+		err := a.nonceStore.Use(projectKey.Key, req.RequestNonce(), req.Time().Add(a.requestMaxSkew))
+		if err != nil {
+			if err == nonce.ErrNonceUsed || err == nonce.ErrNonceInvalid {
+				log.Warn("nonce rejected", log15.Ctx{"err": err, "ProjectKey": projectKey.Key})
+				ErrUnauthorized.Write(w)
+				return nil
+			}
+			log.Error("error storing nonce", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return nil
+		}
 	}
 	return projectKey
 }
@@ -0,0 +1,467 @@
+package v1
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	notification "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// timeout for intents initiated through the API
+	paymentIntentTimeout = 500 * time.Millisecond
+)
+
+var (
+	errCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// PaymentIntentRequest is a signed request which changes the state of an existing
+// payment
+type PaymentIntentRequest interface {
+	ProjectKeyRequester
+	ReadFromRequest(r *http.Request) error
+	Validate() error
+	// RequestPaymentID returns the (encoded) payment id of the request
+	RequestPaymentID() payment.PaymentID
+}
+
+// PaymentIntentFunc performs the intent of the request on the given payment
+type PaymentIntentFunc func(tx *sql.Tx, p *payment.Payment) (*payment.PaymentTransaction, paymentService.CommitIntentFunc, error)
+
+// reads the payment id from the request path
+func readPaymentIDVar(req *http.Request) (string, payment.PaymentID, error) {
+	idStr := mux.Vars(req)["paymentId"]
+	if idStr == "" {
+		return idStr, payment.PaymentID{}, errors.New("no payment id")
+	}
+	id, err := payment.ParsePaymentIDStr(idStr)
+	if err != nil {
+		return idStr, id, errors.New("invalid payment id")
+	}
+	return idStr, id, nil
+}
+
+// checks whether the currency of the request matches the payment currency
+func checkPaymentCurrency(p *payment.Payment, currency string, subunits int8) error {
+	if p.Currency != currency || p.Subunits != subunits {
+		return errCurrencyMismatch
+	}
+	return nil
+}
+
+// paymentIntentHandler returns a handler which reads and authenticates a payment intent
+// request and performs the intent returned by the intent func
+//
+// On success, the response will contain the payment in the notification format including
+// all payment transactions.
+func (a *PaymentAPI) paymentIntentHandler(
+	method string,
+	newRequest func() PaymentIntentRequest,
+	intent func(req PaymentIntentRequest) PaymentIntentFunc,
+	info string) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		log := a.log.New(log15.Ctx{
+			"method": method,
+		})
+		var responseWritten bool
+		var resp ServiceResponse
+		defer func() {
+			if !responseWritten {
+				err := resp.Write(w)
+				if err != nil {
+					log.Error("error writing response", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		req := newRequest()
+		err := req.ReadFromRequest(r)
+		if err != nil {
+			resp = ErrReadJson
+			if Debug {
+				resp.Info = err.Error()
+			}
+			return
+		}
+		err = req.Validate()
+		if err != nil {
+			resp = ErrInval
+			resp.Info = err.Error()
+			return
+		}
+		paymentID := a.paymentService.DecodedPaymentID(req.RequestPaymentID())
+		log = log.New(log15.Ctx{"DisplayPaymentId": req.RequestPaymentID().String()})
+
+		var projectKey *project.Projectkey
+		if projectKey = a.authenticateRequest(req, log, w); projectKey == nil {
+			responseWritten = true
+			return
+		}
+
+		// extend log info
+		log = log.New(log15.Ctx{"projectId": projectKey.Project.ID})
+
+		// DB
+		var tx *sql.Tx
+		var commit bool
+		// deferred rollback if commit == false
+		defer func() {
+			if tx != nil && !commit {
+				txErr := tx.Rollback()
+				if txErr != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": txErr})
+					resp = ErrDatabase
+					if Debug {
+						resp.Info = fmt.Sprintf("error on rollback: %v", err)
+					}
+				}
+			}
+		}()
+		maxRetries := a.ctx.Config().Database.TransactionMaxRetries
+		var retries int
+	beginTx:
+		if retries >= maxRetries {
+			// no need to roll back
+			commit = true
+			log.Crit("too many retries on tx. aborting...", log15.Ctx{"maxRetries": maxRetries})
+			resp = ErrDatabase
+			return
+		}
+		tx, err = a.ctx.PaymentDB().Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			resp = ErrDatabase
+			return
+		}
+
+		// serialize concurrent intents on the payment, so the ledger can not change
+		// until the intent is committed
+		err = payment.LockPaymentTx(tx, paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				resp = ErrNotFound
+				return
+			}
+			if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1213 {
+				tx.Rollback()
+				retries++
+				time.Sleep(time.Second)
+				goto beginTx
+			}
+			log.Error("error locking payment", log15.Ctx{"err": err})
+			resp = ErrDatabase
+			return
+		}
+		p, err := payment.PaymentByIDTx(tx, paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				resp = ErrNotFound
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			resp = ErrDatabase
+			return
+		}
+		if projectKey.Project.ID != p.ProjectID() {
+			log.Warn("project key project and requested payment id mismatch")
+			resp = ErrUnauthorized
+			return
+		}
+
+		paymentTx, commitIntent, err := intent(req)(tx, p)
+		if err != nil {
+			if err == paymentService.ErrDBLockTimeout {
+				tx.Rollback()
+				retries++
+				time.Sleep(time.Second)
+				goto beginTx
+			}
+			resp = intentErrorResponse(log, err)
+			return
+		}
+		providerIntent, err := a.paymentService.BeginProviderIntent(tx, p, paymentTx)
+		if err != nil {
+			if err == paymentService.ErrDBLockTimeout {
+				tx.Rollback()
+				retries++
+				time.Sleep(time.Second)
+				goto beginTx
+			}
+			resp = intentErrorResponse(log, err)
+			return
+		}
+		if providerIntent != nil {
+			// the pending intent must be committed before the provider is requested
+			err = tx.Commit()
+			if err != nil {
+				if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+					// lock error
+					if mysqlErr.Number == 1213 {
+						retries++
+						time.Sleep(time.Second)
+						goto beginTx
+					}
+				}
+				commit = true
+				log.Crit("error on commit tx", log15.Ctx{"err": err})
+				resp = ErrDatabase
+				return
+			}
+			commit = true
+			resp = a.completeProviderIntent(log, projectKey, p, paymentTx, providerIntent, commitIntent, info)
+			return
+		}
+		err = a.paymentService.SetPaymentTransaction(tx, paymentTx)
+		if err != nil {
+			if err == paymentService.ErrDBLockTimeout {
+				tx.Rollback()
+				retries++
+				time.Sleep(time.Second)
+				goto beginTx
+			}
+			if _, ok := err.(*payment.TransitionError); ok {
+				resp = ErrConflict
+				resp.Info = err.Error()
+				return
+			}
+			resp = ErrDatabase
+			return
+		}
+		not, errResp, err := a.intentNotification(tx, p, projectKey, log)
+		if err != nil {
+			resp = errResp
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+				// lock error
+				if mysqlErr.Number == 1213 {
+					retries++
+					time.Sleep(time.Second)
+					goto beginTx
+				}
+			}
+			commit = true
+			log.Crit("error on commit tx", log15.Ctx{"err": err})
+			resp = ErrDatabase
+			return
+		}
+		commit = true
+		if commitIntent != nil {
+			commitIntent()
+		}
+
+		resp.Status = StatusSuccess
+		resp.Info = info
+		resp.Response = not
+	})
+}
+
+// completeProviderIntent requests the payment provider to perform the committed pending
+// intent and records the result
+//
+// The provider will be requested exactly once. Only recording the result will be
+// retried on lock timeouts.
+func (a *PaymentAPI) completeProviderIntent(
+	log log15.Logger,
+	projectKey *project.Projectkey,
+	p *payment.Payment,
+	paymentTx *payment.PaymentTransaction,
+	providerIntent *payment.PaymentProviderIntent,
+	commitIntent paymentService.CommitIntentFunc,
+	info string) ServiceResponse {
+
+	log = log.New(log15.Ctx{"idempotencyKey": providerIntent.IdempotencyKey()})
+	providerErr := a.paymentService.PerformProviderIntent(p, paymentTx, providerIntent)
+
+	var tx *sql.Tx
+	var commit bool
+	defer func() {
+		if tx != nil && !commit {
+			txErr := tx.Rollback()
+			if txErr != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": txErr})
+			}
+		}
+	}()
+	maxRetries := a.ctx.Config().Database.TransactionMaxRetries
+	var retries int
+	var err error
+beginTx:
+	if retries >= maxRetries {
+		commit = true
+		log.Crit("too many retries on tx. provider intent remains pending", log15.Ctx{"maxRetries": maxRetries})
+		return ErrDatabase
+	}
+	tx, err = a.ctx.PaymentDB().Begin()
+	if err != nil {
+		commit = true
+		log.Crit("error on begin. provider intent remains pending", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	err = payment.LockPaymentTx(tx, p.PaymentID())
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1213 {
+			tx.Rollback()
+			retries++
+			time.Sleep(time.Second)
+			goto beginTx
+		}
+		log.Error("error locking payment", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	// the payment might have changed while the provider was requested
+	p, err = payment.PaymentByIDTx(tx, p.PaymentID())
+	if err != nil {
+		log.Error("error retrieving payment", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	// the error, with which the intent was completed
+	var completeErr error
+	err = a.paymentService.CompleteProviderIntent(tx, p, paymentTx, providerIntent, providerErr)
+	if err != nil {
+		if err == paymentService.ErrDBLockTimeout {
+			tx.Rollback()
+			retries++
+			time.Sleep(time.Second)
+			goto beginTx
+		}
+		if err == paymentService.ErrProviderIntentCompleted {
+			return intentErrorResponse(log, err)
+		}
+		if _, ok := err.(*payment.TransitionError); !ok && err != paymentService.ErrRefundAmount {
+			return ErrDatabase
+		}
+		// the completed intent will be committed
+		completeErr = err
+	}
+	var not *notification.Notification
+	if providerErr == nil && completeErr == nil {
+		var errResp ServiceResponse
+		not, errResp, err = a.intentNotification(tx, p, projectKey, log)
+		if err != nil {
+			return errResp
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			// lock error
+			if mysqlErr.Number == 1213 {
+				retries++
+				time.Sleep(time.Second)
+				goto beginTx
+			}
+		}
+		commit = true
+		log.Crit("error on commit tx. provider intent remains pending", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	commit = true
+	if providerErr != nil {
+		return intentErrorResponse(log, providerErr)
+	}
+	if completeErr != nil {
+		return intentErrorResponse(log, completeErr)
+	}
+	if commitIntent != nil {
+		commitIntent()
+	}
+
+	var resp ServiceResponse
+	resp.Status = StatusSuccess
+	resp.Info = info
+	resp.Response = not
+	return resp
+}
+
+// intentNotification creates the signed response notification of the payment including
+// all payment transactions
+func (a *PaymentAPI) intentNotification(tx *sql.Tx, p *payment.Payment, projectKey *project.Projectkey, log log15.Logger) (*notification.Notification, ServiceResponse, error) {
+	tl, err := payment.PaymentTransactionsTx(tx, p)
+	if err != nil {
+		log.Error("error retrieving payment transactions", log15.Ctx{"err": err})
+		return nil, ErrDatabase, err
+	}
+	not, err := notification.New(a.paymentService.EncodedPaymentID(p.PaymentID()), p)
+	if err != nil {
+		log.Error("error creating response notification", log15.Ctx{"err": err})
+		return nil, ErrSystem, err
+	}
+	not.SetTransactions(tl)
+	non, err := nonce.New()
+	if err != nil {
+		log.Error("error creating nonce", log15.Ctx{"err": err})
+		return nil, ErrSystem, err
+	}
+	secret, err := projectKey.SecretBytes()
+	if err != nil {
+		log.Error("error retrieving project secret", log15.Ctx{"err": err})
+		return nil, ErrSystem, err
+	}
+	err = not.Sign(time.Now(), non.Nonce, secret)
+	if err != nil {
+		log.Error("error signing", log15.Ctx{"err": err})
+		return nil, ErrSystem, err
+	}
+	return not, ServiceResponse{}, nil
+}
+
+// intentErrorResponse returns the service response for errors of payment intents
+func intentErrorResponse(log log15.Logger, err error) ServiceResponse {
+	var resp ServiceResponse
+	if _, ok := err.(*payment.TransitionError); ok {
+		resp = ErrConflict
+		resp.Info = err.Error()
+		return resp
+	}
+	switch err {
+	case errCurrencyMismatch:
+		resp = ErrInval
+		resp.Info = "Currency and Subunits must match the payment"
+	case paymentService.ErrIntentNotAllowed:
+		resp = ErrConflict
+		resp.Info = "intent not allowed in current payment state"
+	case paymentService.ErrPaymentMethodDisabled:
+		resp = ErrConflict
+		resp.Info = "payment method disabled"
+	case paymentService.ErrRefundAmount:
+		resp = ErrInval
+		resp.Info = "refund amount exceeds the refundable amount"
+	case paymentService.ErrCaptureAmount:
+		resp = ErrInval
+		resp.Info = "capture amount exceeds the authorized amount"
+	case paymentService.ErrProvider:
+		resp = ErrSystem
+		resp.Info = "provider error"
+	case paymentService.ErrProviderIntentCompleted:
+		resp = ErrConflict
+		resp.Info = "intent already completed"
+	case paymentService.ErrDB:
+		resp = ErrDatabase
+	default:
+		log.Error("error on intent", log15.Ctx{"err": err})
+		resp = ErrSystem
+	}
+	return resp
+}
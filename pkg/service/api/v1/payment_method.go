@@ -24,6 +24,8 @@ type PaymentMethodRequest struct {
 	Status    string
 	CreatedBy string
 	Metadata  map[string]string
+	// Eligibility replaces the eligibility rules of the method if set
+	Eligibility *payment_method.Eligibility
 }
 
 func (a *AdminAPI) PaymentMethodGetRequest() http.Handler {
@@ -63,6 +65,12 @@ func (a *AdminAPI) PaymentMethodGetRequest() http.Handler {
 			log.Error("database error", log15.Ctx{"err": err})
 			return
 		}
+		pm.Eligibility, err = payment_method.EligibilityByPaymentMethodDB(db, pm)
+		if err != nil {
+			ErrDatabase.Write(w)
+			log.Error("database error", log15.Ctx{"err": err})
+			return
+		}
 
 		// return methods
 		resp := ProjectAdminAPIResponse{}
@@ -152,6 +160,16 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	r.Body.Close()
+	if pmr.Eligibility != nil {
+		err = pmr.Eligibility.Validate()
+		if err != nil {
+			resp := ErrInval
+			resp.Info = err.Error()
+			resp.Write(w)
+			log.Info("invalid eligibility rules", log15.Ctx{"err": err})
+			return
+		}
+	}
 
 	// Rollback handling
 	var tx *sql.Tx
@@ -234,6 +252,18 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// insert eligibility rules
+	if pmr.Eligibility != nil {
+		pmr.Eligibility.Created = time.Now()
+		pmr.Eligibility.CreatedBy = pm.CreatedBy
+		err = payment_method.InsertEligibilityTx(tx, &pm, pmr.Eligibility)
+		if err != nil {
+			ErrDatabase.Write(w)
+			log.Error("database error", log15.Ctx{"err": err})
+			return
+		}
+	}
+
 	// get payment_method from db with all set values like status created
 	pmdb, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(tx, pm.ProjectID, pm.Provider.Name, pm.MethodKey)
 	if err != nil {
@@ -241,6 +271,7 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 		log.Error("database error", log15.Ctx{"err": err})
 		return
 	}
+	pmdb.Eligibility = pmr.Eligibility
 
 	commit = true
 	err = tx.Commit()
@@ -290,6 +321,16 @@ func (a *AdminAPI) postChangePaymentMethod(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	r.Body.Close()
+	if pmr.Eligibility != nil {
+		err = pmr.Eligibility.Validate()
+		if err != nil {
+			resp := ErrInval
+			resp.Info = err.Error()
+			resp.Write(w)
+			log.Info("invalid eligibility rules", log15.Ctx{"err": err})
+			return
+		}
+	}
 
 	// Rollback handling
 	var tx *sql.Tx
@@ -370,6 +411,18 @@ func (a *AdminAPI) postChangePaymentMethod(w http.ResponseWriter, r *http.Reques
 		}
 		pm.Metadata = pmmd
 	}
+	// insert eligibility rules if set
+	if pmr.Eligibility != nil {
+		pmr.Eligibility.Created = time.Now()
+		pmr.Eligibility.CreatedBy = auth[AuthUserIDKey].(string)
+		err = payment_method.InsertEligibilityTx(tx, pm, pmr.Eligibility)
+		if err != nil {
+			ErrDatabase.Write(w)
+			log.Error("database error", log15.Ctx{"err": err})
+			return
+		}
+		pm.Eligibility = pmr.Eligibility
+	}
 
 	resp := ProjectAdminAPIResponse{}
 	resp.Status = StatusSuccess
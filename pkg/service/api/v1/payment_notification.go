@@ -0,0 +1,237 @@
+package v1
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// PaymentNotificationState is the response JSON struct for the delivery state of a
+// notification for a single payment transaction
+type PaymentNotificationState struct {
+	TransactionTimestamp int64 `json:",string"`
+	Timestamp            int64 `json:",string"`
+	Status               string
+	Attempts             int
+	NextAttempt          int64  `json:",string"`
+	Error                string `json:",omitempty"`
+}
+
+// PaymentNotificationAttempt is the response JSON struct for a single notification
+// delivery attempt
+type PaymentNotificationAttempt struct {
+	TransactionTimestamp int64 `json:",string"`
+	Timestamp            int64 `json:",string"`
+	URL                  string
+	APIVersion           string
+	HTTPStatusCode       int64 `json:",omitempty"`
+	// latency in milliseconds
+	Latency      int64
+	ResponseBody string `json:",omitempty"`
+	Error        string `json:",omitempty"`
+}
+
+// PaymentNotificationResponse is the response JSON struct for
+// GET /project/{projectid}/payment/{paymentid}/notification
+type PaymentNotificationResponse struct {
+	PaymentId     payment.PaymentID
+	Notifications []PaymentNotificationState
+	Attempts      []PaymentNotificationAttempt
+}
+
+// PaymentNotificationRequest returns the handler for the notification log of a payment
+//
+// GET returns the delivery state and all delivery attempts of the notifications
+// POST re-sends the notification for the current payment transaction
+func (a *AdminAPI) PaymentNotificationRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "PaymentNotificationRequest"})
+
+		switch r.Method {
+		case "GET":
+			a.getPaymentNotifications(w, r)
+		case "POST":
+			a.postResendPaymentNotification(w, r)
+		default:
+			ErrMethod.Write(w)
+			log.Info("http method not supported", log15.Ctx{"requestMethod": r.Method})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// reads the (encoded) payment id from the request path and returns the decoded payment
+// id
+func (a *AdminAPI) readProjectPaymentID(r *http.Request) (payment.PaymentID, bool) {
+	vars := mux.Vars(r)
+	projectID, err := strconv.ParseInt(vars["projectid"], 10, 64)
+	if err != nil {
+		return payment.PaymentID{}, false
+	}
+	id, err := payment.ParsePaymentIDStr(vars["paymentid"])
+	if err != nil {
+		return id, false
+	}
+	if id.ProjectID != projectID {
+		return id, false
+	}
+	return a.paymentService.DecodedPaymentID(id), true
+}
+
+func (a *AdminAPI) getPaymentNotifications(w http.ResponseWriter, r *http.Request) {
+	log := a.log.New(log15.Ctx{"method": "PaymentNotification GET Request"})
+	id, ok := a.readProjectPaymentID(r)
+	if !ok {
+		ErrReadParam.Write(w)
+		log.Info("malformed param", log15.Ctx{"paymentIdParam": mux.Vars(r)["paymentid"]})
+		return
+	}
+	log = log.New(log15.Ctx{"projectID": id.ProjectID, "paymentID": id.PaymentID})
+
+	db := a.ctx.PaymentDB()
+	p, err := payment.PaymentByIDDB(db, id)
+	if err != nil {
+		if err == payment.ErrPaymentNotFound {
+			ErrNotFound.Write(w)
+			return
+		}
+		ErrDatabase.Write(w)
+		log.Error("error retrieving payment", log15.Ctx{"err": err})
+		return
+	}
+	ns, err := payment.PaymentNotificationsDB(db, p.PaymentID())
+	if err != nil {
+		ErrDatabase.Write(w)
+		log.Error("error retrieving notifications", log15.Ctx{"err": err})
+		return
+	}
+	as, err := payment.PaymentNotificationAttemptsDB(db, p.PaymentID())
+	if err != nil {
+		ErrDatabase.Write(w)
+		log.Error("error retrieving notification attempts", log15.Ctx{"err": err})
+		return
+	}
+
+	resp := ProjectAdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = "notifications found"
+	resp.Response = a.paymentNotificationResponse(p, ns, as)
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("error writing response", log15.Ctx{"err": err})
+	}
+}
+
+func (a *AdminAPI) paymentNotificationResponse(
+	p *payment.Payment,
+	ns []*payment.PaymentNotification,
+	as []*payment.PaymentNotificationAttempt) *PaymentNotificationResponse {
+
+	resp := &PaymentNotificationResponse{
+		PaymentId:     a.paymentService.EncodedPaymentID(p.PaymentID()),
+		Notifications: make([]PaymentNotificationState, 0, len(ns)),
+		Attempts:      make([]PaymentNotificationAttempt, 0, len(as)),
+	}
+	for _, n := range ns {
+		resp.Notifications = append(resp.Notifications, PaymentNotificationState{
+			TransactionTimestamp: n.TransactionTimestamp.UnixNano(),
+			Timestamp:            n.Timestamp.UnixNano(),
+			Status:               n.Status.String(),
+			Attempts:             n.Attempts,
+			NextAttempt:          n.NextAttempt.UnixNano(),
+			Error:                n.Error.String,
+		})
+	}
+	for _, at := range as {
+		resp.Attempts = append(resp.Attempts, PaymentNotificationAttempt{
+			TransactionTimestamp: at.TransactionTimestamp.UnixNano(),
+			Timestamp:            at.Timestamp.UnixNano(),
+			URL:                  at.URL,
+			APIVersion:           at.APIVersion,
+			HTTPStatusCode:       at.HTTPStatusCode.Int64,
+			Latency:              int64(at.Latency / time.Millisecond),
+			ResponseBody:         at.ResponseBody.String,
+			Error:                at.Error.String,
+		})
+	}
+	return resp
+}
+
+func (a *AdminAPI) postResendPaymentNotification(w http.ResponseWriter, r *http.Request) {
+	log := a.log.New(log15.Ctx{"method": "PaymentNotification POST Request"})
+	id, ok := a.readProjectPaymentID(r)
+	if !ok {
+		ErrReadParam.Write(w)
+		log.Info("malformed param", log15.Ctx{"paymentIdParam": mux.Vars(r)["paymentid"]})
+		return
+	}
+	log = log.New(log15.Ctx{"projectID": id.ProjectID, "paymentID": id.PaymentID})
+
+	// Rollback handling
+	var tx *sql.Tx
+	var commit bool
+	defer func() {
+		if tx != nil && !commit {
+			err := tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	var err error
+	tx, err = a.ctx.PaymentDB().Begin()
+	if err != nil {
+		ErrDatabase.Write(w)
+		log.Error("error on begin", log15.Ctx{"err": err})
+		return
+	}
+	p, err := payment.PaymentByIDTx(tx, id)
+	if err != nil {
+		if err == payment.ErrPaymentNotFound {
+			ErrNotFound.Write(w)
+			return
+		}
+		ErrDatabase.Write(w)
+		log.Error("error retrieving payment", log15.Ctx{"err": err})
+		return
+	}
+	commitIntent, err := a.paymentService.ResendNotification(tx, p)
+	if err != nil {
+		resp := ErrDatabase
+		switch err {
+		case paymentService.ErrIntentNotAllowed:
+			resp = ErrConflict
+			resp.Info = "payment is not initialized"
+		case paymentService.ErrPaymentCallbackConfig:
+			resp = ErrConflict
+			resp.Info = "no callback configured"
+		default:
+			log.Error("error resending notification", log15.Ctx{"err": err})
+		}
+		resp.Write(w)
+		return
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		ErrDatabase.Write(w)
+		log.Error("error on commit", log15.Ctx{"err": err})
+		return
+	}
+	commitIntent()
+
+	resp := ProjectAdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = "notification enqueued"
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("error writing response", log15.Ctx{"err": err})
+	}
+}
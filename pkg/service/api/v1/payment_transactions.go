@@ -0,0 +1,218 @@
+package v1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/maputil"
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// PaymentTransactionEntry is the response JSON struct for a single payment transaction
+type PaymentTransactionEntry struct {
+	Timestamp     int64 `json:",string"`
+	Amount        int64 `json:",string"`
+	Subunits      int8  `json:",string"`
+	DecimalAmount string
+	Currency      string
+	Status        string
+	Comment       string `json:",omitempty"`
+}
+
+// PaymentTransactionsResponse is the response JSON struct for
+// GET /payment/paymentId/{paymentId}/transactions
+type PaymentTransactionsResponse struct {
+	PaymentId    payment.PaymentID
+	Ident        string
+	Transactions []PaymentTransactionEntry
+	Balance      payment.Balance
+	Timestamp    int64 `json:",string"`
+	Nonce        string
+	Signature    string
+}
+
+func (r *PaymentTransactionsResponse) setTransactions(tl payment.PaymentTransactionList) {
+	r.Balance = tl.Balance()
+	r.Transactions = make([]PaymentTransactionEntry, 0, len(tl))
+	for _, tx := range tl {
+		r.Transactions = append(r.Transactions, PaymentTransactionEntry{
+			Timestamp:     tx.Timestamp.UnixNano(),
+			Amount:        tx.Amount,
+			Subunits:      tx.Subunits,
+			DecimalAmount: tx.Decimal().String(),
+			Currency:      tx.Currency,
+			Status:        tx.Status.String(),
+			Comment:       tx.Comment.String,
+		})
+	}
+}
+
+func (r *PaymentTransactionsResponse) Sign(timestamp time.Time, nonce string, secret []byte) error {
+	r.Timestamp = timestamp.Unix()
+	r.Nonce = nonce
+	sig, err := service.Sign(r, secret)
+	if err != nil {
+		return err
+	}
+	r.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+// Message returns the signature base string
+//
+// It is the concatenation of the PaymentId, the Ident, the fields of all transactions
+// in order, the sorted balance, the Timestamp and the Nonce.
+func (r *PaymentTransactionsResponse) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	_, err = buf.WriteString(r.PaymentId.String())
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.WriteString(r.Ident)
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	for _, tx := range r.Transactions {
+		_, err = buf.WriteString(strconv.FormatInt(tx.Timestamp, 10))
+		if err != nil {
+			return nil, fmt.Errorf("buffer write error: %v", err)
+		}
+		_, err = buf.WriteString(strconv.FormatInt(tx.Amount, 10))
+		if err != nil {
+			return nil, fmt.Errorf("buffer write error: %v", err)
+		}
+		_, err = buf.WriteString(strconv.FormatInt(int64(tx.Subunits), 10))
+		if err != nil {
+			return nil, fmt.Errorf("buffer write error: %v", err)
+		}
+		_, err = buf.WriteString(tx.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("buffer write error: %v", err)
+		}
+		_, err = buf.WriteString(tx.Status)
+		if err != nil {
+			return nil, fmt.Errorf("buffer write error: %v", err)
+		}
+		_, err = buf.WriteString(tx.Comment)
+		if err != nil {
+			return nil, fmt.Errorf("buffer write error: %v", err)
+		}
+	}
+	if r.Balance != nil {
+		err = maputil.WriteSortedMap(buf, r.Balance.FlatMap())
+		if err != nil {
+			return nil, fmt.Errorf("buffer write error: %v", err)
+		}
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.WriteString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *PaymentTransactionsResponse) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+// GetPaymentTransactions returns the handler for the transaction history of a payment
+//
+// The request is authenticated the same way as the GetPayment request.
+func (a *PaymentAPI) GetPaymentTransactions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{
+			"method": "GetPaymentTransactions",
+		})
+		var err error
+		req := &GetPaymentRequest{}
+		err = req.ReadFromRequest(r)
+		if err != nil || req.PaymentId == "" {
+			ret := ErrReadParam
+			if Debug && err != nil {
+				ret.Info = err.Error()
+			}
+			ret.Write(w)
+			return
+		}
+		req.paymentID = a.paymentService.DecodedPaymentID(req.paymentID)
+		log = log.New(log15.Ctx{
+			"DisplayPaymentId": req.PaymentId,
+		})
+		var projectKey *project.Projectkey
+		if projectKey = a.authenticateRequest(req, log, w); projectKey == nil {
+			return
+		}
+		db := a.ctx.PaymentDB(service.ReadOnly)
+		p, err := payment.PaymentByIDDB(db, req.paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if projectKey.Project.ID != p.ProjectID() {
+			log.Warn("project key project and requested payment id mismatch", log15.Ctx{
+				"projectID": projectKey.Project.ID,
+			})
+			ErrUnauthorized.Write(w)
+			return
+		}
+
+		txResp := &PaymentTransactionsResponse{
+			PaymentId: a.paymentService.EncodedPaymentID(p.PaymentID()),
+			Ident:     p.Ident,
+		}
+		tl, err := payment.PaymentTransactionsDB(db, p)
+		if err != nil && err != payment.ErrPaymentTransactionNotFound {
+			log.Error("error retrieving payment transactions", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		txResp.setTransactions(tl)
+
+		non, err := nonce.New()
+		if err != nil {
+			log.Error("error creating nonce", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		secret, err := projectKey.SecretBytes()
+		if err != nil {
+			log.Error("error retrieving project secret", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		err = txResp.Sign(time.Now(), non.Nonce, secret)
+		if err != nil {
+			log.Error("error signing", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+
+		resp := ServiceResponse{}
+		resp.Status = StatusSuccess
+		resp.HttpStatus = http.StatusOK
+		resp.Info = "returning payment transactions"
+		resp.Response = txResp
+		resp.Write(w)
+	})
+}
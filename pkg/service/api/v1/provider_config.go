@@ -0,0 +1,531 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/provider/paypal_rest"
+	"github.com/fritzpay/paymentd/pkg/service/provider/sepa"
+	"github.com/fritzpay/paymentd/pkg/service/provider/stripe"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// provider names of the providers with a config per payment method
+const (
+	providerPaypalREST = "paypal_rest"
+	providerStripe     = "stripe"
+	providerSEPA       = "sepa"
+)
+
+var (
+	errProviderConfigUnsupported = errors.New("provider has no config")
+	errProviderConfigNotFound    = errors.New("config not found")
+	errProviderConfigVersion     = errors.New("config version mismatch")
+)
+
+// providerConfigError is returned on invalid provider configs
+type providerConfigError string
+
+func (e providerConfigError) Error() string {
+	return string(e)
+}
+
+// ProviderConfigRequest is the request JSON struct for PUT
+// project/{projectid}/method/{methodkey}/provider/{provider}/config
+//
+// Secrets which are omitted will be taken from the current version.
+type ProviderConfigRequest struct {
+	// Version is the version the change is based on. If it is set and does not match
+	// the current version, the request will fail with a conflict.
+	Version int64 `json:",string"`
+	// Config is the provider specific config, i.e. a PaypalConfigRequest
+	Config json.RawMessage
+}
+
+// ProviderConfigResponse is the response JSON struct for
+// project/{projectid}/method/{methodkey}/provider/{provider}/config
+//
+// Secrets are write-only. The response will only tell whether they are set.
+type ProviderConfigResponse struct {
+	ProjectID int64
+	MethodKey string
+	Provider  string
+	// Version is the UNIX timestamp of the creation of the config version
+	Version   int64 `json:",string"`
+	Created   time.Time
+	CreatedBy string
+	Config    interface{}
+	// History contains the previous versions, the most recent first
+	History []*ProviderConfigResponse `json:",omitempty"`
+}
+
+// PaypalConfigRequest is the config of a PayPal payment method
+type PaypalConfigRequest struct {
+	Endpoint  string
+	ClientID  string
+	Secret    string
+	Type      string
+	WebhookID string
+}
+
+// PaypalConfigResponse is the config of a PayPal payment method without secrets
+type PaypalConfigResponse struct {
+	Endpoint  string
+	ClientID  string
+	SecretSet bool
+	Type      string
+	WebhookID string `json:",omitempty"`
+}
+
+// StripeConfigRequest is the config of a Stripe payment method
+type StripeConfigRequest struct {
+	Endpoint      string
+	SecretKey     string
+	PublicKey     string
+	WebhookSecret string
+}
+
+// StripeConfigResponse is the config of a Stripe payment method without secrets
+type StripeConfigResponse struct {
+	Endpoint         string
+	SecretKeySet     bool
+	PublicKey        string
+	WebhookSecretSet bool
+}
+
+// SEPAConfig is the creditor config of a SEPA payment method
+type SEPAConfig struct {
+	CreditorName string
+	CreditorID   string
+	IBAN         string
+	BIC          string
+}
+
+func paypalConfigResponse(cfg *paypal_rest.Config) *ProviderConfigResponse {
+	return &ProviderConfigResponse{
+		ProjectID: cfg.ProjectID,
+		MethodKey: cfg.MethodKey,
+		Provider:  providerPaypalREST,
+		Version:   cfg.Created.Unix(),
+		Created:   cfg.Created,
+		CreatedBy: cfg.CreatedBy,
+		Config: PaypalConfigResponse{
+			Endpoint:  cfg.Endpoint,
+			ClientID:  cfg.ClientID,
+			SecretSet: cfg.Secret != "",
+			Type:      cfg.Type,
+			WebhookID: cfg.WebhookID.String,
+		},
+	}
+}
+
+func stripeConfigResponse(cfg *stripe.Config) *ProviderConfigResponse {
+	return &ProviderConfigResponse{
+		ProjectID: cfg.ProjectID,
+		MethodKey: cfg.MethodKey,
+		Provider:  providerStripe,
+		Version:   cfg.Created.Unix(),
+		Created:   cfg.Created,
+		CreatedBy: cfg.CreatedBy,
+		Config: StripeConfigResponse{
+			Endpoint:         cfg.Endpoint,
+			SecretKeySet:     cfg.SecretKey != "",
+			PublicKey:        cfg.PublicKey,
+			WebhookSecretSet: cfg.WebhookSecret != "",
+		},
+	}
+}
+
+func sepaConfigResponse(cfg *sepa.Config) *ProviderConfigResponse {
+	return &ProviderConfigResponse{
+		ProjectID: cfg.ProjectID,
+		MethodKey: cfg.MethodKey,
+		Provider:  providerSEPA,
+		Version:   cfg.Created.Unix(),
+		Created:   cfg.Created,
+		CreatedBy: cfg.CreatedBy,
+		Config: SEPAConfig{
+			CreditorName: cfg.CreditorName,
+			CreditorID:   cfg.CreditorID,
+			IBAN:         cfg.IBAN,
+			BIC:          cfg.BIC,
+		},
+	}
+}
+
+// providerConfigHistory returns the current config of the payment method with its
+// previous versions
+func providerConfigHistory(db *sql.DB, pm *payment_method.Method) (*ProviderConfigResponse, error) {
+	var versions []*ProviderConfigResponse
+	switch pm.Provider.Name {
+	case providerPaypalREST:
+		cfgs, err := paypal_rest.ConfigHistoryByPaymentMethodDB(db, pm)
+		if err != nil {
+			if err == paypal_rest.ErrConfigNotFound {
+				return nil, errProviderConfigNotFound
+			}
+			return nil, err
+		}
+		for _, cfg := range cfgs {
+			versions = append(versions, paypalConfigResponse(cfg))
+		}
+	case providerStripe:
+		cfgs, err := stripe.ConfigHistoryByPaymentMethodDB(db, pm)
+		if err != nil {
+			if err == stripe.ErrConfigNotFound {
+				return nil, errProviderConfigNotFound
+			}
+			return nil, err
+		}
+		for _, cfg := range cfgs {
+			versions = append(versions, stripeConfigResponse(cfg))
+		}
+	case providerSEPA:
+		cfgs, err := sepa.ConfigHistoryByPaymentMethodDB(db, pm)
+		if err != nil {
+			if err == sepa.ErrConfigNotFound {
+				return nil, errProviderConfigNotFound
+			}
+			return nil, err
+		}
+		for _, cfg := range cfgs {
+			versions = append(versions, sepaConfigResponse(cfg))
+		}
+	default:
+		return nil, errProviderConfigUnsupported
+	}
+	resp := versions[0]
+	resp.History = versions[1:]
+	return resp, nil
+}
+
+// checks the requested base version against the version of the current config
+func checkProviderConfigVersion(version int64, current time.Time, found bool) error {
+	if version == 0 {
+		return nil
+	}
+	if !found || current.Unix() != version {
+		return errProviderConfigVersion
+	}
+	return nil
+}
+
+// insertProviderConfig saves a new version of the config of the payment method
+//
+// It returns the new version.
+func insertProviderConfig(tx *sql.Tx, pm *payment_method.Method, req *ProviderConfigRequest, created time.Time, createdBy string) (*ProviderConfigResponse, error) {
+	// serialize config changes of the payment method, so the current version can not
+	// change until the new version is inserted
+	err := payment_method.LockPaymentMethodTx(tx, pm.ID)
+	if err != nil {
+		return nil, err
+	}
+	switch pm.Provider.Name {
+	case providerPaypalREST:
+		current, err := paypal_rest.ConfigByPaymentMethodTx(tx, pm)
+		if err != nil && err != paypal_rest.ErrConfigNotFound {
+			return nil, err
+		}
+		if err := checkProviderConfigVersion(req.Version, current.Created, err == nil); err != nil {
+			return nil, err
+		}
+		cfg, err := newPaypalConfig(pm, req.Config, current, created, createdBy)
+		if err != nil {
+			return nil, err
+		}
+		err = paypal_rest.InsertConfigTx(tx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return paypalConfigResponse(cfg), nil
+
+	case providerStripe:
+		current, err := stripe.ConfigByPaymentMethodTx(tx, pm)
+		if err != nil && err != stripe.ErrConfigNotFound {
+			return nil, err
+		}
+		if err := checkProviderConfigVersion(req.Version, current.Created, err == nil); err != nil {
+			return nil, err
+		}
+		cfg, err := newStripeConfig(pm, req.Config, current, created, createdBy)
+		if err != nil {
+			return nil, err
+		}
+		err = stripe.InsertConfigTx(tx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return stripeConfigResponse(cfg), nil
+
+	case providerSEPA:
+		current, err := sepa.ConfigByPaymentMethodTx(tx, pm)
+		if err != nil && err != sepa.ErrConfigNotFound {
+			return nil, err
+		}
+		if err := checkProviderConfigVersion(req.Version, current.Created, err == nil); err != nil {
+			return nil, err
+		}
+		cfg, err := newSEPAConfig(pm, req.Config, created, createdBy)
+		if err != nil {
+			return nil, err
+		}
+		err = sepa.InsertConfigTx(tx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return sepaConfigResponse(cfg), nil
+
+	default:
+		return nil, errProviderConfigUnsupported
+	}
+}
+
+// newPaypalConfig creates a new version of the PayPal config from the request config
+//
+// An omitted secret will be taken from the current config.
+func newPaypalConfig(pm *payment_method.Method, raw json.RawMessage, current *paypal_rest.Config, created time.Time, createdBy string) (*paypal_rest.Config, error) {
+	cr := PaypalConfigRequest{}
+	if err := json.Unmarshal(raw, &cr); err != nil {
+		return nil, providerConfigError("invalid Config: " + err.Error())
+	}
+	cfg := &paypal_rest.Config{
+		ProjectID: pm.ProjectID,
+		MethodKey: pm.MethodKey,
+		Created:   created,
+		CreatedBy: createdBy,
+		Endpoint:  cr.Endpoint,
+		ClientID:  cr.ClientID,
+		Secret:    cr.Secret,
+		Type:      cr.Type,
+	}
+	if cr.WebhookID != "" {
+		cfg.WebhookID.String, cfg.WebhookID.Valid = cr.WebhookID, true
+	}
+	if cfg.Secret == "" {
+		cfg.Secret = current.Secret
+	}
+	if cfg.Endpoint == "" || cfg.ClientID == "" || cfg.Secret == "" {
+		return nil, providerConfigError("Endpoint, ClientID and Secret are required")
+	}
+	if cfg.Type != paypal_rest.IntentSale && cfg.Type != paypal_rest.IntentAuth {
+		return nil, providerConfigError("invalid Type")
+	}
+	return cfg, nil
+}
+
+// newStripeConfig creates a new version of the Stripe config from the request config
+//
+// Omitted secrets will be taken from the current config.
+func newStripeConfig(pm *payment_method.Method, raw json.RawMessage, current *stripe.Config, created time.Time, createdBy string) (*stripe.Config, error) {
+	cr := StripeConfigRequest{}
+	if err := json.Unmarshal(raw, &cr); err != nil {
+		return nil, providerConfigError("invalid Config: " + err.Error())
+	}
+	cfg := &stripe.Config{
+		ProjectID:     pm.ProjectID,
+		MethodKey:     pm.MethodKey,
+		Created:       created,
+		CreatedBy:     createdBy,
+		Endpoint:      cr.Endpoint,
+		SecretKey:     cr.SecretKey,
+		PublicKey:     cr.PublicKey,
+		WebhookSecret: cr.WebhookSecret,
+	}
+	if cfg.SecretKey == "" {
+		cfg.SecretKey = current.SecretKey
+	}
+	if cfg.WebhookSecret == "" {
+		cfg.WebhookSecret = current.WebhookSecret
+	}
+	if cfg.SecretKey == "" || cfg.PublicKey == "" {
+		return nil, providerConfigError("SecretKey and PublicKey are required")
+	}
+	return cfg, nil
+}
+
+// newSEPAConfig creates a new version of the SEPA config from the request config
+func newSEPAConfig(pm *payment_method.Method, raw json.RawMessage, created time.Time, createdBy string) (*sepa.Config, error) {
+	cr := SEPAConfig{}
+	if err := json.Unmarshal(raw, &cr); err != nil {
+		return nil, providerConfigError("invalid Config: " + err.Error())
+	}
+	cfg := &sepa.Config{
+		ProjectID:    pm.ProjectID,
+		MethodKey:    pm.MethodKey,
+		Created:      created,
+		CreatedBy:    createdBy,
+		CreditorName: cr.CreditorName,
+		CreditorID:   cr.CreditorID,
+		IBAN:         sepa.NormalizeIBAN(cr.IBAN),
+		BIC:          sepa.NormalizeBIC(cr.BIC),
+	}
+	if cfg.CreditorName == "" || len(cfg.CreditorName) > 70 {
+		return nil, providerConfigError("invalid CreditorName")
+	}
+	if sepa.ValidateCreditorID(cfg.CreditorID) != nil {
+		return nil, providerConfigError("invalid CreditorID")
+	}
+	if sepa.ValidateIBAN(cfg.IBAN) != nil {
+		return nil, providerConfigError("invalid IBAN")
+	}
+	if cfg.BIC != "" && sepa.ValidateBIC(cfg.BIC) != nil {
+		return nil, providerConfigError("invalid BIC")
+	}
+	return cfg, nil
+}
+
+// ProviderConfigRequest returns the handler for the provider config of a payment method
+//
+// GET returns the current config with its previous versions
+// PUT creates a new version of the config
+func (a *AdminAPI) ProviderConfigRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProviderConfigRequest"})
+
+		vars := mux.Vars(r)
+		projectID, err := strconv.ParseInt(vars["projectid"], 10, 64)
+		if err != nil {
+			ErrReadParam.Write(w)
+			log.Info("malformed param", log15.Ctx{"projectIdParam": vars["projectid"]})
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":    projectID,
+			"methodKey":    vars["methodkey"],
+			"providerName": vars["provider"],
+		})
+		pm, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyDB(a.ctx.PaymentDB(service.ReadOnly), projectID, vars["provider"], vars["methodkey"])
+		if err != nil {
+			if err == payment_method.ErrPaymentMethodNotFound {
+				ErrNotFound.Write(w)
+				log.Info("payment method not found")
+				return
+			}
+			ErrDatabase.Write(w)
+			log.Error("database error", log15.Ctx{"err": err})
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			a.getProviderConfig(w, pm, log)
+		case "PUT":
+			a.putProviderConfig(w, r, pm, log)
+		default:
+			ErrMethod.Write(w)
+			log.Info("http method not supported", log15.Ctx{"requestMethod": r.Method})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) getProviderConfig(w http.ResponseWriter, pm *payment_method.Method, log log15.Logger) {
+	cfg, err := providerConfigHistory(a.ctx.PaymentDB(service.ReadOnly), pm)
+	if err != nil {
+		if err == errProviderConfigNotFound || err == errProviderConfigUnsupported {
+			resp := ErrNotFound
+			resp.Info = err.Error()
+			resp.Write(w)
+			return
+		}
+		ErrDatabase.Write(w)
+		log.Error("error retrieving provider config", log15.Ctx{"err": err})
+		return
+	}
+
+	resp := ProjectAdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = "provider config found"
+	resp.Response = cfg
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("error writing response", log15.Ctx{"err": err})
+	}
+}
+
+func (a *AdminAPI) putProviderConfig(w http.ResponseWriter, r *http.Request, pm *payment_method.Method, log log15.Logger) {
+	req := &ProviderConfigRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	r.Body.Close()
+	if err != nil || req.Config == nil {
+		ErrReadJson.Write(w)
+		log.Info("json decoding failed", log15.Ctx{"err": err})
+		return
+	}
+
+	tx, err := a.ctx.PaymentDB().Begin()
+	if err != nil {
+		ErrDatabase.Write(w)
+		log.Error("error on begin tx", log15.Ctx{"err": err})
+		return
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			err := tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+
+	auth := service.RequestContextAuth(r)
+	created := time.Now().UTC().Truncate(time.Second)
+	cfg, err := insertProviderConfig(tx, pm, req, created, auth[AuthUserIDKey].(string))
+	if err != nil {
+		switch err {
+		case errProviderConfigUnsupported, payment_method.ErrPaymentMethodNotFound:
+			resp := ErrNotFound
+			resp.Info = err.Error()
+			resp.Write(w)
+			return
+		case errProviderConfigVersion:
+			resp := ErrConflict
+			resp.Info = "config was changed in the meantime"
+			resp.Write(w)
+			return
+		}
+		if _, ok := err.(providerConfigError); ok {
+			resp := ErrInval
+			resp.Info = err.Error()
+			resp.Write(w)
+			return
+		}
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+			// a version with the same timestamp already exists
+			resp := ErrConflict
+			resp.Info = "config was changed in the meantime"
+			resp.Write(w)
+			return
+		}
+		ErrDatabase.Write(w)
+		log.Error("error saving provider config", log15.Ctx{"err": err})
+		return
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		ErrDatabase.Write(w)
+		log.Error("error on commit", log15.Ctx{"err": err})
+		return
+	}
+	log.Info("provider config changed", log15.Ctx{"version": cfg.Version})
+
+	resp := ProjectAdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = "provider config version " + strconv.FormatInt(cfg.Version, 10) + " created"
+	resp.Response = cfg
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("error writing response", log15.Ctx{"err": err})
+	}
+}
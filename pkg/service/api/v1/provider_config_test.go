@@ -0,0 +1,204 @@
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service/provider/paypal_rest"
+	"github.com/fritzpay/paymentd/pkg/service/provider/sepa"
+	"github.com/fritzpay/paymentd/pkg/service/provider/stripe"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProviderConfigVersion(t *testing.T) {
+	Convey("Given a current config version", t, func() {
+		current := time.Unix(1420070400, 0)
+
+		Convey("When the request has no version", func() {
+			Convey("It should be accepted", func() {
+				So(checkProviderConfigVersion(0, current, true), ShouldBeNil)
+				So(checkProviderConfigVersion(0, time.Time{}, false), ShouldBeNil)
+			})
+		})
+		Convey("When the request is based on the current version", func() {
+			Convey("It should be accepted", func() {
+				So(checkProviderConfigVersion(current.Unix(), current, true), ShouldBeNil)
+			})
+		})
+		Convey("When the request is based on a previous version", func() {
+			Convey("It should be rejected", func() {
+				So(checkProviderConfigVersion(current.Unix()-1, current, true), ShouldEqual, errProviderConfigVersion)
+			})
+		})
+		Convey("When the request is based on a version, but there is no config", func() {
+			Convey("It should be rejected", func() {
+				So(checkProviderConfigVersion(current.Unix(), time.Time{}, false), ShouldEqual, errProviderConfigVersion)
+			})
+		})
+	})
+}
+
+func TestPaypalProviderConfig(t *testing.T) {
+	Convey("Given a PayPal payment method with a current config", t, func() {
+		pm := &payment_method.Method{ProjectID: 1, MethodKey: "paypal"}
+		current := &paypal_rest.Config{Secret: "current"}
+		created := time.Now()
+
+		Convey("When a valid config without a secret is requested", func() {
+			cfg, err := newPaypalConfig(pm, json.RawMessage(`{"Endpoint":"https://api.sandbox.paypal.com","ClientID":"client","Type":"`+paypal_rest.IntentSale+`"}`), current, created, "test")
+
+			Convey("It should keep the current secret", func() {
+				So(err, ShouldBeNil)
+				So(cfg.Secret, ShouldEqual, "current")
+				So(cfg.ClientID, ShouldEqual, "client")
+				So(cfg.Created, ShouldResemble, created)
+				So(cfg.CreatedBy, ShouldEqual, "test")
+			})
+		})
+		Convey("When a new secret is requested", func() {
+			cfg, err := newPaypalConfig(pm, json.RawMessage(`{"Endpoint":"https://api.sandbox.paypal.com","ClientID":"client","Secret":"new","Type":"`+paypal_rest.IntentAuth+`"}`), current, created, "test")
+
+			Convey("It should use the new secret", func() {
+				So(err, ShouldBeNil)
+				So(cfg.Secret, ShouldEqual, "new")
+			})
+		})
+		Convey("When the secret is omitted and there is no current config", func() {
+			_, err := newPaypalConfig(pm, json.RawMessage(`{"Endpoint":"https://api.sandbox.paypal.com","ClientID":"client","Type":"`+paypal_rest.IntentSale+`"}`), &paypal_rest.Config{}, created, "test")
+
+			Convey("It should be rejected", func() {
+				So(err, ShouldHaveSameTypeAs, providerConfigError(""))
+			})
+		})
+		Convey("When the ClientID is missing", func() {
+			_, err := newPaypalConfig(pm, json.RawMessage(`{"Endpoint":"https://api.sandbox.paypal.com","Type":"`+paypal_rest.IntentSale+`"}`), current, created, "test")
+
+			Convey("It should be rejected", func() {
+				So(err, ShouldHaveSameTypeAs, providerConfigError(""))
+			})
+		})
+		Convey("When the Type is invalid", func() {
+			_, err := newPaypalConfig(pm, json.RawMessage(`{"Endpoint":"https://api.sandbox.paypal.com","ClientID":"client","Type":"order"}`), current, created, "test")
+
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, providerConfigError("invalid Type"))
+			})
+		})
+		Convey("When the config is not a JSON object", func() {
+			_, err := newPaypalConfig(pm, json.RawMessage(`"config"`), current, created, "test")
+
+			Convey("It should be rejected", func() {
+				So(err, ShouldHaveSameTypeAs, providerConfigError(""))
+			})
+		})
+
+		Convey("When the config is returned", func() {
+			cfg, err := newPaypalConfig(pm, json.RawMessage(`{"Endpoint":"https://api.sandbox.paypal.com","ClientID":"client","Type":"`+paypal_rest.IntentSale+`"}`), current, created, "test")
+			So(err, ShouldBeNil)
+			resp, err := json.Marshal(paypalConfigResponse(cfg))
+			So(err, ShouldBeNil)
+
+			Convey("It should not contain the secret", func() {
+				So(string(resp), ShouldNotContainSubstring, "current")
+				So(string(resp), ShouldContainSubstring, `"SecretSet":true`)
+			})
+		})
+	})
+}
+
+func TestStripeProviderConfig(t *testing.T) {
+	Convey("Given a Stripe payment method with a current config", t, func() {
+		pm := &payment_method.Method{ProjectID: 1, MethodKey: "stripe"}
+		current := &stripe.Config{SecretKey: "sk_current", WebhookSecret: "whsec_current"}
+		created := time.Now()
+
+		Convey("When a config without secrets is requested", func() {
+			cfg, err := newStripeConfig(pm, json.RawMessage(`{"PublicKey":"pk_new"}`), current, created, "test")
+
+			Convey("It should keep the current secrets", func() {
+				So(err, ShouldBeNil)
+				So(cfg.PublicKey, ShouldEqual, "pk_new")
+				So(cfg.SecretKey, ShouldEqual, "sk_current")
+				So(cfg.WebhookSecret, ShouldEqual, "whsec_current")
+			})
+		})
+		Convey("When new secrets are requested", func() {
+			cfg, err := newStripeConfig(pm, json.RawMessage(`{"PublicKey":"pk_new","SecretKey":"sk_new","WebhookSecret":"whsec_new"}`), current, created, "test")
+
+			Convey("It should use the new secrets", func() {
+				So(err, ShouldBeNil)
+				So(cfg.SecretKey, ShouldEqual, "sk_new")
+				So(cfg.WebhookSecret, ShouldEqual, "whsec_new")
+			})
+		})
+		Convey("When the PublicKey is missing", func() {
+			_, err := newStripeConfig(pm, json.RawMessage(`{"SecretKey":"sk_new"}`), current, created, "test")
+
+			Convey("It should be rejected", func() {
+				So(err, ShouldHaveSameTypeAs, providerConfigError(""))
+			})
+		})
+		Convey("When the SecretKey is omitted and there is no current config", func() {
+			_, err := newStripeConfig(pm, json.RawMessage(`{"PublicKey":"pk_new"}`), &stripe.Config{}, created, "test")
+
+			Convey("It should be rejected", func() {
+				So(err, ShouldHaveSameTypeAs, providerConfigError(""))
+			})
+		})
+
+		Convey("When the config is returned", func() {
+			cfg, err := newStripeConfig(pm, json.RawMessage(`{"PublicKey":"pk_new"}`), current, created, "test")
+			So(err, ShouldBeNil)
+			resp, err := json.Marshal(stripeConfigResponse(cfg))
+			So(err, ShouldBeNil)
+
+			Convey("It should not contain the secrets", func() {
+				So(string(resp), ShouldNotContainSubstring, "sk_current")
+				So(string(resp), ShouldNotContainSubstring, "whsec_current")
+				So(string(resp), ShouldContainSubstring, `"SecretKeySet":true`)
+				So(string(resp), ShouldContainSubstring, `"WebhookSecretSet":true`)
+			})
+		})
+	})
+}
+
+func TestSEPAProviderConfig(t *testing.T) {
+	Convey("Given a SEPA payment method", t, func() {
+		pm := &payment_method.Method{ProjectID: 1, MethodKey: "sepa"}
+		created := time.Now()
+
+		Convey("When a valid config is requested", func() {
+			cfg, err := newSEPAConfig(pm, json.RawMessage(`{"CreditorName":"Creditor","CreditorID":"DE98ZZZ09999999999","IBAN":"de89 3704 0044 0532 0130 00","BIC":"cobadeffxxx"}`), created, "test")
+
+			Convey("It should normalize the IBAN and BIC", func() {
+				So(err, ShouldBeNil)
+				So(cfg.IBAN, ShouldEqual, "DE89370400440532013000")
+				So(cfg.BIC, ShouldEqual, "COBADEFFXXX")
+				So(sepa.ValidateIBAN(cfg.IBAN), ShouldBeNil)
+			})
+		})
+		Convey("When the CreditorName is missing", func() {
+			_, err := newSEPAConfig(pm, json.RawMessage(`{"CreditorID":"DE98ZZZ09999999999","IBAN":"DE89370400440532013000"}`), created, "test")
+
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, providerConfigError("invalid CreditorName"))
+			})
+		})
+		Convey("When the CreditorID is invalid", func() {
+			_, err := newSEPAConfig(pm, json.RawMessage(`{"CreditorName":"Creditor","CreditorID":"DE97ZZZ09999999999","IBAN":"DE89370400440532013000"}`), created, "test")
+
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, providerConfigError("invalid CreditorID"))
+			})
+		})
+		Convey("When the IBAN is invalid", func() {
+			_, err := newSEPAConfig(pm, json.RawMessage(`{"CreditorName":"Creditor","CreditorID":"DE98ZZZ09999999999","IBAN":"DE89370400440532013001"}`), created, "test")
+
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, providerConfigError("invalid IBAN"))
+			})
+		})
+	})
+}
@@ -0,0 +1,340 @@
+package v1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	notification "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// the query parameters of a payment search in the order of the signature message
+var searchPaymentParams = []string{
+	"Status",
+	"Currency",
+	"CreatedFrom",
+	"CreatedTo",
+	"PaymentMethodId",
+	"Country",
+	"MetadataKey",
+	"MetadataValue",
+	"Cursor",
+	"Limit",
+}
+
+// SearchPaymentRequest represents a payment search request
+//
+// The search criteria are read from the query parameters
+type SearchPaymentRequest struct {
+	ProjectKey string
+	Params     url.Values
+	Timestamp  int64
+	Nonce      string
+
+	binarySignature []byte
+}
+
+func (r *SearchPaymentRequest) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	_, err = buf.WriteString(r.ProjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	for _, param := range searchPaymentParams {
+		_, err = buf.WriteString(r.Params.Get(param))
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *SearchPaymentRequest) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+func (r *SearchPaymentRequest) Signature() ([]byte, error) {
+	return r.binarySignature, nil
+}
+
+func (r *SearchPaymentRequest) RequestProjectKey() string {
+	return r.ProjectKey
+}
+
+func (r *SearchPaymentRequest) RequestNonce() string {
+	return r.Nonce
+}
+
+func (r *SearchPaymentRequest) Time() time.Time {
+	return time.Unix(r.Timestamp, 0)
+}
+
+func (r *SearchPaymentRequest) ReadFromRequest(req *http.Request) error {
+	var err error
+	q := req.URL.Query()
+	r.Params = q
+	r.ProjectKey = q.Get("ProjectKey")
+	if r.ProjectKey == "" {
+		return errors.New("no project key")
+	}
+	r.Timestamp, err = strconv.ParseInt(q.Get("Timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %v", err)
+	}
+	r.Nonce = q.Get("Nonce")
+	if r.Nonce == "" {
+		return errors.New("no nonce")
+	}
+	if q.Get("Signature") == "" {
+		return errors.New("no signature")
+	}
+	r.binarySignature, err = hex.DecodeString(q.Get("Signature"))
+	if err != nil {
+		return errors.New("invalid signature format")
+	}
+	return nil
+}
+
+// SearchPaymentResponse is the response JSON struct for a payment search
+type SearchPaymentResponse struct {
+	Payments []*notification.Notification
+	// Cursor for the next page of results. Empty if there are no more results
+	Cursor string `json:",omitempty"`
+}
+
+// reads the search filter from the query parameters
+func readPaymentSearchFilter(projectID int64, q url.Values, ps *paymentService.Service) (*payment.SearchFilter, error) {
+	f := &payment.SearchFilter{ProjectID: projectID}
+	var err error
+	if s := q.Get("Status"); s != "" {
+		f.Status = payment.PaymentTransactionStatus(s)
+		if !f.Status.Known() {
+			return nil, fmt.Errorf("invalid Status")
+		}
+	}
+	if c := q.Get("Currency"); c != "" {
+		if len(c) != 3 {
+			return nil, fmt.Errorf("invalid Currency")
+		}
+		f.Currency = c
+	}
+	if from := q.Get("CreatedFrom"); from != "" {
+		ts, err := strconv.ParseInt(from, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CreatedFrom")
+		}
+		f.CreatedFrom = time.Unix(ts, 0)
+	}
+	if to := q.Get("CreatedTo"); to != "" {
+		ts, err := strconv.ParseInt(to, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CreatedTo")
+		}
+		f.CreatedTo = time.Unix(ts, 0)
+	}
+	if meth := q.Get("PaymentMethodId"); meth != "" {
+		f.PaymentMethodID, err = strconv.ParseInt(meth, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PaymentMethodId")
+		}
+	}
+	if c := q.Get("Country"); c != "" {
+		if len(c) != 2 {
+			return nil, fmt.Errorf("invalid Country")
+		}
+		f.Country = c
+	}
+	f.MetadataKey = q.Get("MetadataKey")
+	f.MetadataValue = q.Get("MetadataValue")
+	if f.MetadataValue != "" && f.MetadataKey == "" {
+		return nil, fmt.Errorf("missing MetadataKey")
+	}
+	if cursor := q.Get("Cursor"); cursor != "" {
+		id, err := payment.ParsePaymentIDStr(cursor)
+		if err != nil || id.ProjectID != projectID {
+			return nil, fmt.Errorf("invalid Cursor")
+		}
+		f.Cursor = ps.DecodedPaymentID(id).PaymentID
+	}
+	if limit := q.Get("Limit"); limit != "" {
+		f.Limit, err = strconv.Atoi(limit)
+		if err != nil || f.Limit <= 0 || f.Limit > payment.SearchLimitMax {
+			return nil, fmt.Errorf("invalid Limit")
+		}
+	}
+	return f, nil
+}
+
+// searches payments and adds the balance and transactions to the results
+func searchPayments(db *sql.DB, ps *paymentService.Service, f *payment.SearchFilter) (*SearchPaymentResponse, error) {
+	payments, err := payment.PaymentsBySearchFilterDB(db, f)
+	if err != nil {
+		return nil, err
+	}
+	resp := &SearchPaymentResponse{
+		Payments: make([]*notification.Notification, 0, len(payments)),
+	}
+	for _, p := range payments {
+		err = payment.PaymentMetadataDB(db, p)
+		if err != nil {
+			return nil, err
+		}
+		not, err := notification.New(ps.EncodedPaymentID(p.PaymentID()), p)
+		if err != nil {
+			return nil, err
+		}
+		if p.HasTransaction() {
+			tl, err := payment.PaymentTransactionsBeforeTimestampDB(db, p, p.TransactionTimestamp)
+			if err != nil && err != payment.ErrPaymentTransactionNotFound {
+				return nil, err
+			}
+			not.SetTransactions(tl)
+		}
+		resp.Payments = append(resp.Payments, not)
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = payment.SearchLimitDefault
+	}
+	if len(payments) == limit {
+		resp.Cursor = ps.EncodedPaymentID(payments[len(payments)-1].PaymentID()).String()
+	}
+	return resp, nil
+}
+
+// SearchPayment returns the handler for the payment search
+//
+// Each payment in the result will be signed with the project key secret.
+func (a *PaymentAPI) SearchPayment() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{
+			"method": "SearchPayment",
+		})
+		var err error
+		req := &SearchPaymentRequest{}
+		err = req.ReadFromRequest(r)
+		if err != nil {
+			ret := ErrReadParam
+			if Debug {
+				ret.Info = err.Error()
+			}
+			ret.Write(w)
+			return
+		}
+		var projectKey *project.Projectkey
+		if projectKey = a.authenticateRequest(req, log, w); projectKey == nil {
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectKey.Project.ID})
+		f, err := readPaymentSearchFilter(projectKey.Project.ID, req.Params, a.paymentService)
+		if err != nil {
+			ret := ErrInval
+			ret.Info = err.Error()
+			ret.Write(w)
+			return
+		}
+		res, err := searchPayments(a.ctx.PaymentDB(service.ReadOnly), a.paymentService, f)
+		if err != nil {
+			log.Error("error searching payments", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		secret, err := projectKey.SecretBytes()
+		if err != nil {
+			log.Error("error retrieving project secret", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		for _, not := range res.Payments {
+			non, err := nonce.New()
+			if err != nil {
+				log.Error("error creating nonce", log15.Ctx{"err": err})
+				ErrSystem.Write(w)
+				return
+			}
+			err = not.Sign(time.Now(), non.Nonce, secret)
+			if err != nil {
+				log.Error("error signing", log15.Ctx{"err": err})
+				ErrSystem.Write(w)
+				return
+			}
+		}
+
+		resp := ServiceResponse{}
+		resp.Status = StatusSuccess
+		resp.HttpStatus = http.StatusOK
+		resp.Info = fmt.Sprintf("%d payments found", len(res.Payments))
+		resp.Response = res
+		resp.Write(w)
+	})
+}
+
+// PaymentSearchRequest returns the handler for the payment search of the admin API
+//
+// The search criteria are the same as in the payment API search.
+func (a *AdminAPI) PaymentSearchRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "PaymentSearchRequest"})
+		if r.Method != "GET" {
+			ErrMethod.Write(w)
+			log.Info("http method not supported", log15.Ctx{"requestMethod": r.Method})
+			return
+		}
+		projectID, err := strconv.ParseInt(mux.Vars(r)["projectid"], 10, 64)
+		if err != nil {
+			ErrReadParam.Write(w)
+			log.Info("malformed param", log15.Ctx{"projectIdParam": mux.Vars(r)["projectid"]})
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID})
+		f, err := readPaymentSearchFilter(projectID, r.URL.Query(), a.paymentService)
+		if err != nil {
+			resp := ErrInval
+			resp.Info = err.Error()
+			resp.Write(w)
+			return
+		}
+		res, err := searchPayments(a.ctx.PaymentDB(service.ReadOnly), a.paymentService, f)
+		if err != nil {
+			ErrDatabase.Write(w)
+			log.Error("error searching payments", log15.Ctx{"err": err})
+			return
+		}
+
+		resp := ProjectAdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = fmt.Sprintf("%d payments found", len(res.Payments))
+		resp.Response = res
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("error writing response", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -2,6 +2,7 @@ package v1
 
 import (
 	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"github.com/gorilla/mux"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -36,17 +37,26 @@ type Service struct {
 // NewService creates a new API service
 // It requires a valid service context and takes a router to which
 // the service routes will be attached
-func NewService(ctx *service.Context, mux *mux.Router) (*Service, error) {
+//
+// The provider capabilities c are used for provider intents and can be nil.
+func NewService(ctx *service.Context, mux *mux.Router, c paymentService.ProviderCapabilities) (*Service, error) {
 	s := &Service{
 		log: ctx.Log().New(log15.Ctx{"pkg": "github.com/fritzpay/paymentd/pkg/service/api/v1"}),
 	}
 
 	cfg := ctx.Config()
 
+	s.log.Info("creating payment API...")
+	payment, err := NewPaymentAPI(ctx, c)
+	if err != nil {
+		s.log.Error("error creating payment API", log15.Ctx{"err": err})
+		return nil, err
+	}
+
 	if cfg.API.ServeAdmin {
 		s.log.Info("registering admin API...")
 
-		admin := NewAdminAPI(ctx)
+		admin := NewAdminAPI(ctx, payment.paymentService)
 		mux.Handle(ServicePath+"/authorization", admin.AuthorizationHandler())
 		mux.Handle(ServicePath+"/authorization/{method}", admin.AuthorizeHandler())
 		mux.Handle(ServicePath+"/user", admin.AuthRequiredHandler(admin.GetUserID()))
@@ -58,23 +68,27 @@ func NewService(ctx *service.Context, mux *mux.Router) (*Service, error) {
 		mux.Handle(ServicePath+"/project/{name:[-A-Za-z0-9_]+}/", admin.AuthRequiredHandler(admin.ProjectRequest()))
 		mux.Handle(ServicePath+"/project/{projectid}", admin.AuthRequiredHandler(admin.ProjectGetRequest()))
 		mux.Handle(ServicePath+"/project/{projectid}/method/{methodkey}/provider/{provider}", admin.AuthRequiredHandler(admin.PaymentMethodGetRequest()))
+		mux.Handle(ServicePath+"/project/{projectid}/method/{methodkey}/provider/{provider}/config", admin.AuthRequiredHandler(admin.ProviderConfigRequest()))
 		mux.Handle(ServicePath+"/project/{projectid}/method/", admin.AuthRequiredHandler(admin.PaymentMethodRequest()))
 		mux.Handle(ServicePath+"/project/{projectid}/method/{methodkey}", admin.AuthRequiredHandler(admin.PaymentMethodRequest()))
+		mux.Handle(ServicePath+"/project/{projectid}/payment", admin.AuthRequiredHandler(admin.PaymentSearchRequest()))
+		mux.Handle(ServicePath+"/project/{projectid}/payment/{paymentid}/notification", admin.AuthRequiredHandler(admin.PaymentNotificationRequest()))
 		mux.Handle(ServicePath+"/currency", admin.AuthRequiredHandler(admin.CurrencyGetAllRequest()))
 		mux.Handle(ServicePath+"/currency/{currencycode}", admin.AuthRequiredHandler(admin.CurrencyGetRequest()))
 	}
 
 	s.log.Info("registering payment API...")
-	payment, err := NewPaymentAPI(ctx)
-	if err != nil {
-		s.log.Error("error registering payment API", log15.Ctx{"err": err})
-		return nil, err
-	}
 	mux.Handle(ServicePath+"/payment", ctx.RateLimitHandler(payment.InitPayment())).Methods("POST")
+	mux.Handle(ServicePath+"/payment", ctx.RateLimitHandler(payment.SearchPayment())).Methods("GET")
 	mux.Handle(ServicePath+"/payment/paymentId/{paymentId}", payment.GetPayment()).Methods("GET")
 	mux.Handle(ServicePath+"/payment/PaymentId/{paymentId}", payment.GetPayment()).Methods("GET")
+	mux.Handle(ServicePath+"/payment/paymentId/{paymentId}/transactions", payment.GetPaymentTransactions()).Methods("GET")
+	mux.Handle(ServicePath+"/payment/PaymentId/{paymentId}/transactions", payment.GetPaymentTransactions()).Methods("GET")
 	mux.Handle(ServicePath+"/payment/ident/{ident}", payment.GetPayment()).Methods("GET")
 	mux.Handle(ServicePath+"/payment/Ident/{ident}", payment.GetPayment()).Methods("GET")
+	mux.Handle(ServicePath+"/payment/{paymentId}/refund", ctx.RateLimitHandler(payment.RefundPayment())).Methods("POST")
+	mux.Handle(ServicePath+"/payment/{paymentId}/capture", ctx.RateLimitHandler(payment.CapturePayment())).Methods("POST")
+	mux.Handle(ServicePath+"/payment/{paymentId}/void", ctx.RateLimitHandler(payment.VoidPayment())).Methods("POST")
 
 	return s, nil
 }
@@ -32,7 +32,7 @@ func WithService(ctx *service.Context, logChan <-chan *log15.Record, f func(s *S
 		So(logMsg.Msg, ShouldEqual, testMsg)
 
 		mux := mux.NewRouter()
-		service, err := NewService(ctx, mux)
+		service, err := NewService(ctx, mux, nil)
 		So(err, ShouldBeNil)
 
 		f(service, mux)
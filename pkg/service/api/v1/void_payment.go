@@ -0,0 +1,140 @@
+package v1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+)
+
+// VoidPaymentRequest is the request JSON struct for POST /payment/{paymentId}/void
+type VoidPaymentRequest struct {
+	ProjectKey string
+	PaymentId  string `json:"-"`
+	paymentID  payment.PaymentID
+
+	Timestamp int64 `json:",string"`
+	Nonce     string
+
+	HexSignature    string `json:"Signature"`
+	binarySignature []byte
+}
+
+// Validate input
+func (r *VoidPaymentRequest) Validate() error {
+	if r.ProjectKey == "" {
+		return fmt.Errorf("missing ProjectKey")
+	}
+	if r.PaymentId == "" {
+		return fmt.Errorf("missing PaymentId")
+	}
+	var err error
+	if r.HexSignature == "" {
+		return fmt.Errorf("missing Signature")
+	} else if r.binarySignature, err = hex.DecodeString(r.HexSignature); err != nil {
+		return fmt.Errorf("invalid Signature format")
+	}
+	if r.Timestamp == 0 {
+		return fmt.Errorf("missing Timestamp")
+	}
+	if r.Nonce == "" {
+		return fmt.Errorf("missing Nonce")
+	}
+	if len(r.Nonce) > nonce.NonceBytes {
+		return fmt.Errorf("invalid Nonce")
+	}
+	return nil
+}
+
+// Return the (binary) signature from the request
+//
+// implementing AuthenticatedRequest
+func (r *VoidPaymentRequest) Signature() ([]byte, error) {
+	return r.binarySignature, nil
+}
+
+// HashFunc returns the hash function used to generate a signature
+func (r *VoidPaymentRequest) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+// Return the signature base string (msg)
+func (r *VoidPaymentRequest) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	_, err = buf.WriteString(r.ProjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.PaymentId)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *VoidPaymentRequest) RequestProjectKey() string {
+	return r.ProjectKey
+}
+
+func (r *VoidPaymentRequest) RequestNonce() string {
+	return r.Nonce
+}
+
+func (r *VoidPaymentRequest) Time() time.Time {
+	return time.Unix(r.Timestamp, 0)
+}
+
+// ReadFromRequest reads the payment id from the request path and the JSON
+// request body
+func (r *VoidPaymentRequest) ReadFromRequest(req *http.Request) error {
+	var err error
+	r.PaymentId, r.paymentID, err = readPaymentIDVar(req)
+	if err != nil {
+		return err
+	}
+	return r.ReadJSON(req.Body)
+}
+
+func (r *VoidPaymentRequest) ReadJSON(rd io.Reader) error {
+	dec := json.NewDecoder(rd)
+	err := dec.Decode(r)
+	return err
+}
+
+// RequestPaymentID returns the (encoded) payment id of the request
+func (r *VoidPaymentRequest) RequestPaymentID() payment.PaymentID {
+	return r.paymentID
+}
+
+// VoidPayment returns the handler for voiding an authorized payment
+func (a *PaymentAPI) VoidPayment() http.Handler {
+	newRequest := func() PaymentIntentRequest {
+		return &VoidPaymentRequest{}
+	}
+	intent := func(req PaymentIntentRequest) PaymentIntentFunc {
+		return func(tx *sql.Tx, p *payment.Payment) (*payment.PaymentTransaction, paymentService.CommitIntentFunc, error) {
+			return a.paymentService.IntentVoid(p, paymentIntentTimeout)
+		}
+	}
+	return a.paymentIntentHandler("VoidPayment", newRequest, intent, "payment voided")
+}
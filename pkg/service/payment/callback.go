@@ -1,17 +1,51 @@
 package payment
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/fritzpay/paymentd/pkg/config"
 	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
 	"github.com/fritzpay/paymentd/pkg/service"
 	"github.com/fritzpay/paymentd/pkg/service/payment/notification"
+	"github.com/go-sql-driver/mysql"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
+const (
+	// maximum number of due notifications dispatched at once
+	notificationBatchSize = 64
+	// time for which a notification is reserved by a delivery worker
+	//
+	// If the worker does not finish within this time (i.e. the process was killed),
+	// the notification will be picked up again.
+	notificationLease = time.Minute
+	// timeout for callback HTTP requests
+	notificationTimeout = 10 * time.Second
+	// maximum number of bytes of the callback response body stored with an attempt
+	notificationResponseExcerptLen = 1024
+)
+
+// the notified payment transaction is not in the transaction history of the payment,
+// i.e. after a clock skew or a manual fix of the database. Retrying will not help.
+var errNotifiedTransactionNotFound = errors.New("notified payment transaction not found")
+
+// notificationWake signals the notification workers that a new notification might be
+// due
+//
+// It is shared by all payment services, since the workers run in the service which
+// started them.
+var notificationWake = make(chan struct{}, 1)
+
 // Callbacker describes a type that can provide information about callbacks to be made
 type Callbacker interface {
 	HasCallback() bool
@@ -22,116 +56,501 @@ func CanCallback(c Callbacker) bool {
 	return c.HasCallback()
 }
 
-// performs a callback notification if the payment/project has
-// a callback configured
-func (s *Service) notify(paymentTx *payment.PaymentTransaction) error {
+// notificationConfig holds the parsed notification config
+type notificationConfig struct {
+	workers          int
+	maxAttempts      int
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+	pollInterval     time.Duration
+}
+
+// reads the notification config
+//
+// Unset values will be replaced by the default config values.
+func readNotificationConfig(cfg *config.Config) (notificationConfig, error) {
+	c := cfg.Payment.Notification
+	def := config.DefaultConfig().Payment.Notification
+	if c.Workers <= 0 {
+		c.Workers = def.Workers
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = def.MaxAttempts
+	}
+	if c.RetryInterval == "" {
+		c.RetryInterval = def.RetryInterval
+	}
+	if c.MaxRetryInterval == "" {
+		c.MaxRetryInterval = def.MaxRetryInterval
+	}
+	if c.PollInterval == "" {
+		c.PollInterval = def.PollInterval
+	}
+	nCfg := notificationConfig{
+		workers:     c.Workers,
+		maxAttempts: c.MaxAttempts,
+	}
+	var err error
+	nCfg.retryInterval, err = c.RetryInterval.Duration()
+	if err != nil {
+		return nCfg, fmt.Errorf("invalid RetryInterval: %v", err)
+	}
+	nCfg.maxRetryInterval, err = c.MaxRetryInterval.Duration()
+	if err != nil {
+		return nCfg, fmt.Errorf("invalid MaxRetryInterval: %v", err)
+	}
+	nCfg.pollInterval, err = c.PollInterval.Duration()
+	if err != nil {
+		return nCfg, fmt.Errorf("invalid PollInterval: %v", err)
+	}
+	if nCfg.pollInterval <= 0 {
+		return nCfg, fmt.Errorf("invalid PollInterval: %s", c.PollInterval)
+	}
+	return nCfg, nil
+}
+
+// nextRetryInterval returns the interval before the next attempt after the given number
+// of failed attempts
+func (c notificationConfig) nextRetryInterval(attempts int) time.Duration {
+	d := c.retryInterval
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= c.maxRetryInterval {
+			return c.maxRetryInterval
+		}
+	}
+	return d
+}
+
+// callback returns the callback configuration of the payment/project
+//
+// If neither the payment nor its project has a callback configured, it will return
+// nil.
+func (s *Service) callback(p *payment.Payment) (Callbacker, error) {
+	if CanCallback(&p.Config) {
+		return &p.Config, nil
+	}
+	pr, err := project.ProjectByIDDB(s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		if err == project.ErrProjectNotFound {
+			s.log.Crit("payment with invalid project", log15.Ctx{"projectID": p.ProjectID()})
+			return nil, ErrInternal
+		}
+		s.log.Error("error retrieving project", log15.Ctx{"err": err})
+		return nil, ErrDB
+	}
+	if CanCallback(pr.Config) {
+		return pr.Config, nil
+	}
+	return nil, nil
+}
+
+// ReturnConfig returns the return URL of the payment/project and the callback project
+// key, with which the return parameters are signed
+//
+// The return URL of the payment takes precedence over the return URL of its project. If
+// neither is configured, the returned URL will be empty.
+func (s *Service) ReturnConfig(p *payment.Payment) (returnURL, projectKey string, err error) {
+	c, err := s.callback(p)
+	if err != nil {
+		return "", "", err
+	}
+	if c != nil {
+		_, _, projectKey = c.CallbackConfig()
+	}
+	if p.Config.ReturnURL.Valid {
+		return p.Config.ReturnURL.String, projectKey, nil
+	}
+	pr, err := project.ProjectByIDDB(s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		if err == project.ErrProjectNotFound {
+			s.log.Crit("payment with invalid project", log15.Ctx{"projectID": p.ProjectID()})
+			return "", "", ErrInternal
+		}
+		s.log.Error("error retrieving project", log15.Ctx{"err": err})
+		return "", "", ErrDB
+	}
+	if pr.Config.ReturnURL.Valid {
+		returnURL = pr.Config.ReturnURL.String
+	}
+	return returnURL, projectKey, nil
+}
+
+// enqueues a callback notification for the payment transaction if the payment/project
+// has a callback configured
+//
+// The notification will be delivered once the transaction is committed.
+func (s *Service) enqueueNotification(tx *sql.Tx, paymentTx *payment.PaymentTransaction) error {
 	log := s.log.New(log15.Ctx{
-		"method":    "notify",
+		"method":    "enqueueNotification",
 		"projectID": paymentTx.Payment.ProjectID(),
 		"paymentID": paymentTx.Payment.ID(),
 	})
-	var callback Callbacker
-	if CanCallback(&paymentTx.Payment.Config) {
-		callback = &paymentTx.Payment.Config
-	} else {
-		pr, err := project.ProjectByIDDB(s.ctx.PrincipalDB(service.ReadOnly), paymentTx.Payment.ProjectID())
-		if err != nil {
-			if err == project.ErrProjectNotFound {
-				log.Crit("payment with invalid project", log15.Ctx{"projectID": paymentTx.Payment.ProjectID()})
-				return ErrInternal
+	callback, err := s.callback(paymentTx.Payment)
+	if err != nil {
+		return err
+	}
+	if callback == nil {
+		log.Warn("payment without configured callback")
+		return nil
+	}
+	return s.insertNotification(tx, payment.NewPaymentNotification(paymentTx))
+}
+
+func (s *Service) insertNotification(tx *sql.Tx, n *payment.PaymentNotification) error {
+	err := payment.InsertPaymentNotificationTx(tx, n)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1213 {
+				return ErrDBLockTimeout
 			}
-			log.Error("error retrieving project", log15.Ctx{"err": err})
-			return ErrDB
 		}
-		if CanCallback(pr.Config) {
-			callback = pr.Config
+		s.log.Error("error saving payment notification", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	return nil
+}
+
+// ResendNotification enqueues the notification for the current payment transaction
+// again, regardless of previous deliveries
+//
+// The notification will be delivered once the returned CommitIntentFunc is invoked
+// after the transaction is committed.
+func (s *Service) ResendNotification(tx *sql.Tx, p *payment.Payment) (CommitIntentFunc, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "ResendNotification",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	paymentTx, err := payment.PaymentTransactionCurrentTx(tx, p)
+	if err != nil {
+		if err == payment.ErrPaymentTransactionNotFound {
+			return nil, ErrIntentNotAllowed
+		}
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1213 {
+				return nil, ErrDBLockTimeout
+			}
 		}
+		log.Error("error retrieving current payment transaction", log15.Ctx{"err": err})
+		return nil, ErrDB
 	}
-	if callback != nil {
-		s.doNotify(callback, paymentTx)
-	} else {
-		log.Warn("payment without configured callback")
+	callback, err := s.callback(p)
+	if err != nil {
+		return nil, err
+	}
+	if callback == nil {
+		return nil, ErrPaymentCallbackConfig
+	}
+	err = s.insertNotification(tx, payment.NewPaymentNotification(paymentTx))
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		s.notify(paymentTx)
+	}, nil
+}
+
+// notify signals the delivery workers that a new notification might be due
+//
+// The notification itself was enqueued when the payment transaction was set.
+func (s *Service) notify(paymentTx *payment.PaymentTransaction) error {
+	select {
+	case notificationWake <- struct{}{}:
+	default:
 	}
 	return nil
 }
 
-func (s *Service) doNotify(c Callbacker, paymentTx *payment.PaymentTransaction) {
+// handles the delivery of enqueued notifications until the service context is done
+func (s *Service) handleNotifications() {
+	jobs := make(chan *payment.PaymentNotification)
+	var workers sync.WaitGroup
+	for i := 0; i < s.notificationCfg.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for n := range jobs {
+				s.deliverNotification(n)
+			}
+		}()
+	}
+	poll := time.NewTicker(s.notificationCfg.pollInterval)
+	defer poll.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			close(jobs)
+			s.log.Info("waiting for notification workers...")
+			workers.Wait()
+			return
+		case <-notificationWake:
+			s.dispatchNotifications(jobs)
+		case <-poll.C:
+			s.dispatchNotifications(jobs)
+		}
+	}
+}
+
+// dispatches all due notifications to the workers
+func (s *Service) dispatchNotifications(jobs chan<- *payment.PaymentNotification) {
+	db := s.ctx.PaymentDB()
+	if db == nil {
+		return
+	}
+	ns, err := payment.PaymentNotificationsDueDB(db, time.Now(), notificationBatchSize)
+	if err != nil {
+		s.log.Error("error retrieving due notifications", log15.Ctx{"err": err})
+		return
+	}
+	for _, n := range ns {
+		select {
+		case jobs <- n:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// reserves the notification for delivery
+//
+// Returns nil if the notification is not due anymore, i.e. it was already picked up by
+// another worker.
+func (s *Service) claimNotification(n *payment.PaymentNotification) (*payment.PaymentNotification, error) {
+	tx, err := s.ctx.PaymentDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	current, err := payment.PaymentNotificationCurrentForUpdateTx(tx, n.PaymentID, n.TransactionTimestamp)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	now := time.Now()
+	if current.Status != payment.PaymentNotificationStatusPending || current.NextAttempt.After(now) {
+		tx.Rollback()
+		return nil, nil
+	}
+	current.Timestamp = now
+	current.NextAttempt = now.Add(notificationLease)
+	err = payment.InsertPaymentNotificationTx(tx, current)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// performs a delivery attempt and records its result
+func (s *Service) deliverNotification(n *payment.PaymentNotification) {
+	log := s.log.New(log15.Ctx{
+		"method":                      "deliverNotification",
+		"projectID":                   n.PaymentID.ProjectID,
+		"paymentID":                   n.PaymentID.PaymentID,
+		"paymentTransactionTimestamp": n.TransactionTimestamp.UnixNano(),
+	})
+	n, err := s.claimNotification(n)
+	if err != nil {
+		log.Error("error claiming notification", log15.Ctx{"err": err})
+		return
+	}
+	if n == nil {
+		return
+	}
+	n.Attempts++
+	err = s.attemptNotification(n)
+	n.Timestamp = time.Now()
+	n.SetError("")
+	switch {
+	case err == nil:
+		n.Status = payment.PaymentNotificationStatusDelivered
+		n.NextAttempt = n.Timestamp
+	case err == errNotifiedTransactionNotFound:
+		log.Crit("notification failed. notified transaction not found", log15.Ctx{"attempts": n.Attempts})
+		n.Status = payment.PaymentNotificationStatusFailed
+		n.NextAttempt = n.Timestamp
+		n.SetError(err.Error())
+	case n.Attempts >= s.notificationCfg.maxAttempts:
+		log.Crit("notification failed. giving up", log15.Ctx{"attempts": n.Attempts, "err": err})
+		n.Status = payment.PaymentNotificationStatusFailed
+		n.NextAttempt = n.Timestamp
+		n.SetError(err.Error())
+	default:
+		n.Status = payment.PaymentNotificationStatusPending
+		n.NextAttempt = n.Timestamp.Add(s.notificationCfg.nextRetryInterval(n.Attempts))
+		n.SetError(err.Error())
+		log.Warn("notification failed. retrying...", log15.Ctx{
+			"attempts":    n.Attempts,
+			"nextAttempt": n.NextAttempt,
+			"err":         err,
+		})
+	}
+	err = payment.InsertPaymentNotificationDB(s.ctx.PaymentDB(), n)
+	if err != nil {
+		log.Error("error saving notification result", log15.Ctx{"err": err})
+	}
+}
+
+// loads the payment in the state of the notified transaction and sends the notification
+func (s *Service) attemptNotification(n *payment.PaymentNotification) error {
+	p, err := payment.PaymentByIDDB(s.ctx.PaymentDB(), n.PaymentID)
+	if err != nil {
+		return fmt.Errorf("error retrieving payment: %v", err)
+	}
+	tl, err := payment.PaymentTransactionsBeforeTimestampDB(s.ctx.PaymentDB(), p, n.TransactionTimestamp)
+	if err != nil {
+		return fmt.Errorf("error retrieving transaction history: %v", err)
+	}
+	last, err := notifiedTransaction(tl)
+	if err != nil {
+		return err
+	}
+	p.Status = last.Status
+	p.TransactionTimestamp = last.Timestamp
+
+	callback, err := s.callback(p)
+	if err != nil {
+		return err
+	}
+	if callback == nil {
+		return fmt.Errorf("no callback configured")
+	}
+	return s.doNotify(callback, p, tl)
+}
+
+// returns the notified payment transaction, which is the last transaction of the
+// transaction history up to the notified transaction
+func notifiedTransaction(tl payment.PaymentTransactionList) (*payment.PaymentTransaction, error) {
+	if len(tl) == 0 {
+		return nil, errNotifiedTransactionNotFound
+	}
+	return tl[len(tl)-1], nil
+}
+
+// sends the notification and records the delivery attempt
+func (s *Service) doNotify(c Callbacker, p *payment.Payment, tl payment.PaymentTransactionList) (err error) {
 	cbURL, cbAPIVersion, cbProjectKey := c.CallbackConfig()
 	log := s.log.New(log15.Ctx{
 		"method":                      "doNotify",
-		"projectID":                   paymentTx.Payment.ProjectID(),
-		"paymentID":                   paymentTx.Payment.ID(),
-		"paymentTransactionTimestamp": paymentTx.Timestamp.UnixNano(),
+		"projectID":                   p.ProjectID(),
+		"paymentID":                   p.ID(),
+		"paymentTransactionTimestamp": p.TransactionTimestamp.UnixNano(),
 		"callbackURL":                 cbURL,
 		"callbackAPIVersion":          cbAPIVersion,
 		"callbackProjectKey":          cbProjectKey,
 	})
+	attempt := &payment.PaymentNotificationAttempt{
+		PaymentID:            p.PaymentID(),
+		TransactionTimestamp: p.TransactionTimestamp,
+		Timestamp:            time.Now(),
+		URL:                  cbURL,
+		APIVersion:           cbAPIVersion,
+	}
+	defer func() {
+		attempt.SetError(err)
+		if saveErr := payment.InsertPaymentNotificationAttemptDB(s.ctx.PaymentDB(), attempt); saveErr != nil {
+			log.Error("error saving notification attempt", log15.Ctx{"err": saveErr})
+		}
+	}()
 	log.Info("notifying...")
 	projectKey, err := project.ProjectKeyByKeyDB(s.ctx.PrincipalDB(service.ReadOnly), cbProjectKey)
 	if err != nil {
 		if err == project.ErrProjectKeyNotFound {
 			log.Error("invalid project key")
-			return
+			return fmt.Errorf("invalid project key")
 		}
 		log.Error("error retrieving project key", log15.Ctx{"err": err})
-		return
+		return fmt.Errorf("error retrieving project key: %v", err)
 	}
 	if !projectKey.IsValid() {
 		log.Warn("cannot notify with invalid project key", log15.Ctx{"projectKey": projectKey})
-		return
+		return fmt.Errorf("invalid project key")
 	}
 	// metadata
-	err = payment.PaymentMetadataDB(s.ctx.PaymentDB(service.ReadOnly), paymentTx.Payment)
+	err = payment.PaymentMetadataDB(s.ctx.PaymentDB(service.ReadOnly), p)
 	if err != nil {
 		log.Error("error retrieving payment metadata", log15.Ctx{"err": err})
-		return
+		return fmt.Errorf("error retrieving payment metadata: %v", err)
 	}
 	// create new notification
 	notF, err := notification.NotificationByVersion(cbAPIVersion)
 	if err != nil {
 		log.Error("error retrieving notification by version", log15.Ctx{"err": err})
-		return
+		return err
 	}
-	not, err := notF(s.EncodedPaymentID(paymentTx.Payment.PaymentID()), paymentTx.Payment)
+	not, err := notF(s.EncodedPaymentID(p.PaymentID()), p)
 	if err != nil {
 		log.Error("error creating notification", log15.Ctx{"err": err})
-		return
+		return fmt.Errorf("error creating notification: %v", err)
 	}
-	// balance
-	tl, err := payment.PaymentTransactionsBeforeDB(s.ctx.PaymentDB(service.ReadOnly), paymentTx)
-	if err != nil {
-		log.Error("error retrieving transaction history", log15.Ctx{"err": err})
-		return
+	// payment method
+	if m, ok := not.(notification.PaymentMethodNotification); ok && p.Config.PaymentMethodID.Valid {
+		meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+		if err != nil {
+			log.Error("error retrieving payment method", log15.Ctx{"err": err})
+			return fmt.Errorf("error retrieving payment method: %v", err)
+		}
+		m.SetPaymentMethod(meth.MethodKey, meth.Provider.Name)
 	}
+	// balance
 	not.SetTransactions(tl)
 	// signing
 	non, err := nonce.New()
 	if err != nil {
 		log.Error("error generating nonce", log15.Ctx{"err": err})
-		return
+		return fmt.Errorf("error generating nonce: %v", err)
 	}
 	secret, err := projectKey.SecretBytes()
 	if err != nil {
 		log.Error("error retrieving secret", log15.Ctx{"err": err})
-		return
+		return fmt.Errorf("error retrieving secret: %v", err)
 	}
 	err = not.Sign(time.Now(), non.Nonce, secret)
 	if err != nil {
 		log.Error("error signing notification", log15.Ctx{"err": err})
-		return
+		return fmt.Errorf("error signing notification: %v", err)
 	}
 
 	req, err := http.NewRequest("POST", cbURL, not.Reader())
 	if err != nil {
 		log.Error("error creating HTTP request", log15.Ctx{"err": err})
-		return
+		return fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	if h, ok := not.(notification.HeaderNotification); ok {
+		for k, v := range h.Header() {
+			req.Header[k] = v
+		}
 	}
 	req.Header.Set("User-Agent", not.Identification())
 	req.Close = true
+	start := time.Now()
 	res, err := s.cl.Do(req)
 	if err != nil {
+		attempt.Latency = time.Since(start)
 		log.Error("error on HTTP request", log15.Ctx{"err": err})
-	} else {
-		log.Info("notified", log15.Ctx{"HTTPStatusCode": res.StatusCode})
+		return fmt.Errorf("error on HTTP request: %v", err)
+	}
+	body, readErr := ioutil.ReadAll(io.LimitReader(res.Body, notificationResponseExcerptLen))
+	res.Body.Close()
+	attempt.Latency = time.Since(start)
+	attempt.HTTPStatusCode.Int64, attempt.HTTPStatusCode.Valid = int64(res.StatusCode), true
+	if readErr != nil {
+		log.Warn("error reading response body", log15.Ctx{"err": readErr})
 	}
+	if len(body) > 0 {
+		attempt.ResponseBody.String, attempt.ResponseBody.Valid = string(body), true
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		log.Warn("callback not acknowledged", log15.Ctx{"HTTPStatusCode": res.StatusCode})
+		return fmt.Errorf("HTTP status %d", res.StatusCode)
+	}
+	log.Info("notified", log15.Ctx{
+		"HTTPStatusCode": res.StatusCode,
+		"latency":        attempt.Latency,
+	})
+	return nil
 }
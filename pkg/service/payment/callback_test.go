@@ -0,0 +1,81 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNotificationConfig(t *testing.T) {
+	Convey("Given an empty config", t, func() {
+		cfg := &config.Config{}
+
+		Convey("When reading the notification config", func() {
+			nCfg, err := readNotificationConfig(cfg)
+			So(err, ShouldBeNil)
+
+			Convey("It should use the default values", func() {
+				So(nCfg.workers, ShouldEqual, 2)
+				So(nCfg.maxAttempts, ShouldEqual, 10)
+				So(nCfg.retryInterval, ShouldEqual, 30*time.Second)
+				So(nCfg.maxRetryInterval, ShouldEqual, 6*time.Hour)
+				So(nCfg.pollInterval, ShouldEqual, 10*time.Second)
+			})
+		})
+
+		Convey("When the config contains an invalid interval", func() {
+			cfg.Payment.Notification.RetryInterval = config.Duration("often")
+
+			Convey("Reading the notification config should fail", func() {
+				_, err := readNotificationConfig(cfg)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a notification config", t, func() {
+		nCfg := notificationConfig{
+			retryInterval:    time.Minute,
+			maxRetryInterval: 10 * time.Minute,
+		}
+
+		Convey("The retry interval should double with each attempt", func() {
+			So(nCfg.nextRetryInterval(1), ShouldEqual, time.Minute)
+			So(nCfg.nextRetryInterval(2), ShouldEqual, 2*time.Minute)
+			So(nCfg.nextRetryInterval(3), ShouldEqual, 4*time.Minute)
+			So(nCfg.nextRetryInterval(4), ShouldEqual, 8*time.Minute)
+		})
+
+		Convey("The retry interval should not exceed the maximum interval", func() {
+			So(nCfg.nextRetryInterval(5), ShouldEqual, 10*time.Minute)
+			So(nCfg.nextRetryInterval(50), ShouldEqual, 10*time.Minute)
+		})
+	})
+}
+
+func TestNotifiedTransaction(t *testing.T) {
+	Convey("Given a transaction history", t, func() {
+		tl := payment.PaymentTransactionList{
+			&payment.PaymentTransaction{Status: payment.PaymentStatusOpen},
+			&payment.PaymentTransaction{Status: payment.PaymentStatusPaid},
+		}
+
+		Convey("The notified transaction should be the last transaction", func() {
+			last, err := notifiedTransaction(tl)
+			So(err, ShouldBeNil)
+			So(last.Status, ShouldEqual, payment.PaymentStatusPaid)
+		})
+	})
+
+	Convey("Given an empty transaction history", t, func() {
+		var tl payment.PaymentTransactionList
+
+		Convey("It should not find the notified transaction", func() {
+			_, err := notifiedTransaction(tl)
+			So(err, ShouldEqual, errNotifiedTransactionNotFound)
+		})
+	})
+}
@@ -0,0 +1,222 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/server"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// maximum number of expired payments cancelled per sweep
+	expiryBatchSize = 64
+	// timeout for the cancel intent of expired payments
+	expiryIntentTimeout = 10 * time.Second
+	expiryComment       = "payment expired"
+)
+
+func readExpirySweepInterval(cfg *config.Config) (time.Duration, error) {
+	interval := cfg.Payment.ExpirySweepInterval
+	if interval == "" {
+		interval = config.DefaultConfig().Payment.ExpirySweepInterval
+	}
+	d, err := interval.Duration()
+	if err != nil {
+		return 0, fmt.Errorf("invalid ExpirySweepInterval: %v", err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid ExpirySweepInterval: %s", interval)
+	}
+	return d, nil
+}
+
+// sets the default payment expiry of the project if the payment has no explicit
+// expiry time
+func (s *Service) setDefaultExpiry(p *payment.Payment) error {
+	if p.Config.Expires != nil {
+		return nil
+	}
+	pr, err := project.ProjectByIDDB(s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		return err
+	}
+	if exp, ok := pr.Config.DefaultPaymentExpiry(); ok {
+		p.Config.SetExpires(p.Created.Add(exp))
+	}
+	return nil
+}
+
+// periodically cancels expired payments until the service context is closed
+func (s *Service) handleExpiry() {
+	server.Wait.Add(1)
+	defer server.Wait.Done()
+	if s.expirySweepInterval == 0 {
+		s.log.Info("automatic cancellation of expired payments disabled")
+		return
+	}
+	sweep := time.NewTicker(s.expirySweepInterval)
+	defer sweep.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-sweep.C:
+			s.cancelExpiredPayments()
+		}
+	}
+}
+
+// cancels all open payments, which passed their expiry time
+func (s *Service) cancelExpiredPayments() {
+	db := s.ctx.PaymentDB(service.ReadOnly)
+	if db == nil {
+		return
+	}
+	ids, err := payment.ExpiredPaymentIDsDB(db, time.Now(), payment.PaymentStatusOpen, expiryBatchSize)
+	if err != nil {
+		s.log.Error("error retrieving expired payments", log15.Ctx{"err": err})
+		return
+	}
+	for _, id := range ids {
+		select {
+		case <-s.ctx.Done():
+			return
+		default:
+		}
+		s.cancelExpiredPayment(id)
+	}
+}
+
+func (s *Service) cancelExpiredPayment(id payment.PaymentID) {
+	log := s.log.New(log15.Ctx{
+		"method":    "cancelExpiredPayment",
+		"projectID": id.ProjectID,
+		"paymentID": id.PaymentID,
+	})
+	s.syncExpiredPayment(id, log)
+	maxRetries := s.ctx.Config().Database.TransactionMaxRetries
+	var retries int
+	var err error
+	for {
+		err = s.doCancelExpiredPayment(id)
+		if err == ErrDBLockTimeout && retries < maxRetries {
+			retries++
+			time.Sleep(time.Duration(retries) * time.Second)
+			continue
+		}
+		break
+	}
+	if err != nil {
+		log.Error("error cancelling expired payment", log15.Ctx{"err": err})
+		return
+	}
+}
+
+// syncs the status of an expired payment with the payment provider before it is
+// cancelled
+//
+// The payment might have been paid in the meantime. Errors are only logged, since the
+// cancellation will check the status of the payment again.
+func (s *Service) syncExpiredPayment(id payment.PaymentID, log log15.Logger) {
+	p, err := payment.PaymentByIDDB(s.ctx.PaymentDB(service.ReadOnly), id)
+	if err != nil {
+		log.Error("error retrieving expired payment", log15.Ctx{"err": err})
+		return
+	}
+	err = s.SyncStatus(p)
+	if err != nil && err != ErrIntentNotAllowed {
+		log.Warn("error syncing expired payment status", log15.Ctx{"err": err})
+	}
+}
+
+func (s *Service) doCancelExpiredPayment(id payment.PaymentID) error {
+	tx, err := s.ctx.PaymentDB().Begin()
+	if err != nil {
+		return err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
+		}
+	}()
+	// serialize with other sweepers
+	err = payment.LockPaymentTx(tx, id)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1213 {
+			return ErrDBLockTimeout
+		}
+		return err
+	}
+	p, err := payment.PaymentByIDTx(tx, id)
+	if err != nil {
+		return err
+	}
+	// the payment changed in the meantime
+	if p.Status != payment.PaymentStatusOpen || !p.Expired(time.Now()) {
+		return nil
+	}
+	processing, err := s.providerProcessing(tx, p)
+	if err != nil {
+		return err
+	}
+	// the payment might be paid once the provider finished processing it
+	if processing {
+		s.log.Info("expired payment is processed by the provider. skipping...", log15.Ctx{
+			"projectID": id.ProjectID,
+			"paymentID": id.PaymentID,
+		})
+		return nil
+	}
+	paymentTx, commitIntent, err := s.IntentCancel(p, expiryIntentTimeout)
+	if err != nil {
+		return err
+	}
+	paymentTx.Comment.String, paymentTx.Comment.Valid = expiryComment, true
+	err = s.SetPaymentTransaction(tx, paymentTx)
+	if err != nil {
+		return err
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	commitIntent()
+	s.log.Info("expired payment cancelled", log15.Ctx{
+		"projectID": id.ProjectID,
+		"paymentID": id.PaymentID,
+	})
+	return nil
+}
+
+// returns true if the provider driver of the payment method is currently processing the
+// payment
+func (s *Service) providerProcessing(tx *sql.Tx, p *payment.Payment) (bool, error) {
+	if !p.Config.PaymentMethodID.Valid {
+		return false, nil
+	}
+	s.mIntent.RLock()
+	c := s.providerCapabilities
+	s.mIntent.RUnlock()
+	if c == nil {
+		return false, nil
+	}
+	meth, err := payment_method.PaymentMethodByIDTx(tx, p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return false, err
+	}
+	checker, ok := c.ProcessingChecker(meth)
+	if !ok {
+		return false, nil
+	}
+	return checker.Processing(tx, *p)
+}
@@ -0,0 +1,43 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExpirySweepInterval(t *testing.T) {
+	Convey("Given an empty config", t, func() {
+		cfg := &config.Config{}
+
+		Convey("When reading the expiry sweep interval", func() {
+			interval, err := readExpirySweepInterval(cfg)
+			So(err, ShouldBeNil)
+
+			Convey("It should use the default value", func() {
+				So(interval, ShouldEqual, time.Minute)
+			})
+		})
+
+		Convey("When the sweep interval is set to 0", func() {
+			cfg.Payment.ExpirySweepInterval = config.Duration("0")
+
+			Convey("The sweeper should be disabled", func() {
+				interval, err := readExpirySweepInterval(cfg)
+				So(err, ShouldBeNil)
+				So(interval, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the sweep interval is invalid", func() {
+			cfg.Payment.ExpirySweepInterval = config.Duration("-1m")
+
+			Convey("Reading the interval should fail", func() {
+				_, err := readExpirySweepInterval(cfg)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
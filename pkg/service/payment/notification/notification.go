@@ -3,11 +3,13 @@ package notification
 import (
 	"errors"
 	"io"
+	"net/http"
 	"time"
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/service"
 	notificationV2 "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	notificationV3 "github.com/fritzpay/paymentd/pkg/service/payment/notification/v3"
 )
 
 var (
@@ -24,12 +26,30 @@ type Notification interface {
 	Identification() string
 }
 
+// HeaderNotification is a Notification which requires additional HTTP headers
+// (i.e. for the signature) to be sent with the notification request
+type HeaderNotification interface {
+	Notification
+	Header() http.Header
+}
+
+// PaymentMethodNotification is a Notification which includes information about the
+// payment method
+type PaymentMethodNotification interface {
+	Notification
+	SetPaymentMethod(methodKey, provider string)
+}
+
 func NotificationByVersion(ver string) (NewNotificationFunc, error) {
 	switch ver {
 	case "2":
 		return NewNotificationFunc(func(encPaymentID payment.PaymentID, p *payment.Payment) (Notification, error) {
 			return notificationV2.New(encPaymentID, p)
 		}), nil
+	case "3":
+		return NewNotificationFunc(func(encPaymentID payment.PaymentID, p *payment.Payment) (Notification, error) {
+			return notificationV3.New(encPaymentID, p)
+		}), nil
 	default:
 		return nil, ErrInvalidNotificationVersion
 	}
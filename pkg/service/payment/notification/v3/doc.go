@@ -0,0 +1,29 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package notification provides the Notification type for notifications in the
+version:
+
+3.x
+
+The notification body is transmitted as-is and signed using HTTP headers. The
+signature is the hex-encoded HMAC-SHA256 of the concatenation of the
+X-Paymentd-Timestamp header, the X-Paymentd-Nonce header and the raw request body,
+using the secret of the callback project key. Receivers can verify the signature
+without decoding the body.
+*/
+package notification
@@ -0,0 +1,230 @@
+package notification
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+)
+
+const (
+	PaymentNotificationVersion = "3.0.0"
+)
+
+// HTTP headers of the notification request
+const (
+	HeaderTimestamp = "X-Paymentd-Timestamp"
+	HeaderNonce     = "X-Paymentd-Nonce"
+	HeaderSignature = "X-Paymentd-Signature"
+)
+
+// Event is the type of event which triggered the notification
+type Event string
+
+const (
+	EventOpened         Event = "opened"
+	EventPending              = "pending"
+	EventPaid                 = "paid"
+	EventSettled              = "settled"
+	EventAuthorized           = "authorized"
+	EventError                = "error"
+	EventCancelled            = "cancelled"
+	EventFailed               = "failed"
+	EventChargeback           = "chargeback"
+	EventRefunded             = "refunded"
+	EventRefundReversed       = "refund-reversed"
+)
+
+// EventByStatus returns the event which is triggered by a payment transaction with
+// the given status
+func EventByStatus(s payment.PaymentTransactionStatus) Event {
+	switch s {
+	case payment.PaymentStatusOpen:
+		return EventOpened
+	default:
+		return Event(s.String())
+	}
+}
+
+// Transaction represents a single payment transaction (ledger entry)
+type Transaction struct {
+	Timestamp     int64 `json:",string"`
+	Amount        int64 `json:",string"`
+	Subunits      int8  `json:",string"`
+	DecimalAmount string
+	Currency      string
+	Status        string
+	Comment       string `json:",omitempty"`
+}
+
+// Notification represents a notification for connected systems about
+// the state of a payment
+type Notification struct {
+	Version              string
+	Event                Event
+	PaymentId            payment.PaymentID
+	Ident                string
+	Amount               int64 `json:",string"`
+	Subunits             int8  `json:",string"`
+	DecimalAmount        string
+	Currency             string
+	Country              string            `json:",omitempty"`
+	PaymentMethodId      int64             `json:",string,omitempty"`
+	PaymentMethodKey     string            `json:",omitempty"`
+	Provider             string            `json:",omitempty"`
+	Locale               string            `json:",omitempty"`
+	Balance              payment.Balance   `json:",omitempty"`
+	Status               string            `json:",omitempty"`
+	TransactionTimestamp int64             `json:",string,omitempty"`
+	Transactions         []Transaction     `json:",omitempty"`
+	Metadata             map[string]string `json:",omitempty"`
+	Timestamp            int64             `json:",string"`
+
+	nonce     string
+	body      []byte
+	signature []byte
+}
+
+func New(encodedPaymentID payment.PaymentID, p *payment.Payment) (*Notification, error) {
+	n := &Notification{
+		Version:       PaymentNotificationVersion,
+		Event:         EventByStatus(p.Status),
+		PaymentId:     encodedPaymentID,
+		Ident:         p.Ident,
+		Amount:        p.Amount,
+		Subunits:      p.Subunits,
+		DecimalAmount: p.Decimal().String(),
+		Currency:      p.Currency,
+		Status:        p.Status.String(),
+		Metadata:      p.Metadata,
+	}
+	if !p.TransactionTimestamp.IsZero() {
+		n.TransactionTimestamp = p.TransactionTimestamp.UnixNano()
+	}
+	if !p.Config.IsConfigured() {
+		return n, nil
+	}
+	if p.Config.Country.Valid {
+		n.Country = p.Config.Country.String
+	}
+	if p.Config.PaymentMethodID.Valid {
+		n.PaymentMethodId = p.Config.PaymentMethodID.Int64
+	}
+	if p.Config.Locale.Valid {
+		n.Locale = p.Config.Locale.String
+	}
+	return n, nil
+}
+
+func (n *Notification) Identification() string {
+	return fmt.Sprintf("payment notification %s", n.Version)
+}
+
+// SetTransactions sets the balance and the list of transactions
+func (n *Notification) SetTransactions(tl payment.PaymentTransactionList) {
+	n.Balance = tl.Balance()
+	n.Transactions = make([]Transaction, 0, len(tl))
+	for _, tx := range tl {
+		t := Transaction{
+			Timestamp:     tx.Timestamp.UnixNano(),
+			Amount:        tx.Amount,
+			Subunits:      tx.Subunits,
+			DecimalAmount: tx.Decimal().String(),
+			Currency:      tx.Currency,
+			Status:        tx.Status.String(),
+		}
+		if tx.Comment.Valid {
+			t.Comment = tx.Comment.String
+		}
+		n.Transactions = append(n.Transactions, t)
+	}
+}
+
+// SetPaymentMethod sets the payment method key and the provider name
+func (n *Notification) SetPaymentMethod(methodKey, provider string) {
+	n.PaymentMethodKey = methodKey
+	n.Provider = provider
+}
+
+// Sign encodes the notification body and signs it
+//
+// Changes to the notification after signing will not be reflected in the body.
+func (n *Notification) Sign(timestamp time.Time, nonce string, secret []byte) error {
+	n.Timestamp = timestamp.Unix()
+	n.nonce = nonce
+	var err error
+	n.body, err = json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	n.signature, err = service.Sign(n, secret)
+	return err
+}
+
+// Message returns the signature base string
+//
+// It is the concatenation of the timestamp, the nonce and the encoded body.
+func (n *Notification) Message() ([]byte, error) {
+	if n.body == nil {
+		return nil, fmt.Errorf("notification not encoded")
+	}
+	buf := bytes.NewBuffer(nil)
+	_, err := buf.WriteString(strconv.FormatInt(n.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.WriteString(n.nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.Write(n.body)
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (n *Notification) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+// Header returns the HTTP headers containing the signature
+func (n *Notification) Header() http.Header {
+	h := make(http.Header)
+	h.Set("Content-Type", "application/json")
+	h.Set(HeaderTimestamp, strconv.FormatInt(n.Timestamp, 10))
+	h.Set(HeaderNonce, n.nonce)
+	h.Set(HeaderSignature, hex.EncodeToString(n.signature))
+	return h
+}
+
+// Reader returns the encoded notification body
+//
+// If the notification was signed, the body will be the exact signed body.
+func (n *Notification) Reader() io.ReadCloser {
+	if n.body != nil {
+		return ioutil.NopCloser(bytes.NewReader(n.body))
+	}
+	r, w := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(w)
+		err := enc.Encode(n)
+		if err != nil {
+			r.CloseWithError(err)
+			w.CloseWithError(err)
+			return
+		}
+		w.Close()
+	}()
+	return r
+}
@@ -0,0 +1,76 @@
+package notification
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNotificationSignature(t *testing.T) {
+	Convey("Given a payment with transactions", t, func() {
+		p := &payment.Payment{
+			Ident:    "test",
+			Amount:   1234,
+			Subunits: 2,
+			Currency: "EUR",
+			Status:   payment.PaymentStatusPaid,
+		}
+		tl := payment.PaymentTransactionList{
+			&payment.PaymentTransaction{
+				Payment:   p,
+				Timestamp: time.Unix(1, 0),
+				Amount:    -1234,
+				Subunits:  2,
+				Currency:  "EUR",
+				Status:    payment.PaymentStatusOpen,
+			},
+			&payment.PaymentTransaction{
+				Payment:   p,
+				Timestamp: time.Unix(2, 0),
+				Amount:    1234,
+				Subunits:  2,
+				Currency:  "EUR",
+				Status:    payment.PaymentStatusPaid,
+				Comment:   sql.NullString{String: "paid", Valid: true},
+			},
+		}
+
+		Convey("When creating a signed notification", func() {
+			n, err := New(payment.PaymentID{ProjectID: 1, PaymentID: 1}, p)
+			So(err, ShouldBeNil)
+			n.SetTransactions(tl)
+			secret := []byte("secret")
+			err = n.Sign(time.Unix(1000, 0), "nonce", secret)
+			So(err, ShouldBeNil)
+
+			Convey("It should contain the event and all transactions", func() {
+				So(n.Event, ShouldEqual, EventPaid)
+				So(len(n.Transactions), ShouldEqual, 2)
+				So(n.Transactions[1].Comment, ShouldEqual, "paid")
+			})
+
+			Convey("The body signature should be verifiable from the headers", func() {
+				body, err := ioutil.ReadAll(n.Reader())
+				So(err, ShouldBeNil)
+				h := n.Header()
+				mac := hmac.New(sha256.New, secret)
+				mac.Write([]byte(h.Get(HeaderTimestamp) + h.Get(HeaderNonce)))
+				mac.Write(body)
+				So(h.Get(HeaderSignature), ShouldEqual, hex.EncodeToString(mac.Sum(nil)))
+
+				decoded := &Notification{}
+				err = json.Unmarshal(body, decoded)
+				So(err, ShouldBeNil)
+				So(decoded.Event, ShouldEqual, EventPaid)
+			})
+		})
+	})
+}
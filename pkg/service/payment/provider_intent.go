@@ -0,0 +1,164 @@
+package payment
+
+import (
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/server"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	providerIntentSweepInterval = time.Minute
+	// maximum number of stale provider intents resolved per sweep
+	providerIntentBatchSize = 64
+	// pending provider intents older than this are considered stale, i.e. the process
+	// performing them stopped before the result was recorded
+	//
+	// It must be well above the request timeouts of the provider drivers.
+	providerIntentStaleAfter = 10 * time.Minute
+	// stale provider intents will only be performed again within this window
+	//
+	// Providers keep the idempotency keys for at least a day. Afterwards performing the
+	// intent again might perform it twice.
+	providerIntentRetryWindow = 23 * time.Hour
+)
+
+// periodically resolves stale provider intents until the service context is closed
+//
+// Stale intents are performed again with their idempotency key, so the provider will
+// return the result of the original request, and the result will be recorded.
+//
+// Stale intents older than the retry window will not be performed again. They will be
+// logged and must be resolved by an administrator: check the state of the intent with
+// the payment provider and insert a payment_provider_intent entry with the status
+// "done" or "failed" and the created time of the pending intent. For performed intents,
+// the matching payment_transaction must be inserted as well.
+func (s *Service) handleProviderIntents() {
+	server.Wait.Add(1)
+	defer server.Wait.Done()
+	sweep := time.NewTicker(providerIntentSweepInterval)
+	defer sweep.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-sweep.C:
+			s.resolveStaleProviderIntents()
+		}
+	}
+}
+
+func (s *Service) resolveStaleProviderIntents() {
+	db := s.ctx.PaymentDB(service.ReadOnly)
+	if db == nil {
+		return
+	}
+	now := time.Now()
+	intents, err := payment.PaymentProviderIntentsStaleDB(db, now.Add(-providerIntentStaleAfter), providerIntentBatchSize)
+	if err != nil {
+		s.log.Error("error retrieving stale provider intents", log15.Ctx{"err": err})
+		return
+	}
+	for _, intent := range intents {
+		select {
+		case <-s.ctx.Done():
+			return
+		default:
+		}
+		log := s.log.New(log15.Ctx{
+			"method":         "resolveStaleProviderIntents",
+			"projectID":      intent.PaymentID.ProjectID,
+			"paymentID":      intent.PaymentID.PaymentID,
+			"intent":         intent.Intent.String(),
+			"idempotencyKey": intent.IdempotencyKey(),
+		})
+		if intent.Created.Before(now.Add(-providerIntentRetryWindow)) {
+			log.Crit("stale provider intent can not be performed again. resolve manually")
+			continue
+		}
+		err = s.resolveProviderIntent(intent, log)
+		if err != nil {
+			log.Error("error resolving stale provider intent", log15.Ctx{"err": err})
+		}
+	}
+}
+
+// resolveProviderIntent performs the stale intent again and records the result
+func (s *Service) resolveProviderIntent(intent *payment.PaymentProviderIntent, log log15.Logger) error {
+	p, err := payment.PaymentByIDDB(s.ctx.PaymentDB(service.ReadOnly), intent.PaymentID)
+	if err != nil {
+		return err
+	}
+	paymentTx := p.NewTransaction(intent.Intent)
+	paymentTx.Amount = intent.Amount
+	log.Info("performing stale provider intent...")
+	providerErr := s.PerformProviderIntent(p, paymentTx, intent)
+
+	maxRetries := s.ctx.Config().Database.TransactionMaxRetries
+	var retries int
+	for {
+		err = s.completeStaleProviderIntent(p.PaymentID(), paymentTx, intent, providerErr)
+		if err == ErrDBLockTimeout && retries < maxRetries {
+			retries++
+			time.Sleep(time.Duration(retries) * time.Second)
+			continue
+		}
+		break
+	}
+	switch err.(type) {
+	case nil:
+	case *payment.TransitionError:
+		return nil
+	default:
+		if err == ErrRefundAmount || err == ErrProviderIntentCompleted {
+			return nil
+		}
+		return err
+	}
+	if providerErr != nil {
+		log.Info("stale provider intent failed", log15.Ctx{"err": providerErr})
+		return nil
+	}
+	log.Info("stale provider intent completed")
+	return s.notify(paymentTx)
+}
+
+func (s *Service) completeStaleProviderIntent(id payment.PaymentID, paymentTx *payment.PaymentTransaction, intent *payment.PaymentProviderIntent, providerErr error) error {
+	tx, err := s.ctx.PaymentDB().Begin()
+	if err != nil {
+		return err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
+		}
+	}()
+	err = payment.LockPaymentTx(tx, id)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1213 {
+			return ErrDBLockTimeout
+		}
+		return err
+	}
+	// the payment might have changed while the provider was requested
+	p, err := payment.PaymentByIDTx(tx, id)
+	if err != nil {
+		return err
+	}
+	completeErr := s.CompleteProviderIntent(tx, p, paymentTx, intent, providerErr)
+	if completeErr != nil {
+		if _, ok := completeErr.(*payment.TransitionError); !ok && completeErr != ErrRefundAmount {
+			return completeErr
+		}
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return completeErr
+}
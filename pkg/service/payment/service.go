@@ -42,6 +42,20 @@ func (e errorID) Error() string {
 		return "intent timeout"
 	case ErrIntentNotAllowed:
 		return "intent not allowed"
+	case ErrRefundAmount:
+		return "invalid refund amount"
+	case ErrCaptureAmount:
+		return "invalid capture amount"
+	case ErrProvider:
+		return "provider error"
+	case ErrPaymentExpired:
+		return "payment expired"
+	case ErrChargebackAmount:
+		return "invalid chargeback amount"
+	case ErrPaymentMethodNotEligible:
+		return "payment method not eligible"
+	case ErrProviderIntentCompleted:
+		return "provider intent already completed"
 	default:
 		return "unknown error"
 	}
@@ -70,6 +84,20 @@ const (
 	ErrIntentTimeout
 	// intent not allowed
 	ErrIntentNotAllowed
+	// invalid refund amount
+	ErrRefundAmount
+	// invalid capture amount
+	ErrCaptureAmount
+	// provider error
+	ErrProvider
+	// payment expired
+	ErrPaymentExpired
+	// invalid chargeback amount
+	ErrChargebackAmount
+	// payment method not eligible for the payment
+	ErrPaymentMethodNotEligible
+	// provider intent already completed
+	ErrProviderIntentCompleted
 )
 
 const (
@@ -82,6 +110,9 @@ const (
 	PaymentTokenMaxAgeDefault = time.Minute * 15
 	// PaymentTokenParam is the name of the token parameter
 	PaymentTokenParam = "token"
+	// ReturnPath is the path of the web service, which returns the end user to the
+	// return URL of the payment
+	ReturnPath = "/payment/return"
 )
 
 // IntentWorkers are the primary means of synchronizing and controlling changes on payment
@@ -121,6 +152,95 @@ type CommitIntentWorker interface {
 
 type CommitIntentFunc func()
 
+// Refunder is an optional capability of provider drivers, which can refund (paid)
+// payments with the payment provider.
+//
+// The Refund method is invoked after the refund was recorded as a pending provider
+// intent, outside of any database transaction. The amount to refund is the amount of the
+// payment transaction. Requests with the same idempotency key must be performed only
+// once by the provider. Any error returned will fail the intent.
+type Refunder interface {
+	Refund(p payment.Payment, paymentTx payment.PaymentTransaction, idempotencyKey string) error
+}
+
+// Capturer is an optional capability of provider drivers, which can capture authorized
+// payments with the payment provider.
+//
+// The Capture method is invoked like the Refund method of a Refunder on capture intents.
+type Capturer interface {
+	Capture(p payment.Payment, paymentTx payment.PaymentTransaction, idempotencyKey string) error
+}
+
+// Voider is an optional capability of provider drivers, which can void authorized
+// payments with the payment provider.
+//
+// The Void method is invoked like the Refund method of a Refunder on void intents.
+type Voider interface {
+	Void(p payment.Payment, paymentTx payment.PaymentTransaction, idempotencyKey string) error
+}
+
+// StatusSyncer is an optional capability of provider drivers, which can retrieve the
+// current status of a payment from the payment provider.
+//
+// Any status changes found should be applied through the Intent* methods.
+type StatusSyncer interface {
+	SyncStatus(p payment.Payment) error
+}
+
+// ProcessingChecker is an optional capability of provider drivers, which can tell whether
+// a payment is currently processed by the payment provider, i.e. a charge was requested
+// but its result was not recorded yet.
+//
+// The payment is locked in the given transaction. Expired payments will not be cancelled
+// while they are processed.
+type ProcessingChecker interface {
+	Processing(tx *sql.Tx, p payment.Payment) (bool, error)
+}
+
+// checkout action types
+const (
+	// the frontend should redirect the end user to the URL
+	CheckoutActionRedirect = "redirect"
+	// the frontend should submit the form fields to the URL
+	CheckoutActionForm = "form"
+	// the frontend should keep polling the payment status
+	CheckoutActionPoll = "poll"
+	// the checkout is finished
+	CheckoutActionNone = "none"
+)
+
+// CheckoutAction describes the next step a checkout frontend has to take
+type CheckoutAction struct {
+	Type   string
+	URL    string            `json:",omitempty"`
+	Method string            `json:",omitempty"`
+	Fields map[string]string `json:",omitempty"`
+}
+
+// CheckoutActioner is an optional capability of provider drivers, which can describe
+// the next step of an initialized payment for checkout frontends.
+//
+// If the returned action is nil, the web service will decide on the next step.
+type CheckoutActioner interface {
+	CheckoutAction(p *payment.Payment, method *payment_method.Method) (*CheckoutAction, error)
+}
+
+// ProviderCapabilities looks up the optional capabilities of the provider driver of a
+// payment method
+//
+// The second return value will be false if the driver does not have the capability or
+// if no driver is attached for the payment method.
+type ProviderCapabilities interface {
+	// Available returns true if the driver of the payment method is attached
+	Available(method *payment_method.Method) bool
+
+	Refunder(method *payment_method.Method) (Refunder, bool)
+	Capturer(method *payment_method.Method) (Capturer, bool)
+	Voider(method *payment_method.Method) (Voider, bool)
+	StatusSyncer(method *payment_method.Method) (StatusSyncer, bool)
+	ProcessingChecker(method *payment_method.Method) (ProcessingChecker, bool)
+}
+
 // Service is the payment service
 type Service struct {
 	ctx *service.Context
@@ -135,6 +255,12 @@ type Service struct {
 	preIntents    []PreIntentWorker
 	postIntents   []PostIntentWorker
 	commitIntents []CommitIntentWorker
+
+	providerCapabilities ProviderCapabilities
+
+	notificationCfg notificationConfig
+
+	expirySweepInterval time.Duration
 }
 
 // NewService creates a new payment service
@@ -158,10 +284,21 @@ func NewService(ctx *service.Context) (*Service, error) {
 		s.log.Error("error initializing payment ID encoder", log15.Ctx{"err": err})
 		return nil, err
 	}
+	s.notificationCfg, err = readNotificationConfig(cfg)
+	if err != nil {
+		s.log.Error("error reading notification config", log15.Ctx{"err": err})
+		return nil, err
+	}
+	s.expirySweepInterval, err = readExpirySweepInterval(cfg)
+	if err != nil {
+		s.log.Error("error reading expiry config", log15.Ctx{"err": err})
+		return nil, err
+	}
 
 	s.tr = &http.Transport{}
 	s.cl = &http.Client{
 		Transport: s.tr,
+		Timeout:   notificationTimeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			if len(via) > 10 {
 				return errors.New("too many redirects")
@@ -184,6 +321,18 @@ func NewService(ctx *service.Context) (*Service, error) {
 	return s, nil
 }
 
+// StartWorkers starts the background workers of the payment service, which deliver
+// the enqueued notifications, cancel expired payments and resolve stale provider intents
+//
+// The workers operate on all payments. They should be started once per process, no
+// matter how many payment services are created. They stop when the service context
+// is done.
+func (s *Service) StartWorkers() {
+	go s.handleWorkers()
+	go s.handleExpiry()
+	go s.handleProviderIntents()
+}
+
 func (s *Service) handleBackground() {
 	// if attached to a server, this will tell the server to wait with shutting down
 	// until the cleanup process is complete
@@ -200,6 +349,13 @@ func (s *Service) handleBackground() {
 	}
 }
 
+func (s *Service) handleWorkers() {
+	server.Wait.Add(1)
+	defer server.Wait.Done()
+	// returns when the notification workers are finished
+	s.handleNotifications()
+}
+
 func (s *Service) RegisterPreIntentWorker(worker PreIntentWorker) {
 	s.mIntent.Lock()
 	s.preIntents = append(s.preIntents, worker)
@@ -218,6 +374,16 @@ func (s *Service) RegisterCommitIntentWorker(worker CommitIntentWorker) {
 	s.mIntent.Unlock()
 }
 
+// RegisterProviderCapabilities registers the lookup of provider driver capabilities
+//
+// Without registered capabilities, intents which require an action of the payment
+// provider will not be allowed, including refunds.
+func (s *Service) RegisterProviderCapabilities(c ProviderCapabilities) {
+	s.mIntent.Lock()
+	s.providerCapabilities = c
+	s.mIntent.Unlock()
+}
+
 // EncodedPaymentID returns a payment id with the id part encoded
 func (s *Service) EncodedPaymentID(id payment.PaymentID) payment.PaymentID {
 	id.PaymentID = s.idCoder.Hide(id.PaymentID)
@@ -254,7 +420,12 @@ func (s *Service) CreatePayment(tx *sql.Tx, p *payment.Payment) error {
 			return ErrPaymentCallbackConfig
 		}
 	}
-	err := payment.InsertPaymentTx(tx, p)
+	err := s.setDefaultExpiry(p)
+	if err != nil {
+		log.Error("error retrieving project config", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	err = payment.InsertPaymentTx(tx, p)
 	if err != nil {
 		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
 			if mysqlErr.Number == 1213 {
@@ -311,6 +482,21 @@ func (s *Service) SetPaymentConfig(tx *sql.Tx, p *payment.Payment) error {
 			log.Warn(ErrPaymentMethodInactive.Error())
 			return ErrPaymentMethodInactive
 		}
+		elig, err := payment_method.EligibilityByPaymentMethodTx(tx, meth)
+		if err != nil {
+			if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+				if mysqlErr.Number == 1213 {
+					return ErrDBLockTimeout
+				}
+			}
+			log.Error("error on select payment method eligibility", log15.Ctx{"err": err})
+			return ErrDB
+		}
+		err = elig.Check(p.Currency, p.Config.Country.String, p.Amount, p.Subunits)
+		if err != nil {
+			log.Warn(ErrPaymentMethodNotEligible.Error(), log15.Ctx{"reason": err})
+			return ErrPaymentMethodNotEligible
+		}
 	}
 	err := payment.InsertPaymentConfigTx(tx, p)
 	if err != nil {
@@ -372,11 +558,40 @@ func (s *Service) IsInitialized(p *payment.Payment) bool {
 
 // SetPaymentTransaction adds a new payment transaction
 //
-// If a callback method is configured for this payment/project, it will send a callback
-// notification
+// The status of the payment transaction must be a valid transition from the current
+// payment status. Otherwise a *payment.TransitionError will be returned.
+//
+// If a callback method is configured for this payment/project, it will enqueue a callback
+// notification in the same transaction. The notification will be delivered once the
+// intent is committed and retried until the callback receiver acknowledges it.
 func (s *Service) SetPaymentTransaction(tx *sql.Tx, paymentTx *payment.PaymentTransaction) error {
 	log := s.log.New(log15.Ctx{"method": "SetPaymentTransaction"})
-	err := payment.InsertPaymentTransactionTx(tx, paymentTx)
+	// read the current status into a copy, since the payment might already have the
+	// status of the new transaction
+	current := *paymentTx.Payment
+	_, err := payment.PaymentTransactionCurrentTx(tx, &current)
+	if err != nil && err != payment.ErrPaymentTransactionNotFound {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1213 {
+				return ErrDBLockTimeout
+			}
+		}
+		log.Error("error retrieving current payment transaction", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	if err == payment.ErrPaymentTransactionNotFound {
+		current.Status = payment.PaymentStatusNone
+	}
+	err = payment.ValidateTransition(current.Status, paymentTx.Status)
+	if err != nil {
+		log.Error("invalid payment transaction", log15.Ctx{
+			"projectID": paymentTx.Payment.ProjectID(),
+			"paymentID": paymentTx.Payment.ID(),
+			"err":       err,
+		})
+		return err
+	}
+	err = payment.InsertPaymentTransactionTx(tx, paymentTx)
 	if err != nil {
 		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
 			if mysqlErr.Number == 1213 {
@@ -386,7 +601,7 @@ func (s *Service) SetPaymentTransaction(tx *sql.Tx, paymentTx *payment.PaymentTr
 		log.Error("error saving payment transaction", log15.Ctx{"err": err})
 		return ErrDB
 	}
-	return nil
+	return s.enqueueNotification(tx, paymentTx)
 }
 
 // PaymentTransaction returns the current payment transaction for the given payment
@@ -511,6 +726,9 @@ func (s *Service) IntentOpen(p *payment.Payment, timeout time.Duration) (*paymen
 	if !s.IsProcessablePayment(p) {
 		return nil, nil, ErrIntentNotAllowed
 	}
+	if err := payment.ValidateTransition(p.Status, payment.PaymentStatusOpen); err != nil {
+		return nil, nil, err
+	}
 	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
 	if err != nil {
 		return nil, nil, err
@@ -524,8 +742,35 @@ func (s *Service) IntentOpen(p *payment.Payment, timeout time.Duration) (*paymen
 }
 
 func (s *Service) IntentCancel(p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
-	if p.Status != payment.PaymentStatusOpen {
-		return nil, nil, ErrIntentNotAllowed
+	if err := payment.ValidateTransition(p.Status, payment.PaymentStatusCancelled); err != nil {
+		return nil, nil, err
+	}
+	// payments can be cancelled before a payment method was selected
+	if p.Config.PaymentMethodID.Valid {
+		meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+		if err != nil {
+			return nil, nil, err
+		}
+		if meth.Disabled() {
+			return nil, nil, ErrPaymentMethodDisabled
+		}
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusCancelled)
+	paymentTx.Amount = 0
+	return s.handleIntent(p, paymentTx, timeout)
+}
+
+// IntentPending marks a payment as pending
+//
+// It is used when the provider accepted the payment, but the funds will be received
+// later, i.e. on direct debits. Pending payments will not expire.
+func (s *Service) IntentPending(p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if err := payment.ValidateTransition(p.Status, payment.PaymentStatusPending); err != nil {
+		return nil, nil, err
+	}
+	// expired payments will be cancelled
+	if p.Expired(time.Now()) {
+		return nil, nil, ErrPaymentExpired
 	}
 	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
 	if err != nil {
@@ -534,14 +779,19 @@ func (s *Service) IntentCancel(p *payment.Payment, timeout time.Duration) (*paym
 	if meth.Disabled() {
 		return nil, nil, ErrPaymentMethodDisabled
 	}
-	paymentTx := p.NewTransaction(payment.PaymentStatusCancelled)
+	paymentTx := p.NewTransaction(payment.PaymentStatusPending)
 	paymentTx.Amount = 0
 	return s.handleIntent(p, paymentTx, timeout)
 }
 
 func (s *Service) IntentPaid(p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
-	if p.Status != payment.PaymentStatusOpen {
-		return nil, nil, ErrIntentNotAllowed
+	if err := payment.ValidateTransition(p.Status, payment.PaymentStatusPaid); err != nil {
+		return nil, nil, err
+	}
+	// expired payments will be cancelled. pending payments were accepted by the
+	// provider before
+	if p.Status != payment.PaymentStatusPending && p.Expired(time.Now()) {
+		return nil, nil, ErrPaymentExpired
 	}
 	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
 	if err != nil {
@@ -555,8 +805,12 @@ func (s *Service) IntentPaid(p *payment.Payment, timeout time.Duration) (*paymen
 }
 
 func (s *Service) IntentAuthorized(p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
-	if p.Status != payment.PaymentStatusOpen {
-		return nil, nil, ErrIntentNotAllowed
+	if err := payment.ValidateTransition(p.Status, payment.PaymentStatusAuthorized); err != nil {
+		return nil, nil, err
+	}
+	// expired payments will be cancelled
+	if p.Expired(time.Now()) {
+		return nil, nil, ErrPaymentExpired
 	}
 	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
 	if err != nil {
@@ -570,6 +824,494 @@ func (s *Service) IntentAuthorized(p *payment.Payment, timeout time.Duration) (*
 	return s.handleIntent(p, paymentTx, timeout)
 }
 
+// IntentFailed marks a payment as failed
+//
+// It is used when the provider declined the payment, i.e. the customer was not charged.
+func (s *Service) IntentFailed(p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if err := payment.ValidateTransition(p.Status, payment.PaymentStatusFailed); err != nil {
+		return nil, nil, err
+	}
+	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meth.Disabled() {
+		return nil, nil, ErrPaymentMethodDisabled
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusFailed)
+	paymentTx.Amount = 0
+	return s.handleIntent(p, paymentTx, timeout)
+}
+
+// IntentRefund creates a refund transaction on a paid payment
+//
+// The amount will be recorded as a positive amount in the ledger. Multiple (partial) refunds
+// are possible as long as the total refunded amount does not exceed the paid amount.
+// If the amount exceeds the refundable amount, it will return an ErrRefundAmount.
+//
+// Refunds which are pending with the payment provider count as refunded. The refund must
+// be performed with BeginProviderIntent. If the provider driver of the payment method is
+// not a Refunder, the refund will only be recorded.
+func (s *Service) IntentRefund(tx *sql.Tx, p *payment.Payment, amount int64, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	return s.intentRefund(tx, p, amount, timeout, true)
+}
+
+// IntentProviderRefund creates a refund transaction on a paid payment, which was already
+// refunded by the payment provider
+//
+// It should be used by provider drivers when they are notified of refunds. Other than
+// IntentRefund it does not need to be performed by the Refunder of the provider driver.
+func (s *Service) IntentProviderRefund(tx *sql.Tx, p *payment.Payment, amount int64, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	return s.intentRefund(tx, p, amount, timeout, false)
+}
+
+// intentRefund creates the refund transaction
+//
+// If requestProvider is true, the refund still has to be requested from the payment
+// provider. Otherwise the provider reported the refund.
+func (s *Service) intentRefund(tx *sql.Tx, p *payment.Payment, amount int64, timeout time.Duration, requestProvider bool) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if err := payment.ValidateTransition(p.Status, payment.PaymentStatusRefunded); err != nil {
+		return nil, nil, err
+	}
+	if amount <= 0 {
+		return nil, nil, ErrRefundAmount
+	}
+	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meth.Disabled() {
+		return nil, nil, ErrPaymentMethodDisabled
+	}
+	txs, err := payment.PaymentTransactionsTx(tx, p)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1213 {
+				return nil, nil, ErrDBLockTimeout
+			}
+		}
+		s.log.Error("error retrieving payment transactions", log15.Ctx{
+			"method": "IntentRefund",
+			"err":    err,
+		})
+		return nil, nil, ErrDB
+	}
+	refunded := txs.Refunded()
+	if requestProvider {
+		// refunds which are currently performed by the provider can not be refunded again
+		pending, err := payment.PaymentProviderIntentsPendingTx(tx, p.PaymentID())
+		if err != nil {
+			if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+				if mysqlErr.Number == 1213 {
+					return nil, nil, ErrDBLockTimeout
+				}
+			}
+			s.log.Error("error retrieving pending provider intents", log15.Ctx{
+				"method": "IntentRefund",
+				"err":    err,
+			})
+			return nil, nil, ErrDB
+		}
+		refunded += pending.Amount(payment.PaymentStatusRefunded)
+	}
+	if refunded+amount > txs.Paid() {
+		return nil, nil, ErrRefundAmount
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusRefunded)
+	paymentTx.Amount = amount
+	return s.handleIntent(p, paymentTx, timeout)
+}
+
+// IntentChargeback creates a chargeback transaction on a paid payment
+//
+// It is used when the customer disputed the payment with the provider. The payment must
+// be locked in the given transaction. The amount will be recorded as a positive amount
+// in the ledger. It will be capped at the paid amount, which was not refunded or charged
+// back yet. If nothing is left to charge back, it will return an ErrChargebackAmount.
+func (s *Service) IntentChargeback(tx *sql.Tx, p *payment.Payment, amount int64, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "IntentChargeback",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	if err := payment.ValidateTransition(p.Status, payment.PaymentStatusChargeback); err != nil {
+		return nil, nil, err
+	}
+	if amount <= 0 {
+		return nil, nil, ErrChargebackAmount
+	}
+	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meth.Disabled() {
+		return nil, nil, ErrPaymentMethodDisabled
+	}
+	txs, err := payment.PaymentTransactionsTx(tx, p)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1213 {
+				return nil, nil, ErrDBLockTimeout
+			}
+		}
+		log.Error("error retrieving payment transactions", log15.Ctx{"err": err})
+		return nil, nil, ErrDB
+	}
+	chargeable := txs.Paid() - txs.Refunded() - txs.ChargedBack()
+	if chargeable <= 0 {
+		return nil, nil, ErrChargebackAmount
+	}
+	if amount > chargeable {
+		log.Warn("chargeback exceeds the chargeable amount. capping...", log15.Ctx{
+			"amount":     amount,
+			"chargeable": chargeable,
+		})
+		amount = chargeable
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusChargeback)
+	paymentTx.Amount = amount
+	return s.handleIntent(p, paymentTx, timeout)
+}
+
+// IntentCapture captures an authorized payment
+//
+// The amount may be less than the authorized amount (partial capture). The captured amount
+// will be recorded as paid. The provider driver of the payment method must be a Capturer.
+// The capture must be performed with BeginProviderIntent.
+func (s *Service) IntentCapture(p *payment.Payment, amount int64, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	// capture only applies to authorized payments
+	if p.Status != payment.PaymentStatusAuthorized {
+		return nil, nil, ErrIntentNotAllowed
+	}
+	if err := payment.ValidateTransition(p.Status, payment.PaymentStatusPaid); err != nil {
+		return nil, nil, err
+	}
+	if amount <= 0 || amount > p.Amount {
+		return nil, nil, ErrCaptureAmount
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusPaid)
+	paymentTx.Amount = amount
+	return s.handleIntent(p, paymentTx, timeout)
+}
+
+// IntentVoid voids an authorized payment
+//
+// The provider driver of the payment method must be a Voider. The void must be performed
+// with BeginProviderIntent.
+func (s *Service) IntentVoid(p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	// void only applies to authorized payments
+	if p.Status != payment.PaymentStatusAuthorized {
+		return nil, nil, ErrIntentNotAllowed
+	}
+	if err := payment.ValidateTransition(p.Status, payment.PaymentStatusCancelled); err != nil {
+		return nil, nil, err
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusCancelled)
+	paymentTx.Amount = 0
+	return s.handleIntent(p, paymentTx, timeout)
+}
+
+// providerIntentFunc performs a provider intent with the given idempotency key
+type providerIntentFunc func(idempotencyKey string) error
+
+// providerIntent returns the func which performs the intent of the payment transaction
+// with the payment provider
+//
+// It will return a nil func if the intent does not need to be performed by the provider,
+// i.e. refunds on providers which can not refund. If the capabilities of the provider
+// driver are unknown, the intent will not be allowed.
+func (s *Service) providerIntent(p *payment.Payment, paymentTx *payment.PaymentTransaction) (providerIntentFunc, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "providerIntent",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+		"intent":    paymentTx.Status.String(),
+	})
+	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		if err == payment_method.ErrPaymentMethodNotFound {
+			return nil, ErrPaymentMethodNotFound
+		}
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return nil, ErrDB
+	}
+	if meth.Disabled() {
+		return nil, ErrPaymentMethodDisabled
+	}
+	s.mIntent.RLock()
+	c := s.providerCapabilities
+	s.mIntent.RUnlock()
+	if c == nil {
+		log.Warn("no provider capabilities registered")
+		return nil, ErrIntentNotAllowed
+	}
+	// refunds will only be recorded for drivers known to have no Refunder
+	if !c.Available(meth) {
+		log.Warn("no driver attached for provider", log15.Ctx{"providerName": meth.Provider.Name})
+		return nil, ErrIntentNotAllowed
+	}
+	switch paymentTx.Status {
+	case payment.PaymentStatusPaid:
+		capturer, ok := c.Capturer(meth)
+		if !ok {
+			log.Info("provider can not capture", log15.Ctx{"providerName": meth.Provider.Name})
+			return nil, ErrIntentNotAllowed
+		}
+		return func(key string) error {
+			return capturer.Capture(*p, *paymentTx, key)
+		}, nil
+	case payment.PaymentStatusCancelled:
+		voider, ok := c.Voider(meth)
+		if !ok {
+			log.Info("provider can not void", log15.Ctx{"providerName": meth.Provider.Name})
+			return nil, ErrIntentNotAllowed
+		}
+		return func(key string) error {
+			return voider.Void(*p, *paymentTx, key)
+		}, nil
+	case payment.PaymentStatusRefunded:
+		refunder, ok := c.Refunder(meth)
+		if !ok {
+			// refund will only be recorded
+			return nil, nil
+		}
+		return func(key string) error {
+			return refunder.Refund(*p, *paymentTx, key)
+		}, nil
+	default:
+		return nil, ErrIntentNotAllowed
+	}
+}
+
+// BeginProviderIntent records the payment transaction of a refund, capture or void intent
+// as a pending provider intent
+//
+// The payment must be locked in the given transaction. The transaction must be committed
+// before the intent is performed with PerformProviderIntent.
+//
+// It will return a nil intent if the intent does not need to be performed by the payment
+// provider. In this case the payment transaction can be set right away.
+func (s *Service) BeginProviderIntent(tx *sql.Tx, p *payment.Payment, paymentTx *payment.PaymentTransaction) (*payment.PaymentProviderIntent, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "BeginProviderIntent",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+		"intent":    paymentTx.Status.String(),
+	})
+	perform, err := s.providerIntent(p, paymentTx)
+	if err != nil {
+		return nil, err
+	}
+	if perform == nil {
+		return nil, nil
+	}
+	pending, err := payment.PaymentProviderIntentsPendingTx(tx, p.PaymentID())
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1213 {
+				return nil, ErrDBLockTimeout
+			}
+		}
+		log.Error("error retrieving pending provider intents", log15.Ctx{"err": err})
+		return nil, ErrDB
+	}
+	// the authorization can only be captured or voided once
+	if paymentTx.Status != payment.PaymentStatusRefunded &&
+		(pending.Has(payment.PaymentStatusPaid) || pending.Has(payment.PaymentStatusCancelled)) {
+		log.Info("capture or void already pending")
+		return nil, ErrIntentNotAllowed
+	}
+	intent := payment.NewPaymentProviderIntent(paymentTx)
+	err = payment.InsertPaymentProviderIntentTx(tx, intent)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1213 {
+				return nil, ErrDBLockTimeout
+			}
+		}
+		log.Error("error saving provider intent", log15.Ctx{"err": err})
+		return nil, ErrDB
+	}
+	return intent, nil
+}
+
+// PerformProviderIntent requests the payment provider to perform the pending intent
+//
+// It must not be called within a database transaction, since the provider request might
+// take a while. The request will be sent with the idempotency key of the intent. It must
+// not be retried with another intent, since the provider might have performed the intent
+// even if an error is returned.
+func (s *Service) PerformProviderIntent(p *payment.Payment, paymentTx *payment.PaymentTransaction, intent *payment.PaymentProviderIntent) error {
+	log := s.log.New(log15.Ctx{
+		"method":         "PerformProviderIntent",
+		"projectID":      p.ProjectID(),
+		"paymentID":      p.ID(),
+		"intent":         intent.Intent.String(),
+		"idempotencyKey": intent.IdempotencyKey(),
+	})
+	perform, err := s.providerIntent(p, paymentTx)
+	if err != nil {
+		return err
+	}
+	if perform == nil {
+		log.Crit("provider intent can not be performed by the provider")
+		return ErrIntentNotAllowed
+	}
+	err = perform(intent.IdempotencyKey())
+	if err != nil {
+		log.Error("error on provider intent", log15.Ctx{"err": err})
+		if _, ok := err.(errorID); ok {
+			return err
+		}
+		return ErrProvider
+	}
+	return nil
+}
+
+// CompleteProviderIntent records the result of a performed provider intent
+//
+// The payment must be locked in the given transaction. If the intent is not pending
+// anymore, i.e. it was completed by the reconciliation of stale intents, it will return
+// an ErrProviderIntentCompleted.
+//
+// If the provider performed the intent, the payment transaction will be set on the
+// payment. If the payment status changed in the meantime, the intent will be completed
+// and a *payment.TransitionError will be returned. If a refund exceeds the refundable
+// amount, since other refunds were recorded in the meantime, the intent will be completed
+// and an ErrRefundAmount will be returned. The transaction should be committed in both
+// cases.
+func (s *Service) CompleteProviderIntent(tx *sql.Tx, p *payment.Payment, paymentTx *payment.PaymentTransaction, intent *payment.PaymentProviderIntent, providerErr error) error {
+	log := s.log.New(log15.Ctx{
+		"method":         "CompleteProviderIntent",
+		"projectID":      p.ProjectID(),
+		"paymentID":      p.ID(),
+		"intent":         intent.Intent.String(),
+		"idempotencyKey": intent.IdempotencyKey(),
+	})
+	pending, err := payment.PaymentProviderIntentsPendingTx(tx, p.PaymentID())
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1213 {
+				return ErrDBLockTimeout
+			}
+		}
+		log.Error("error retrieving pending provider intents", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	if _, ok := pending.Pending(intent); !ok {
+		log.Warn("provider intent already completed")
+		return ErrProviderIntentCompleted
+	}
+	var result *payment.PaymentProviderIntent
+	var setErr error
+	if providerErr != nil {
+		result = intent.WithStatus(payment.PaymentProviderIntentStatusFailed, providerErr.Error())
+	} else {
+		setErr = s.checkProviderRefund(tx, p, intent)
+		if setErr == nil {
+			// the payment transaction will be recorded after the pending intent
+			paymentTx.Payment = p
+			paymentTx.Timestamp = time.Now()
+			p.TransactionTimestamp, p.Status = paymentTx.Timestamp, paymentTx.Status
+			setErr = s.SetPaymentTransaction(tx, paymentTx)
+		}
+		switch setErr.(type) {
+		case nil:
+			result = intent.WithStatus(payment.PaymentProviderIntentStatusDone, "")
+		case *payment.TransitionError:
+			log.Crit("provider intent performed, but the payment status changed in the meantime", log15.Ctx{"err": setErr})
+			result = intent.WithStatus(payment.PaymentProviderIntentStatusDone, setErr.Error())
+		default:
+			if setErr != ErrRefundAmount {
+				return setErr
+			}
+			log.Crit("refund performed, but it exceeds the refundable amount", log15.Ctx{"amount": intent.Amount})
+			result = intent.WithStatus(payment.PaymentProviderIntentStatusDone, setErr.Error())
+		}
+	}
+	err = payment.InsertPaymentProviderIntentTx(tx, result)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1213 {
+				return ErrDBLockTimeout
+			}
+		}
+		log.Error("error saving provider intent", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	return setErr
+}
+
+// checkProviderRefund checks that a performed refund intent does not exceed the
+// refundable amount
+//
+// Refunds reported by the provider might have been recorded while the intent was
+// performed.
+func (s *Service) checkProviderRefund(tx *sql.Tx, p *payment.Payment, intent *payment.PaymentProviderIntent) error {
+	if intent.Intent != payment.PaymentStatusRefunded {
+		return nil
+	}
+	txs, err := payment.PaymentTransactionsTx(tx, p)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1213 {
+				return ErrDBLockTimeout
+			}
+		}
+		s.log.Error("error retrieving payment transactions", log15.Ctx{
+			"method": "checkProviderRefund",
+			"err":    err,
+		})
+		return ErrDB
+	}
+	if txs.Refunded()+intent.Amount > txs.Paid() {
+		return ErrRefundAmount
+	}
+	return nil
+}
+
+// SyncStatus retrieves the current status of the payment from the payment provider
+//
+// If the provider driver of the payment method is not a StatusSyncer, it will return
+// an ErrIntentNotAllowed.
+func (s *Service) SyncStatus(p *payment.Payment) error {
+	log := s.log.New(log15.Ctx{
+		"method":    "SyncStatus",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	if !p.Config.PaymentMethodID.Valid {
+		return ErrIntentNotAllowed
+	}
+	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		if err == payment_method.ErrPaymentMethodNotFound {
+			return ErrPaymentMethodNotFound
+		}
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	s.mIntent.RLock()
+	c := s.providerCapabilities
+	s.mIntent.RUnlock()
+	if c == nil {
+		return ErrIntentNotAllowed
+	}
+	syncer, ok := c.StatusSyncer(meth)
+	if !ok {
+		return ErrIntentNotAllowed
+	}
+	err = syncer.SyncStatus(*p)
+	if err != nil {
+		log.Error("error syncing payment status", log15.Ctx{"err": err})
+		if _, ok := err.(errorID); ok {
+			return err
+		}
+		return ErrProvider
+	}
+	return nil
+}
+
 // CreatePaymentToken creates a new random payment token
 func (s *Service) CreatePaymentToken(tx *sql.Tx, p *payment.Payment) (*payment.PaymentToken, error) {
 	log := s.log.New(log15.Ctx{"method": "CreatePaymentToken"})
@@ -16,5 +16,18 @@
 
 /*
 Package provider provides functionality for dealing with Payment Service Providers (PSPs)
+
+Provider drivers are looked up by the provider name in a registry. The built-in drivers
+are registered by this package. Additional drivers can be registered from a custom main
+package:
+
+	func init() {
+		provider.Register("mypsp", func() provider.Driver {
+			return &mypsp.Driver{}
+		})
+	}
+
+Payment methods of providers in the database without a registered driver will be
+unavailable.
 */
 package provider
@@ -6,20 +6,39 @@ import (
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
 	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/provider/fritzpay"
+	"github.com/fritzpay/paymentd/pkg/service/provider/paypal_rest"
+	"github.com/fritzpay/paymentd/pkg/service/provider/sepa"
+	"github.com/fritzpay/paymentd/pkg/service/provider/stripe"
 	"github.com/gorilla/mux"
 )
 
-// Provider Driver Registry
+// Built-in provider drivers
 //
 // These names should match the provider names in the provider table
 const (
 	driverFritzpay   = "fritzpay"
 	driverPaypalREST = "paypal_rest"
 	driverStripe     = "stripe"
+	driverSEPA       = "sepa"
 )
 
+// Driver is the interface all provider drivers must implement
+//
+// Drivers can additionally implement the optional capabilities of the payment service,
+// i.e. paymentService.Refunder, paymentService.Capturer, paymentService.Voider and
+// paymentService.StatusSyncer. The capabilities will be invoked on the matching intents.
+// A paymentService.CheckoutActioner will be asked for the next checkout step by the
+// JSON checkout status of the web service.
 type Driver interface {
 	Attach(ctx *service.Context, mux *mux.Router) error
 
 	InitPayment(p *payment.Payment, method *payment_method.Method) (http.Handler, error)
 }
+
+func init() {
+	Register(driverFritzpay, func() Driver { return &fritzpay.Driver{} })
+	Register(driverPaypalREST, func() Driver { return &paypal_rest.Driver{} })
+	Register(driverStripe, func() Driver { return &stripe.Driver{} })
+	Register(driverSEPA, func() Driver { return &sepa.Driver{} })
+}
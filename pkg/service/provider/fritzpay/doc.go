@@ -19,5 +19,15 @@ Package fritzpay provides the Test-/Demo-Payment Service Provider (PSP) FritzPay
 
 This package demonstrates how to add new PSP drivers. This provider can also be used
 to test out the functionality of paymentd when interacting as an end-user with it.
+
+The lifecycle of a payment on the mock PSP can be configured with the payment metadata
+or the payment method metadata. The payment metadata takes precedence.
+
+	fritzpay.outcome          One of "paid", "failed", "pending_paid" or "chargeback".
+	                          Without an outcome the payment will stay open.
+	fritzpay.delay            The delay between the PSP notifications, i.e. "5s".
+	                          Defaults to 1s.
+	fritzpay.chargebackDelay  The delay between the paid and the chargeback
+	                          notification. Defaults to fritzpay.delay.
 */
 package fritzpay
@@ -26,6 +26,8 @@ const (
 	providerIDFritzpay     = "fritzpay"
 	defaultLocale          = "en_US"
 	fritzpayDefaultTimeout = 30 * time.Second
+	// timeout for payment intents
+	fritzpayIntentTimeout = 500 * time.Millisecond
 )
 
 var (
@@ -91,6 +93,9 @@ func (d *Driver) PaymentInfo() http.Handler {
 // Callback handles callback from the "psp" (payment service provider; in this case
 // a mock implementation)
 //
+// The status of the callback will be applied to the payment, i.e. a paid notification
+// will set the payment to paid.
+//
 // It will always answer with a HTTP status 200 OK unless there was a data error
 // We expect the PSP to re-send the callback notification if we answer with anything
 // other than 200
@@ -188,6 +193,18 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	err = payment.LockPaymentTx(tx, p.PaymentID())
+	if err != nil {
+		log.Error("error locking payment", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	p, err = payment.PaymentByIDTx(tx, p.PaymentID())
+	if err != nil {
+		log.Error("error retrieving payment", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	fritzpayP, err := PaymentByPaymentIDTx(tx, p.PaymentID())
 	if err != nil {
 		if err == ErrPaymentNotFound {
@@ -212,19 +229,38 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Query().Get("fritzpayID") != "" {
 		fritzpayTx.FritzpayID.String, fritzpayTx.FritzpayID.Valid = r.URL.Query().Get("fritzpayID"), true
 	}
+	var intent func(p *payment.Payment) (*payment.PaymentTransaction, paymentService.CommitIntentFunc, error)
 	switch r.URL.Query().Get("status") {
 	case TransactionPSPInit:
-		if currentTx.Status == TransactionOpen {
-			// noop
-			w.WriteHeader(http.StatusOK)
-			return
-		}
 		fritzpayTx.Status = TransactionOpen
+	case TransactionPSPPending:
+		// the payment is already pending after initialization
+		fritzpayTx.Status = TransactionPending
+	case TransactionPSPPaid:
+		fritzpayTx.Status = TransactionPaid
+		intent = func(p *payment.Payment) (*payment.PaymentTransaction, paymentService.CommitIntentFunc, error) {
+			return d.paymentService.IntentPaid(p, fritzpayIntentTimeout)
+		}
+	case TransactionPSPFailed:
+		fritzpayTx.Status = TransactionFailed
+		intent = func(p *payment.Payment) (*payment.PaymentTransaction, paymentService.CommitIntentFunc, error) {
+			return d.paymentService.IntentFailed(p, fritzpayIntentTimeout)
+		}
+	case TransactionPSPChargeback:
+		fritzpayTx.Status = TransactionChargeback
+		intent = func(p *payment.Payment) (*payment.PaymentTransaction, paymentService.CommitIntentFunc, error) {
+			return d.paymentService.IntentChargeback(tx, p, p.Amount, fritzpayIntentTimeout)
+		}
 	default:
 		log.Warn("invalid status", log15.Ctx{"status": r.URL.Query().Get("status")})
 		w.WriteHeader(http.StatusOK)
 		return
 	}
+	if currentTx.Status == fritzpayTx.Status {
+		// noop
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 	err = InsertPaymentTransactionTx(tx, fritzpayTx)
 	if err != nil {
 		log.Error("error on insert payment tx", log15.Ctx{"err": err})
@@ -232,6 +268,29 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var commitIntent paymentService.CommitIntentFunc
+	if intent != nil {
+		var paymentTx *payment.PaymentTransaction
+		paymentTx, commitIntent, err = intent(p)
+		if err != nil {
+			if _, ok := err.(*payment.TransitionError); ok {
+				log.Warn("notification does not apply to payment", log15.Ctx{"err": err})
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log.Error("error on payment intent", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		paymentTx.Comment.String, paymentTx.Comment.Valid = "FritzPay: "+fritzpayTx.Status, true
+		err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
+		if err != nil {
+			log.Error("error setting payment tx", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
 	commit = true
 	err = tx.Commit()
 	if err != nil {
@@ -239,4 +298,7 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if commitIntent != nil {
+		commitIntent()
+	}
 }
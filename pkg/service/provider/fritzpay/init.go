@@ -85,6 +85,12 @@ beginTx:
 	}
 	log = log.New(log15.Ctx{"fritzpayPaymentID": fritzpayP.ID})
 
+	sc, err := d.scenario(tx, p, method)
+	if err != nil {
+		log.Error("error reading scenario", log15.Ctx{"err": err})
+		return nil, err
+	}
+
 	if currentStatus, err := d.paymentService.PaymentTransaction(tx, p); err != nil && err != payment.ErrPaymentTransactionNotFound {
 		log.Error("error retrieving payment transaction", log15.Ctx{"err": err})
 		return nil, ErrDB
@@ -143,8 +149,8 @@ beginTx:
 		q.Set("paymentID", d.paymentService.EncodedPaymentID(p.PaymentID()).String())
 		callbackURL.RawQuery = q.Encode()
 
-		workerCtx, _ := context.WithTimeout(d.ctx, fritzpayDefaultTimeout)
-		go pspInit(workerCtx, fritzpayP, callbackURL.String())
+		workerCtx, _ := context.WithTimeout(d.ctx, fritzpayDefaultTimeout+sc.duration())
+		go pspRun(workerCtx, fritzpayP, callbackURL.String(), sc)
 		defer func() {
 			if err := recover(); err != nil {
 				log.Crit("panic on worker", log15.Ctx{"err": err})
@@ -154,3 +160,18 @@ beginTx:
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	}), nil
 }
+
+// scenario reads the simulated payment lifecycle from the payment and payment method
+// metadata
+func (d *Driver) scenario(tx *sql.Tx, p *payment.Payment, method *payment_method.Method) (scenario, error) {
+	metaP := *p
+	err := payment.PaymentMetadataTx(tx, &metaP)
+	if err != nil {
+		return scenario{}, err
+	}
+	methodMeta, err := payment_method.PaymentMethodMetadataTx(tx, method)
+	if err != nil {
+		return scenario{}, err
+	}
+	return readScenario(metaP.Metadata, methodMeta)
+}
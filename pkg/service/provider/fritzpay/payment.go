@@ -13,11 +13,23 @@ type Payment struct {
 	MethodKey string
 }
 
+// Transaction statuses
+//
+// Statuses prefixed with "psp_" are set by the mock PSP. The other statuses are set
+// when the PSP notification was received.
 const (
-	TransactionPSPInit  = "psp_init"
-	TransactionInit     = "initialized"
-	TransactionPSPError = "psp_error"
-	TransactionOpen     = "open"
+	TransactionPSPInit       = "psp_init"
+	TransactionPSPPending    = "psp_pending"
+	TransactionPSPPaid       = "psp_paid"
+	TransactionPSPFailed     = "psp_failed"
+	TransactionPSPChargeback = "psp_chargeback"
+	TransactionInit          = "initialized"
+	TransactionPSPError      = "psp_error"
+	TransactionOpen          = "open"
+	TransactionPending       = "pending"
+	TransactionPaid          = "paid"
+	TransactionFailed        = "failed"
+	TransactionChargeback    = "chargeback"
 )
 
 type PaymentTransaction struct {
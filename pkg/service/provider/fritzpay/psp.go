@@ -13,11 +13,37 @@ import (
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
-func pspInit(ctx context.Context, fritzpayP Payment, callbackURL string) {
+// pspRun simulates the payment on the payment service provider (PSP) end
+//
+// It will initialize the payment and send the notifications of the scenario.
+func pspRun(ctx context.Context, fritzpayP Payment, callbackURL string, sc scenario) {
+	log := ctx.Value("log").(log15.Logger).New(log15.Ctx{
+		"pkg":         "github.com/fritzpay/paymentd/pkg/service/provider/fritzpay",
+		"method":      "pspRun",
+		"callbackURL": callbackURL,
+		"outcome":     sc.outcome,
+	})
+	if !pspInit(ctx, fritzpayP, callbackURL) {
+		return
+	}
+	for _, step := range sc.steps() {
+		select {
+		case <-ctx.Done():
+			log.Warn("cancelling scenario...", log15.Ctx{"err": ctx.Err()})
+			return
+		case <-time.After(step.delay):
+		}
+		if !pspNotify(ctx, fritzpayP, callbackURL, step.status) {
+			return
+		}
+	}
+}
+
+func pspInit(ctx context.Context, fritzpayP Payment, callbackURL string) bool {
 	if deadline, ok := ctx.Deadline(); ok {
 		// let's assume we will need at least 3 seconds to run
 		if deadline.Before(time.Now().Add(3 * time.Second)) {
-			return
+			return false
 		}
 	}
 	log := ctx.Value("log").(log15.Logger).New(log15.Ctx{
@@ -25,47 +51,88 @@ func pspInit(ctx context.Context, fritzpayP Payment, callbackURL string) {
 		"method":      "doInit",
 		"callbackURL": callbackURL,
 	})
+	return pspCallback(ctx, log, callbackURL, func(tx *sql.Tx) (PaymentTransaction, error) {
+		paymentTx, err := PaymentTransactionCurrentByPaymentIDProviderTx(tx, fritzpayP.ID)
+		if err != nil && err != ErrTransactionNotFound {
+			return paymentTx, err
+		}
+		if err == ErrTransactionNotFound {
+			h := sha1.New()
+			_, err = h.Write([]byte(fmt.Sprintf("%d", fritzpayP.ID)))
+			if err != nil {
+				return paymentTx, err
+			}
+			paymentTx.FritzpayPaymentID = fritzpayP.ID
+			paymentTx.Timestamp = time.Now()
+			paymentTx.Status = TransactionPSPInit
+			paymentTx.FritzpayID.String, paymentTx.FritzpayID.Valid = hex.EncodeToString(h.Sum(nil)), true
+			paymentTx.Payload.String, paymentTx.Payload.Valid = "initialized on psp", true
+			err = InsertPaymentTransactionTx(tx, paymentTx)
+			if err != nil {
+				return paymentTx, err
+			}
+		}
+		return paymentTx, nil
+	})
+}
+
+// pspNotify changes the status of the payment on the PSP end and notifies paymentd
+func pspNotify(ctx context.Context, fritzpayP Payment, callbackURL string, status string) bool {
+	log := ctx.Value("log").(log15.Logger).New(log15.Ctx{
+		"pkg":         "github.com/fritzpay/paymentd/pkg/service/provider/fritzpay",
+		"method":      "pspNotify",
+		"callbackURL": callbackURL,
+		"status":      status,
+	})
+	return pspCallback(ctx, log, callbackURL, func(tx *sql.Tx) (PaymentTransaction, error) {
+		paymentTx, err := PaymentTransactionCurrentByPaymentIDProviderTx(tx, fritzpayP.ID)
+		if err != nil {
+			return paymentTx, err
+		}
+		if paymentTx.Status == status {
+			// re-send notification
+			return paymentTx, nil
+		}
+		paymentTx.Timestamp = time.Now()
+		paymentTx.Status = status
+		paymentTx.Payload.String, paymentTx.Payload.Valid = status+" on psp", true
+		err = InsertPaymentTransactionTx(tx, paymentTx)
+		if err != nil {
+			return paymentTx, err
+		}
+		return paymentTx, nil
+	})
+}
+
+// pspCallback sends the PSP transaction returned by txFunc to the callback URL
+//
+// The PSP transactions will be committed if the callback was sent. It returns true
+// if the callback was accepted.
+func pspCallback(ctx context.Context, log log15.Logger, callbackURL string, txFunc func(tx *sql.Tx) (PaymentTransaction, error)) bool {
 	callback, err := url.Parse(callbackURL)
 	if err != nil {
 		log.Error("error on parsing callback URL", log15.Ctx{"err": err})
-		return
+		return false
 	}
 	tx, err := ctx.Value("paymentDB").(*sql.DB).Begin()
 	if err != nil {
 		log.Crit("error on begin tx", log15.Ctx{"err": err})
-		return
+		return false
 	}
 	if Debug {
 		log.Debug("worker start...")
 	}
 	var req *http.Request
+	var accepted bool
 	tr, cl := newClient()
 	ok := make(chan struct{})
 	errors := make(chan error)
 	go func() {
-		paymentTx, err := PaymentTransactionCurrentByPaymentIDProviderTx(tx, fritzpayP.ID)
-		if err != nil && err != ErrTransactionNotFound {
+		paymentTx, err := txFunc(tx)
+		if err != nil {
 			errors <- err
 			return
 		}
-		if err == ErrTransactionNotFound {
-			h := sha1.New()
-			_, err = h.Write([]byte(fmt.Sprintf("%d", fritzpayP.ID)))
-			if err != nil {
-				errors <- err
-				return
-			}
-			paymentTx.FritzpayPaymentID = fritzpayP.ID
-			paymentTx.Timestamp = time.Now()
-			paymentTx.Status = TransactionPSPInit
-			paymentTx.FritzpayID.String, paymentTx.FritzpayID.Valid = hex.EncodeToString(h.Sum(nil)), true
-			paymentTx.Payload.String, paymentTx.Payload.Valid = "initialized on psp", true
-			err = InsertPaymentTransactionTx(tx, paymentTx)
-			if err != nil {
-				errors <- err
-				return
-			}
-		}
 		q := callback.Query()
 		q.Set("fritzpayID", paymentTx.FritzpayID.String)
 		q.Set("status", paymentTx.Status)
@@ -80,7 +147,9 @@ func pspInit(ctx context.Context, fritzpayP Payment, callbackURL string) {
 			errors <- err
 			return
 		}
-		if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		accepted = res.StatusCode == http.StatusOK
+		if !accepted {
 			paymentTx.Timestamp = time.Now()
 			paymentTx.Status = TransactionPSPError
 			paymentTx.Payload.String = "error reaching callback URL"
@@ -108,18 +177,18 @@ func pspInit(ctx context.Context, fritzpayP Payment, callbackURL string) {
 			log.Crit("error on rollback", log15.Ctx{"err": err})
 		}
 		tr.CancelRequest(req)
-		return
+		return false
 	case err := <-errors:
 		log.Error("error on worker", log15.Ctx{"err": err})
 		err = tx.Rollback()
 		if err != nil {
 			log.Crit("error on rollback", log15.Ctx{"err": err})
 		}
-		return
+		return false
 	case <-ok:
 		if Debug {
 			log.Debug("worker done")
 		}
-		return
+		return accepted
 	}
 }
@@ -0,0 +1,130 @@
+package fritzpay
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metadata keys which control the simulated payment lifecycle
+//
+// The keys are read from the payment metadata first and from the payment method
+// metadata second.
+const (
+	// MetadataOutcome is the outcome of the payment on the mock PSP
+	MetadataOutcome = "fritzpay.outcome"
+	// MetadataDelay is the delay between the steps of the lifecycle, i.e. "2s"
+	MetadataDelay = "fritzpay.delay"
+	// MetadataChargebackDelay is the delay between the paid and the chargeback
+	// notification. Defaults to the MetadataDelay.
+	MetadataChargebackDelay = "fritzpay.chargebackDelay"
+)
+
+// Payment outcomes on the mock PSP
+//
+// Without a configured outcome, the payment will stay open.
+const (
+	OutcomePaid        = "paid"
+	OutcomeFailed      = "failed"
+	OutcomePendingPaid = "pending_paid"
+	OutcomeChargeback  = "chargeback"
+)
+
+const (
+	scenarioDefaultDelay = time.Second
+	scenarioMaxDelay     = 10 * time.Minute
+)
+
+// a step of the simulated payment lifecycle
+type scenarioStep struct {
+	delay  time.Duration
+	status string
+}
+
+// scenario is the simulated lifecycle of a payment on the mock PSP
+type scenario struct {
+	outcome         string
+	delay           time.Duration
+	chargebackDelay time.Duration
+}
+
+// readScenario reads the scenario from the given metadata
+//
+// The payment metadata takes precedence over the method metadata.
+func readScenario(paymentMeta, methodMeta map[string]string) (scenario, error) {
+	get := func(key string) string {
+		if v, ok := paymentMeta[key]; ok {
+			return v
+		}
+		return methodMeta[key]
+	}
+	sc := scenario{
+		outcome: get(MetadataOutcome),
+		delay:   scenarioDefaultDelay,
+	}
+	switch sc.outcome {
+	case "", OutcomePaid, OutcomeFailed, OutcomePendingPaid, OutcomeChargeback:
+	default:
+		return sc, fmt.Errorf("invalid %s: %s", MetadataOutcome, sc.outcome)
+	}
+	var err error
+	if d := get(MetadataDelay); d != "" {
+		sc.delay, err = parseScenarioDelay(MetadataDelay, d)
+		if err != nil {
+			return sc, err
+		}
+	}
+	sc.chargebackDelay = sc.delay
+	if d := get(MetadataChargebackDelay); d != "" {
+		sc.chargebackDelay, err = parseScenarioDelay(MetadataChargebackDelay, d)
+		if err != nil {
+			return sc, err
+		}
+	}
+	return sc, nil
+}
+
+func parseScenarioDelay(key, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	if d < 0 || d > scenarioMaxDelay {
+		return 0, fmt.Errorf("invalid %s: must be between 0 and %s", key, scenarioMaxDelay)
+	}
+	return d, nil
+}
+
+// steps returns the PSP notifications which will follow the initialization
+func (sc scenario) steps() []scenarioStep {
+	switch sc.outcome {
+	case OutcomePaid:
+		return []scenarioStep{
+			{sc.delay, TransactionPSPPaid},
+		}
+	case OutcomeFailed:
+		return []scenarioStep{
+			{sc.delay, TransactionPSPFailed},
+		}
+	case OutcomePendingPaid:
+		return []scenarioStep{
+			{sc.delay, TransactionPSPPending},
+			{sc.delay, TransactionPSPPaid},
+		}
+	case OutcomeChargeback:
+		return []scenarioStep{
+			{sc.delay, TransactionPSPPaid},
+			{sc.chargebackDelay, TransactionPSPChargeback},
+		}
+	default:
+		return nil
+	}
+}
+
+// duration returns the total duration of the delays of the scenario
+func (sc scenario) duration() time.Duration {
+	var d time.Duration
+	for _, step := range sc.steps() {
+		d += step.delay
+	}
+	return d
+}
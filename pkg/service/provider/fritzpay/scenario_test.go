@@ -0,0 +1,70 @@
+package fritzpay
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScenario(t *testing.T) {
+	Convey("Given method metadata with an outcome and a delay", t, func() {
+		methodMeta := map[string]string{
+			MetadataOutcome: OutcomePaid,
+			MetadataDelay:   "2s",
+		}
+
+		Convey("When reading the scenario without payment metadata", func() {
+			sc, err := readScenario(nil, methodMeta)
+			So(err, ShouldBeNil)
+
+			Convey("It should use the method metadata", func() {
+				So(sc.outcome, ShouldEqual, OutcomePaid)
+				So(sc.steps(), ShouldResemble, []scenarioStep{
+					{2 * time.Second, TransactionPSPPaid},
+				})
+			})
+		})
+
+		Convey("When the payment metadata sets a chargeback outcome", func() {
+			sc, err := readScenario(map[string]string{
+				MetadataOutcome:         OutcomeChargeback,
+				MetadataChargebackDelay: "1m",
+			}, methodMeta)
+			So(err, ShouldBeNil)
+
+			Convey("It should take precedence", func() {
+				So(sc.steps(), ShouldResemble, []scenarioStep{
+					{2 * time.Second, TransactionPSPPaid},
+					{time.Minute, TransactionPSPChargeback},
+				})
+				So(sc.duration(), ShouldEqual, time.Minute+2*time.Second)
+			})
+		})
+
+		Convey("When the outcome is invalid", func() {
+			_, err := readScenario(map[string]string{MetadataOutcome: "lost"}, methodMeta)
+
+			Convey("It should return an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the delay exceeds the maximum", func() {
+			_, err := readScenario(map[string]string{MetadataDelay: "1h"}, methodMeta)
+
+			Convey("It should return an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given no metadata", t, func() {
+		sc, err := readScenario(nil, nil)
+		So(err, ShouldBeNil)
+
+		Convey("The payment should stay open", func() {
+			So(sc.steps(), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,247 @@
+package paypal_rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// endpoint path for authorizations
+	paypalAuthorizationPath = "/v1/payments/authorization"
+)
+
+// PayPal authorization states
+const (
+	authorizationStateAuthorized = "authorized"
+	authorizationStateCaptured   = "captured"
+	authorizationStateVoided     = "voided"
+)
+
+// Capture captures the authorization of a PayPal payment
+//
+// implementing the Capturer capability of the payment service
+func (d *Driver) Capture(p payment.Payment, paymentTx payment.PaymentTransaction, idempotencyKey string) error {
+	return d.captureAuthorization(&p, &paymentTx, idempotencyKey)
+}
+
+// Void voids the authorization of a PayPal payment
+//
+// implementing the Voider capability of the payment service
+func (d *Driver) Void(p payment.Payment, paymentTx payment.PaymentTransaction, idempotencyKey string) error {
+	return d.voidAuthorization(&p, idempotencyKey)
+}
+
+func (d *Driver) authorizationConfig(p *payment.Payment) (*Config, *Authorization, error) {
+	log := d.log.New(log15.Ctx{
+		"method":    "authorizationConfig",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	method, err := payment_method.PaymentMethodByIDDB(d.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return nil, nil, ErrDatabase
+	}
+	cfg, err := ConfigByPaymentMethodDB(d.ctx.PaymentDB(service.ReadOnly), method)
+	if err != nil {
+		log.Error("error retrieving paypal config", log15.Ctx{"err": err})
+		return nil, nil, ErrDatabase
+	}
+	auth, err := AuthorizationCurrentByPaymentIDDB(d.ctx.PaymentDB(service.ReadOnly), p.PaymentID())
+	if err != nil {
+		if err == ErrAuthorizationNotFound {
+			log.Error("payment has no authorization")
+			return nil, nil, ErrProvider
+		}
+		log.Error("error retrieving authorization", log15.Ctx{"err": err})
+		return nil, nil, ErrDatabase
+	}
+	if auth.State != authorizationStateAuthorized {
+		log.Error("authorization in invalid state", log15.Ctx{"state": auth.State})
+		return nil, nil, ErrProvider
+	}
+	return cfg, auth, nil
+}
+
+func (d *Driver) captureAuthorization(p *payment.Payment, paymentTx *payment.PaymentTransaction, idempotencyKey string) error {
+	cfg, auth, err := d.authorizationConfig(p)
+	if err != nil {
+		return err
+	}
+	capture := &PayPalCapture{
+		Amount: PayPalAmount{
+			Currency: paymentTx.Currency,
+			Total:    paymentTx.DecimalRound(2).String(),
+		},
+		IsFinalCapture: true,
+	}
+	body, err := json.Marshal(capture)
+	if err != nil {
+		d.log.Error("error encoding capture request", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	res, err := d.doAuthorizationRequest(p, cfg, auth, "capture", body, idempotencyKey, TransactionTypeCapture, TransactionTypeCaptureResponse)
+	if err != nil {
+		return err
+	}
+	if res.State != "completed" && res.State != "pending" {
+		d.log.Error("capture not completed", log15.Ctx{"state": res.State})
+		return ErrProvider
+	}
+	auth.State = authorizationStateCaptured
+	return d.updateAuthorization(auth, res)
+}
+
+func (d *Driver) voidAuthorization(p *payment.Payment, idempotencyKey string) error {
+	cfg, auth, err := d.authorizationConfig(p)
+	if err != nil {
+		return err
+	}
+	res, err := d.doAuthorizationRequest(p, cfg, auth, "void", nil, idempotencyKey, TransactionTypeVoid, TransactionTypeVoidResponse)
+	if err != nil {
+		return err
+	}
+	if res.State != authorizationStateVoided {
+		d.log.Error("authorization not voided", log15.Ctx{"state": res.State})
+		return ErrProvider
+	}
+	auth.State = authorizationStateVoided
+	return d.updateAuthorization(auth, res)
+}
+
+// saves the authorization with the changed state
+func (d *Driver) updateAuthorization(auth *Authorization, res *PayPalResource) error {
+	var err error
+	auth.Timestamp = time.Now()
+	auth.Data, err = json.Marshal(res)
+	if err != nil {
+		d.log.Error("error encoding authorization", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	err = InsertAuthorizationDB(d.ctx.PaymentDB(), auth)
+	if err != nil {
+		d.log.Error("error saving authorization", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	return nil
+}
+
+// performs a request on the authorization resource and records the request and response
+// as PayPal transactions
+//
+// The request will be sent with the idempotency key as the PayPal-Request-Id, so PayPal
+// performs the action only once.
+func (d *Driver) doAuthorizationRequest(
+	p *payment.Payment,
+	cfg *Config,
+	auth *Authorization,
+	action string,
+	body []byte,
+	idempotencyKey string,
+	reqType, respType string) (*PayPalResource, error) {
+
+	log := d.log.New(log15.Ctx{
+		"method":          "doAuthorizationRequest",
+		"projectID":       p.ProjectID(),
+		"paymentID":       p.ID(),
+		"authorizationID": auth.AuthorizationID,
+		"action":          action,
+	})
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		log.Error("error on endpoint URL", log15.Ctx{"err": err})
+		return nil, ErrInternal
+	}
+	endpoint.Path = path.Join(paypalAuthorizationPath, auth.AuthorizationID, action)
+
+	paypalTx := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      reqType,
+	}
+	paypalTx.SetPaypalID(auth.PaypalID)
+	paypalTx.Data = body
+	err = InsertTransactionDB(d.ctx.PaymentDB(), paypalTx)
+	if err != nil {
+		log.Error("error saving transaction", log15.Ctx{"err": err})
+		return nil, ErrDatabase
+	}
+
+	req, err := http.NewRequest("POST", endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		log.Error("error creating HTTP request", log15.Ctx{"err": err})
+		return nil, ErrInternal
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("PayPal-Request-Id", idempotencyKey)
+
+	res := &PayPalResource{}
+	responseFunc := func(resp *http.Response, err error) error {
+		if err != nil {
+			log.Error("error on HTTP request", log15.Ctx{"err": err})
+			d.setPayPalError(p, nil)
+			return ErrHTTP
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Error("error reading response body", log15.Ctx{"err": err})
+			d.setPayPalError(p, nil)
+			return ErrHTTP
+		}
+		log = log.New(log15.Ctx{"responseBody": string(respBody)})
+		if Debug {
+			log.Debug("received response")
+		}
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+			log.Error("error on HTTP request", log15.Ctx{"HTTPStatusCode": resp.StatusCode})
+			d.setPayPalError(p, respBody)
+			return ErrHTTP
+		}
+		err = json.Unmarshal(respBody, res)
+		if err != nil {
+			log.Error("error decoding PayPal response", log15.Ctx{"err": err})
+			d.setPayPalError(p, respBody)
+			return ErrProvider
+		}
+		respTx := &Transaction{
+			ProjectID: p.ProjectID(),
+			PaymentID: p.ID(),
+			Timestamp: time.Now(),
+			Type:      respType,
+		}
+		respTx.SetPaypalID(auth.PaypalID)
+		if res.State != "" {
+			respTx.SetState(res.State)
+		}
+		if res.Links != nil {
+			respTx.Links, err = json.Marshal(res.Links)
+			if err != nil {
+				log.Warn("error encoding links", log15.Ctx{"err": err})
+			}
+		}
+		respTx.Data = respBody
+		err = InsertTransactionDB(d.ctx.PaymentDB(), respTx)
+		if err != nil {
+			log.Error("error saving response transaction", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+		return nil
+	}
+	err = httpDo(d.ctx, d.oAuthTransportFunc(p, cfg), req, responseFunc)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
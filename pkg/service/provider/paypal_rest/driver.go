@@ -23,6 +23,7 @@ import (
 
 	"github.com/fritzpay/paymentd/pkg/service"
 	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	tmpl "github.com/fritzpay/paymentd/pkg/template"
 	"github.com/gorilla/mux"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -53,7 +54,8 @@ type Driver struct {
 	mux *mux.Router
 	log log15.Logger
 
-	tmplDir string
+	tmplDir   string
+	templates *tmpl.Cache
 
 	paymentService *paymentService.Service
 
@@ -89,6 +91,7 @@ func (d *Driver) Attach(ctx *service.Context, mux *mux.Router) error {
 	if !dirInfo.IsDir() {
 		return fmt.Errorf("provider template dir %s is not a directory", d.tmplDir)
 	}
+	d.templates = tmpl.NewCache(d.tmplDir, defaultLocale, tmpl.Funcs(d.staticPath))
 	_, err = url.Parse(cfg.Provider.URL)
 	if err != nil {
 		d.log.Error("error parsing provider base URL", log15.Ctx{"err": err})
@@ -104,6 +107,7 @@ func (d *Driver) Attach(ctx *service.Context, mux *mux.Router) error {
 	d.mux = driverRoute.Subrouter()
 	d.mux.Handle("/return", ctx.RateLimitHandler(d.ReturnHandler())).Name("returnHandler")
 	d.mux.Handle("/cancel", ctx.RateLimitHandler(d.CancelHandler())).Name("cancelHandler")
+	d.mux.Handle("/webhook", d.WebhookHandler()).Name("webhookHandler")
 	staticDir := path.Join(d.tmplDir, "static")
 	d.log.Info("serving static dir", log15.Ctx{
 		"staticDir": staticDir,
@@ -4,45 +4,46 @@ import (
 	"fmt"
 	"html/template"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"path"
 	"strings"
 	"time"
 
-	tmpl "github.com/fritzpay/paymentd/pkg/template"
-
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
-func (d *Driver) getTemplate(t *template.Template, tmplDir, locale, baseName string) (err error) {
-	tmplFile, err := tmpl.TemplateFileName(tmplDir, locale, defaultLocale, baseName)
-	if err != nil {
-		return err
+// TemplateBaseNames returns the templates the driver requires in every locale
+func (d *Driver) TemplateBaseNames() []string {
+	return []string{
+		"init.html.tmpl",
+		"return.html.tmpl",
+		"cancel.html.tmpl",
+		"success.html.tmpl",
+		"not_found.html.tmpl",
+		"internal_error.html.tmpl",
 	}
-	tmplB, err := ioutil.ReadFile(tmplFile)
+}
+
+func (d *Driver) staticPath() (string, error) {
+	url, err := d.mux.Get("staticHandler").URLPath()
 	if err != nil {
-		return err
+		return "", err
 	}
-	tmplLocale := path.Base(path.Ext(tmplFile))
-	t.Funcs(template.FuncMap(map[string]interface{}{
-		"staticPath": func() (string, error) {
-			url, err := d.mux.Get("staticHandler").URLPath()
-			if err != nil {
-				return "", err
-			}
-			return url.Path, nil
-		},
-		"locale": func() string {
-			return tmplLocale
-		},
-	}))
-	_, err = t.Parse(string(tmplB))
-	if err != nil {
-		return err
+	return url.Path, nil
+}
+
+// getTemplate returns the parsed template for the payment
+//
+// The project of the payment may override the template.
+func (d *Driver) getTemplate(p *payment.Payment, locale, baseName string) (*template.Template, error) {
+	var projectID int64
+	if p != nil {
+		projectID = p.ProjectID()
 	}
-	return nil
+	return d.templates.Template(projectID, locale, baseName)
 }
 
 func (d *Driver) templatePaymentData(p *payment.Payment) map[string]interface{} {
@@ -51,6 +52,10 @@ func (d *Driver) templatePaymentData(p *payment.Payment) map[string]interface{}
 		tmplData["payment"] = p
 		tmplData["paymentID"] = d.paymentService.EncodedPaymentID(p.PaymentID())
 		tmplData["amount"] = p.DecimalRound(2)
+		// link back to the shop if a return URL is configured
+		if returnURL, _, err := d.paymentService.ReturnConfig(p); err == nil && returnURL != "" {
+			tmplData["returnURL"] = paymentService.ReturnPath
+		}
 	}
 	tmplData["timestamp"] = time.Now().Unix()
 	return tmplData
@@ -78,8 +83,7 @@ func (d *Driver) InitPageHandler(p *payment.Payment) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := d.log.New(log15.Ctx{"method": "InitPageHandler"})
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl := template.New("init")
-		err := d.getTemplate(tmpl, d.tmplDir, p.Config.Locale.String, baseName)
+		tmpl, err := d.getTemplate(p, p.Config.Locale.String, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -110,8 +114,7 @@ func (d *Driver) InternalErrorHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("internal_error")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.getTemplate(p, locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -141,8 +144,7 @@ func (d *Driver) NotFoundHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("not_found")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.getTemplate(p, locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -159,9 +161,8 @@ func (d *Driver) CancelPageHandler(p *payment.Payment) http.Handler {
 			"paymentID": p.PaymentID(),
 		})
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl := template.New("cancel")
 		const baseName = "cancel.html.tmpl"
-		err := d.getTemplate(tmpl, d.tmplDir, p.Config.Locale.String, baseName)
+		tmpl, err := d.getTemplate(p, p.Config.Locale.String, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -183,9 +184,8 @@ func (d *Driver) ReturnPageHandler(p *payment.Payment) http.Handler {
 			"paymentID": p.PaymentID(),
 		})
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl := template.New("return")
 		const baseName = "return.html.tmpl"
-		err := d.getTemplate(tmpl, d.tmplDir, p.Config.Locale.String, baseName)
+		tmpl, err := d.getTemplate(p, p.Config.Locale.String, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -209,8 +209,7 @@ func (d *Driver) SuccessHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("success")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.getTemplate(p, locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -309,3 +308,46 @@ func (d *Driver) pollStatusHandler(tx *Transaction, parent http.Handler) http.Ha
 		return
 	})
 }
+
+// CheckoutAction returns the next checkout step based on the current paypal transaction
+//
+// implementing the CheckoutActioner capability of the payment service
+func (d *Driver) CheckoutAction(p *payment.Payment, method *payment_method.Method) (*paymentService.CheckoutAction, error) {
+	log := d.log.New(log15.Ctx{
+		"method":    "CheckoutAction",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	tx, err := TransactionCurrentByPaymentIDDB(d.ctx.PaymentDB(service.ReadOnly), p.PaymentID())
+	if err != nil {
+		if err == ErrTransactionNotFound {
+			return nil, nil
+		}
+		log.Error("error retrieving transaction", log15.Ctx{"err": err})
+		return nil, ErrDatabase
+	}
+	switch tx.Type {
+	// wait on pending requests
+	case TransactionTypeCreatePayment, TransactionTypeExecutePayment, TransactionTypeGetPayment:
+		return &paymentService.CheckoutAction{Type: paymentService.CheckoutActionPoll}, nil
+	case TransactionTypeCreatePaymentResponse:
+		if tx.PaypalState.String != "created" {
+			return nil, nil
+		}
+		links, err := tx.PayPalLinks()
+		if err != nil {
+			log.Error("transaction links error", log15.Ctx{"err": err})
+			return nil, ErrProvider
+		}
+		if links["approval_url"] == nil {
+			log.Error("no approval URL")
+			return nil, ErrProvider
+		}
+		return &paymentService.CheckoutAction{
+			Type: paymentService.CheckoutActionRedirect,
+			URL:  links["approval_url"].HRef,
+		}, nil
+	default:
+		return nil, nil
+	}
+}
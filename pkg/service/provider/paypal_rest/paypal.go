@@ -42,6 +42,13 @@ const (
 	TransactionTypeExecutePaymentResponse = "executePaymentResponse"
 	TransactionTypeGetPayment             = "getPayment"
 	TransactionTypeGetPaymentResponse     = "getPaymentResponse"
+	TransactionTypeCapture                = "capture"
+	TransactionTypeCaptureResponse        = "captureResponse"
+	TransactionTypeVoid                   = "void"
+	TransactionTypeVoidResponse           = "voidResponse"
+	TransactionTypeRefund                 = "refund"
+	TransactionTypeRefundResponse         = "refundResponse"
+	TransactionTypeWebhookEvent           = "webhookEvent"
 )
 
 var (
@@ -143,6 +150,14 @@ type PaypalPayment struct {
 	Links      []PayPalLink `json:"links"`
 }
 
+// PayPalCapture represents the request to capture an authorization
+//
+// See https://developer.paypal.com/docs/api/#capture-an-authorization
+type PayPalCapture struct {
+	Amount         PayPalAmount `json:"amount"`
+	IsFinalCapture bool         `json:"is_final_capture"`
+}
+
 type PayPalPaymentExecution struct {
 	PayerID      string              `json:"payer_id"`
 	Transactions []PayPalTransaction `json:"transactions,omitempty"`
@@ -278,6 +293,10 @@ type Config struct {
 	ClientID string
 	Secret   string
 	Type     string
+	// WebhookID is the ID of the webhook registered with PayPal
+	//
+	// Webhook events can only be verified if it is set.
+	WebhookID sql.NullString
 }
 
 // Transaction represents a transaction on a paypal payment
@@ -304,6 +323,8 @@ type Transaction struct {
 	PaypalUpdateTime *time.Time
 	Links            []byte
 	Data             []byte
+	// EventID is the ID of the webhook event which created the transaction
+	EventID sql.NullString
 }
 
 func (t *Transaction) SetNonce(nonce string) {
@@ -326,6 +347,10 @@ func (t *Transaction) SetState(state string) {
 	t.PaypalState.String, t.PaypalState.Valid = state, true
 }
 
+func (t *Transaction) SetEventID(id string) {
+	t.EventID.String, t.EventID.Valid = id, true
+}
+
 func (t *Transaction) PayPalLinks() (map[string]*PayPalLink, error) {
 	if t.Links == nil || len(t.Links) == 0 {
 		return nil, ErrNoLinks
@@ -0,0 +1,323 @@
+package paypal_rest
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// endpoint path for sales
+	paypalSalePath = "/v1/payments/sale"
+	// endpoint path for captures
+	paypalCapturePath = "/v1/payments/capture"
+)
+
+// PayPal refund states
+const (
+	refundStatePending   = "pending"
+	refundStateCompleted = "completed"
+	refundStateFailed    = "failed"
+)
+
+var (
+	ErrNoRefundableResource = errors.New("no refundable sale or capture")
+)
+
+// PayPalRefundRequest represents the request to refund a sale or a capture
+//
+// See https://developer.paypal.com/docs/api/#refund-a-sale
+type PayPalRefundRequest struct {
+	Amount *PayPalAmount `json:"amount,omitempty"`
+}
+
+// saleID returns the ID of the sale of an executed PayPal payment
+func (p *PaypalPayment) saleID() (string, error) {
+	for _, t := range p.Transactions {
+		for _, sale := range t.RelatedResources.Resources("sale") {
+			if sale.ID != "" {
+				return sale.ID, nil
+			}
+		}
+	}
+	return "", ErrNoRefundableResource
+}
+
+// Refund refunds the amount of the payment transaction on the PayPal sale or capture
+// of the payment
+//
+// implementing the Refunder capability of the payment service
+//
+// Partial refunds are possible. Completed and pending refunds will be recorded as
+// refunds of the payment, pending refunds will be completed by PayPal. A failed refund
+// will cancel the refund intent.
+func (d *Driver) Refund(p payment.Payment, paymentTx payment.PaymentTransaction, idempotencyKey string) error {
+	log := d.log.New(log15.Ctx{
+		"method":         "Refund",
+		"projectID":      p.ProjectID(),
+		"paymentID":      p.ID(),
+		"idempotencyKey": idempotencyKey,
+	})
+	db := d.ctx.PaymentDB(service.ReadOnly)
+	method, err := payment_method.PaymentMethodByIDDB(db, p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	cfg, err := ConfigByPaymentMethodDB(db, method)
+	if err != nil {
+		log.Error("error retrieving paypal config", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	paypalID, resourcePath, err := refundResourceDB(db, p.PaymentID())
+	if err != nil {
+		if err == ErrNoRefundableResource {
+			log.Error("payment has no refundable sale or capture")
+			return ErrProvider
+		}
+		log.Error("error retrieving refundable resource", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	log = log.New(log15.Ctx{"resourcePath": resourcePath})
+	total, err := paypalAmount(&p, paymentTx.Amount)
+	if err != nil {
+		log.Error("refund amount cannot be represented", log15.Ctx{
+			"amount":   paymentTx.Amount,
+			"currency": p.Currency,
+			"subunits": p.Subunits,
+		})
+		return paymentService.ErrRefundAmount
+	}
+
+	body, err := json.Marshal(&PayPalRefundRequest{
+		Amount: &PayPalAmount{
+			Currency: paymentTx.Currency,
+			Total:    total,
+		},
+	})
+	if err != nil {
+		log.Error("error encoding refund request", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	paypalTx := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeRefund,
+	}
+	paypalTx.SetPaypalID(paypalID)
+	paypalTx.Data = body
+	err = InsertTransactionDB(d.ctx.PaymentDB(), paypalTx)
+	if err != nil {
+		log.Error("error saving transaction", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+
+	res, respBody, err := d.requestRefund(&p, cfg, resourcePath, body, idempotencyKey)
+	if err != nil {
+		d.setPayPalError(&p, respBody)
+		return err
+	}
+	respTx := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeRefundResponse,
+	}
+	respTx.SetPaypalID(paypalID)
+	if res.State != "" {
+		respTx.SetState(res.State)
+	}
+	if res.Links != nil {
+		respTx.Links, err = json.Marshal(res.Links)
+		if err != nil {
+			log.Warn("error encoding links", log15.Ctx{"err": err})
+		}
+	}
+	respTx.Data = respBody
+	err = InsertTransactionDB(d.ctx.PaymentDB(), respTx)
+	if err != nil {
+		// the refund was performed
+		log.Crit("error saving refund response transaction", log15.Ctx{"err": err})
+	}
+	err = refundResult(res)
+	if err != nil {
+		log.Error("refund not completed", log15.Ctx{
+			"state":      res.State,
+			"reasonCode": res.ReasonCode,
+		})
+		return err
+	}
+	if res.State == refundStatePending {
+		log.Info("refund pending", log15.Ctx{"refundID": res.ID})
+	}
+	return nil
+}
+
+// refundResult maps the state of a PayPal refund
+//
+// Completed and pending refunds are successful, all other states will return an
+// ErrProvider.
+func refundResult(res *PayPalResource) error {
+	switch res.State {
+	case refundStateCompleted, refundStatePending:
+		return nil
+	default:
+		return ErrProvider
+	}
+}
+
+// refundResourceDB returns the PayPal payment ID and the refund path of the resource
+// which can be refunded on the payment
+//
+// Captured authorizations will be refunded on the capture, payments with the sale
+// intent on the sale. If there is no such resource, it will return an
+// ErrNoRefundableResource.
+func refundResourceDB(db *sql.DB, paymentID payment.PaymentID) (paypalID, resourcePath string, err error) {
+	captureTx, err := TransactionByPaymentIDAndTypeDB(db, paymentID, TransactionTypeCaptureResponse)
+	if err != nil && err != ErrTransactionNotFound {
+		return "", "", err
+	}
+	if err == nil {
+		capture := &PayPalResource{}
+		err = json.Unmarshal(captureTx.Data, capture)
+		if err != nil || capture.ID == "" {
+			return "", "", ErrNoRefundableResource
+		}
+		return captureTx.PaypalID.String, path.Join(paypalCapturePath, capture.ID, "refund"), nil
+	}
+	executeTx, err := TransactionByPaymentIDAndTypeDB(db, paymentID, TransactionTypeExecutePaymentResponse)
+	if err != nil {
+		if err == ErrTransactionNotFound {
+			return "", "", ErrNoRefundableResource
+		}
+		return "", "", err
+	}
+	pay := &PaypalPayment{}
+	err = json.Unmarshal(executeTx.Data, pay)
+	if err != nil {
+		return "", "", ErrNoRefundableResource
+	}
+	saleID, err := pay.saleID()
+	if err != nil {
+		return "", "", err
+	}
+	return executeTx.PaypalID.String, path.Join(paypalSalePath, saleID, "refund"), nil
+}
+
+// requestRefund performs the refund request on the given resource path
+//
+// The returned response body can be used to record errors.
+func (d *Driver) requestRefund(p *payment.Payment, cfg *Config, resourcePath string, body []byte, idempotencyKey string) (*PayPalResource, []byte, error) {
+	log := d.log.New(log15.Ctx{
+		"method":       "requestRefund",
+		"projectID":    p.ProjectID(),
+		"paymentID":    p.ID(),
+		"resourcePath": resourcePath,
+	})
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		log.Error("error on endpoint URL", log15.Ctx{"err": err})
+		return nil, nil, ErrInternal
+	}
+	endpoint.Path = resourcePath
+	req, err := http.NewRequest("POST", endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		log.Error("error creating HTTP request", log15.Ctx{"err": err})
+		return nil, nil, ErrInternal
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// PayPal performs requests with the same request id only once
+	req.Header.Set("PayPal-Request-Id", idempotencyKey)
+
+	res := &PayPalResource{}
+	var respBody []byte
+	responseFunc := func(resp *http.Response, err error) error {
+		if err != nil {
+			log.Error("error on HTTP request", log15.Ctx{"err": err})
+			return ErrHTTP
+		}
+		respBody, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Error("error reading response body", log15.Ctx{"err": err})
+			return ErrHTTP
+		}
+		log = log.New(log15.Ctx{"responseBody": string(respBody)})
+		if Debug {
+			log.Debug("received response")
+		}
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+			log.Error("error on HTTP request", log15.Ctx{"HTTPStatusCode": resp.StatusCode})
+			return ErrHTTP
+		}
+		err = json.Unmarshal(respBody, res)
+		if err != nil {
+			log.Error("error decoding PayPal response", log15.Ctx{"err": err})
+			return ErrProvider
+		}
+		return nil
+	}
+	err = httpDo(d.ctx, d.oAuthTransportFunc(p, cfg), req, responseFunc)
+	if err != nil {
+		return nil, respBody, err
+	}
+	return res, respBody, nil
+}
+
+// refundRecordedTx returns true if the refund with the given PayPal refund ID was
+// requested through the driver, i.e. it was already recorded on the payment
+func refundRecordedTx(db *sql.Tx, paymentID payment.PaymentID, refundID string) (bool, error) {
+	if refundID == "" {
+		return false, nil
+	}
+	txs, err := TransactionsByPaymentIDAndTypeTx(db, paymentID, TransactionTypeRefundResponse)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range txs {
+		res := &PayPalResource{}
+		if json.Unmarshal(t.Data, res) != nil {
+			continue
+		}
+		if res.ID == refundID && refundResult(res) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// refundPendingTx returns true if a pending refund intent of the payment matches the
+// refund with the given amount
+//
+// The refund was then most likely requested through the driver and its webhook event
+// arrived before the refund response was recorded. The refund will be recorded when the
+// intent is completed.
+func refundPendingTx(db *sql.Tx, paymentID payment.PaymentID, amount int64) (bool, error) {
+	pending, err := payment.PaymentProviderIntentsPendingTx(db, paymentID)
+	if err != nil {
+		return false, err
+	}
+	return refundPending(pending, amount), nil
+}
+
+func refundPending(pending payment.PaymentProviderIntentList, amount int64) bool {
+	for _, i := range pending {
+		if i.Intent == payment.PaymentStatusRefunded && i.Amount == amount {
+			return true
+		}
+	}
+	return false
+}
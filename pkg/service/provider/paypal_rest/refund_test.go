@@ -0,0 +1,233 @@
+package paypal_rest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/testutil"
+	testPay "github.com/fritzpay/paymentd/pkg/testutil/payment"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestSaleID(t *testing.T) {
+	Convey("Given an executed sale", t, func() {
+		body := []byte(`{
+			"id": "PAY-9N9834337A9191208KOZOQWI",
+			"state": "approved",
+			"transactions": [{
+				"amount": {"total": "12.34", "currency": "EUR"},
+				"related_resources": [{
+					"sale": {"id": "36C38912MN9658832", "state": "completed"}
+				}]
+			}]
+		}`)
+		pay := &PaypalPayment{}
+		err := json.Unmarshal(body, pay)
+		So(err, ShouldBeNil)
+
+		Convey("It should return the sale ID", func() {
+			id, err := pay.saleID()
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "36C38912MN9658832")
+		})
+	})
+	Convey("Given an executed authorization", t, func() {
+		pay := &PaypalPayment{}
+		err := json.Unmarshal([]byte(`{
+			"id": "PAY-9N9834337A9191208KOZOQWI",
+			"transactions": [{
+				"related_resources": [{
+					"authorization": {"id": "2DC87612EK520411B", "state": "authorized"}
+				}]
+			}]
+		}`), pay)
+		So(err, ShouldBeNil)
+
+		Convey("It should have no refundable sale", func() {
+			_, err := pay.saleID()
+			So(err, ShouldEqual, ErrNoRefundableResource)
+		})
+	})
+}
+
+func TestRefund(t *testing.T) {
+	Convey("Given a PayPal API stand-in", t, testutil.WithContext(func(ctx *service.Context, logChan <-chan *log15.Record) {
+		var reqPath string
+		var reqBody []byte
+		var reqID string
+		var respStatus int
+		var respBody string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Path == paypalTokenPath {
+				w.Write([]byte(`{"access_token":"test","token_type":"Bearer","expires_in":3600}`))
+				return
+			}
+			reqPath = r.URL.Path
+			reqBody, _ = ioutil.ReadAll(r.Body)
+			reqID = r.Header.Get("PayPal-Request-Id")
+			w.WriteHeader(respStatus)
+			w.Write([]byte(respBody))
+		}))
+		Reset(func() {
+			srv.Close()
+		})
+		d := &Driver{
+			ctx:   ctx,
+			log:   ctx.Log(),
+			oauth: NewOAuthTransportStore(),
+		}
+		cfg := &Config{
+			ProjectID: 1,
+			MethodKey: "test",
+			Endpoint:  srv.URL,
+			ClientID:  "client",
+			Secret:    "secret",
+		}
+		p := &payment.Payment{
+			Amount:   1234,
+			Subunits: 2,
+			Currency: "EUR",
+		}
+
+		Convey("Given a partial refund request", func() {
+			body, err := json.Marshal(&PayPalRefundRequest{
+				Amount: &PayPalAmount{Currency: "EUR", Total: "2.50"},
+			})
+			So(err, ShouldBeNil)
+
+			Convey("When the refund is completed", func() {
+				respStatus = http.StatusCreated
+				respBody = `{"id":"0P209507D6694645N","state":"completed","amount":{"total":"2.50","currency":"EUR"},"sale_id":"36C38912MN9658832"}`
+				res, raw, err := d.requestRefund(p, cfg, "/v1/payments/sale/36C38912MN9658832/refund", body, "paymentd-1-1-1")
+
+				Convey("It should succeed", func() {
+					So(err, ShouldBeNil)
+					So(res.ID, ShouldEqual, "0P209507D6694645N")
+					So(string(raw), ShouldEqual, respBody)
+					So(refundResult(res), ShouldBeNil)
+				})
+				Convey("It should request the refund on the sale", func() {
+					So(reqPath, ShouldEqual, "/v1/payments/sale/36C38912MN9658832/refund")
+					So(string(reqBody), ShouldEqual, `{"amount":{"currency":"EUR","total":"2.50"}}`)
+				})
+				Convey("It should send the idempotency key as the request id", func() {
+					So(reqID, ShouldEqual, "paymentd-1-1-1")
+				})
+			})
+
+			Convey("When the refund is pending", func() {
+				respStatus = http.StatusCreated
+				respBody = `{"id":"0P209507D6694645N","state":"pending"}`
+				res, _, err := d.requestRefund(p, cfg, "/v1/payments/capture/8F148933LY9388354/refund", body, "paymentd-1-1-1")
+
+				Convey("It should be successful", func() {
+					So(err, ShouldBeNil)
+					So(refundResult(res), ShouldBeNil)
+				})
+			})
+
+			Convey("When the refund failed", func() {
+				respStatus = http.StatusCreated
+				respBody = `{"id":"0P209507D6694645N","state":"failed"}`
+				res, _, err := d.requestRefund(p, cfg, "/v1/payments/sale/36C38912MN9658832/refund", body, "paymentd-1-1-1")
+
+				Convey("It should return a provider error", func() {
+					So(err, ShouldBeNil)
+					So(res.State, ShouldEqual, refundStateFailed)
+					So(refundResult(res), ShouldEqual, ErrProvider)
+				})
+			})
+
+			Convey("When PayPal refuses the refund", func() {
+				respStatus = http.StatusBadRequest
+				respBody = `{"name":"TRANSACTION_REFUSED","message":"The request was refused"}`
+				_, raw, err := d.requestRefund(p, cfg, "/v1/payments/sale/36C38912MN9658832/refund", body, "paymentd-1-1-1")
+
+				Convey("It should return an HTTP error with the response body", func() {
+					So(err, ShouldEqual, ErrHTTP)
+					So(string(raw), ShouldEqual, respBody)
+				})
+			})
+		})
+	}))
+}
+
+func TestRefundPending(t *testing.T) {
+	Convey("Given a pending refund intent", t, func() {
+		pending := payment.PaymentProviderIntentList{
+			&payment.PaymentProviderIntent{
+				Intent: payment.PaymentStatusRefunded,
+				Amount: 250,
+				Status: payment.PaymentProviderIntentStatusPending,
+			},
+		}
+
+		Convey("When the webhook event of the refund arrives", func() {
+			Convey("It should be covered by the intent", func() {
+				So(refundPending(pending, 250), ShouldBeTrue)
+			})
+		})
+		Convey("When the webhook event of another refund arrives", func() {
+			Convey("It should not be covered by the intent", func() {
+				So(refundPending(pending, 300), ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given a payment DB", t, testutil.WithPaymentDB(t, func(db *sql.DB) {
+		tx, err := db.Begin()
+		So(err, ShouldBeNil)
+		Reset(func() {
+			err = tx.Rollback()
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Given a payment", testPay.WithPaymentInTx(tx, func(p *payment.Payment) {
+			Convey("Given a refund requested through the driver", func() {
+				now := time.Now()
+				intent := &payment.PaymentProviderIntent{
+					PaymentID: p.PaymentID(),
+					Created:   now,
+					Timestamp: now,
+					Intent:    payment.PaymentStatusRefunded,
+					Amount:    250,
+					Status:    payment.PaymentProviderIntentStatusPending,
+				}
+				err = payment.InsertPaymentProviderIntentTx(tx, intent)
+				So(err, ShouldBeNil)
+
+				Convey("When the webhook event arrives before the refund response", func() {
+					pending, err := refundPendingTx(tx, p.PaymentID(), 250)
+
+					Convey("It should not record the refund again", func() {
+						So(err, ShouldBeNil)
+						So(pending, ShouldBeTrue)
+					})
+				})
+
+				Convey("When the refund response was recorded", func() {
+					done := *intent
+					done.Timestamp = now.Add(time.Second)
+					done.Status = payment.PaymentProviderIntentStatusDone
+					err = payment.InsertPaymentProviderIntentTx(tx, &done)
+					So(err, ShouldBeNil)
+
+					Convey("It should not be pending", func() {
+						pending, err := refundPendingTx(tx, p.PaymentID(), 250)
+						So(err, ShouldBeNil)
+						So(pending, ShouldBeFalse)
+					})
+				})
+			})
+		}))
+	}))
+}
@@ -8,11 +8,14 @@ import (
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/secret"
 )
 
 var (
 	ErrConfigNotFound      = errors.New("config not found")
 	ErrTransactionNotFound = errors.New("transaction not found")
+	// ErrAuthorizationNotFound is returned when no authorization for a payment exists
+	ErrAuthorizationNotFound = errors.New("authorization not found")
 )
 
 const selectConfig = `
@@ -24,7 +27,8 @@ SELECT
 	c.endpoint,
 	c.client_id,
 	c.secret,
-	c.type
+	c.type,
+	c.webhook_id
 FROM provider_paypal_config AS c
 `
 const selectConfigByProjectIDAndMethodKey = selectConfig + `
@@ -53,6 +57,7 @@ func scanConfig(row *sql.Row) (*Config, error) {
 		&cfg.ClientID,
 		&cfg.Secret,
 		&cfg.Type,
+		&cfg.WebhookID,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -60,6 +65,10 @@ func scanConfig(row *sql.Row) (*Config, error) {
 		}
 		return cfg, err
 	}
+	cfg.Secret, err = secret.Decrypt(cfg.Secret)
+	if err != nil {
+		return cfg, err
+	}
 	return cfg, nil
 }
 
@@ -73,6 +82,88 @@ func ConfigByPaymentMethodDB(db *sql.DB, method *payment_method.Method) (*Config
 	return scanConfig(row)
 }
 
+const selectConfigHistoryByProjectIDAndMethodKey = selectConfig + `
+WHERE
+	c.project_id = ?
+	AND
+	c.method_key = ?
+ORDER BY c.created DESC
+`
+
+// ConfigHistoryByPaymentMethodDB returns all versions of the config of the given
+// payment method, the most recent first
+//
+// The secrets of the returned configs will not be decrypted.
+func ConfigHistoryByPaymentMethodDB(db *sql.DB, method *payment_method.Method) ([]*Config, error) {
+	rows, err := db.Query(selectConfigHistoryByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
+	if err != nil {
+		return nil, err
+	}
+	cfgs := make([]*Config, 0, 8)
+	for rows.Next() {
+		cfg := &Config{}
+		err = rows.Scan(
+			&cfg.ProjectID,
+			&cfg.MethodKey,
+			&cfg.Created,
+			&cfg.CreatedBy,
+			&cfg.Endpoint,
+			&cfg.ClientID,
+			&cfg.Secret,
+			&cfg.Type,
+			&cfg.WebhookID,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfgs) == 0 {
+		return nil, ErrConfigNotFound
+	}
+	return cfgs, nil
+}
+
+const insertConfig = `
+INSERT INTO provider_paypal_config
+(project_id, method_key, created, created_by, endpoint, client_id, secret, type, webhook_id)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertConfigTx saves a new version of the config
+//
+// The secret will be encrypted.
+func InsertConfigTx(db *sql.Tx, cfg *Config) error {
+	encSecret, err := secret.Encrypt(cfg.Secret)
+	if err != nil {
+		return err
+	}
+	stmt, err := db.Prepare(insertConfig)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		cfg.ProjectID,
+		cfg.MethodKey,
+		cfg.Created,
+		cfg.CreatedBy,
+		cfg.Endpoint,
+		cfg.ClientID,
+		encSecret,
+		cfg.Type,
+		cfg.WebhookID,
+	)
+	stmt.Close()
+	return err
+}
+
 const selectTransaction = `
 SELECT
 	t.project_id,
@@ -87,7 +178,8 @@ SELECT
 	t.paypal_state,
 	t.paypal_update_time,
 	t.links,
-	t.data
+	t.data,
+	t.event_id
 `
 
 const selectTransactionCurrentByPaymentID = selectTransaction + `
@@ -145,7 +237,30 @@ WHERE
 	)
 `
 
-func scanTransactionRow(row *sql.Row) (*Transaction, error) {
+const selectTransactionsByPaymentIDAndType = selectTransaction + `
+FROM provider_paypal_transaction AS t
+WHERE
+	t.project_id = ?
+	AND
+	t.payment_id = ?
+	AND
+	t.type = ?
+ORDER BY t.timestamp
+`
+
+const selectTransactionByPaypalID = selectTransaction + `
+FROM provider_paypal_transaction AS t
+WHERE
+	t.paypal_id = ?
+ORDER BY t.timestamp DESC
+LIMIT 1
+`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTransactionRow(row scanner) (*Transaction, error) {
 	t := &Transaction{}
 	var ts int64
 	err := row.Scan(
@@ -162,6 +277,7 @@ func scanTransactionRow(row *sql.Row) (*Transaction, error) {
 		&t.PaypalUpdateTime,
 		&t.Links,
 		&t.Data,
+		&t.EventID,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -203,11 +319,37 @@ func TransactionByPaymentIDAndTypeDB(db *sql.DB, paymentID payment.PaymentID, t
 	return scanTransactionRow(row)
 }
 
+// TransactionsByPaymentIDAndTypeTx returns all transactions of the given type on the
+// payment, ordered by their timestamp
+func TransactionsByPaymentIDAndTypeTx(db *sql.Tx, paymentID payment.PaymentID, t string) ([]*Transaction, error) {
+	rows, err := db.Query(selectTransactionsByPaymentIDAndType, paymentID.ProjectID, paymentID.PaymentID, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	txs := make([]*Transaction, 0, 4)
+	for rows.Next() {
+		paypalTx, err := scanTransactionRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, paypalTx)
+	}
+	return txs, rows.Err()
+}
+
+// TransactionByPaypalIDDB returns the most recent transaction referencing the given
+// PayPal payment ID
+func TransactionByPaypalIDDB(db *sql.DB, paypalID string) (*Transaction, error) {
+	row := db.QueryRow(selectTransactionByPaypalID, paypalID)
+	return scanTransactionRow(row)
+}
+
 const insertTransaction = `
 INSERT INTO provider_paypal_transaction
-(project_id, payment_id, timestamp, type, nonce, intent, paypal_id, payer_id, paypal_create_time, paypal_state, paypal_update_time, links, data)
+(project_id, payment_id, timestamp, type, nonce, intent, paypal_id, payer_id, paypal_create_time, paypal_state, paypal_update_time, links, data, event_id)
 VALUES
-(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
 func doInsertTransaction(stmt *sql.Stmt, t *Transaction) error {
@@ -225,6 +367,7 @@ func doInsertTransaction(stmt *sql.Stmt, t *Transaction) error {
 		t.PaypalUpdateTime,
 		t.Links,
 		t.Data,
+		t.EventID,
 	)
 	stmt.Close()
 	return err
@@ -253,12 +396,8 @@ VALUES
 (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
-func InsertAuthorizationTx(db *sql.Tx, auth *Authorization) error {
-	stmt, err := db.Prepare(insertAuthorization)
-	if err != nil {
-		return err
-	}
-	_, err = stmt.Exec(
+func doInsertAuthorization(stmt *sql.Stmt, auth *Authorization) error {
+	_, err := stmt.Exec(
 		auth.ProjectID,
 		auth.PaymentID,
 		auth.Timestamp.UnixNano(),
@@ -274,3 +413,83 @@ func InsertAuthorizationTx(db *sql.Tx, auth *Authorization) error {
 	stmt.Close()
 	return err
 }
+
+func InsertAuthorizationTx(db *sql.Tx, auth *Authorization) error {
+	stmt, err := db.Prepare(insertAuthorization)
+	if err != nil {
+		return err
+	}
+	return doInsertAuthorization(stmt, auth)
+}
+
+func InsertAuthorizationDB(db *sql.DB, auth *Authorization) error {
+	stmt, err := db.Prepare(insertAuthorization)
+	if err != nil {
+		return err
+	}
+	return doInsertAuthorization(stmt, auth)
+}
+
+const selectAuthorization = `
+SELECT
+	a.project_id,
+	a.payment_id,
+	a.timestamp,
+	a.valid_until,
+	a.state,
+	a.authorization_id,
+	a.paypal_id,
+	a.amount,
+	a.currency,
+	a.links,
+	a.data
+FROM provider_paypal_authorization AS a
+WHERE
+	a.project_id = ?
+	AND
+	a.payment_id = ?
+	AND
+	a.timestamp = (
+		SELECT MAX(timestamp) FROM provider_paypal_authorization
+		WHERE
+			project_id = a.project_id
+			AND
+			payment_id = a.payment_id
+	)
+`
+
+func scanAuthorizationRow(row *sql.Row) (*Authorization, error) {
+	auth := &Authorization{}
+	var ts int64
+	err := row.Scan(
+		&auth.ProjectID,
+		&auth.PaymentID,
+		&ts,
+		&auth.ValidUntil,
+		&auth.State,
+		&auth.AuthorizationID,
+		&auth.PaypalID,
+		&auth.Amount,
+		&auth.Currency,
+		&auth.Links,
+		&auth.Data,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return auth, ErrAuthorizationNotFound
+		}
+		return auth, err
+	}
+	auth.Timestamp = time.Unix(0, ts)
+	return auth, nil
+}
+
+func AuthorizationCurrentByPaymentIDTx(db *sql.Tx, paymentID payment.PaymentID) (*Authorization, error) {
+	row := db.QueryRow(selectAuthorization, paymentID.ProjectID, paymentID.PaymentID)
+	return scanAuthorizationRow(row)
+}
+
+func AuthorizationCurrentByPaymentIDDB(db *sql.DB, paymentID payment.PaymentID) (*Authorization, error) {
+	row := db.QueryRow(selectAuthorization, paymentID.ProjectID, paymentID.PaymentID)
+	return scanAuthorizationRow(row)
+}
@@ -0,0 +1,462 @@
+package paypal_rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// endpoint path for the webhook signature verification
+	paypalVerifyWebhookPath = "/v1/notifications/verify-webhook-signature"
+	// maximum size of a webhook request body
+	webhookMaxBodySize = 1 << 16
+	// timeout for payment intents caused by webhook events
+	webhookIntentTimeout = 500 * time.Millisecond
+	// verification status of a valid webhook event
+	verificationSuccess = "SUCCESS"
+)
+
+// PayPal webhook event types
+const (
+	EventSaleCompleted  = "PAYMENT.SALE.COMPLETED"
+	EventSaleDenied     = "PAYMENT.SALE.DENIED"
+	EventSaleRefunded   = "PAYMENT.SALE.REFUNDED"
+	EventSaleReversed   = "PAYMENT.SALE.REVERSED"
+	EventDisputeCreated = "CUSTOMER.DISPUTE.CREATED"
+	EventRiskDispute    = "RISK.DISPUTE.CREATED"
+)
+
+var (
+	ErrWebhookSignature = errors.New("invalid webhook signature")
+	ErrNoWebhookID      = errors.New("no webhook id configured")
+)
+
+// PayPalWebhookEvent represents a webhook event as sent by PayPal
+//
+// See https://developer.paypal.com/docs/api/#webhooks
+type PayPalWebhookEvent struct {
+	ID           string          `json:"id"`
+	CreateTime   string          `json:"create_time"`
+	ResourceType string          `json:"resource_type"`
+	EventType    string          `json:"event_type"`
+	Summary      string          `json:"summary"`
+	Resource     json.RawMessage `json:"resource"`
+}
+
+// PayPalWebhookResource represents the resource of a webhook event
+//
+// It can be a sale, a refund or a dispute.
+type PayPalWebhookResource struct {
+	PayPalResource
+
+	SaleID               string `json:"sale_id,omitempty"`
+	InvoiceNumber        string `json:"invoice_number,omitempty"`
+	DisputedTransactions []struct {
+		InvoiceNumber string `json:"invoice_number"`
+	} `json:"disputed_transactions,omitempty"`
+}
+
+// invoiceNumber returns the invoice number of the resource, which is the encoded
+// payment ID
+func (r *PayPalWebhookResource) invoiceNumber() string {
+	if r.InvoiceNumber != "" {
+		return r.InvoiceNumber
+	}
+	for _, t := range r.DisputedTransactions {
+		if t.InvoiceNumber != "" {
+			return t.InvoiceNumber
+		}
+	}
+	return ""
+}
+
+// PayPalVerifyWebhookRequest represents the request to verify a webhook signature
+type PayPalVerifyWebhookRequest struct {
+	AuthAlgo         string          `json:"auth_algo"`
+	CertURL          string          `json:"cert_url"`
+	TransmissionID   string          `json:"transmission_id"`
+	TransmissionSig  string          `json:"transmission_sig"`
+	TransmissionTime string          `json:"transmission_time"`
+	WebhookID        string          `json:"webhook_id"`
+	WebhookEvent     json.RawMessage `json:"webhook_event"`
+}
+
+type PayPalVerifyWebhookResponse struct {
+	VerificationStatus string `json:"verification_status"`
+}
+
+// amountSubunits converts a PayPal amount into the subunits of the payment currency
+func amountSubunits(p *payment.Payment, total string) (int64, error) {
+	d, ok := dec.NewDecInt64(0).SetString(total)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %s", total)
+	}
+	d.Round(d, dec.Scale(p.Subunits), dec.RoundHalfUp)
+	unscaled := d.Unscaled()
+	if unscaled.BitLen() > 63 {
+		return 0, fmt.Errorf("amount %s out of range", total)
+	}
+	return unscaled.Int64(), nil
+}
+
+// the decimal places of PayPal amounts for currencies, which do not support 2 decimal
+// places
+var currencyDecimals = map[string]int{
+	"HUF": 0,
+	"JPY": 0,
+	"TWD": 0,
+}
+
+// paypalAmount converts an amount in the subunits of the payment currency into a PayPal
+// amount with the decimal places supported by PayPal for the currency
+//
+// It will return an error if the amount can not be represented without rounding.
+func paypalAmount(p *payment.Payment, amount int64) (string, error) {
+	decimals, ok := currencyDecimals[strings.ToUpper(p.Currency)]
+	if !ok {
+		decimals = 2
+	}
+	a, err := currency.ScaleAmount(amount, int(p.Subunits), decimals)
+	if err != nil {
+		return "", err
+	}
+	d := dec.NewDecInt64(a)
+	d.SetScale(dec.Scale(decimals))
+	return d.String(), nil
+}
+
+// WebhookHandler handles the webhook events sent by PayPal
+//
+// The events will be verified through the PayPal API using the webhook ID of the
+// payment method configuration.
+func (d *Driver) WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := d.log.New(log15.Ctx{"method": "WebhookHandler"})
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBodySize))
+		if err != nil {
+			log.Warn("error reading request body", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ev := &PayPalWebhookEvent{}
+		err = json.Unmarshal(body, ev)
+		if err != nil {
+			log.Warn("error decoding event", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"eventID":   ev.ID,
+			"eventType": ev.EventType,
+		})
+		switch ev.EventType {
+		case EventSaleCompleted, EventSaleDenied, EventSaleRefunded, EventSaleReversed,
+			EventDisputeCreated, EventRiskDispute:
+		default:
+			if Debug {
+				log.Debug("ignoring event")
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		res := &PayPalWebhookResource{}
+		err = json.Unmarshal(ev.Resource, res)
+		if err != nil {
+			log.Warn("error decoding event resource", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		paymentID, err := d.webhookPaymentID(res)
+		if err != nil {
+			if err == ErrTransactionNotFound {
+				// not a payment created by paymentd
+				log.Info("no payment for event", log15.Ctx{"parentPayment": res.ParentPayment})
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log.Error("error retrieving payment ID", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID": paymentID.ProjectID,
+			"paymentID": paymentID.PaymentID,
+		})
+		p, err := payment.PaymentByIDDB(d.ctx.PaymentDB(service.ReadOnly), paymentID)
+		if err != nil {
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = d.verifyWebhookEvent(p, r.Header, body)
+		if err != nil {
+			switch err {
+			case ErrNoWebhookID:
+				log.Warn("no webhook id configured")
+				w.WriteHeader(http.StatusForbidden)
+			case ErrWebhookSignature:
+				log.Warn("webhook verification failed")
+				w.WriteHeader(http.StatusUnauthorized)
+			default:
+				log.Error("error verifying webhook event", log15.Ctx{"err": err})
+				// paypal will retry the event
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+
+		maxRetries := d.ctx.Config().Database.TransactionMaxRetries
+		var retries int
+		for {
+			err = d.handleWebhookEvent(paymentID, ev, res, body)
+			if err == paymentService.ErrDBLockTimeout && retries < maxRetries {
+				retries++
+				time.Sleep(time.Duration(retries) * time.Second)
+				continue
+			}
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*payment.TransitionError); ok {
+				// the event does not apply to the payment status
+				log.Info("event does not apply to payment", log15.Ctx{"err": err})
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log.Error("error handling event", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// webhookPaymentID determines the payment ID of a webhook resource
+//
+// It will look up the PayPal transactions by the parent payment first and fall back
+// to the invoice number.
+func (d *Driver) webhookPaymentID(res *PayPalWebhookResource) (payment.PaymentID, error) {
+	if res.ParentPayment != "" {
+		paypalTx, err := TransactionByPaypalIDDB(d.ctx.PaymentDB(service.ReadOnly), res.ParentPayment)
+		if err == nil {
+			return payment.PaymentID{ProjectID: paypalTx.ProjectID, PaymentID: paypalTx.PaymentID}, nil
+		}
+		if err != ErrTransactionNotFound {
+			return payment.PaymentID{}, err
+		}
+	}
+	if res.invoiceNumber() == "" {
+		return payment.PaymentID{}, ErrTransactionNotFound
+	}
+	paymentID, err := payment.ParsePaymentIDStr(res.invoiceNumber())
+	if err != nil {
+		return payment.PaymentID{}, ErrTransactionNotFound
+	}
+	return d.paymentService.DecodedPaymentID(paymentID), nil
+}
+
+// verifyWebhookEvent verifies the webhook event through the PayPal API
+func (d *Driver) verifyWebhookEvent(p *payment.Payment, header http.Header, body []byte) error {
+	if !p.Config.PaymentMethodID.Valid {
+		return ErrInternal
+	}
+	method, err := payment_method.PaymentMethodByIDDB(d.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return err
+	}
+	cfg, err := ConfigByPaymentMethodDB(d.ctx.PaymentDB(service.ReadOnly), method)
+	if err != nil {
+		return err
+	}
+	if !cfg.WebhookID.Valid || cfg.WebhookID.String == "" {
+		return ErrNoWebhookID
+	}
+	verify := &PayPalVerifyWebhookRequest{
+		AuthAlgo:         header.Get("Paypal-Auth-Algo"),
+		CertURL:          header.Get("Paypal-Cert-Url"),
+		TransmissionID:   header.Get("Paypal-Transmission-Id"),
+		TransmissionSig:  header.Get("Paypal-Transmission-Sig"),
+		TransmissionTime: header.Get("Paypal-Transmission-Time"),
+		WebhookID:        cfg.WebhookID.String,
+		WebhookEvent:     json.RawMessage(body),
+	}
+	if verify.TransmissionSig == "" {
+		return ErrWebhookSignature
+	}
+	reqBody, err := json.Marshal(verify)
+	if err != nil {
+		return err
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return err
+	}
+	endpoint.Path = paypalVerifyWebhookPath
+	req, err := http.NewRequest("POST", endpoint.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	responseFunc := func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("invalid HTTP status code %d: %s", resp.StatusCode, respBody)
+		}
+		verifyResp := &PayPalVerifyWebhookResponse{}
+		err = json.Unmarshal(respBody, verifyResp)
+		if err != nil {
+			return err
+		}
+		if verifyResp.VerificationStatus != verificationSuccess {
+			return ErrWebhookSignature
+		}
+		return nil
+	}
+	return httpDo(d.ctx, d.oAuthTransportFunc(p, cfg), req, responseFunc)
+}
+
+// handleWebhookEvent records the event and creates the matching payment transaction
+//
+// Events which were already recorded will be ignored.
+func (d *Driver) handleWebhookEvent(paymentID payment.PaymentID, ev *PayPalWebhookEvent, res *PayPalWebhookResource, body []byte) error {
+	tx, err := d.ctx.PaymentDB().Begin()
+	if err != nil {
+		return err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
+		}
+	}()
+	err = payment.LockPaymentTx(tx, paymentID)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1213 {
+			return paymentService.ErrDBLockTimeout
+		}
+		return err
+	}
+	p, err := payment.PaymentByIDTx(tx, paymentID)
+	if err != nil {
+		return err
+	}
+
+	paypalTx := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeWebhookEvent,
+		Data:      body,
+	}
+	paypalTx.SetEventID(ev.ID)
+	if res.ParentPayment != "" {
+		paypalTx.SetPaypalID(res.ParentPayment)
+	}
+	if res.State != "" {
+		paypalTx.SetState(res.State)
+	}
+	err = InsertTransactionTx(tx, paypalTx)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+			// event already recorded
+			return nil
+		}
+		return err
+	}
+
+	var paymentTx *payment.PaymentTransaction
+	var commitIntent paymentService.CommitIntentFunc
+	switch ev.EventType {
+	case EventSaleCompleted:
+		paymentTx, commitIntent, err = d.paymentService.IntentPaid(p, webhookIntentTimeout)
+	case EventSaleDenied:
+		paymentTx, commitIntent, err = d.paymentService.IntentFailed(p, webhookIntentTimeout)
+	case EventSaleRefunded, EventSaleReversed:
+		var amount int64
+		amount, err = amountSubunits(p, res.Amount.Total)
+		if err != nil {
+			return err
+		}
+		// amounts of refunds and reversals might be negative
+		if amount < 0 {
+			amount = -amount
+		}
+		if ev.EventType == EventSaleRefunded {
+			// refunds requested through the driver were already recorded
+			var recorded bool
+			recorded, err = refundRecordedTx(tx, p.PaymentID(), res.ID)
+			if err != nil {
+				return err
+			}
+			if !recorded {
+				// refunds requested through the driver which are still pending will be
+				// recorded by the intent
+				recorded, err = refundPendingTx(tx, p.PaymentID(), amount)
+				if err != nil {
+					return err
+				}
+			}
+			if recorded {
+				commit = true
+				return tx.Commit()
+			}
+			paymentTx, commitIntent, err = d.paymentService.IntentProviderRefund(tx, p, amount, webhookIntentTimeout)
+		} else {
+			paymentTx, commitIntent, err = d.paymentService.IntentChargeback(tx, p, amount, webhookIntentTimeout)
+		}
+	default:
+		// disputes will only be recorded. A lost dispute will be sent as a reversal.
+		commit = true
+		return tx.Commit()
+	}
+	if err != nil {
+		if _, ok := err.(*payment.TransitionError); ok {
+			// record the event even if it does not apply to the payment status
+			commit = true
+			if commitErr := tx.Commit(); commitErr != nil {
+				return commitErr
+			}
+		}
+		return err
+	}
+	paymentTx.Comment.String, paymentTx.Comment.Valid = "PayPal Event: "+ev.ID, true
+	err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
+	if err != nil {
+		return err
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	commitIntent()
+	return nil
+}
@@ -0,0 +1,111 @@
+package paypal_rest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhookResource(t *testing.T) {
+	Convey("Given a refund event", t, func() {
+		body := []byte(`{
+			"id": "WH-2N242548W9943490U-1JU23391CS4765624",
+			"event_type": "PAYMENT.SALE.REFUNDED",
+			"resource": {
+				"id": "6YX43824R4443062K",
+				"sale_id": "9T0916710M1105906",
+				"parent_payment": "PAY-5437236047802405NKRIZ3UY",
+				"state": "completed",
+				"invoice_number": "1-2",
+				"amount": {"total": "-1.23", "currency": "EUR"}
+			}
+		}`)
+		ev := &PayPalWebhookEvent{}
+		err := json.Unmarshal(body, ev)
+		So(err, ShouldBeNil)
+
+		Convey("When decoding the resource", func() {
+			res := &PayPalWebhookResource{}
+			err = json.Unmarshal(ev.Resource, res)
+
+			Convey("It should succeed", func() {
+				So(err, ShouldBeNil)
+				So(res.ParentPayment, ShouldEqual, "PAY-5437236047802405NKRIZ3UY")
+				So(res.SaleID, ShouldEqual, "9T0916710M1105906")
+				So(res.invoiceNumber(), ShouldEqual, "1-2")
+			})
+
+			Convey("When converting the amount", func() {
+				p := &payment.Payment{Subunits: 2, Currency: "EUR"}
+				amount, err := amountSubunits(p, res.Amount.Total)
+
+				Convey("It should return the amount in subunits", func() {
+					So(err, ShouldBeNil)
+					So(amount, ShouldEqual, -123)
+				})
+			})
+		})
+	})
+
+	Convey("Given a dispute resource", t, func() {
+		res := &PayPalWebhookResource{}
+		err := json.Unmarshal([]byte(`{"disputed_transactions":[{"invoice_number":"1-3"}]}`), res)
+		So(err, ShouldBeNil)
+
+		Convey("It should return the invoice number of the disputed transaction", func() {
+			So(res.invoiceNumber(), ShouldEqual, "1-3")
+		})
+	})
+
+	Convey("Given an invalid amount", t, func() {
+		p := &payment.Payment{Subunits: 2}
+		_, err := amountSubunits(p, "abc")
+
+		Convey("It should return an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPaypalAmount(t *testing.T) {
+	Convey("Given a payment with 3 subunits", t, func() {
+		p := &payment.Payment{Subunits: 3, Currency: "EUR"}
+
+		Convey("When converting an amount in cents", func() {
+			total, err := paypalAmount(p, 12340)
+
+			Convey("It should return the amount with 2 decimal places", func() {
+				So(err, ShouldBeNil)
+				So(total, ShouldEqual, "12.34")
+			})
+		})
+		Convey("When converting an amount with fractions of a cent", func() {
+			_, err := paypalAmount(p, 12345)
+
+			Convey("It should return an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+		Convey("Given a currency without decimal places", func() {
+			p.Currency = "JPY"
+
+			Convey("When converting a whole amount", func() {
+				total, err := paypalAmount(p, 12000)
+
+				Convey("It should return the amount without decimal places", func() {
+					So(err, ShouldBeNil)
+					So(total, ShouldEqual, "12")
+				})
+			})
+			Convey("When converting a fractional amount", func() {
+				_, err := paypalAmount(p, 12340)
+
+				Convey("It should return an error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,50 @@
+package provider
+
+import (
+	"sort"
+	"sync"
+)
+
+// DriverFactory creates a new, unattached instance of a provider driver
+type DriverFactory func() Driver
+
+var (
+	mRegistry sync.RWMutex
+	registry  = make(map[string]DriverFactory)
+)
+
+// Register makes a provider driver available under the given provider name
+//
+// The name must match the provider name in the provider table. Register should be
+// called from an init function, before the services are started. It panics if the
+// factory is nil or if a driver with the same name is already registered.
+func Register(name string, factory DriverFactory) {
+	mRegistry.Lock()
+	defer mRegistry.Unlock()
+	if factory == nil {
+		panic("provider: Register driver factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("provider: Register called twice for driver " + name)
+	}
+	registry[name] = factory
+}
+
+// Drivers returns the sorted names of the registered provider drivers
+func Drivers() []string {
+	mRegistry.RLock()
+	defer mRegistry.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func driverFactory(name string) (DriverFactory, bool) {
+	mRegistry.RLock()
+	f, ok := registry[name]
+	mRegistry.RUnlock()
+	return f, ok
+}
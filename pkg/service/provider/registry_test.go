@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDriverRegistry(t *testing.T) {
+	Convey("Given the driver registry", t, func() {
+		Convey("It should contain the built-in drivers", func() {
+			So(Drivers(), ShouldContain, driverFritzpay)
+			So(Drivers(), ShouldContain, driverPaypalREST)
+			So(Drivers(), ShouldContain, driverStripe)
+		})
+
+		Convey("When registering a driver with an existing name", func() {
+			Convey("It should panic", func() {
+				So(func() {
+					Register(driverFritzpay, func() Driver { return nil })
+				}, ShouldPanic)
+			})
+		})
+
+		Convey("When registering a nil factory", func() {
+			Convey("It should panic", func() {
+				So(func() {
+					Register("nil_factory", nil)
+				}, ShouldPanic)
+				_, ok := driverFactory("nil_factory")
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When linting the templates of a driver without templates", func() {
+			_, err := LintTemplates("", driverFritzpay)
+
+			Convey("It should return ErrNoTemplates", func() {
+				So(err, ShouldEqual, ErrNoTemplates)
+			})
+		})
+
+		Convey("When linting the templates of an unknown driver", func() {
+			_, err := LintTemplates("", "unknown")
+
+			Convey("It should return ErrNoDriver", func() {
+				So(err, ShouldEqual, ErrNoDriver)
+			})
+		})
+
+		Convey("The drivers with templates should provide their template base names", func() {
+			for _, name := range []string{driverPaypalREST, driverStripe, driverSEPA} {
+				f, _ := driverFactory(name)
+				d, ok := f().(TemplateDriver)
+				So(ok, ShouldBeTrue)
+				So(d.TemplateBaseNames(), ShouldNotBeEmpty)
+			}
+		})
+	})
+}
@@ -0,0 +1,6 @@
+// +build debug
+
+package sepa
+
+// Debug flag whether debugging is turned on
+const Debug = true
@@ -0,0 +1,505 @@
+package sepa
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	tmpl "github.com/fritzpay/paymentd/pkg/template"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// SEPADriverPath is the (sub-)path under which SEPA driver endpoints will be
+	// attached
+	SEPADriverPath = "/sepa"
+)
+
+const (
+	providerTemplateDir = "sepa"
+	defaultLocale       = "en_US"
+	// timeout for payment intents
+	intentTimeout = 500 * time.Millisecond
+)
+
+// errors shown on the mandate form
+const (
+	formErrorAccountHolder = "accountHolder"
+	formErrorIBAN          = "iban"
+	formErrorBIC           = "bic"
+	formErrorConsent       = "consent"
+)
+
+var (
+	ErrDatabase = errors.New("database error")
+	ErrInternal = errors.New("sepa driver internal error")
+)
+
+// Driver is the SEPA direct debit provider driver
+//
+// The customer issues a mandate with the mandate form. The payment will be pending
+// until a return file reports the collection.
+type Driver struct {
+	context        *service.Context
+	tmplDir        string
+	templates      *tmpl.Cache
+	log            log15.Logger
+	mux            *mux.Router
+	paymentService *paymentService.Service
+
+	// directory for the exchanged files
+	fileDir          string
+	exchangeInterval time.Duration
+}
+
+func (d *Driver) Attach(ctx *service.Context, m *mux.Router) error {
+	d.context = ctx
+	d.log = ctx.Log().New(log15.Ctx{
+		"pkg": "github.com/fritzpay/paymentd/pkg/service/provider/sepa",
+	})
+
+	var err error
+	d.paymentService, err = paymentService.NewService(ctx)
+	if err != nil {
+		d.log.Error("error initializing payment service", log15.Ctx{"err": err})
+		return err
+	}
+
+	cfg := ctx.Config()
+	if cfg.Provider.ProviderTemplateDir == "" {
+		return fmt.Errorf("provider template dir not set")
+	}
+	d.tmplDir = path.Join(cfg.Provider.ProviderTemplateDir, providerTemplateDir)
+	dirInfo, err := os.Stat(d.tmplDir)
+	if err != nil {
+		d.log.Error("error opening template dir", log15.Ctx{
+			"err":     err,
+			"tmplDir": d.tmplDir,
+		})
+		return err
+	}
+	if !dirInfo.IsDir() {
+		return fmt.Errorf("provider template dir %s is not a directory", d.tmplDir)
+	}
+	d.templates = tmpl.NewCache(d.tmplDir, defaultLocale, tmpl.Funcs(d.staticPath))
+
+	err = d.initFileExchange()
+	if err != nil {
+		d.log.Error("error initializing file exchange", log15.Ctx{"err": err})
+		return err
+	}
+
+	driverRoute := m.PathPrefix(SEPADriverPath)
+	url, err := driverRoute.URLPath()
+	if err != nil {
+		d.log.Error("error determining path prefix", log15.Ctx{"err": err})
+		return fmt.Errorf("error on subroute path: %v", err)
+	}
+	d.mux = driverRoute.Subrouter()
+	d.mux.Handle("/mandate", ctx.RateLimitHandler(d.MandateHandler())).Name("mandateHandler")
+	staticDir := path.Join(d.tmplDir, "static")
+	d.mux.PathPrefix("/static").Handler(http.StripPrefix(url.Path+"/static", http.FileServer(http.Dir(staticDir)))).Name("staticHandler")
+
+	if d.fileDir != "" {
+		go d.handleFileExchange()
+	}
+	return nil
+}
+
+func (d *Driver) InitPayment(p *payment.Payment, pm *payment_method.Method) (http.Handler, error) {
+	log := d.log.New(log15.Ctx{
+		"method":    "InitPayment",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	_, err := centAmount(p)
+	if err != nil {
+		log.Warn("payment cannot be collected", log15.Ctx{
+			"err":      err,
+			"currency": p.Currency,
+		})
+		return nil, err
+	}
+	db := d.context.PaymentDB(service.ReadOnly)
+	cfg, err := ConfigByPaymentMethodDB(db, pm)
+	if err != nil {
+		if err == ErrConfigNotFound {
+			log.Error("no sepa config for payment method", log15.Ctx{"methodKey": pm.MethodKey})
+			return nil, ErrInternal
+		}
+		log.Error("error retrieving sepa config", log15.Ctx{"err": err})
+		return nil, ErrDatabase
+	}
+	// the mandate was already issued
+	mandate, err := MandateByPaymentIDDB(db, p.PaymentID())
+	if err == nil {
+		return d.MandatePageHandler(p, cfg, mandate), nil
+	}
+	if err != ErrMandateNotFound {
+		log.Error("error retrieving mandate", log15.Ctx{"err": err})
+		return nil, ErrDatabase
+	}
+	return d.FormPageHandler(p, cfg, nil), nil
+}
+
+// mandateForm holds the values entered in the mandate form
+type mandateForm struct {
+	AccountHolder string
+	IBAN          string
+	BIC           string
+	Error         string
+}
+
+// validate normalizes and validates the form values
+//
+// It will set the Error field on the first invalid value.
+func (f *mandateForm) validate(consent bool) bool {
+	f.IBAN = NormalizeIBAN(f.IBAN)
+	f.BIC = NormalizeBIC(f.BIC)
+	switch {
+	case !validName(f.AccountHolder):
+		f.Error = formErrorAccountHolder
+	case ValidateIBAN(f.IBAN) != nil:
+		f.Error = formErrorIBAN
+	case f.BIC != "" && ValidateBIC(f.BIC) != nil:
+		f.Error = formErrorBIC
+	case !consent:
+		f.Error = formErrorConsent
+	}
+	return f.Error == ""
+}
+
+// FormPageHandler serves the mandate form
+func (d *Driver) FormPageHandler(p *payment.Payment, cfg *Config, form *mandateForm) http.Handler {
+	const baseName = "form.html.tmpl"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := d.log.New(log15.Ctx{"method": "FormPageHandler"})
+		tmplData := d.templatePaymentData(p)
+		tmplData["creditorName"] = cfg.CreditorName
+		tmplData["creditorID"] = cfg.CreditorID
+		if form == nil {
+			form = &mandateForm{}
+		}
+		tmplData["form"] = form
+		mandateURL, err := d.mux.Get("mandateHandler").URLPath()
+		if err != nil {
+			log.Error("error determining mandate URL", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		tmplData["mandateURL"] = mandateURL.String()
+		d.executeTemplate(w, http.StatusOK, p, baseName, tmplData, log)
+	})
+}
+
+// MandatePageHandler serves the confirmation of an issued mandate
+func (d *Driver) MandatePageHandler(p *payment.Payment, cfg *Config, mandate *Mandate) http.Handler {
+	const baseName = "mandate.html.tmpl"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := d.log.New(log15.Ctx{"method": "MandatePageHandler"})
+		tmplData := d.templatePaymentData(p)
+		tmplData["creditorName"] = cfg.CreditorName
+		tmplData["creditorID"] = cfg.CreditorID
+		tmplData["mandateReference"] = mandate.Reference
+		tmplData["accountHolder"] = mandate.AccountHolder
+		tmplData["iban"] = maskIBAN(mandate.IBAN)
+		d.executeTemplate(w, http.StatusOK, p, baseName, tmplData, log)
+	})
+}
+
+// MandateHandler handles the submitted mandate form
+//
+// On valid input, the mandate will be issued and the payment will be pending until
+// the direct debit was collected. Otherwise the form will be shown again.
+func (d *Driver) MandateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := d.log.New(log15.Ctx{"method": "MandateHandler"})
+		if r.Method != "POST" {
+			d.BadRequestHandler().ServeHTTP(w, r)
+			return
+		}
+		r.ParseForm()
+		paymentIDStr := r.Form.Get("paymentid")
+		paymentID, err := payment.ParsePaymentIDStr(paymentIDStr)
+		if err != nil {
+			log.Warn("error parsing payment ID", log15.Ctx{
+				"err":          err,
+				"paymentIDStr": paymentIDStr,
+			})
+			d.BadRequestHandler().ServeHTTP(w, r)
+			return
+		}
+		paymentID = d.paymentService.DecodedPaymentID(paymentID)
+		log = log.New(log15.Ctx{
+			"projectID": paymentID.ProjectID,
+			"paymentID": paymentID.PaymentID,
+		})
+		form := &mandateForm{
+			AccountHolder: r.Form.Get("accountholder"),
+			IBAN:          r.Form.Get("iban"),
+			BIC:           r.Form.Get("bic"),
+		}
+		if !form.validate(r.Form.Get("consent") != "") {
+			p, cfg, err := d.paymentConfig(paymentID)
+			if err != nil {
+				if err == payment.ErrPaymentNotFound {
+					d.NotFoundHandler(nil).ServeHTTP(w, r)
+					return
+				}
+				log.Error("error retrieving payment", log15.Ctx{"err": err})
+				d.InternalErrorHandler(nil).ServeHTTP(w, r)
+				return
+			}
+			d.FormPageHandler(p, cfg, form).ServeHTTP(w, r)
+			return
+		}
+
+		maxRetries := d.context.Config().Database.TransactionMaxRetries
+		var retries int
+		var p *payment.Payment
+		var cfg *Config
+		var mandate *Mandate
+		for {
+			p, cfg, mandate, err = d.issueMandate(paymentID, form)
+			if err == paymentService.ErrDBLockTimeout && retries < maxRetries {
+				retries++
+				time.Sleep(time.Duration(retries) * time.Second)
+				continue
+			}
+			break
+		}
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				log.Info("payment not found")
+				d.NotFoundHandler(nil).ServeHTTP(w, r)
+				return
+			}
+			log.Error("error issuing mandate", log15.Ctx{"err": err})
+			d.InternalErrorHandler(p).ServeHTTP(w, r)
+			return
+		}
+		d.MandatePageHandler(p, cfg, mandate).ServeHTTP(w, r)
+	})
+}
+
+// paymentConfig returns the payment and the sepa config of its payment method
+func (d *Driver) paymentConfig(paymentID payment.PaymentID) (*payment.Payment, *Config, error) {
+	db := d.context.PaymentDB(service.ReadOnly)
+	p, err := payment.PaymentByIDDB(db, paymentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.Config.PaymentMethodID.Valid {
+		return p, nil, ErrInternal
+	}
+	meth, err := payment_method.PaymentMethodByIDDB(db, p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return p, nil, err
+	}
+	cfg, err := ConfigByPaymentMethodDB(db, meth)
+	if err != nil {
+		return p, nil, err
+	}
+	return p, cfg, nil
+}
+
+// issueMandate saves the mandate of the payment and sets the payment to pending
+//
+// If a mandate was already issued on the payment, it will return the existing
+// mandate.
+func (d *Driver) issueMandate(paymentID payment.PaymentID, form *mandateForm) (*payment.Payment, *Config, *Mandate, error) {
+	tx, err := d.context.PaymentDB().Begin()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
+		}
+	}()
+	err = payment.LockPaymentTx(tx, paymentID)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1213 {
+			return nil, nil, nil, paymentService.ErrDBLockTimeout
+		}
+		return nil, nil, nil, err
+	}
+	p, err := payment.PaymentByIDTx(tx, paymentID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !p.Config.PaymentMethodID.Valid {
+		return p, nil, nil, ErrInternal
+	}
+	meth, err := payment_method.PaymentMethodByIDTx(tx, p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return p, nil, nil, err
+	}
+	cfg, err := ConfigByPaymentMethodTx(tx, meth)
+	if err != nil {
+		return p, nil, nil, err
+	}
+	mandate, err := MandateByPaymentIDTx(tx, paymentID)
+	if err == nil {
+		return p, cfg, mandate, nil
+	}
+	if err != ErrMandateNotFound {
+		return p, cfg, nil, err
+	}
+
+	paymentTx, commitIntent, err := d.paymentService.IntentPending(p, intentTimeout)
+	if err != nil {
+		return p, cfg, nil, err
+	}
+	mandate = &Mandate{
+		ProjectID:     p.ProjectID(),
+		PaymentID:     p.ID(),
+		MethodKey:     meth.MethodKey,
+		Reference:     mandateReference(d.paymentService.EncodedPaymentID(paymentID)),
+		Created:       time.Now().UTC().Truncate(time.Second),
+		AccountHolder: form.AccountHolder,
+		IBAN:          form.IBAN,
+	}
+	if form.BIC != "" {
+		mandate.BIC.String, mandate.BIC.Valid = form.BIC, true
+	}
+	err = InsertMandateTx(tx, mandate)
+	if err != nil {
+		return p, cfg, nil, err
+	}
+	err = InsertTransactionTx(tx, &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeMandate,
+	})
+	if err != nil {
+		return p, cfg, nil, err
+	}
+	paymentTx.Comment.String, paymentTx.Comment.Valid = "SEPA Mandate: "+mandate.Reference, true
+	err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
+	if err != nil {
+		return p, cfg, nil, err
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		return p, cfg, nil, err
+	}
+	commitIntent()
+	return p, cfg, mandate, nil
+}
+
+// maskIBAN masks all but the country code and the last four characters of the IBAN
+func maskIBAN(iban string) string {
+	if len(iban) <= 8 {
+		return iban
+	}
+	b := []byte(iban)
+	for i := 4; i < len(b)-4; i++ {
+		b[i] = '*'
+	}
+	return string(b)
+}
+
+// TemplateBaseNames returns the templates the driver requires in every locale
+func (d *Driver) TemplateBaseNames() []string {
+	return []string{
+		"form.html.tmpl",
+		"mandate.html.tmpl",
+		"not_found.html.tmpl",
+		"internal_error.html.tmpl",
+	}
+}
+
+func (d *Driver) staticPath() (string, error) {
+	url, err := d.mux.Get("staticHandler").URLPath()
+	if err != nil {
+		return "", err
+	}
+	return url.Path, nil
+}
+
+// getTemplate returns the parsed template for the payment
+//
+// The project of the payment may override the template.
+func (d *Driver) getTemplate(p *payment.Payment, locale, baseName string) (*template.Template, error) {
+	var projectID int64
+	if p != nil {
+		projectID = p.ProjectID()
+	}
+	return d.templates.Template(projectID, locale, baseName)
+}
+
+func (d *Driver) executeTemplate(w http.ResponseWriter, status int, p *payment.Payment, baseName string, tmplData map[string]interface{}, log log15.Logger) {
+	locale := defaultLocale
+	if p != nil && p.Config.Locale.Valid {
+		locale = p.Config.Locale.String
+	}
+	t, err := d.getTemplate(p, locale, baseName)
+	if err != nil {
+		log.Error("error initializing template", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err = t.Execute(w, tmplData)
+	if err != nil {
+		log.Error("error executing template", log15.Ctx{"err": err})
+	}
+}
+
+func (d *Driver) templatePaymentData(p *payment.Payment) map[string]interface{} {
+	tmplData := make(map[string]interface{})
+	if p != nil {
+		tmplData["payment"] = p
+		tmplData["paymentID"] = d.paymentService.EncodedPaymentID(p.PaymentID())
+		tmplData["amount"] = p.DecimalRound(2)
+		// link back to the shop if a return URL is configured
+		if returnURL, _, err := d.paymentService.ReturnConfig(p); err == nil && returnURL != "" {
+			tmplData["returnURL"] = paymentService.ReturnPath
+		}
+	}
+	tmplData["timestamp"] = time.Now().Unix()
+	return tmplData
+}
+
+func (d *Driver) BadRequestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+}
+
+func (d *Driver) NotFoundHandler(p *payment.Payment) http.Handler {
+	const baseName = "not_found.html.tmpl"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := d.log.New(log15.Ctx{"method": "NotFoundHandler"})
+		tmplData := d.templatePaymentData(p)
+		// do log so we can find the timestamp in the logs
+		log.Warn("payment not found", log15.Ctx{"timestamp": tmplData["timestamp"]})
+		d.executeTemplate(w, http.StatusNotFound, p, baseName, tmplData, log)
+	})
+}
+
+func (d *Driver) InternalErrorHandler(p *payment.Payment) http.Handler {
+	const baseName = "internal_error.html.tmpl"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := d.log.New(log15.Ctx{"method": "InternalErrorHandler"})
+		tmplData := d.templatePaymentData(p)
+		// do log so we can find the timestamp in the logs
+		log.Error("internal error", log15.Ctx{"timestamp": tmplData["timestamp"]})
+		d.executeTemplate(w, http.StatusInternalServerError, p, baseName, tmplData, log)
+	})
+}
@@ -0,0 +1,479 @@
+package sepa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/server"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// subdirectories of the file exchange directory
+const (
+	// pain.008 batches will be written to this directory
+	exportDir = "out"
+	// return files will be read from this directory
+	importDir = "in"
+	// imported return files will be moved to this subdirectory of the import directory
+	processedDir = "processed"
+	// return files which cannot be read will be moved to this subdirectory of the
+	// import directory
+	failedDir = "failed"
+)
+
+const (
+	// maximum number of payments exported per run
+	exportBatchSize = 1000
+	// timeout for the intents of imported status reports
+	importIntentTimeout = 10 * time.Second
+)
+
+// initFileExchange reads the file exchange config and creates the directories
+//
+// The file exchange is disabled if no directory is configured.
+func (d *Driver) initFileExchange() error {
+	cfg := d.context.Config()
+	d.fileDir = cfg.Provider.SEPA.Dir
+	if d.fileDir == "" {
+		d.log.Info("SEPA file exchange disabled")
+		return nil
+	}
+	interval := cfg.Provider.SEPA.Interval
+	if interval == "" {
+		interval = config.DefaultConfig().Provider.SEPA.Interval
+	}
+	var err error
+	d.exchangeInterval, err = interval.Duration()
+	if err != nil {
+		return fmt.Errorf("invalid SEPA Interval: %v", err)
+	}
+	if d.exchangeInterval <= 0 {
+		return fmt.Errorf("invalid SEPA Interval: %s", interval)
+	}
+	for _, dir := range []string{
+		filepath.Join(d.fileDir, exportDir),
+		filepath.Join(d.fileDir, importDir, processedDir),
+		filepath.Join(d.fileDir, importDir, failedDir),
+	} {
+		err = os.MkdirAll(dir, 0750)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// periodically exports batches and imports return files until the service context
+// is closed
+func (d *Driver) handleFileExchange() {
+	server.Wait.Add(1)
+	defer server.Wait.Done()
+	d.log.Info("SEPA file exchange started", log15.Ctx{
+		"dir":      d.fileDir,
+		"interval": d.exchangeInterval,
+	})
+	tick := time.NewTicker(d.exchangeInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-d.context.Done():
+			return
+		case <-tick.C:
+			d.ImportReturnFiles()
+			d.ExportBatches()
+		}
+	}
+}
+
+// ExportBatches writes the payments with issued mandates, which were not exported
+// yet, as pain.008 files to the export directory
+//
+// One file per payment method will be written.
+func (d *Driver) ExportBatches() {
+	log := d.log.New(log15.Ctx{"method": "ExportBatches"})
+	mandates, err := MandatesPendingExportDB(d.context.PaymentDB(service.ReadOnly), exportBatchSize)
+	if err != nil {
+		log.Error("error retrieving mandates", log15.Ctx{"err": err})
+		return
+	}
+	// the mandates are ordered by payment method
+	for len(mandates) > 0 {
+		n := 1
+		for n < len(mandates) && mandates[n].ProjectID == mandates[0].ProjectID && mandates[n].MethodKey == mandates[0].MethodKey {
+			n++
+		}
+		err = d.exportBatch(mandates[:n])
+		if err != nil {
+			log.Error("error exporting batch", log15.Ctx{
+				"err":       err,
+				"projectID": mandates[0].ProjectID,
+				"methodKey": mandates[0].MethodKey,
+			})
+		}
+		mandates = mandates[n:]
+	}
+}
+
+// exportBatch exports the mandates of a single payment method
+//
+// The file will be written before the export is recorded and renamed afterwards, so
+// only files of committed exports will be visible in the export directory.
+func (d *Driver) exportBatch(mandates []*Mandate) error {
+	log := d.log.New(log15.Ctx{
+		"method":    "exportBatch",
+		"projectID": mandates[0].ProjectID,
+		"methodKey": mandates[0].MethodKey,
+	})
+	tx, err := d.context.PaymentDB().Begin()
+	if err != nil {
+		return err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
+		}
+	}()
+	cfg, err := ConfigByProjectIDAndMethodKeyTx(tx, mandates[0].ProjectID, mandates[0].MethodKey)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	batch := &Batch{
+		MessageID:      "PD" + strconv.FormatInt(cfg.ProjectID, 10) + "-" + strconv.FormatInt(now.UnixNano(), 36),
+		Created:        now,
+		CollectionDate: collectionDate(now),
+		Creditor:       cfg,
+		Debits:         make([]Debit, 0, len(mandates)),
+	}
+	var exportTxs []*Transaction
+	for _, m := range mandates {
+		paymentID := payment.PaymentID{ProjectID: m.ProjectID, PaymentID: m.PaymentID}
+		err = payment.LockPaymentTx(tx, paymentID)
+		if err != nil {
+			return err
+		}
+		p, err := payment.PaymentByIDTx(tx, paymentID)
+		if err != nil {
+			return err
+		}
+		// exported concurrently
+		currentTx, err := TransactionCurrentByPaymentIDTx(tx, paymentID)
+		if err != nil {
+			return err
+		}
+		if currentTx.Type != TransactionTypeMandate {
+			continue
+		}
+		t := &Transaction{
+			ProjectID: p.ProjectID(),
+			PaymentID: p.ID(),
+			Timestamp: time.Now(),
+		}
+		cents, err := centAmount(p)
+		if err != nil || p.Status != payment.PaymentStatusPending {
+			// will not be collected
+			log.Warn("payment not exported", log15.Ctx{
+				"paymentID": p.ID(),
+				"status":    p.Status,
+				"err":       err,
+			})
+			t.Type = TransactionTypeError
+			t.Data = []byte("payment not exported, status " + p.Status.String())
+			err = InsertTransactionTx(tx, t)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		encodedID := d.paymentService.EncodedPaymentID(paymentID)
+		batch.Debits = append(batch.Debits, Debit{
+			EndToEndID:     encodedID.String(),
+			Cents:          cents,
+			Mandate:        m,
+			RemittanceInfo: p.Ident,
+		})
+		t.Type = TransactionTypeExport
+		t.SetMessageID(batch.MessageID)
+		exportTxs = append(exportTxs, t)
+	}
+	if len(batch.Debits) == 0 {
+		commit = true
+		return tx.Commit()
+	}
+
+	buf := &bytes.Buffer{}
+	err = WritePain008(buf, batch)
+	if err != nil {
+		return err
+	}
+	fileName := filepath.Join(d.fileDir, exportDir, batch.MessageID+".xml")
+	tmpFileName := fileName + ".tmp"
+	err = ioutil.WriteFile(tmpFileName, buf.Bytes(), 0640)
+	if err != nil {
+		return err
+	}
+	for _, t := range exportTxs {
+		err = InsertTransactionTx(tx, t)
+		if err != nil {
+			os.Remove(tmpFileName)
+			return err
+		}
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+	err = os.Rename(tmpFileName, fileName)
+	if err != nil {
+		// the payments are recorded as exported
+		log.Crit("error renaming exported batch", log15.Ctx{
+			"err":      err,
+			"fileName": tmpFileName,
+		})
+		return err
+	}
+	log.Info("batch exported", log15.Ctx{
+		"msgID":    batch.MessageID,
+		"fileName": fileName,
+		"count":    len(batch.Debits),
+	})
+	return nil
+}
+
+// ImportReturnFiles imports all return files in the import directory
+//
+// Imported files will be moved to the processed directory. Files which cannot be read
+// will be moved to the failed directory. If a status could not be applied due to a
+// temporary error, the file will be imported again on the next run. Status reports
+// which were already applied will be ignored.
+func (d *Driver) ImportReturnFiles() {
+	log := d.log.New(log15.Ctx{"method": "ImportReturnFiles"})
+	dir := filepath.Join(d.fileDir, importDir)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Error("error reading import directory", log15.Ctx{"err": err})
+		return
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		select {
+		case <-d.context.Done():
+			return
+		default:
+		}
+		fileLog := log.New(log15.Ctx{"fileName": name})
+		fileName := filepath.Join(dir, name)
+		f, err := os.Open(fileName)
+		if err != nil {
+			fileLog.Error("error opening return file", log15.Ctx{"err": err})
+			continue
+		}
+		reports, err := ParseReturnFile(f)
+		f.Close()
+		if err != nil {
+			fileLog.Error("error reading return file", log15.Ctx{"err": err})
+			d.moveImportFile(fileName, failedDir, fileLog)
+			continue
+		}
+		var failed bool
+		for _, report := range reports {
+			err = d.applyStatusReport(report)
+			if err != nil {
+				failed = true
+				fileLog.Error("error applying status report", log15.Ctx{
+					"err":        err,
+					"endToEndID": report.EndToEndID,
+					"msgID":      report.MessageID,
+				})
+			}
+		}
+		if failed {
+			continue
+		}
+		fileLog.Info("return file imported", log15.Ctx{"count": len(reports)})
+		d.moveImportFile(fileName, processedDir, fileLog)
+	}
+}
+
+func (d *Driver) moveImportFile(fileName, subDir string, log log15.Logger) {
+	err := os.Rename(fileName, filepath.Join(filepath.Dir(fileName), subDir, filepath.Base(fileName)))
+	if err != nil {
+		log.Error("error moving return file", log15.Ctx{"err": err})
+	}
+}
+
+// applyStatusReport applies the status report to the payments it refers to
+func (d *Driver) applyStatusReport(report StatusReport) error {
+	if Debug {
+		d.log.Debug("applying status report", log15.Ctx{"report": report})
+	}
+	var paymentIDs []payment.PaymentID
+	if report.EndToEndID != "" {
+		paymentID, err := payment.ParsePaymentIDStr(report.EndToEndID)
+		if err != nil {
+			d.log.Warn("status report for unknown end to end ID", log15.Ctx{"endToEndID": report.EndToEndID})
+			return nil
+		}
+		paymentIDs = append(paymentIDs, d.paymentService.DecodedPaymentID(paymentID))
+	} else {
+		txs, err := ExportTransactionsByMessageIDDB(d.context.PaymentDB(service.ReadOnly), report.MessageID)
+		if err != nil {
+			return err
+		}
+		for _, t := range txs {
+			paymentIDs = append(paymentIDs, payment.PaymentID{ProjectID: t.ProjectID, PaymentID: t.PaymentID})
+		}
+	}
+	maxRetries := d.context.Config().Database.TransactionMaxRetries
+	for _, paymentID := range paymentIDs {
+		var retries int
+		var err error
+		for {
+			err = d.applyPaymentStatus(paymentID, report)
+			if err == paymentService.ErrDBLockTimeout && retries < maxRetries {
+				retries++
+				time.Sleep(time.Duration(retries) * time.Second)
+				continue
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPaymentStatus records the status report and creates the matching payment
+// transaction
+//
+// Collected payments will be paid. Rejected payments will be failed. Returns of paid
+// payments are chargebacks.
+func (d *Driver) applyPaymentStatus(paymentID payment.PaymentID, report StatusReport) error {
+	log := d.log.New(log15.Ctx{
+		"method":    "applyPaymentStatus",
+		"projectID": paymentID.ProjectID,
+		"paymentID": paymentID.PaymentID,
+	})
+	tx, err := d.context.PaymentDB().Begin()
+	if err != nil {
+		return err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
+		}
+	}()
+	err = payment.LockPaymentTx(tx, paymentID)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1213 {
+			return paymentService.ErrDBLockTimeout
+		}
+		return err
+	}
+	p, err := payment.PaymentByIDTx(tx, paymentID)
+	if err != nil {
+		if err == payment.ErrPaymentNotFound {
+			log.Warn("status report for unknown payment")
+			return nil
+		}
+		return err
+	}
+	// only payments collected by the SEPA driver
+	_, err = MandateByPaymentIDTx(tx, paymentID)
+	if err != nil {
+		if err == ErrMandateNotFound {
+			log.Warn("status report for payment without mandate")
+			return nil
+		}
+		return err
+	}
+
+	var paymentTx *payment.PaymentTransaction
+	var commitIntent paymentService.CommitIntentFunc
+	paid := p.Status == payment.PaymentStatusPaid || p.Status == payment.PaymentStatusSettled
+	switch {
+	case report.Status == StatusPaid:
+		paymentTx, commitIntent, err = d.paymentService.IntentPaid(p, importIntentTimeout)
+	case report.Status == StatusFailed && !paid, report.Status == StatusReturned && !paid:
+		paymentTx, commitIntent, err = d.paymentService.IntentFailed(p, importIntentTimeout)
+	case report.Status == StatusFailed, report.Status == StatusReturned:
+		// the debit is returned in full. The chargeback will be capped at the collected
+		// amount, which was not refunded yet
+		paymentTx, commitIntent, err = d.paymentService.IntentChargeback(tx, p, p.Amount, importIntentTimeout)
+	}
+	if err != nil {
+		if _, ok := err.(*payment.TransitionError); ok {
+			// the report was already applied or does not apply
+			log.Info("status report does not apply to payment", log15.Ctx{"err": err})
+			return nil
+		}
+		return err
+	}
+
+	t := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeStatus,
+	}
+	if report.MessageID != "" {
+		t.SetMessageID(report.MessageID)
+	}
+	if report.StatusCode != "" {
+		t.SetStatusCode(report.StatusCode)
+	}
+	if report.ReasonCode != "" {
+		t.SetReasonCode(report.ReasonCode)
+	}
+	t.Data, err = json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	err = InsertTransactionTx(tx, t)
+	if err != nil {
+		return err
+	}
+	if paymentTx != nil {
+		comment := "SEPA: " + report.Status
+		if report.ReasonCode != "" {
+			comment += " " + report.ReasonCode
+		}
+		paymentTx.Comment.String, paymentTx.Comment.Valid = comment, true
+		err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
+		if err != nil {
+			return err
+		}
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	if commitIntent != nil {
+		commitIntent()
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package sepa
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrIBANInvalid       = errors.New("invalid IBAN")
+	ErrBICInvalid        = errors.New("invalid BIC")
+	ErrCreditorIDInvalid = errors.New("invalid creditor identifier")
+)
+
+// ibanLengths contains the IBAN lengths of the countries of the SEPA scheme
+var ibanLengths = map[string]int{
+	"AD": 24, "AT": 20, "BE": 16, "BG": 22, "CH": 21, "CY": 28, "CZ": 24, "DE": 22,
+	"DK": 18, "EE": 20, "ES": 24, "FI": 18, "FR": 27, "GB": 22, "GI": 23, "GR": 27,
+	"HR": 21, "HU": 28, "IE": 22, "IS": 26, "IT": 27, "LI": 21, "LT": 20, "LU": 20,
+	"LV": 21, "MC": 27, "MT": 31, "NL": 18, "NO": 15, "PL": 28, "PT": 25, "RO": 24,
+	"SE": 24, "SI": 19, "SK": 24, "SM": 27, "VA": 22,
+}
+
+var bicRegexp = regexp.MustCompile(`^[A-Z]{6}[A-Z2-9][A-NP-Z0-9]([A-Z0-9]{3})?$`)
+
+// NormalizeIBAN removes spaces from the given IBAN and converts it to upper case
+func NormalizeIBAN(iban string) string {
+	return strings.ToUpper(strings.Replace(strings.TrimSpace(iban), " ", "", -1))
+}
+
+// NormalizeBIC removes spaces from the given BIC and converts it to upper case
+func NormalizeBIC(bic string) string {
+	return NormalizeIBAN(bic)
+}
+
+// ValidateIBAN validates the format, the country specific length and the checksum
+// of the given (normalized) IBAN
+func ValidateIBAN(iban string) error {
+	if len(iban) < 5 {
+		return ErrIBANInvalid
+	}
+	l, ok := ibanLengths[iban[:2]]
+	if !ok || len(iban) != l {
+		return ErrIBANInvalid
+	}
+	if !isDigits(iban[2:4]) {
+		return ErrIBANInvalid
+	}
+	// the country code and the check digits are moved to the end
+	if mod97(iban[4:]+iban[:4]) != 1 {
+		return ErrIBANInvalid
+	}
+	return nil
+}
+
+// ValidateBIC validates the format of the given BIC
+func ValidateBIC(bic string) error {
+	if !bicRegexp.MatchString(bic) {
+		return ErrBICInvalid
+	}
+	return nil
+}
+
+// ValidateCreditorID validates the SEPA creditor identifier
+//
+// The creditor identifier consists of the country code, the check digits, the creditor
+// business code and the national identifier. The check digits are calculated like the
+// IBAN check digits, but without the creditor business code.
+func ValidateCreditorID(id string) error {
+	if len(id) < 8 || len(id) > 35 {
+		return ErrCreditorIDInvalid
+	}
+	if !isUpperAlpha(id[:2]) || !isDigits(id[2:4]) {
+		return ErrCreditorIDInvalid
+	}
+	if mod97(id[7:]+id[:4]) != 1 {
+		return ErrCreditorIDInvalid
+	}
+	return nil
+}
+
+// mod97 calculates the ISO 7064 MOD 97-10 remainder of the given alphanumeric string
+//
+// Letters are converted to numbers, A = 10, B = 11, ..., Z = 35. It will return -1 if
+// the string contains other characters.
+func mod97(s string) int {
+	var r int
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			r = (r*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			r = (r*100 + int(c-'A') + 10) % 97
+		default:
+			return -1
+		}
+	}
+	return r
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isUpperAlpha(s string) bool {
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
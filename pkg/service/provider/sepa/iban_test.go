@@ -0,0 +1,115 @@
+package sepa
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIBAN(t *testing.T) {
+	Convey("Given a valid IBAN with spaces", t, func() {
+		iban := NormalizeIBAN(" de89 3704 0044 0532 0130 00")
+
+		Convey("It should be normalized", func() {
+			So(iban, ShouldEqual, "DE89370400440532013000")
+		})
+		Convey("It should be valid", func() {
+			So(ValidateIBAN(iban), ShouldBeNil)
+		})
+	})
+	Convey("Given IBANs with invalid checksums", t, func() {
+		Convey("They should be invalid", func() {
+			So(ValidateIBAN("DE88370400440532013000"), ShouldEqual, ErrIBANInvalid)
+			So(ValidateIBAN("DE89370400440532013001"), ShouldEqual, ErrIBANInvalid)
+		})
+	})
+	Convey("Given IBANs with an invalid format", t, func() {
+		Convey("They should be invalid", func() {
+			So(ValidateIBAN(""), ShouldEqual, ErrIBANInvalid)
+			So(ValidateIBAN("DE8937040044053201300"), ShouldEqual, ErrIBANInvalid)
+			So(ValidateIBAN("XX89370400440532013000"), ShouldEqual, ErrIBANInvalid)
+			So(ValidateIBAN("DE8937040044053201300!"), ShouldEqual, ErrIBANInvalid)
+		})
+	})
+	Convey("Given IBANs of other SEPA countries", t, func() {
+		Convey("They should be valid", func() {
+			So(ValidateIBAN("AT611904300234573201"), ShouldBeNil)
+			So(ValidateIBAN("GB29NWBK60161331926819"), ShouldBeNil)
+			So(ValidateIBAN("FR1420041010050500013M02606"), ShouldBeNil)
+		})
+	})
+}
+
+func TestBICAndCreditorID(t *testing.T) {
+	Convey("Given BICs", t, func() {
+		Convey("Valid BICs should be accepted", func() {
+			So(ValidateBIC("COBADEFFXXX"), ShouldBeNil)
+			So(ValidateBIC("COBADEFF"), ShouldBeNil)
+		})
+		Convey("Invalid BICs should be rejected", func() {
+			So(ValidateBIC("COBADEF"), ShouldEqual, ErrBICInvalid)
+			So(ValidateBIC("C0BADEFFXXX"), ShouldEqual, ErrBICInvalid)
+		})
+	})
+	Convey("Given creditor identifiers", t, func() {
+		Convey("A valid creditor identifier should be accepted", func() {
+			So(ValidateCreditorID("DE98ZZZ09999999999"), ShouldBeNil)
+		})
+		Convey("A creditor identifier with an invalid checksum should be rejected", func() {
+			So(ValidateCreditorID("DE97ZZZ09999999999"), ShouldEqual, ErrCreditorIDInvalid)
+		})
+	})
+}
+
+func TestCentAmount(t *testing.T) {
+	Convey("Given a euro payment", t, func() {
+		p := &payment.Payment{
+			Amount:   1234,
+			Subunits: 2,
+			Currency: "EUR",
+		}
+		Convey("The cent amount should match", func() {
+			cents, err := centAmount(p)
+			So(err, ShouldBeNil)
+			So(cents, ShouldEqual, 1234)
+			So(formatCents(cents), ShouldEqual, "12.34")
+		})
+		Convey("When the payment has more subunits", func() {
+			p.Amount, p.Subunits = 123400, 4
+
+			Convey("It should be converted to cents", func() {
+				cents, err := centAmount(p)
+				So(err, ShouldBeNil)
+				So(cents, ShouldEqual, 1234)
+			})
+		})
+		Convey("When the payment has fractions of cents", func() {
+			p.Amount, p.Subunits = 123456, 4
+
+			Convey("It should not be collectable", func() {
+				_, err := centAmount(p)
+				So(err, ShouldEqual, ErrAmount)
+			})
+		})
+		Convey("When the payment has half a cent", func() {
+			p.Amount, p.Subunits = 123450, 4
+
+			Convey("It should not be collectable", func() {
+				_, err := centAmount(p)
+				So(err, ShouldEqual, ErrAmount)
+			})
+		})
+	})
+	Convey("Given a payment in another currency", t, func() {
+		p := &payment.Payment{
+			Amount:   1234,
+			Subunits: 2,
+			Currency: "USD",
+		}
+		Convey("It should not be collectable", func() {
+			_, err := centAmount(p)
+			So(err, ShouldEqual, ErrCurrency)
+		})
+	})
+}
@@ -0,0 +1,226 @@
+package sepa
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	pain008Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.008.001.02"
+	// BIC placeholder if the BIC is unknown
+	bicNotProvided = "NOTPROVIDED"
+	isoDate        = "2006-01-02"
+	isoDateTime    = "2006-01-02T15:04:05"
+)
+
+// Batch is a batch of direct debits of a creditor
+//
+// It will be exported as a pain.008 file with a single payment information block. The
+// ID of the payment information block is the message ID.
+type Batch struct {
+	MessageID      string
+	Created        time.Time
+	CollectionDate time.Time
+	Creditor       *Config
+	Debits         []Debit
+}
+
+// Debit is a single direct debit of a batch
+type Debit struct {
+	// EndToEndID identifies the payment in the status reports of the bank
+	EndToEndID     string
+	Cents          int64
+	Mandate        *Mandate
+	RemittanceInfo string
+}
+
+// total returns the total amount of the batch in cents
+func (b *Batch) total() int64 {
+	var sum int64
+	for _, d := range b.Debits {
+		sum += d.Cents
+	}
+	return sum
+}
+
+type pain008Document struct {
+	XMLName xml.Name          `xml:"Document"`
+	Xmlns   string            `xml:"xmlns,attr"`
+	Init    pain008Initiation `xml:"CstmrDrctDbtInitn"`
+}
+
+type pain008Initiation struct {
+	GrpHdr pain008GroupHeader
+	PmtInf pain008PaymentInfo
+}
+
+type pain008GroupHeader struct {
+	MsgId    string
+	CreDtTm  string
+	NbOfTxs  string
+	CtrlSum  string
+	InitgPty pain008Party
+}
+
+type pain008Party struct {
+	Nm string
+}
+
+type pain008PaymentInfo struct {
+	PmtInfId     string
+	PmtMtd       string
+	BtchBookg    bool
+	NbOfTxs      string
+	CtrlSum      string
+	PmtTpInf     pain008PaymentType
+	ReqdColltnDt string
+	Cdtr         pain008Party
+	CdtrAcct     pain008Account
+	CdtrAgt      pain008Agent
+	ChrgBr       string
+	CdtrSchmeId  pain008SchemeID
+	DrctDbtTxInf []pain008Transaction
+}
+
+type pain008PaymentType struct {
+	SvcLvl struct {
+		Cd string
+	}
+	LclInstrm struct {
+		Cd string
+	}
+	SeqTp string
+}
+
+type pain008Account struct {
+	IBAN string `xml:"Id>IBAN"`
+}
+
+type pain008Agent struct {
+	FinInstnId struct {
+		BIC  string `xml:",omitempty"`
+		Othr *pain008OtherID
+	}
+}
+
+type pain008OtherID struct {
+	Id string
+}
+
+type pain008SchemeID struct {
+	ID         string `xml:"Id>PrvtId>Othr>Id"`
+	SchemeName string `xml:"Id>PrvtId>Othr>SchmeNm>Prtry"`
+}
+
+type pain008Amount struct {
+	Ccy   string `xml:"Ccy,attr"`
+	Value string `xml:",chardata"`
+}
+
+type pain008Transaction struct {
+	EndToEndId string `xml:"PmtId>EndToEndId"`
+	InstdAmt   pain008Amount
+	MndtId     string `xml:"DrctDbtTx>MndtRltdInf>MndtId"`
+	DtOfSgntr  string `xml:"DrctDbtTx>MndtRltdInf>DtOfSgntr"`
+	DbtrAgt    pain008Agent
+	Dbtr       pain008Party
+	DbtrAcct   pain008Account
+	RmtInf     *pain008Remittance
+}
+
+type pain008Remittance struct {
+	Ustrd string
+}
+
+func pain008AgentFor(bic string) pain008Agent {
+	a := pain008Agent{}
+	if bic == "" {
+		a.FinInstnId.Othr = &pain008OtherID{Id: bicNotProvided}
+	} else {
+		a.FinInstnId.BIC = bic
+	}
+	return a
+}
+
+// WritePain008 writes the batch as a pain.008.001.02 (SEPA core direct debit) file
+//
+// All debits are one-off direct debits (sequence type OOFF).
+func WritePain008(w io.Writer, b *Batch) error {
+	nbOfTxs := strconv.Itoa(len(b.Debits))
+	ctrlSum := formatCents(b.total())
+	doc := pain008Document{Xmlns: pain008Namespace}
+	doc.Init.GrpHdr = pain008GroupHeader{
+		MsgId:    b.MessageID,
+		CreDtTm:  b.Created.UTC().Format(isoDateTime),
+		NbOfTxs:  nbOfTxs,
+		CtrlSum:  ctrlSum,
+		InitgPty: pain008Party{Nm: sepaText(b.Creditor.CreditorName, maxNameLen)},
+	}
+	pmtInf := pain008PaymentInfo{
+		PmtInfId:     b.MessageID,
+		PmtMtd:       "DD",
+		BtchBookg:    true,
+		NbOfTxs:      nbOfTxs,
+		CtrlSum:      ctrlSum,
+		ReqdColltnDt: b.CollectionDate.Format(isoDate),
+		Cdtr:         pain008Party{Nm: sepaText(b.Creditor.CreditorName, maxNameLen)},
+		CdtrAcct:     pain008Account{IBAN: b.Creditor.IBAN},
+		CdtrAgt:      pain008AgentFor(b.Creditor.BIC),
+		ChrgBr:       "SLEV",
+		CdtrSchmeId: pain008SchemeID{
+			ID:         b.Creditor.CreditorID,
+			SchemeName: "SEPA",
+		},
+		DrctDbtTxInf: make([]pain008Transaction, 0, len(b.Debits)),
+	}
+	pmtInf.PmtTpInf.SvcLvl.Cd = "SEPA"
+	pmtInf.PmtTpInf.LclInstrm.Cd = "CORE"
+	pmtInf.PmtTpInf.SeqTp = "OOFF"
+	for _, d := range b.Debits {
+		var rmtInf *pain008Remittance
+		if d.RemittanceInfo != "" {
+			rmtInf = &pain008Remittance{Ustrd: sepaText(d.RemittanceInfo, maxRemittanceLen)}
+		}
+		pmtInf.DrctDbtTxInf = append(pmtInf.DrctDbtTxInf, pain008Transaction{
+			EndToEndId: d.EndToEndID,
+			InstdAmt:   pain008Amount{Ccy: currencyEUR, Value: formatCents(d.Cents)},
+			MndtId:     d.Mandate.Reference,
+			DtOfSgntr:  d.Mandate.Created.UTC().Format(isoDate),
+			DbtrAgt:    pain008AgentFor(d.Mandate.BIC.String),
+			Dbtr:       pain008Party{Nm: sepaText(d.Mandate.AccountHolder, maxNameLen)},
+			DbtrAcct:   pain008Account{IBAN: d.Mandate.IBAN},
+			RmtInf:     rmtInf,
+		})
+	}
+	doc.Init.PmtInf = pmtInf
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// collectionDate returns the requested collection date for a batch created at the
+// given time
+//
+// The collection date is the second business day (Monday to Friday) after the creation.
+func collectionDate(t time.Time) time.Time {
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	for days := 0; days < 2; {
+		d = d.AddDate(0, 0, 1)
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			days++
+		}
+	}
+	return d
+}
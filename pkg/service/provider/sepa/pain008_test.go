@@ -0,0 +1,90 @@
+package sepa
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWritePain008(t *testing.T) {
+	Convey("Given a batch", t, func() {
+		created := time.Date(2014, 12, 5, 10, 30, 0, 0, time.UTC)
+		b := &Batch{
+			MessageID:      "PD1-test",
+			Created:        created,
+			CollectionDate: collectionDate(created),
+			Creditor: &Config{
+				CreditorName: "Fritz Payment GmbH",
+				CreditorID:   "DE98ZZZ09999999999",
+				IBAN:         "DE89370400440532013000",
+				BIC:          "COBADEFFXXX",
+			},
+			Debits: []Debit{
+				{
+					EndToEndID: "1-1234",
+					Cents:      1234,
+					Mandate: &Mandate{
+						Reference:     "PD-1-1234",
+						Created:       created,
+						AccountHolder: "Jürgen Müller",
+						IBAN:          "AT611904300234573201",
+					},
+					RemittanceInfo: "order <1>",
+				},
+				{
+					EndToEndID: "1-5678",
+					Cents:      100,
+					Mandate: &Mandate{
+						Reference:     "PD-1-5678",
+						Created:       created,
+						AccountHolder: "Jane Doe",
+						IBAN:          "GB29NWBK60161331926819",
+						BIC:           sql.NullString{String: "NWBKGB2L", Valid: true},
+					},
+				},
+			},
+		}
+
+		Convey("The collection date should be two business days later", func() {
+			So(b.CollectionDate.Format(isoDate), ShouldEqual, "2014-12-09")
+		})
+
+		Convey("When writing the pain.008 file", func() {
+			buf := &bytes.Buffer{}
+			err := WritePain008(buf, b)
+			So(err, ShouldBeNil)
+			doc := buf.String()
+
+			Convey("It should contain the group header", func() {
+				So(doc, ShouldContainSubstring, `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.008.001.02">`)
+				So(doc, ShouldContainSubstring, "<MsgId>PD1-test</MsgId>")
+				So(doc, ShouldContainSubstring, "<CreDtTm>2014-12-05T10:30:00</CreDtTm>")
+				So(doc, ShouldContainSubstring, "<NbOfTxs>2</NbOfTxs>")
+				So(doc, ShouldContainSubstring, "<CtrlSum>13.34</CtrlSum>")
+			})
+			Convey("It should contain the creditor", func() {
+				So(doc, ShouldContainSubstring, "<ReqdColltnDt>2014-12-09</ReqdColltnDt>")
+				So(doc, ShouldContainSubstring, "<Id>DE98ZZZ09999999999</Id>")
+				So(doc, ShouldContainSubstring, "<SeqTp>OOFF</SeqTp>")
+			})
+			Convey("It should contain the debits", func() {
+				So(doc, ShouldContainSubstring, "<EndToEndId>1-1234</EndToEndId>")
+				So(doc, ShouldContainSubstring, `<InstdAmt Ccy="EUR">12.34</InstdAmt>`)
+				So(doc, ShouldContainSubstring, "<MndtId>PD-1-1234</MndtId>")
+				So(doc, ShouldContainSubstring, "<DtOfSgntr>2014-12-05</DtOfSgntr>")
+				So(doc, ShouldContainSubstring, `<InstdAmt Ccy="EUR">1.00</InstdAmt>`)
+				So(doc, ShouldContainSubstring, "<BIC>NWBKGB2L</BIC>")
+			})
+			Convey("Unknown BICs should not be provided", func() {
+				So(doc, ShouldContainSubstring, "<Id>NOTPROVIDED</Id>")
+			})
+			Convey("Texts should only contain SEPA characters", func() {
+				So(doc, ShouldContainSubstring, "<Nm>J rgen M ller</Nm>")
+				So(doc, ShouldContainSubstring, "<Ustrd>order  1 </Ustrd>")
+			})
+		})
+	})
+}
@@ -0,0 +1,219 @@
+package sepa
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+)
+
+// Statuses of status reports
+const (
+	// StatusPaid denotes a collected direct debit
+	StatusPaid = "paid"
+	// StatusFailed denotes a direct debit rejected before the collection
+	StatusFailed = "failed"
+	// StatusReturned denotes a collected direct debit, which was returned by the
+	// debtor bank, i.e. on a refusal of the debtor
+	StatusReturned = "returned"
+	// StatusPending denotes a direct debit which was accepted, but not collected yet
+	StatusPending = "pending"
+)
+
+var (
+	ErrReturnFile = errors.New("not a pain.002 or camt.054 file")
+)
+
+// StatusReport is the status of a direct debit or of a whole batch read from a
+// return file
+type StatusReport struct {
+	// EndToEndID identifies the direct debit. If it is empty, the report applies to
+	// all direct debits of the batch with the MessageID
+	EndToEndID string
+	MessageID  string
+	Status     string
+	// StatusCode is the transaction status of a pain.002 (i.e. RJCT) or the booking
+	// status of a camt.054 entry (i.e. BOOK)
+	StatusCode string
+	// ReasonCode is the ISO reason code of a rejection or return
+	ReasonCode string
+}
+
+type returnDocument struct {
+	XMLName      xml.Name
+	StatusReport *pain002Report  `xml:"CstmrPmtStsRpt"`
+	Notification *camt054Message `xml:"BkToCstmrDbtCdtNtfctn"`
+}
+
+type reasonInfo struct {
+	Cd    string `xml:"Rsn>Cd"`
+	Prtry string `xml:"Rsn>Prtry"`
+}
+
+func reasonCode(infos []reasonInfo) string {
+	for _, i := range infos {
+		if i.Cd != "" {
+			return i.Cd
+		}
+		if i.Prtry != "" {
+			return i.Prtry
+		}
+	}
+	return ""
+}
+
+type pain002Report struct {
+	OrgnlGrpInfAndSts struct {
+		OrgnlMsgId string
+		GrpSts     string
+		StsRsnInf  []reasonInfo
+	}
+	OrgnlPmtInfAndSts []struct {
+		OrgnlPmtInfId string
+		PmtInfSts     string
+		StsRsnInf     []reasonInfo
+		TxInfAndSts   []struct {
+			OrgnlEndToEndId string
+			TxSts           string
+			StsRsnInf       []reasonInfo
+		}
+	}
+}
+
+type camt054Message struct {
+	Ntfctn []struct {
+		Ntry []struct {
+			CdtDbtInd string
+			RvslInd   bool
+			Sts       string
+			NtryDtls  []struct {
+				TxDtls []struct {
+					Refs struct {
+						MsgId      string
+						PmtInfId   string
+						EndToEndId string
+					}
+					RtrInf struct {
+						Cd    string `xml:"Rsn>Cd"`
+						Prtry string `xml:"Rsn>Prtry"`
+					}
+				}
+			}
+		}
+	}
+}
+
+// pain.002 transaction status codes
+const (
+	txStatusAcceptedSettlement = "ACSC"
+	txStatusRejected           = "RJCT"
+)
+
+func pain002Status(txSts string) string {
+	switch txSts {
+	case txStatusAcceptedSettlement:
+		return StatusPaid
+	case txStatusRejected:
+		return StatusFailed
+	default:
+		return StatusPending
+	}
+}
+
+// ParseReturnFile reads the status reports of a pain.002 (payment status report) or
+// camt.054 (debit/credit notification) file
+//
+// Only booked camt.054 entries will be reported. Credits will be reported as paid,
+// debits and reversals as returned.
+func ParseReturnFile(r io.Reader) ([]StatusReport, error) {
+	doc := &returnDocument{}
+	err := xml.NewDecoder(r).Decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case doc.StatusReport != nil:
+		return doc.StatusReport.reports(), nil
+	case doc.Notification != nil:
+		return doc.Notification.reports(), nil
+	default:
+		return nil, ErrReturnFile
+	}
+}
+
+func (rpt *pain002Report) reports() []StatusReport {
+	var reports []StatusReport
+	grp := rpt.OrgnlGrpInfAndSts
+	for _, pmtInf := range rpt.OrgnlPmtInfAndSts {
+		if len(pmtInf.TxInfAndSts) == 0 && pmtInf.PmtInfSts != "" {
+			// status of the whole payment information block
+			reports = append(reports, StatusReport{
+				MessageID:  pmtInf.OrgnlPmtInfId,
+				Status:     pain002Status(pmtInf.PmtInfSts),
+				StatusCode: pmtInf.PmtInfSts,
+				ReasonCode: reasonCode(pmtInf.StsRsnInf),
+			})
+			continue
+		}
+		for _, txInf := range pmtInf.TxInfAndSts {
+			reports = append(reports, StatusReport{
+				EndToEndID: txInf.OrgnlEndToEndId,
+				MessageID:  grp.OrgnlMsgId,
+				Status:     pain002Status(txInf.TxSts),
+				StatusCode: txInf.TxSts,
+				ReasonCode: reasonCode(txInf.StsRsnInf),
+			})
+		}
+	}
+	if len(reports) == 0 && grp.GrpSts != "" {
+		// status of the whole batch
+		reports = append(reports, StatusReport{
+			MessageID:  grp.OrgnlMsgId,
+			Status:     pain002Status(grp.GrpSts),
+			StatusCode: grp.GrpSts,
+			ReasonCode: reasonCode(grp.StsRsnInf),
+		})
+	}
+	return reports
+}
+
+// camt.054 entry values
+const (
+	entryStatusBooked = "BOOK"
+	entryCredit       = "CRDT"
+)
+
+func (msg *camt054Message) reports() []StatusReport {
+	var reports []StatusReport
+	for _, ntfctn := range msg.Ntfctn {
+		for _, ntry := range ntfctn.Ntry {
+			if ntry.Sts != entryStatusBooked {
+				continue
+			}
+			for _, dtls := range ntry.NtryDtls {
+				for _, txDtls := range dtls.TxDtls {
+					report := StatusReport{
+						EndToEndID: txDtls.Refs.EndToEndId,
+						MessageID:  txDtls.Refs.MsgId,
+						Status:     StatusPaid,
+						StatusCode: ntry.Sts,
+						ReasonCode: txDtls.RtrInf.Cd,
+					}
+					if report.ReasonCode == "" {
+						report.ReasonCode = txDtls.RtrInf.Prtry
+					}
+					if report.MessageID == "" {
+						report.MessageID = txDtls.Refs.PmtInfId
+					}
+					if ntry.CdtDbtInd != entryCredit || ntry.RvslInd || report.ReasonCode != "" {
+						report.Status = StatusReturned
+					}
+					if report.EndToEndID == "" && report.MessageID == "" {
+						continue
+					}
+					reports = append(reports, report)
+				}
+			}
+		}
+	}
+	return reports
+}
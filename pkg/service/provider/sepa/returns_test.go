@@ -0,0 +1,128 @@
+package sepa
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testPain002 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.002.001.03">
+  <CstmrPmtStsRpt>
+    <GrpHdr><MsgId>STATUS-1</MsgId><CreDtTm>2014-12-08T10:00:00</CreDtTm></GrpHdr>
+    <OrgnlGrpInfAndSts>
+      <OrgnlMsgId>PD1-test</OrgnlMsgId>
+      <OrgnlMsgNmId>pain.008.001.02</OrgnlMsgNmId>
+      <GrpSts>PART</GrpSts>
+    </OrgnlGrpInfAndSts>
+    <OrgnlPmtInfAndSts>
+      <OrgnlPmtInfId>PD1-test</OrgnlPmtInfId>
+      <TxInfAndSts>
+        <OrgnlEndToEndId>1-1234</OrgnlEndToEndId>
+        <TxSts>RJCT</TxSts>
+        <StsRsnInf><Rsn><Cd>AC04</Cd></Rsn></StsRsnInf>
+      </TxInfAndSts>
+      <TxInfAndSts>
+        <OrgnlEndToEndId>1-5678</OrgnlEndToEndId>
+        <TxSts>ACSC</TxSts>
+      </TxInfAndSts>
+      <TxInfAndSts>
+        <OrgnlEndToEndId>1-9999</OrgnlEndToEndId>
+        <TxSts>ACCP</TxSts>
+      </TxInfAndSts>
+    </OrgnlPmtInfAndSts>
+  </CstmrPmtStsRpt>
+</Document>`
+
+const testPain002Group = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.002.001.03">
+  <CstmrPmtStsRpt>
+    <OrgnlGrpInfAndSts>
+      <OrgnlMsgId>PD1-test</OrgnlMsgId>
+      <GrpSts>RJCT</GrpSts>
+      <StsRsnInf><Rsn><Cd>FF01</Cd></Rsn></StsRsnInf>
+    </OrgnlGrpInfAndSts>
+  </CstmrPmtStsRpt>
+</Document>`
+
+const testCamt054 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.054.001.02">
+  <BkToCstmrDbtCdtNtfctn>
+    <Ntfctn>
+      <Ntry>
+        <Amt Ccy="EUR">12.34</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <NtryDtls><TxDtls><Refs><EndToEndId>1-1234</EndToEndId></Refs></TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">1.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <NtryDtls>
+          <TxDtls>
+            <Refs><EndToEndId>1-5678</EndToEndId></Refs>
+            <RtrInf><Rsn><Cd>MD06</Cd></Rsn></RtrInf>
+          </TxDtls>
+        </NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">5.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>PDNG</Sts>
+        <NtryDtls><TxDtls><Refs><EndToEndId>1-9999</EndToEndId></Refs></TxDtls></NtryDtls>
+      </Ntry>
+    </Ntfctn>
+  </BkToCstmrDbtCdtNtfctn>
+</Document>`
+
+func TestParseReturnFile(t *testing.T) {
+	Convey("Given a pain.002 file with transaction statuses", t, func() {
+		reports, err := ParseReturnFile(strings.NewReader(testPain002))
+		So(err, ShouldBeNil)
+
+		Convey("It should report the status of each direct debit", func() {
+			So(len(reports), ShouldEqual, 3)
+			So(reports[0].EndToEndID, ShouldEqual, "1-1234")
+			So(reports[0].MessageID, ShouldEqual, "PD1-test")
+			So(reports[0].Status, ShouldEqual, StatusFailed)
+			So(reports[0].StatusCode, ShouldEqual, "RJCT")
+			So(reports[0].ReasonCode, ShouldEqual, "AC04")
+			So(reports[1].Status, ShouldEqual, StatusPaid)
+			So(reports[2].Status, ShouldEqual, StatusPending)
+		})
+	})
+	Convey("Given a pain.002 file rejecting the whole batch", t, func() {
+		reports, err := ParseReturnFile(strings.NewReader(testPain002Group))
+		So(err, ShouldBeNil)
+
+		Convey("It should report the status of the batch", func() {
+			So(len(reports), ShouldEqual, 1)
+			So(reports[0].EndToEndID, ShouldEqual, "")
+			So(reports[0].MessageID, ShouldEqual, "PD1-test")
+			So(reports[0].Status, ShouldEqual, StatusFailed)
+			So(reports[0].ReasonCode, ShouldEqual, "FF01")
+		})
+	})
+	Convey("Given a camt.054 file", t, func() {
+		reports, err := ParseReturnFile(strings.NewReader(testCamt054))
+		So(err, ShouldBeNil)
+
+		Convey("It should report the booked entries", func() {
+			So(len(reports), ShouldEqual, 2)
+			So(reports[0].EndToEndID, ShouldEqual, "1-1234")
+			So(reports[0].Status, ShouldEqual, StatusPaid)
+			So(reports[1].EndToEndID, ShouldEqual, "1-5678")
+			So(reports[1].Status, ShouldEqual, StatusReturned)
+			So(reports[1].ReasonCode, ShouldEqual, "MD06")
+		})
+	})
+	Convey("Given another XML file", t, func() {
+		_, err := ParseReturnFile(strings.NewReader(`<Document><Other/></Document>`))
+
+		Convey("It should fail", func() {
+			So(err, ShouldEqual, ErrReturnFile)
+		})
+	})
+}
@@ -0,0 +1,164 @@
+package sepa
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+)
+
+// SEPA transaction types
+const (
+	// TransactionTypeMandate is recorded when the customer issued the mandate. The
+	// payment will be included in the next batch
+	TransactionTypeMandate = "mandate"
+	// TransactionTypeExport is recorded when the payment was exported in a batch
+	TransactionTypeExport = "export"
+	// TransactionTypeStatus is recorded for each status imported from a return file
+	TransactionTypeStatus = "status"
+	// TransactionTypeError is recorded when the payment could not be exported
+	TransactionTypeError = "error"
+)
+
+const (
+	// the only currency of the SEPA direct debit scheme
+	currencyEUR = "EUR"
+	// maximum length of SEPA identifiers
+	maxIDLen = 35
+	// maximum length of names
+	maxNameLen = 70
+	// maximum length of the unstructured remittance information
+	maxRemittanceLen = 140
+)
+
+var (
+	ErrCurrency = errors.New("currency not supported by SEPA")
+	ErrAmount   = errors.New("amount cannot be collected")
+)
+
+// Config is the creditor config of a SEPA payment method
+type Config struct {
+	ProjectID int64
+	MethodKey string
+	Created   time.Time
+	CreatedBy string
+
+	// CreditorName is the name of the creditor as shown on the mandate
+	CreditorName string
+	// CreditorID is the SEPA creditor identifier
+	CreditorID string
+	// IBAN is the account of the creditor
+	IBAN string
+	// BIC is the BIC of the creditor bank. It is optional
+	BIC string
+}
+
+// Mandate is the direct debit mandate of a payment
+//
+// Each payment has its own one-off mandate.
+type Mandate struct {
+	ProjectID int64
+	PaymentID int64
+	// MethodKey is the key of the payment method. The payment will be collected
+	// with the creditor config of the method
+	MethodKey string
+	// Reference is the unique mandate reference
+	Reference     string
+	Created       time.Time
+	AccountHolder string
+	IBAN          string
+	BIC           sql.NullString
+}
+
+// Transaction represents a transaction on a SEPA direct debit
+//
+// The most recent transaction denotes the state of the direct debit.
+type Transaction struct {
+	ProjectID int64
+	PaymentID int64
+	Timestamp time.Time
+	Type      string
+	// MessageID is the message ID of the batch the payment was exported with
+	MessageID sql.NullString
+	// StatusCode is the status of an imported status report, i.e. ACSC or RJCT
+	StatusCode sql.NullString
+	// ReasonCode is the ISO reason code of a rejection or return, i.e. AC04
+	ReasonCode sql.NullString
+	Data       []byte
+}
+
+func (t *Transaction) SetMessageID(id string) {
+	t.MessageID.String, t.MessageID.Valid = id, true
+}
+
+func (t *Transaction) SetStatusCode(code string) {
+	t.StatusCode.String, t.StatusCode.Valid = code, true
+}
+
+func (t *Transaction) SetReasonCode(code string) {
+	t.ReasonCode.String, t.ReasonCode.Valid = code, true
+}
+
+// mandateReference returns the mandate reference for the payment with the given
+// (encoded) payment ID
+func mandateReference(encodedPaymentID payment.PaymentID) string {
+	return "PD-" + encodedPaymentID.String()
+}
+
+// centAmount returns the amount of the payment in euro cents
+//
+// It will return an ErrCurrency if the payment is not in euro and an ErrAmount if
+// the amount cannot be expressed in cents.
+func centAmount(p *payment.Payment) (int64, error) {
+	if p.Currency != currencyEUR {
+		return 0, ErrCurrency
+	}
+	amount := p.Amount
+	subunits := p.Subunits
+	for ; subunits < 2; subunits++ {
+		amount *= 10
+	}
+	for ; subunits > 2; subunits-- {
+		if amount%10 != 0 {
+			return 0, ErrAmount
+		}
+		amount /= 10
+	}
+	if amount <= 0 {
+		return 0, ErrAmount
+	}
+	return amount, nil
+}
+
+// formatCents formats a cent amount as a decimal with two fraction digits
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+// sepaText replaces all characters which are not in the SEPA character set and
+// truncates the text to the given maximum length
+func sepaText(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for _, c := range s {
+		if len(b) >= max {
+			break
+		}
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '/', c == '-', c == '?', c == ':', c == '(', c == ')', c == '.', c == ',', c == '\'', c == '+', c == ' ':
+		default:
+			c = ' '
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+
+// validName returns true if the given name can be used as the account holder or
+// creditor name
+func validName(name string) bool {
+	return name != "" && utf8.RuneCountInString(name) <= maxNameLen
+}
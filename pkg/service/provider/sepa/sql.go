@@ -0,0 +1,401 @@
+package sepa
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/secret"
+)
+
+var (
+	ErrConfigNotFound      = errors.New("config not found")
+	ErrMandateNotFound     = errors.New("mandate not found")
+	ErrTransactionNotFound = errors.New("transaction not found")
+)
+
+const selectConfig = `
+SELECT
+	c.project_id,
+	c.method_key,
+	c.created,
+	c.created_by,
+	c.creditor_name,
+	c.creditor_id,
+	c.iban,
+	c.bic
+FROM provider_sepa_config AS c
+`
+const selectConfigByProjectIDAndMethodKey = selectConfig + `
+WHERE
+	c.project_id = ?
+	AND
+	c.method_key = ?
+	AND
+	c.created = (
+		SELECT MAX(created) FROM provider_sepa_config
+		WHERE
+			project_id = c.project_id
+			AND
+			method_key = c.method_key
+	)
+`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanConfig(row scanner) (*Config, error) {
+	cfg := &Config{}
+	err := row.Scan(
+		&cfg.ProjectID,
+		&cfg.MethodKey,
+		&cfg.Created,
+		&cfg.CreatedBy,
+		&cfg.CreditorName,
+		&cfg.CreditorID,
+		&cfg.IBAN,
+		&cfg.BIC,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return cfg, ErrConfigNotFound
+		}
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func ConfigByPaymentMethodTx(db *sql.Tx, method *payment_method.Method) (*Config, error) {
+	row := db.QueryRow(selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
+	return scanConfig(row)
+}
+
+func ConfigByPaymentMethodDB(db *sql.DB, method *payment_method.Method) (*Config, error) {
+	row := db.QueryRow(selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
+	return scanConfig(row)
+}
+
+// ConfigByProjectIDAndMethodKeyTx returns the current config of the payment method
+// with the given project ID and method key
+func ConfigByProjectIDAndMethodKeyTx(db *sql.Tx, projectID int64, methodKey string) (*Config, error) {
+	row := db.QueryRow(selectConfigByProjectIDAndMethodKey, projectID, methodKey)
+	return scanConfig(row)
+}
+
+const selectConfigHistoryByProjectIDAndMethodKey = selectConfig + `
+WHERE
+	c.project_id = ?
+	AND
+	c.method_key = ?
+ORDER BY c.created DESC
+`
+
+// ConfigHistoryByPaymentMethodDB returns all versions of the config of the given
+// payment method, the most recent first
+func ConfigHistoryByPaymentMethodDB(db *sql.DB, method *payment_method.Method) ([]*Config, error) {
+	rows, err := db.Query(selectConfigHistoryByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
+	if err != nil {
+		return nil, err
+	}
+	cfgs := make([]*Config, 0, 8)
+	for rows.Next() {
+		cfg, err := scanConfig(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfgs) == 0 {
+		return nil, ErrConfigNotFound
+	}
+	return cfgs, nil
+}
+
+const insertConfig = `
+INSERT INTO provider_sepa_config
+(project_id, method_key, created, created_by, creditor_name, creditor_id, iban, bic)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertConfigTx saves a new version of the config
+func InsertConfigTx(db *sql.Tx, cfg *Config) error {
+	stmt, err := db.Prepare(insertConfig)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		cfg.ProjectID,
+		cfg.MethodKey,
+		cfg.Created,
+		cfg.CreatedBy,
+		cfg.CreditorName,
+		cfg.CreditorID,
+		cfg.IBAN,
+		cfg.BIC,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectMandate = `
+SELECT
+	m.project_id,
+	m.payment_id,
+	m.method_key,
+	m.reference,
+	m.created,
+	m.account_holder,
+	m.iban,
+	m.bic
+FROM provider_sepa_mandate AS m
+`
+
+const selectMandateByPaymentID = selectMandate + `
+WHERE
+	m.project_id = ?
+	AND
+	m.payment_id = ?
+`
+
+// the most recent transaction of the payments with a pending export is the mandate
+const selectMandatePendingExport = selectMandate + `
+INNER JOIN provider_sepa_transaction AS t ON
+	t.project_id = m.project_id
+	AND
+	t.payment_id = m.payment_id
+	AND
+	t.timestamp = (
+		SELECT MAX(timestamp) FROM provider_sepa_transaction
+		WHERE
+			project_id = t.project_id
+			AND
+			payment_id = t.payment_id
+	)
+WHERE
+	t.type = ?
+ORDER BY m.project_id, m.method_key, m.payment_id
+LIMIT ?
+`
+
+// the debtor IBAN is personal data and will be stored encrypted
+func scanMandate(row scanner) (*Mandate, error) {
+	m := &Mandate{}
+	err := row.Scan(
+		&m.ProjectID,
+		&m.PaymentID,
+		&m.MethodKey,
+		&m.Reference,
+		&m.Created,
+		&m.AccountHolder,
+		&m.IBAN,
+		&m.BIC,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return m, ErrMandateNotFound
+		}
+		return m, err
+	}
+	m.IBAN, err = secret.Decrypt(m.IBAN)
+	if err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func MandateByPaymentIDTx(db *sql.Tx, paymentID payment.PaymentID) (*Mandate, error) {
+	row := db.QueryRow(selectMandateByPaymentID, paymentID.ProjectID, paymentID.PaymentID)
+	return scanMandate(row)
+}
+
+func MandateByPaymentIDDB(db *sql.DB, paymentID payment.PaymentID) (*Mandate, error) {
+	row := db.QueryRow(selectMandateByPaymentID, paymentID.ProjectID, paymentID.PaymentID)
+	return scanMandate(row)
+}
+
+// MandatesPendingExportDB returns the mandates of the payments which were not
+// exported yet
+//
+// The mandates are ordered by project and payment method.
+func MandatesPendingExportDB(db *sql.DB, limit int) ([]*Mandate, error) {
+	rows, err := db.Query(selectMandatePendingExport, TransactionTypeMandate, limit)
+	if err != nil {
+		return nil, err
+	}
+	mandates := make([]*Mandate, 0, limit)
+	for rows.Next() {
+		m, err := scanMandate(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		mandates = append(mandates, m)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return mandates, nil
+}
+
+const insertMandate = `
+INSERT INTO provider_sepa_mandate
+(project_id, payment_id, method_key, reference, created, account_holder, iban, bic)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertMandateTx saves the mandate of a payment
+//
+// The IBAN will be encrypted.
+func InsertMandateTx(db *sql.Tx, m *Mandate) error {
+	iban, err := secret.Encrypt(m.IBAN)
+	if err != nil {
+		return err
+	}
+	stmt, err := db.Prepare(insertMandate)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		m.ProjectID,
+		m.PaymentID,
+		m.MethodKey,
+		m.Reference,
+		m.Created,
+		m.AccountHolder,
+		iban,
+		m.BIC,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectTransaction = `
+SELECT
+	t.project_id,
+	t.payment_id,
+	t.timestamp,
+	t.type,
+	t.msg_id,
+	t.status_code,
+	t.reason_code,
+	t.data
+FROM provider_sepa_transaction AS t
+`
+
+const selectTransactionCurrentByPaymentID = selectTransaction + `
+WHERE
+	t.project_id = ?
+	AND
+	t.payment_id = ?
+	AND
+	t.timestamp = (
+		SELECT MAX(timestamp) FROM provider_sepa_transaction
+		WHERE
+			project_id = t.project_id
+			AND
+			payment_id = t.payment_id
+	)
+`
+
+const selectTransactionByMessageID = selectTransaction + `
+WHERE
+	t.msg_id = ?
+	AND
+	t.type = ?
+`
+
+func scanTransaction(row scanner) (*Transaction, error) {
+	t := &Transaction{}
+	var ts int64
+	err := row.Scan(
+		&t.ProjectID,
+		&t.PaymentID,
+		&ts,
+		&t.Type,
+		&t.MessageID,
+		&t.StatusCode,
+		&t.ReasonCode,
+		&t.Data,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return t, ErrTransactionNotFound
+		}
+		return t, err
+	}
+	t.Timestamp = time.Unix(0, ts)
+	return t, nil
+}
+
+func TransactionCurrentByPaymentIDTx(db *sql.Tx, paymentID payment.PaymentID) (*Transaction, error) {
+	row := db.QueryRow(selectTransactionCurrentByPaymentID, paymentID.ProjectID, paymentID.PaymentID)
+	return scanTransaction(row)
+}
+
+func TransactionCurrentByPaymentIDDB(db *sql.DB, paymentID payment.PaymentID) (*Transaction, error) {
+	row := db.QueryRow(selectTransactionCurrentByPaymentID, paymentID.ProjectID, paymentID.PaymentID)
+	return scanTransaction(row)
+}
+
+// ExportTransactionsByMessageIDDB returns the export transactions of the batch with
+// the given message ID
+func ExportTransactionsByMessageIDDB(db *sql.DB, msgID string) ([]*Transaction, error) {
+	rows, err := db.Query(selectTransactionByMessageID, msgID, TransactionTypeExport)
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]*Transaction, 0, 16)
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		txs = append(txs, t)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+const insertTransaction = `
+INSERT INTO provider_sepa_transaction
+(project_id, payment_id, timestamp, type, msg_id, status_code, reason_code, data)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+func InsertTransactionTx(db *sql.Tx, t *Transaction) error {
+	stmt, err := db.Prepare(insertTransaction)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		t.ProjectID,
+		t.PaymentID,
+		t.Timestamp.UnixNano(),
+		t.Type,
+		t.MessageID,
+		t.StatusCode,
+		t.ReasonCode,
+		t.Data,
+	)
+	stmt.Close()
+	return err
+}
@@ -5,12 +5,9 @@ import (
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/provider"
 
-	"github.com/fritzpay/paymentd/pkg/service/provider/paypal_rest"
-	"github.com/fritzpay/paymentd/pkg/service/provider/stripe"
-
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
 	"github.com/fritzpay/paymentd/pkg/service"
-	"github.com/fritzpay/paymentd/pkg/service/provider/fritzpay"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"github.com/gorilla/mux"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -50,20 +47,18 @@ func (s *Service) AttachDrivers(mux *mux.Router) error {
 	}
 	// add drivers
 	for _, prov := range providers {
+		factory, ok := driverFactory(prov.Name)
+		if !ok {
+			s.log.Error("no driver registered for provider. payment methods of this provider will be unavailable", log15.Ctx{
+				"providerName":      prov.Name,
+				"registeredDrivers": Drivers(),
+			})
+			continue
+		}
 		s.log.Info("attaching provider driver...", log15.Ctx{
 			"providerName": prov.Name,
 		})
-		switch prov.Name {
-		case driverFritzpay:
-			s.drivers[driverFritzpay] = &fritzpay.Driver{}
-		case driverPaypalREST:
-			s.drivers[driverPaypalREST] = &paypal_rest.Driver{}
-		case driverStripe:
-			s.drivers[driverStripe] = &stripe.Driver{}
-		default:
-			s.log.Error("unknown provider id in database", log15.Ctx{"providerName": prov.Name})
-			return ErrNoDriver
-		}
+		s.drivers[prov.Name] = factory()
 	}
 
 	mux = mux.PathPrefix(ProviderPath).Subrouter()
@@ -76,6 +71,15 @@ func (s *Service) AttachDrivers(mux *mux.Router) error {
 	return nil
 }
 
+// Available returns true if the driver of the given payment method is attached
+//
+// Payment methods of providers without a registered driver are unavailable.
+func (s *Service) Available(method *payment_method.Method) bool {
+	_, ok := s.drivers[method.Provider.Name]
+	return ok
+}
+
+// Driver returns the attached driver of the given payment method
 func (s *Service) Driver(method *payment_method.Method) (Driver, error) {
 	if dr, ok := s.drivers[method.Provider.Name]; !ok {
 		return nil, ErrNoDriver
@@ -83,3 +87,75 @@ func (s *Service) Driver(method *payment_method.Method) (Driver, error) {
 		return dr, nil
 	}
 }
+
+// Refunder returns the Refunder capability of the driver of the given payment method
+//
+// implementing the ProviderCapabilities of the payment service
+func (s *Service) Refunder(method *payment_method.Method) (paymentService.Refunder, bool) {
+	dr, err := s.Driver(method)
+	if err != nil {
+		return nil, false
+	}
+	r, ok := dr.(paymentService.Refunder)
+	return r, ok
+}
+
+// Capturer returns the Capturer capability of the driver of the given payment method
+//
+// implementing the ProviderCapabilities of the payment service
+func (s *Service) Capturer(method *payment_method.Method) (paymentService.Capturer, bool) {
+	dr, err := s.Driver(method)
+	if err != nil {
+		return nil, false
+	}
+	c, ok := dr.(paymentService.Capturer)
+	return c, ok
+}
+
+// Voider returns the Voider capability of the driver of the given payment method
+//
+// implementing the ProviderCapabilities of the payment service
+func (s *Service) Voider(method *payment_method.Method) (paymentService.Voider, bool) {
+	dr, err := s.Driver(method)
+	if err != nil {
+		return nil, false
+	}
+	v, ok := dr.(paymentService.Voider)
+	return v, ok
+}
+
+// StatusSyncer returns the StatusSyncer capability of the driver of the given payment method
+//
+// implementing the ProviderCapabilities of the payment service
+func (s *Service) StatusSyncer(method *payment_method.Method) (paymentService.StatusSyncer, bool) {
+	dr, err := s.Driver(method)
+	if err != nil {
+		return nil, false
+	}
+	ss, ok := dr.(paymentService.StatusSyncer)
+	return ss, ok
+}
+
+// ProcessingChecker returns the ProcessingChecker capability of the driver of the given
+// payment method
+//
+// implementing the ProviderCapabilities of the payment service
+func (s *Service) ProcessingChecker(method *payment_method.Method) (paymentService.ProcessingChecker, bool) {
+	dr, err := s.Driver(method)
+	if err != nil {
+		return nil, false
+	}
+	pc, ok := dr.(paymentService.ProcessingChecker)
+	return pc, ok
+}
+
+// CheckoutActioner returns the CheckoutActioner capability of the driver of the given
+// payment method
+func (s *Service) CheckoutActioner(method *payment_method.Method) (paymentService.CheckoutActioner, bool) {
+	dr, err := s.Driver(method)
+	if err != nil {
+		return nil, false
+	}
+	ca, ok := dr.(paymentService.CheckoutActioner)
+	return ca, ok
+}
@@ -0,0 +1,6 @@
+// +build !debug
+
+package stripe
+
+// Debug flag whether debugging is turned on
+const Debug = false
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
@@ -19,7 +18,6 @@ import (
 	tmpl "github.com/fritzpay/paymentd/pkg/template"
 	"github.com/gorilla/mux"
 	"github.com/stripe/stripe-go"
-	"github.com/stripe/stripe-go/charge"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
@@ -30,10 +28,14 @@ const (
 )
 
 const (
-	providerTemplateDir  = "stripe"
-	defaultLocale        = "en_US"
-	stripeSecretKey      = ""
-	stripePublishableKey = ""
+	providerTemplateDir = "stripe"
+	defaultLocale       = "en_US"
+	// timeout for requests to the Stripe API
+	stripeRequestTimeout = 30 * time.Second
+	// timeout for payment intents
+	intentTimeout = 500 * time.Millisecond
+	// charges in progress for longer are considered interrupted
+	chargeInterruptedAfter = 2 * stripeRequestTimeout
 )
 
 var (
@@ -41,15 +43,28 @@ var (
 	ErrInternal = errors.New("stripe driver internal error")
 	ErrHTTP     = errors.New("HTTP error")
 	ErrProvider = errors.New("provider error")
+	// ErrChargeInProgress is returned when a charge on a payment was already requested
+	// and the response is still pending
+	ErrChargeInProgress = errors.New("charge in progress")
+	// ErrChargeInterrupted is returned when a charge on a payment was requested, but the
+	// response was never recorded
+	ErrChargeInterrupted = errors.New("charge interrupted")
+	// ErrChargeNotFound is returned when no Stripe charge exists for a payment
+	ErrChargeNotFound = errors.New("charge not found")
+	// ErrAmount is returned when an amount can not be represented in the smallest
+	// currency unit of Stripe
+	ErrAmount = errors.New("invalid amount")
 )
 
 // Driver is the Stripe provider driver
 type Driver struct {
 	context        *service.Context
 	tmplDir        string
+	templates      *tmpl.Cache
 	log            log15.Logger
 	mux            *mux.Router
 	paymentService *paymentService.Service
+	httpClient     *http.Client
 }
 
 func (d *Driver) Attach(ctx *service.Context, m *mux.Router) error {
@@ -59,6 +74,14 @@ func (d *Driver) Attach(ctx *service.Context, m *mux.Router) error {
 		"pkg": "github.com/fritzpay/paymentd/pkg/service/provider/stripe",
 	})
 
+	var err error
+	d.paymentService, err = paymentService.NewService(ctx)
+	if err != nil {
+		d.log.Error("error initializing payment service", log15.Ctx{"err": err})
+		return err
+	}
+	d.httpClient = newClient()
+
 	//set template path
 	cfg := ctx.Config()
 	if cfg.Provider.ProviderTemplateDir == "" {
@@ -76,14 +99,13 @@ func (d *Driver) Attach(ctx *service.Context, m *mux.Router) error {
 	if !dirInfo.IsDir() {
 		return fmt.Errorf("provider template dir %s is not a directory", d.tmplDir)
 	}
+	d.templates = tmpl.NewCache(d.tmplDir, defaultLocale, tmpl.Funcs(d.staticPath))
 	_, err = url.Parse(cfg.Provider.URL)
 	if err != nil {
 		d.log.Error("error parsing provider base URL", log15.Ctx{"err": err})
 		return fmt.Errorf("error on provider base URL: %v", err)
 	}
 
-	d.paymentService, err = paymentService.NewService(ctx)
-
 	// add subrouting
 	driverRoute := m.PathPrefix(StripeDriverPath)
 	url, err := driverRoute.URLPath()
@@ -93,6 +115,7 @@ func (d *Driver) Attach(ctx *service.Context, m *mux.Router) error {
 	}
 	d.mux = driverRoute.Subrouter()
 	d.mux.Handle("/process", ctx.RateLimitHandler(d.ProcessHandler())).Name("processFormHandler")
+	d.mux.Handle("/webhook", d.WebhookHandler()).Name("webhookHandler")
 	staticDir := path.Join(d.tmplDir, "static")
 	d.log.Info("serving static dir", log15.Ctx{
 		"staticDir": staticDir,
@@ -100,36 +123,58 @@ func (d *Driver) Attach(ctx *service.Context, m *mux.Router) error {
 	})
 	d.mux.PathPrefix("/static").Handler(http.StripPrefix(url.Path+"/static", http.FileServer(http.Dir(staticDir)))).Name("staticHandler")
 
-	if err != nil {
-		d.log.Error("error initializing payment service", log15.Ctx{"err": err})
-		return err
-	}
-
-	return err
+	return nil
 }
 
 func (d *Driver) InitPayment(p *payment.Payment, pm *payment_method.Method) (http.Handler, error) {
-
-	// start transaction
+	log := d.log.New(log15.Ctx{
+		"method":    "InitPayment",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	_, err := stripeAmount(p, p.Amount)
+	if err != nil {
+		log.Warn("payment cannot be charged", log15.Ctx{
+			"err":      err,
+			"currency": p.Currency,
+			"subunits": p.Subunits,
+		})
+		return nil, err
+	}
+	cfg, err := ConfigByPaymentMethodDB(d.context.PaymentDB(service.ReadOnly), pm)
+	if err != nil {
+		if err == ErrConfigNotFound {
+			log.Error("no stripe config for payment method", log15.Ctx{"methodKey": pm.MethodKey})
+			return nil, ErrInternal
+		}
+		log.Error("error retrieving stripe config", log15.Ctx{"err": err})
+		return nil, ErrDatabase
+	}
 	// show stripe.js form
-	return d.InitPageHandler(p), nil
-
+	return d.InitPageHandler(p, cfg), nil
 }
 
-// InitPageHandler serves the init page (loading screen)
-func (d *Driver) InitPageHandler(p *payment.Payment) http.Handler {
+// InitPageHandler serves the init page (stripe.js form)
+func (d *Driver) InitPageHandler(p *payment.Payment, cfg *Config) http.Handler {
 	const baseName = "form.html.tmpl"
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := d.log.New(log15.Ctx{"method": "InitPageHandler"})
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl := template.New("init")
-		err := d.getTemplate(tmpl, d.tmplDir, p.Config.Locale.String, baseName)
+		tmpl, err := d.getTemplate(p, p.Config.Locale.String, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		tmplData := d.templatePaymentData(p)
+		tmplData["publishableKey"] = cfg.PublicKey
+		processURL, err := d.mux.Get("processFormHandler").URLPath()
+		if err != nil {
+			log.Error("error determining process URL", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		tmplData["processURL"] = processURL.String()
 		err = tmpl.Execute(w, tmplData)
 
 		if err != nil {
@@ -143,6 +188,10 @@ func (d *Driver) ProcessHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := d.log.New(log15.Ctx{"method": "ProcessHandler"})
 
+		if r.Method != "POST" {
+			d.BadRequestHandler().ServeHTTP(w, r)
+			return
+		}
 		r.ParseForm()
 		paymentIDStr := r.Form.Get("paymentid")
 		stripeTokenStr := r.Form.Get("stripeToken")
@@ -156,63 +205,358 @@ func (d *Driver) ProcessHandler() http.Handler {
 			d.BadRequestHandler().ServeHTTP(w, r)
 			return
 		}
-
-		// get payment data
-		var tx *sql.Tx
-		var commit bool
-		defer func() {
-			if tx != nil && !commit {
-				err = tx.Rollback()
-				if err != nil {
-					log.Crit("error on rollback", log15.Ctx{"err": err})
-				}
-			}
-		}()
-		tx, err = d.context.PaymentDB().Begin()
+		if stripeTokenStr == "" {
+			log.Warn("no stripe token")
+			d.BadRequestHandler().ServeHTTP(w, r)
+			return
+		}
 		paymentID = d.paymentService.DecodedPaymentID(paymentID)
+		log = log.New(log15.Ctx{
+			"projectID": paymentID.ProjectID,
+			"paymentID": paymentID.PaymentID,
+		})
 
-		p, err := payment.PaymentByIDTx(tx, paymentID)
+		p, cfg, err := d.beginCharge(paymentID)
 		if err != nil {
-			if err == payment.ErrPaymentNotFound {
-				log.Info("payment not found", log15.Ctx{"err": err})
+			switch err {
+			case payment.ErrPaymentNotFound:
+				log.Info("payment not found")
 				d.NotFoundHandler(nil).ServeHTTP(w, r)
-				return
+			case ErrChargeInProgress:
+				log.Warn("charge already in progress")
+				d.InternalErrorHandler(p).ServeHTTP(w, r)
+			case ErrChargeInterrupted:
+				log.Warn("charge was interrupted. resolving...")
+				d.resolveCharge(log, cfg, p).ServeHTTP(w, r)
+			default:
+				if _, ok := err.(*payment.TransitionError); ok && p.Status == payment.PaymentStatusPaid {
+					// charge was already completed
+					d.SuccessHandler(p).ServeHTTP(w, r)
+					return
+				}
+				log.Error("error beginning charge", log15.Ctx{"err": err})
+				d.InternalErrorHandler(p).ServeHTTP(w, r)
 			}
-			log.Error("error retrieving payment", log15.Ctx{"err": err})
-			d.InternalErrorHandler(nil).ServeHTTP(w, r)
 			return
 		}
 
-		// stripe charge
-		stripe.Key = stripeSecretKey
-
-		params := &stripe.ChargeParams{
-			Amount:   uint64(p.Amount),
-			Currency: stripe.Currency(p.Currency),
-			Card: &stripe.CardParams{
-				Token: stripeTokenStr,
-			},
+		paymentTx, commitIntent, err := d.paymentService.IntentPaid(p, intentTimeout)
+		if err != nil {
+			log.Error("error on intent paid", log15.Ctx{"err": err})
+			d.saveChargeError(p, err)
+			d.InternalErrorHandler(p).ServeHTTP(w, r)
+			return
 		}
-		ch, err := charge.New(params)
+
+		ch, err := d.createCharge(cfg, p, stripeTokenStr)
 		if err != nil {
-			log.Error("error retrieving stripe charge object", log15.Ctx{"err": err})
-			d.InternalErrorHandler(nil).ServeHTTP(w, r)
+			if isCardError(err) {
+				log.Info("card declined", log15.Ctx{"err": err})
+				err = d.failCharge(p, err)
+				if err != nil {
+					log.Error("error on failing payment", log15.Ctx{"err": err})
+					d.InternalErrorHandler(p).ServeHTTP(w, r)
+					return
+				}
+				d.FailedHandler(p).ServeHTTP(w, r)
+				return
+			}
+			log.Error("error on stripe charge", log15.Ctx{"err": err})
+			// the charge might have been created anyway
+			chargeErr := err
+			ch, err = d.findCharge(cfg, p)
+			if err != nil {
+				if err == ErrChargeNotFound {
+					// the charge can be retried
+					d.saveChargeError(p, chargeErr)
+				} else {
+					// the charge remains in progress until it is resolved
+					log.Error("error looking up charge", log15.Ctx{"err": err})
+				}
+				d.InternalErrorHandler(p).ServeHTTP(w, r)
+				return
+			}
+			log.Info("charge was created", log15.Ctx{"stripeChargeID": ch.ID})
 		}
-		log.Debug("payment", log15.Ctx{"payment": p})
-		log.Debug("charge params", log15.Ctx{"params": params})
+		d.chargeResultHandler(log, p, ch, paymentTx, commitIntent).ServeHTTP(w, r)
+	})
+}
+
+// chargeResultHandler records the result of a Stripe charge on the payment and returns
+// the handler for the resulting payment status
+func (d *Driver) chargeResultHandler(
+	log log15.Logger,
+	p *payment.Payment,
+	ch *stripe.Charge,
+	paymentTx *payment.PaymentTransaction,
+	commitIntent paymentService.CommitIntentFunc) http.Handler {
+
+	if Debug {
 		log.Debug("charge object", log15.Ctx{"charge": ch})
-		// check charge
+	}
+	if !ch.Paid {
+		log.Warn("charge not paid", log15.Ctx{"failureCode": ch.FailCode})
+		err := d.failCharge(p, &stripe.Error{
+			Type: stripe.CardErr,
+			Code: stripe.ErrorCode(ch.FailCode),
+			Msg:  ch.FailMsg,
+		})
+		if err != nil {
+			log.Error("error on failing payment", log15.Ctx{"err": err})
+			return d.InternalErrorHandler(p)
+		}
+		return d.FailedHandler(p)
+	}
+
+	current, err := d.completeCharge(p, ch, paymentTx)
+	if err != nil {
+		if _, ok := err.(*payment.TransitionError); ok && current.Status == payment.PaymentStatusPaid {
+			// the charge was already recorded
+			return d.SuccessHandler(current)
+		}
+		log.Crit("error completing paid charge. the customer was charged", log15.Ctx{
+			"err":            err,
+			"stripeChargeID": ch.ID,
+		})
+		return d.InternalErrorHandler(p)
+	}
+	commitIntent()
+	return d.SuccessHandler(current)
+}
 
-		// log stripe charge
-		d.SuccessHandler(p).ServeHTTP(w, r)
-		commit = true
-		err = tx.Commit()
+// resolveCharge resolves an interrupted charge on the payment
+//
+// If Stripe created the charge, its result will be recorded. Otherwise the charge will
+// be recorded as failed and can be retried.
+func (d *Driver) resolveCharge(log log15.Logger, cfg *Config, p *payment.Payment) http.Handler {
+	ch, err := d.findCharge(cfg, p)
+	if err != nil {
+		if err == ErrChargeNotFound {
+			log.Info("no charge was created")
+			d.saveChargeError(p, ErrChargeInterrupted)
+		} else {
+			log.Error("error looking up charge", log15.Ctx{"err": err})
+		}
+		return d.InternalErrorHandler(p)
+	}
+	log = log.New(log15.Ctx{"stripeChargeID": ch.ID})
+	paymentTx, commitIntent, err := d.paymentService.IntentPaid(p, intentTimeout)
+	if err != nil {
+		log.Crit("error on intent paid of created charge", log15.Ctx{"err": err})
+		return d.InternalErrorHandler(p)
+	}
+	return d.chargeResultHandler(log, p, ch, paymentTx, commitIntent)
+}
+
+// beginCharge retrieves the payment and its stripe config and records the charge request
+//
+// It will return an ErrChargeInProgress if a charge on the payment was already requested.
+// If the charge was requested too long ago to be still in progress, it will return an
+// ErrChargeInterrupted. The charge must be resolved before it can be retried.
+func (d *Driver) beginCharge(paymentID payment.PaymentID) (*payment.Payment, *Config, error) {
+	tx, err := d.context.PaymentDB().Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
+		}
+	}()
+	// serialize concurrent charges on the same payment
+	err = payment.LockPaymentTx(tx, paymentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := payment.PaymentByIDTx(tx, paymentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = payment.ValidateTransition(p.Status, payment.PaymentStatusPaid)
+	if err != nil {
+		return p, nil, err
+	}
+	if !p.Config.PaymentMethodID.Valid {
+		return p, nil, ErrInternal
+	}
+	meth, err := payment_method.PaymentMethodByIDTx(tx, p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return p, nil, err
+	}
+	cfg, err := ConfigByPaymentMethodTx(tx, meth)
+	if err != nil {
+		return p, nil, err
+	}
+	currentTx, err := TransactionCurrentByPaymentIDTx(tx, paymentID)
+	if err != nil && err != ErrTransactionNotFound {
+		return p, nil, err
+	}
+	if err == nil && currentTx.Type == TransactionTypeCharge {
+		if time.Since(currentTx.Timestamp) > chargeInterruptedAfter {
+			return p, cfg, ErrChargeInterrupted
+		}
+		return p, nil, ErrChargeInProgress
+	}
+	chargeTx, err := NewChargeTransaction(p)
+	if err != nil {
+		return p, nil, err
+	}
+	err = InsertTransactionTx(tx, chargeTx)
+	if err != nil {
+		return p, nil, err
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		return p, nil, err
+	}
+	return p, cfg, nil
+}
+
+// Processing returns true if a charge on the payment was requested, but its result was
+// not recorded yet
+//
+// implementing the ProcessingChecker of the payment service. Expired payments will not
+// be cancelled while the customer might be charged.
+func (d *Driver) Processing(tx *sql.Tx, p payment.Payment) (bool, error) {
+	currentTx, err := TransactionCurrentByPaymentIDTx(tx, p.PaymentID())
+	if err != nil {
+		if err == ErrTransactionNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return currentTx.Type == TransactionTypeCharge, nil
+}
+
+// createCharge requests a charge on the Stripe API
+func (d *Driver) createCharge(cfg *Config, p *payment.Payment, token string) (*stripe.Charge, error) {
+	encodedPaymentID := d.paymentService.EncodedPaymentID(p.PaymentID())
+	params, err := chargeParams(p, encodedPaymentID, token)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.chargeClient(d.httpClient, chargeIdempotencyKey(encodedPaymentID)).New(params)
+}
+
+// findCharge looks up the Stripe charge of the payment by its paymentID metadata
+//
+// It will return an ErrChargeNotFound if Stripe has no charge for the payment.
+func (d *Driver) findCharge(cfg *Config, p *payment.Payment) (*stripe.Charge, error) {
+	return findCharge(cfg.chargeClient(d.httpClient, ""), p, d.paymentService.EncodedPaymentID(p.PaymentID()))
+}
+
+// completeCharge records the charge response and the paid payment transaction
+//
+// The payment transaction was created before the charge was requested. The payment
+// might have changed in the meantime, so the transition will be validated against the
+// current payment, which will be returned. If the payment can not be paid anymore, only
+// the charge response will be recorded and a *payment.TransitionError will be returned.
+func (d *Driver) completeCharge(p *payment.Payment, ch *stripe.Charge, paymentTx *payment.PaymentTransaction) (*payment.Payment, error) {
+	stripeTx, err := NewChargeResponseTransaction(p, ch)
+	if err != nil {
+		return p, err
+	}
+	tx, err := d.context.PaymentDB().Begin()
+	if err != nil {
+		return p, err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
+		}
+	}()
+	err = payment.LockPaymentTx(tx, p.PaymentID())
+	if err != nil {
+		return p, err
+	}
+	current, err := payment.PaymentByIDTx(tx, p.PaymentID())
+	if err != nil {
+		return p, err
+	}
+	err = InsertTransactionTx(tx, stripeTx)
+	if err != nil {
+		return current, err
+	}
+	transitionErr := payment.ValidateTransition(current.Status, payment.PaymentStatusPaid)
+	if transitionErr == nil {
+		paymentTx.Payment = current
+		paymentTx.Timestamp = time.Now()
+		paymentTx.Comment.String, paymentTx.Comment.Valid = "Stripe Charge: "+ch.ID, true
+		err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
 		if err != nil {
-			log.Crit("error on commit", log15.Ctx{"err": err})
-			d.InternalErrorHandler(p)
+			return current, err
+		}
+		current.TransactionTimestamp, current.Status = paymentTx.Timestamp, paymentTx.Status
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		return current, err
+	}
+	return current, transitionErr
+}
 
+// failCharge records the charge error and sets the payment to failed
+func (d *Driver) failCharge(p *payment.Payment, chargeErr error) error {
+	tx, err := d.context.PaymentDB().Begin()
+	if err != nil {
+		return err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
 		}
-	})
+	}()
+	err = payment.LockPaymentTx(tx, p.PaymentID())
+	if err != nil {
+		return err
+	}
+	// the payment might have changed while the charge was requested
+	p, err = payment.PaymentByIDTx(tx, p.PaymentID())
+	if err != nil {
+		return err
+	}
+	paymentTx, commitIntent, err := d.paymentService.IntentFailed(p, intentTimeout)
+	if err != nil {
+		return err
+	}
+	stripeTx := NewErrorTransaction(p, chargeErr)
+	err = InsertTransactionTx(tx, stripeTx)
+	if err != nil {
+		return err
+	}
+	if stripeTx.FailureCode.Valid {
+		paymentTx.Comment.String, paymentTx.Comment.Valid = "Stripe: "+stripeTx.FailureCode.String, true
+	}
+	err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
+	if err != nil {
+		return err
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	commitIntent()
+	return nil
+}
+
+// saveChargeError records an error on a charge without changing the payment status
+//
+// The charge can be retried afterwards.
+func (d *Driver) saveChargeError(p *payment.Payment, chargeErr error) {
+	err := InsertTransactionDB(d.context.PaymentDB(), NewErrorTransaction(p, chargeErr))
+	if err != nil {
+		d.log.Error("error saving stripe error transaction", log15.Ctx{
+			"err":       err,
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+		})
+	}
 }
 
 // ProcessFormPageHandler serves the post action (form processing)
@@ -221,8 +565,7 @@ func (d *Driver) processFormPageHandler(p *payment.Payment) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := d.log.New(log15.Ctx{"method": "InitPageHandler"})
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl := template.New("init")
-		err := d.getTemplate(tmpl, d.tmplDir, p.Config.Locale.String, baseName)
+		tmpl, err := d.getTemplate(p, p.Config.Locale.String, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -237,33 +580,34 @@ func (d *Driver) processFormPageHandler(p *payment.Payment) http.Handler {
 	})
 }
 
-func (d *Driver) getTemplate(t *template.Template, tmplDir, locale, baseName string) (err error) {
-	tmplFile, err := tmpl.TemplateFileName(tmplDir, locale, defaultLocale, baseName)
-	if err != nil {
-		return err
+// TemplateBaseNames returns the templates the driver requires in every locale
+func (d *Driver) TemplateBaseNames() []string {
+	return []string{
+		"form.html.tmpl",
+		"success.html.tmpl",
+		"failed.html.tmpl",
+		"not_found.html.tmpl",
+		"internal_error.html.tmpl",
 	}
-	tmplB, err := ioutil.ReadFile(tmplFile)
+}
+
+func (d *Driver) staticPath() (string, error) {
+	url, err := d.mux.Get("staticHandler").URLPath()
 	if err != nil {
-		return err
+		return "", err
 	}
-	tmplLocale := path.Base(path.Ext(tmplFile))
-	t.Funcs(template.FuncMap(map[string]interface{}{
-		"staticPath": func() (string, error) {
-			url, err := d.mux.Get("staticHandler").URLPath()
-			if err != nil {
-				return "", err
-			}
-			return url.Path, nil
-		},
-		"locale": func() string {
-			return tmplLocale
-		},
-	}))
-	_, err = t.Parse(string(tmplB))
-	if err != nil {
-		return err
+	return url.Path, nil
+}
+
+// getTemplate returns the parsed template for the payment
+//
+// The project of the payment may override the template.
+func (d *Driver) getTemplate(p *payment.Payment, locale, baseName string) (*template.Template, error) {
+	var projectID int64
+	if p != nil {
+		projectID = p.ProjectID()
 	}
-	return nil
+	return d.templates.Template(projectID, locale, baseName)
 }
 
 func (d *Driver) templatePaymentData(p *payment.Payment) map[string]interface{} {
@@ -272,6 +616,10 @@ func (d *Driver) templatePaymentData(p *payment.Payment) map[string]interface{}
 		tmplData["payment"] = p
 		tmplData["paymentID"] = d.paymentService.EncodedPaymentID(p.PaymentID())
 		tmplData["amount"] = p.DecimalRound(2)
+		// link back to the shop if a return URL is configured
+		if returnURL, _, err := d.paymentService.ReturnConfig(p); err == nil && returnURL != "" {
+			tmplData["returnURL"] = paymentService.ReturnPath
+		}
 
 	}
 	tmplData["timestamp"] = time.Now().Unix()
@@ -280,7 +628,9 @@ func (d *Driver) templatePaymentData(p *payment.Payment) map[string]interface{}
 }
 
 func (d *Driver) BadRequestHandler() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
 }
 
 func (d *Driver) NotFoundHandler(p *payment.Payment) http.Handler {
@@ -296,8 +646,7 @@ func (d *Driver) NotFoundHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("not_found")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.getTemplate(p, locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -322,8 +671,7 @@ func (d *Driver) InternalErrorHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("internal_error")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.getTemplate(p, locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -345,8 +693,29 @@ func (d *Driver) SuccessHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("success")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.getTemplate(p, locale, baseName)
+		if err != nil {
+			log.Error("error initializing template", log15.Ctx{"err": err})
+			return
+		}
+		err = tmpl.Execute(w, tmplData)
+		if err != nil {
+			log.Error("error executing template", log15.Ctx{"err": err})
+		}
+	})
+}
+
+func (d *Driver) FailedHandler(p *payment.Payment) http.Handler {
+	const baseName = "failed.html.tmpl"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := d.log.New(log15.Ctx{"method": "FailedHandler"})
+
+		tmplData := d.templatePaymentData(p)
+		locale := defaultLocale
+		if p != nil {
+			locale = p.Config.Locale.String
+		}
+		tmpl, err := d.getTemplate(p, locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -0,0 +1,13 @@
+// +build go1.3
+
+package stripe
+
+import (
+	"net/http"
+)
+
+func newClient() *http.Client {
+	return &http.Client{
+		Timeout: stripeRequestTimeout,
+	}
+}
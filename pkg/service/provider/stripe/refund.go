@@ -0,0 +1,87 @@
+package stripe
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/stripe/stripe-go"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Refund refunds the amount of the payment transaction on the Stripe charge of the payment
+//
+// implementing the Refunder capability of the payment service
+func (d *Driver) Refund(p payment.Payment, paymentTx payment.PaymentTransaction, idempotencyKey string) error {
+	log := d.log.New(log15.Ctx{
+		"method":         "Refund",
+		"projectID":      p.ProjectID(),
+		"paymentID":      p.ID(),
+		"idempotencyKey": idempotencyKey,
+	})
+	db := d.context.PaymentDB(service.ReadOnly)
+	meth, err := payment_method.PaymentMethodByIDDB(db, p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	cfg, err := ConfigByPaymentMethodDB(db, meth)
+	if err != nil {
+		log.Error("error retrieving stripe config", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	chargeTx, err := TransactionChargeByPaymentIDDB(db, p.PaymentID())
+	if err != nil {
+		if err == ErrTransactionNotFound {
+			log.Error("payment has no stripe charge")
+			return ErrProvider
+		}
+		log.Error("error retrieving charge transaction", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	log = log.New(log15.Ctx{"stripeChargeID": chargeTx.StripeChargeID.String})
+	amount, err := stripeAmount(&p, paymentTx.Amount)
+	if err != nil {
+		log.Error("refund amount cannot be represented", log15.Ctx{
+			"amount":   paymentTx.Amount,
+			"currency": p.Currency,
+			"subunits": p.Subunits,
+		})
+		return paymentService.ErrRefundAmount
+	}
+
+	ref, err := cfg.refundClient(d.httpClient, idempotencyKey).New(&stripe.RefundParams{
+		Charge: chargeTx.StripeChargeID.String,
+		Amount: amount,
+	})
+	if err != nil {
+		log.Error("error on refund request", log15.Ctx{"err": err})
+		errTx := NewErrorTransaction(&p, err)
+		errTx.SetStripeChargeID(chargeTx.StripeChargeID.String)
+		if err := InsertTransactionDB(d.context.PaymentDB(), errTx); err != nil {
+			log.Error("error saving stripe error transaction", log15.Ctx{"err": err})
+		}
+		return ErrProvider
+	}
+	stripeTx := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeRefund,
+	}
+	stripeTx.SetStripeChargeID(chargeTx.StripeChargeID.String)
+	stripeTx.Data, err = json.Marshal(ref)
+	if err != nil {
+		log.Error("error encoding refund", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	err = InsertTransactionDB(d.context.PaymentDB(), stripeTx)
+	if err != nil {
+		// the refund was performed
+		log.Crit("error saving refund transaction", log15.Ctx{"err": err})
+	}
+	return nil
+}
@@ -3,8 +3,11 @@ package stripe
 import (
 	"database/sql"
 	"errors"
+	"time"
 
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/secret"
 )
 
 var (
@@ -18,8 +21,10 @@ SELECT
 	c.method_key,
 	c.created,
 	c.created_by,
+	c.endpoint,
 	c.secure_key,
-	c.public_key
+	c.public_key,
+	c.webhook_secret
 FROM provider_stripe_config AS c
 `
 const selectConfigByProjectIDAndMethodKey = selectConfig + `
@@ -44,8 +49,10 @@ func scanConfig(row *sql.Row) (*Config, error) {
 		&cfg.MethodKey,
 		&cfg.Created,
 		&cfg.CreatedBy,
+		&cfg.Endpoint,
 		&cfg.SecretKey,
 		&cfg.PublicKey,
+		&cfg.WebhookSecret,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -53,6 +60,14 @@ func scanConfig(row *sql.Row) (*Config, error) {
 		}
 		return cfg, err
 	}
+	cfg.SecretKey, err = secret.Decrypt(cfg.SecretKey)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.WebhookSecret, err = secret.Decrypt(cfg.WebhookSecret)
+	if err != nil {
+		return cfg, err
+	}
 	return cfg, nil
 }
 
@@ -65,3 +80,219 @@ func ConfigByPaymentMethodDB(db *sql.DB, method *payment_method.Method) (*Config
 	row := db.QueryRow(selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
 	return scanConfig(row)
 }
+
+const selectConfigHistoryByProjectIDAndMethodKey = selectConfig + `
+WHERE
+	c.project_id = ?
+	AND
+	c.method_key = ?
+ORDER BY c.created DESC
+`
+
+// ConfigHistoryByPaymentMethodDB returns all versions of the config of the given
+// payment method, the most recent first
+//
+// The secrets of the returned configs will not be decrypted.
+func ConfigHistoryByPaymentMethodDB(db *sql.DB, method *payment_method.Method) ([]*Config, error) {
+	rows, err := db.Query(selectConfigHistoryByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
+	if err != nil {
+		return nil, err
+	}
+	cfgs := make([]*Config, 0, 8)
+	for rows.Next() {
+		cfg := &Config{}
+		err = rows.Scan(
+			&cfg.ProjectID,
+			&cfg.MethodKey,
+			&cfg.Created,
+			&cfg.CreatedBy,
+			&cfg.Endpoint,
+			&cfg.SecretKey,
+			&cfg.PublicKey,
+			&cfg.WebhookSecret,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfgs) == 0 {
+		return nil, ErrConfigNotFound
+	}
+	return cfgs, nil
+}
+
+const insertConfig = `
+INSERT INTO provider_stripe_config
+(project_id, method_key, created, created_by, endpoint, secure_key, public_key, webhook_secret)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertConfigTx saves a new version of the config
+//
+// The secrets will be encrypted.
+func InsertConfigTx(db *sql.Tx, cfg *Config) error {
+	secretKey, err := secret.Encrypt(cfg.SecretKey)
+	if err != nil {
+		return err
+	}
+	webhookSecret, err := secret.Encrypt(cfg.WebhookSecret)
+	if err != nil {
+		return err
+	}
+	stmt, err := db.Prepare(insertConfig)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		cfg.ProjectID,
+		cfg.MethodKey,
+		cfg.Created,
+		cfg.CreatedBy,
+		cfg.Endpoint,
+		secretKey,
+		cfg.PublicKey,
+		webhookSecret,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectTransaction = `
+SELECT
+	t.project_id,
+	t.payment_id,
+	t.timestamp,
+	t.type,
+	t.stripe_charge_id,
+	t.failure_code,
+	t.data
+`
+
+const selectTransactionCurrentByPaymentID = selectTransaction + `
+FROM provider_stripe_transaction AS t
+WHERE
+	t.project_id = ?
+	AND
+	t.payment_id = ?
+	AND
+	t.timestamp = (
+		SELECT MAX(timestamp) FROM provider_stripe_transaction
+		WHERE
+			project_id = t.project_id
+			AND
+			payment_id = t.payment_id
+	)
+`
+
+const selectTransactionChargeByPaymentID = selectTransaction + `
+FROM provider_stripe_transaction AS t
+WHERE
+	t.project_id = ?
+	AND
+	t.payment_id = ?
+	AND
+	t.type = ?
+	AND
+	t.stripe_charge_id IS NOT NULL
+ORDER BY t.timestamp DESC
+LIMIT 1
+`
+
+const selectTransactionByStripeChargeID = selectTransaction + `
+FROM provider_stripe_transaction AS t
+WHERE
+	t.stripe_charge_id = ?
+ORDER BY t.timestamp DESC
+LIMIT 1
+`
+
+func scanTransactionRow(row *sql.Row) (*Transaction, error) {
+	t := &Transaction{}
+	var ts int64
+	err := row.Scan(
+		&t.ProjectID,
+		&t.PaymentID,
+		&ts,
+		&t.Type,
+		&t.StripeChargeID,
+		&t.FailureCode,
+		&t.Data,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return t, ErrTransactionNotFound
+		}
+		return t, err
+	}
+	t.Timestamp = time.Unix(0, ts)
+	return t, nil
+}
+
+func TransactionCurrentByPaymentIDTx(db *sql.Tx, paymentID payment.PaymentID) (*Transaction, error) {
+	row := db.QueryRow(selectTransactionCurrentByPaymentID, paymentID.ProjectID, paymentID.PaymentID)
+	return scanTransactionRow(row)
+}
+
+func TransactionCurrentByPaymentIDDB(db *sql.DB, paymentID payment.PaymentID) (*Transaction, error) {
+	row := db.QueryRow(selectTransactionCurrentByPaymentID, paymentID.ProjectID, paymentID.PaymentID)
+	return scanTransactionRow(row)
+}
+
+// TransactionChargeByPaymentIDDB returns the charge response transaction of the given
+// payment
+func TransactionChargeByPaymentIDDB(db *sql.DB, paymentID payment.PaymentID) (*Transaction, error) {
+	row := db.QueryRow(selectTransactionChargeByPaymentID, paymentID.ProjectID, paymentID.PaymentID, TransactionTypeChargeResponse)
+	return scanTransactionRow(row)
+}
+
+// TransactionByStripeChargeIDDB returns the most recent transaction referencing the
+// given stripe charge
+func TransactionByStripeChargeIDDB(db *sql.DB, chargeID string) (*Transaction, error) {
+	row := db.QueryRow(selectTransactionByStripeChargeID, chargeID)
+	return scanTransactionRow(row)
+}
+
+const insertTransaction = `
+INSERT INTO provider_stripe_transaction
+(project_id, payment_id, timestamp, type, stripe_charge_id, failure_code, data)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+func doInsertTransaction(stmt *sql.Stmt, t *Transaction) error {
+	_, err := stmt.Exec(
+		t.ProjectID,
+		t.PaymentID,
+		t.Timestamp.UnixNano(),
+		t.Type,
+		t.StripeChargeID,
+		t.FailureCode,
+		t.Data,
+	)
+	stmt.Close()
+	return err
+}
+
+func InsertTransactionTx(db *sql.Tx, t *Transaction) error {
+	stmt, err := db.Prepare(insertTransaction)
+	if err != nil {
+		return err
+	}
+	return doInsertTransaction(stmt, t)
+}
+
+func InsertTransactionDB(db *sql.DB, t *Transaction) error {
+	stmt, err := db.Prepare(insertTransaction)
+	if err != nil {
+		return err
+	}
+	return doInsertTransaction(stmt, t)
+}
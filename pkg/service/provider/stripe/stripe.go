@@ -1,7 +1,28 @@
 package stripe
 
 import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/charge"
+	"github.com/stripe/stripe-go/refund"
+)
+
+// Stripe transaction types
+const (
+	TransactionTypeCharge         = "charge"
+	TransactionTypeChargeResponse = "chargeResponse"
+	TransactionTypeError          = "error"
+	TransactionTypeEvent          = "event"
+	TransactionTypeRefund         = "refund"
 )
 
 type Config struct {
@@ -10,6 +31,270 @@ type Config struct {
 	Created   time.Time
 	CreatedBy string
 
+	// Endpoint is the base URL of the Stripe API, i.e. https://api.stripe.com/v1
+	Endpoint  string
 	SecretKey string
 	PublicKey string
+	// WebhookSecret is the signing secret of the webhook endpoint
+	WebhookSecret string
+}
+
+// chargeClient returns a client for the Stripe charges API using the configured
+// endpoint and secret key
+//
+// Requests will be sent with the given idempotency key, if it is not empty.
+func (c *Config) chargeClient(httpClient *http.Client, idempotencyKey string) *charge.Client {
+	return &charge.Client{
+		B: &idempotentBackend{
+			InternalBackend: stripe.NewInternalBackend(httpClient, c.Endpoint),
+			key:             idempotencyKey,
+		},
+		Key: c.SecretKey,
+	}
+}
+
+// refundClient returns a client for the Stripe refunds API using the configured
+// endpoint and secret key
+//
+// Requests will be sent with the given idempotency key.
+func (c *Config) refundClient(httpClient *http.Client, idempotencyKey string) *refund.Client {
+	return &refund.Client{
+		B: &idempotentBackend{
+			InternalBackend: stripe.NewInternalBackend(httpClient, c.Endpoint),
+			key:             idempotencyKey,
+		},
+		Key: c.SecretKey,
+	}
+}
+
+// idempotentBackend is a Stripe backend which sends its requests with an idempotency key
+//
+// Stripe will perform requests with the same key only once and return the result of
+// the first request on retries.
+type idempotentBackend struct {
+	*stripe.InternalBackend
+	key string
+}
+
+// Call implements the stripe.Backend
+func (b *idempotentBackend) Call(method, path, key string, form *url.Values, v interface{}) error {
+	req, err := b.NewRequest(method, path, key, form)
+	if err != nil {
+		return err
+	}
+	if b.key != "" {
+		req.Header.Set("Idempotency-Key", b.key)
+	}
+	return b.Do(req, v)
+}
+
+// chargeIdempotencyKey returns the idempotency key of charges on the given payment
+//
+// A payment can only be charged once. Stripe will perform only the first charge request
+// of the payment, even if the request is retried.
+func chargeIdempotencyKey(encodedPaymentID payment.PaymentID) string {
+	return "paymentd-charge-" + encodedPaymentID.String()
+}
+
+// findCharge returns the charge created for the given payment
+//
+// Charges are identified by the paymentID metadata. Only charges created after the
+// payment will be searched.
+func findCharge(c *charge.Client, p *payment.Payment, encodedPaymentID payment.PaymentID) (*stripe.Charge, error) {
+	params := &stripe.ChargeListParams{}
+	params.Filters.AddFilter("created", "gte", strconv.FormatInt(p.Created.Unix(), 10))
+	params.Limit = 100
+	it := c.List(params)
+	for it.Next() {
+		ch := it.Charge()
+		if ch.Meta["paymentID"] == encodedPaymentID.String() {
+			return ch, nil
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrChargeNotFound
+}
+
+// the decimal places of the smallest currency unit of Stripe for currencies, which are
+// not charged in cents
+var currencyDecimals = map[string]int{
+	"BIF": 0,
+	"CLP": 0,
+	"DJF": 0,
+	"GNF": 0,
+	"JPY": 0,
+	"KMF": 0,
+	"KRW": 0,
+	"MGA": 0,
+	"PYG": 0,
+	"RWF": 0,
+	"UGX": 0,
+	"VND": 0,
+	"VUV": 0,
+	"XAF": 0,
+	"XOF": 0,
+	"XPF": 0,
+	"BHD": 3,
+	"JOD": 3,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+}
+
+// decimals returns the decimal places of the smallest currency unit of Stripe
+func decimals(cur string) int {
+	if d, ok := currencyDecimals[strings.ToUpper(cur)]; ok {
+		return d
+	}
+	return 2
+}
+
+// stripeAmount converts an amount in the subunits of the payment into the smallest
+// currency unit of Stripe
+//
+// It will return an ErrAmount if the amount is not positive or if it can not be
+// represented without rounding.
+func stripeAmount(p *payment.Payment, amount int64) (uint64, error) {
+	if amount <= 0 {
+		return 0, ErrAmount
+	}
+	a, err := currency.ScaleAmount(amount, int(p.Subunits), decimals(p.Currency))
+	if err != nil {
+		return 0, ErrAmount
+	}
+	return uint64(a), nil
+}
+
+// paymentAmount converts an amount in the smallest currency unit of Stripe into the
+// subunits of the payment
+func paymentAmount(p *payment.Payment, amount int64) (int64, error) {
+	a, err := currency.ScaleAmount(amount, decimals(p.Currency), int(p.Subunits))
+	if err != nil {
+		return 0, ErrAmount
+	}
+	return a, nil
+}
+
+// chargeParams returns the parameters for a charge on the given payment using the card
+// token created by stripe.js
+func chargeParams(p *payment.Payment, encodedPaymentID payment.PaymentID, token string) (*stripe.ChargeParams, error) {
+	amount, err := stripeAmount(p, p.Amount)
+	if err != nil {
+		return nil, err
+	}
+	params := &stripe.ChargeParams{
+		Amount:   amount,
+		Currency: stripe.Currency(strings.ToLower(p.Currency)),
+		Card: &stripe.CardParams{
+			Token: token,
+		},
+		Desc: p.Ident,
+	}
+	params.AddMeta("paymentID", encodedPaymentID.String())
+	return params, nil
+}
+
+// Transaction represents a transaction on a stripe charge
+//
+// It can be one of the following:
+//
+//   - A representation of a charge request.
+//   - A representation of a charge response.
+//   - An error returned by the Stripe API.
+//   - A refund on the charge.
+//   - An event received by the webhook.
+//
+// The most recent transaction denotes the state of the charge.
+type Transaction struct {
+	ProjectID      int64
+	PaymentID      int64
+	Timestamp      time.Time
+	Type           string
+	StripeChargeID sql.NullString
+	FailureCode    sql.NullString
+	Data           []byte
+}
+
+func (t *Transaction) SetStripeChargeID(id string) {
+	t.StripeChargeID.String, t.StripeChargeID.Valid = id, true
+}
+
+func (t *Transaction) SetFailureCode(code string) {
+	t.FailureCode.String, t.FailureCode.Valid = code, true
+}
+
+// NewChargeTransaction creates a transaction representing a charge request for
+// the given payment
+func NewChargeTransaction(p *payment.Payment) (*Transaction, error) {
+	t := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeCharge,
+	}
+	var err error
+	// the card token must not be stored
+	t.Data, err = json.Marshal(map[string]interface{}{
+		"amount":   p.Amount,
+		"currency": p.Currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewChargeResponseTransaction creates a transaction representing the charge
+// returned by the Stripe API
+func NewChargeResponseTransaction(p *payment.Payment, ch *stripe.Charge) (*Transaction, error) {
+	t := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeChargeResponse,
+	}
+	if ch.ID != "" {
+		t.SetStripeChargeID(ch.ID)
+	}
+	if ch.FailCode != "" {
+		t.SetFailureCode(ch.FailCode)
+	}
+	var err error
+	t.Data, err = json.Marshal(ch)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewErrorTransaction creates a transaction representing an error on a charge
+// request
+func NewErrorTransaction(p *payment.Payment, chargeErr error) *Transaction {
+	t := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeError,
+	}
+	if stripeErr, ok := chargeErr.(*stripe.Error); ok {
+		if stripeErr.Code != "" {
+			t.SetFailureCode(string(stripeErr.Code))
+		} else {
+			t.SetFailureCode(string(stripeErr.Type))
+		}
+		t.Data = []byte(stripeErr.Error())
+		return t
+	}
+	t.Data = []byte(chargeErr.Error())
+	return t
+}
+
+// isCardError returns true if the error denotes a declined card
+//
+// In this case the payment can be considered failed. Other errors might be temporary.
+func isCardError(err error) bool {
+	stripeErr, ok := err.(*stripe.Error)
+	return ok && stripeErr.Type == stripe.CardErr
 }
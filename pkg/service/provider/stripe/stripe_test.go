@@ -0,0 +1,208 @@
+package stripe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCharge(t *testing.T) {
+	Convey("Given a Stripe API stand-in", t, func() {
+		var reqPath, reqKey, reqIdempotencyKey string
+		var reqForm, reqQuery url.Values
+		var respStatus int
+		var respBody string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqPath = r.URL.Path
+			reqKey, _, _ = r.BasicAuth()
+			reqIdempotencyKey = r.Header.Get("Idempotency-Key")
+			reqQuery = r.URL.Query()
+			r.ParseForm()
+			reqForm = r.PostForm
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(respStatus)
+			w.Write([]byte(respBody))
+		}))
+		Reset(func() {
+			srv.Close()
+		})
+		cfg := &Config{
+			Endpoint:  srv.URL + "/v1",
+			SecretKey: "sk_test",
+			PublicKey: "pk_test",
+		}
+
+		Convey("Given a payment", func() {
+			p := &payment.Payment{
+				Amount:   1234,
+				Subunits: 2,
+				Currency: "EUR",
+				Ident:    "test",
+				Created:  time.Now(),
+			}
+			paymentID := payment.PaymentID{ProjectID: 1, PaymentID: 2}
+			params, err := chargeParams(p, paymentID, "tok_test")
+			So(err, ShouldBeNil)
+
+			Convey("When the charge succeeds", func() {
+				respStatus = http.StatusOK
+				respBody = `{"id":"ch_test","amount":1234,"currency":"eur","paid":true}`
+				ch, err := cfg.chargeClient(http.DefaultClient, chargeIdempotencyKey(paymentID)).New(params)
+
+				Convey("It should succeed", func() {
+					So(err, ShouldBeNil)
+					So(ch.ID, ShouldEqual, "ch_test")
+					So(ch.Paid, ShouldBeTrue)
+				})
+				Convey("It should request a charge with the secret key", func() {
+					So(reqPath, ShouldEqual, "/v1/charges")
+					So(reqKey, ShouldEqual, "sk_test")
+				})
+				Convey("It should request the charge with the idempotency key of the payment", func() {
+					So(reqIdempotencyKey, ShouldEqual, "paymentd-charge-1-2")
+				})
+				Convey("It should request the payment amount", func() {
+					So(reqForm.Get("amount"), ShouldEqual, "1234")
+					So(reqForm.Get("currency"), ShouldEqual, "eur")
+					So(reqForm.Get("card"), ShouldEqual, "tok_test")
+				})
+
+				Convey("When creating a response transaction", func() {
+					stripeTx, err := NewChargeResponseTransaction(p, ch)
+
+					Convey("It should reference the charge", func() {
+						So(err, ShouldBeNil)
+						So(stripeTx.Type, ShouldEqual, TransactionTypeChargeResponse)
+						So(stripeTx.StripeChargeID.String, ShouldEqual, "ch_test")
+						So(stripeTx.FailureCode.Valid, ShouldBeFalse)
+					})
+				})
+			})
+
+			Convey("When the card is declined", func() {
+				respStatus = http.StatusPaymentRequired
+				respBody = `{"error":{"type":"card_error","message":"Your card was declined.","code":"card_declined"}}`
+				_, err := cfg.chargeClient(http.DefaultClient, "").New(params)
+
+				Convey("It should return a card error", func() {
+					So(err, ShouldNotBeNil)
+					So(isCardError(err), ShouldBeTrue)
+				})
+
+				Convey("When creating an error transaction", func() {
+					stripeTx := NewErrorTransaction(p, err)
+
+					Convey("It should contain the failure code", func() {
+						So(stripeTx.Type, ShouldEqual, TransactionTypeError)
+						So(stripeTx.FailureCode.String, ShouldEqual, "card_declined")
+					})
+				})
+			})
+
+			Convey("When the Stripe API fails", func() {
+				respStatus = http.StatusInternalServerError
+				respBody = `{"error":{"type":"api_error","message":"Internal error"}}`
+				_, err := cfg.chargeClient(http.DefaultClient, "").New(params)
+
+				Convey("It should not return a card error", func() {
+					So(err, ShouldNotBeNil)
+					So(isCardError(err), ShouldBeFalse)
+				})
+			})
+
+			Convey("When looking up the charge of the payment", func() {
+				respStatus = http.StatusOK
+
+				Convey("When Stripe created a charge for the payment", func() {
+					respBody = `{"object":"list","has_more":false,"data":[
+						{"id":"ch_other","paid":true,"metadata":{"paymentID":"1-3"}},
+						{"id":"ch_test","paid":true,"metadata":{"paymentID":"1-2"}}
+					]}`
+					ch, err := findCharge(cfg.chargeClient(http.DefaultClient, ""), p, paymentID)
+
+					Convey("It should return the charge", func() {
+						So(err, ShouldBeNil)
+						So(ch.ID, ShouldEqual, "ch_test")
+						So(ch.Paid, ShouldBeTrue)
+					})
+					Convey("It should list the charges created since the payment", func() {
+						So(reqPath, ShouldEqual, "/v1/charges")
+						So(reqQuery.Get("created[gte]"), ShouldEqual, strconv.FormatInt(p.Created.Unix(), 10))
+						So(reqIdempotencyKey, ShouldEqual, "")
+					})
+				})
+
+				Convey("When Stripe has no charge for the payment", func() {
+					respBody = `{"object":"list","has_more":false,"data":[
+						{"id":"ch_other","paid":true,"metadata":{"paymentID":"1-3"}}
+					]}`
+					_, err := findCharge(cfg.chargeClient(http.DefaultClient, ""), p, paymentID)
+
+					Convey("It should return an ErrChargeNotFound", func() {
+						So(err, ShouldEqual, ErrChargeNotFound)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestAmount(t *testing.T) {
+	Convey("Given a payment with 3 subunits", t, func() {
+		p := &payment.Payment{
+			Amount:   12340,
+			Subunits: 3,
+			Currency: "EUR",
+		}
+
+		Convey("When converting the amount to Stripe", func() {
+			a, err := stripeAmount(p, p.Amount)
+
+			Convey("It should be in cents", func() {
+				So(err, ShouldBeNil)
+				So(a, ShouldEqual, 1234)
+			})
+		})
+		Convey("When converting an amount with fractions of a cent", func() {
+			_, err := stripeAmount(p, 12345)
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrAmount)
+			})
+		})
+		Convey("When converting a Stripe amount", func() {
+			a, err := paymentAmount(p, 1234)
+
+			Convey("It should be in the payment subunits", func() {
+				So(err, ShouldBeNil)
+				So(a, ShouldEqual, 12340)
+			})
+		})
+		Convey("Given a zero-decimal currency", func() {
+			p.Currency = "JPY"
+			p.Amount = 1000
+
+			Convey("When converting the amount to Stripe", func() {
+				a, err := stripeAmount(p, p.Amount)
+
+				Convey("It should be in whole units", func() {
+					So(err, ShouldBeNil)
+					So(a, ShouldEqual, 1)
+				})
+			})
+		})
+		Convey("When converting a non-positive amount", func() {
+			_, err := stripeAmount(p, 0)
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrAmount)
+			})
+		})
+	})
+}
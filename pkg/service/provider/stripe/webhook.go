@@ -0,0 +1,357 @@
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stripe/stripe-go"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// HeaderSignature is the HTTP header containing the signature of a webhook request
+	HeaderSignature = "Stripe-Signature"
+	// maximum age of a signed webhook request
+	webhookTolerance = 5 * time.Minute
+	// maximum size of a webhook request body
+	webhookMaxBodySize = 1 << 16
+)
+
+// Stripe event types
+const (
+	EventChargeRefunded       = "charge.refunded"
+	EventChargeDisputeCreated = "charge.dispute.created"
+	EventChargeFailed         = "charge.failed"
+)
+
+var (
+	ErrSignature        = errors.New("invalid signature")
+	ErrSignatureExpired = errors.New("signature expired")
+)
+
+// webhookObject represents the object of a Stripe event
+//
+// It contains the fields of charges and disputes which are relevant to paymentd.
+type webhookObject struct {
+	Object         string            `json:"object"`
+	ID             string            `json:"id"`
+	Charge         string            `json:"charge"`
+	Amount         int64             `json:"amount"`
+	AmountRefunded int64             `json:"amount_refunded"`
+	FailCode       string            `json:"failure_code"`
+	Meta           map[string]string `json:"metadata"`
+}
+
+// chargeID returns the ID of the charge the object refers to
+func (o *webhookObject) chargeID() string {
+	if o.Object == "dispute" {
+		return o.Charge
+	}
+	return o.ID
+}
+
+// verifySignature verifies the Stripe-Signature header of a webhook request
+//
+// The header contains the timestamp t and one or more v1 signatures. The signature
+// is the hex encoded HMAC-SHA256 of the timestamp, a dot and the request body.
+func verifySignature(header string, body []byte, secret string, now time.Time) error {
+	var ts string
+	var sigs [][]byte
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			sig, err := hex.DecodeString(kv[1])
+			if err != nil {
+				continue
+			}
+			sigs = append(sigs, sig)
+		}
+	}
+	if ts == "" || len(sigs) == 0 {
+		return ErrSignature
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrSignature
+	}
+	age := now.Sub(time.Unix(unix, 0))
+	if age > webhookTolerance || age < -webhookTolerance {
+		return ErrSignatureExpired
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, sig := range sigs {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrSignature
+}
+
+// WebhookHandler handles the events sent by Stripe
+//
+// The payment of an event is determined by the charge it refers to. The request
+// signature will be verified against the webhook secret of the payment method.
+func (d *Driver) WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := d.log.New(log15.Ctx{"method": "WebhookHandler"})
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBodySize))
+		if err != nil {
+			log.Warn("error reading request body", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ev := &stripe.Event{}
+		err = json.Unmarshal(body, ev)
+		if err != nil || ev.Data == nil {
+			log.Warn("error decoding event", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"eventID":   ev.ID,
+			"eventType": ev.Type,
+		})
+		switch ev.Type {
+		case EventChargeRefunded, EventChargeDisputeCreated, EventChargeFailed:
+		default:
+			if Debug {
+				log.Debug("ignoring event")
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		obj := &webhookObject{}
+		err = json.Unmarshal(ev.Data.Raw, obj)
+		if err != nil {
+			log.Warn("error decoding event object", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		paymentID, err := d.webhookPaymentID(obj)
+		if err != nil {
+			if err == ErrTransactionNotFound {
+				// not a charge created by paymentd
+				log.Info("no payment for event", log15.Ctx{"stripeChargeID": obj.chargeID()})
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log.Error("error retrieving payment ID", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID": paymentID.ProjectID,
+			"paymentID": paymentID.PaymentID,
+		})
+		cfg, err := d.webhookConfig(paymentID)
+		if err != nil {
+			log.Error("error retrieving stripe config", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if cfg.WebhookSecret == "" {
+			log.Warn("no webhook secret configured")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		err = verifySignature(r.Header.Get(HeaderSignature), body, cfg.WebhookSecret, time.Now())
+		if err != nil {
+			log.Warn("webhook signature verification failed", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		maxRetries := d.context.Config().Database.TransactionMaxRetries
+		var retries int
+		for {
+			err = d.handleEvent(paymentID, ev, obj, body)
+			if err == paymentService.ErrDBLockTimeout && retries < maxRetries {
+				retries++
+				time.Sleep(time.Duration(retries) * time.Second)
+				continue
+			}
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*payment.TransitionError); ok {
+				// the event was already processed or does not apply
+				log.Info("event does not apply to payment", log15.Ctx{"err": err})
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log.Error("error handling event", log15.Ctx{"err": err})
+			// stripe will retry the event
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// webhookPaymentID determines the payment ID of the charge of an event
+//
+// It will look up the stripe transactions first and fall back to the charge metadata.
+func (d *Driver) webhookPaymentID(obj *webhookObject) (payment.PaymentID, error) {
+	if obj.chargeID() != "" {
+		stripeTx, err := TransactionByStripeChargeIDDB(d.context.PaymentDB(service.ReadOnly), obj.chargeID())
+		if err == nil {
+			return payment.PaymentID{ProjectID: stripeTx.ProjectID, PaymentID: stripeTx.PaymentID}, nil
+		}
+		if err != ErrTransactionNotFound {
+			return payment.PaymentID{}, err
+		}
+	}
+	if obj.Meta == nil || obj.Meta["paymentID"] == "" {
+		return payment.PaymentID{}, ErrTransactionNotFound
+	}
+	paymentID, err := payment.ParsePaymentIDStr(obj.Meta["paymentID"])
+	if err != nil {
+		return payment.PaymentID{}, ErrTransactionNotFound
+	}
+	return d.paymentService.DecodedPaymentID(paymentID), nil
+}
+
+func (d *Driver) webhookConfig(paymentID payment.PaymentID) (*Config, error) {
+	db := d.context.PaymentDB(service.ReadOnly)
+	p, err := payment.PaymentByIDDB(db, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Config.PaymentMethodID.Valid {
+		return nil, ErrConfigNotFound
+	}
+	meth, err := payment_method.PaymentMethodByIDDB(db, p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, err
+	}
+	return ConfigByPaymentMethodDB(db, meth)
+}
+
+// handleEvent records the event and creates the matching payment transaction
+func (d *Driver) handleEvent(paymentID payment.PaymentID, ev *stripe.Event, obj *webhookObject, body []byte) error {
+	tx, err := d.context.PaymentDB().Begin()
+	if err != nil {
+		return err
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			tx.Rollback()
+		}
+	}()
+	err = payment.LockPaymentTx(tx, paymentID)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1213 {
+			return paymentService.ErrDBLockTimeout
+		}
+		return err
+	}
+	p, err := payment.PaymentByIDTx(tx, paymentID)
+	if err != nil {
+		return err
+	}
+
+	var paymentTx *payment.PaymentTransaction
+	var commitIntent paymentService.CommitIntentFunc
+	switch ev.Type {
+	case EventChargeRefunded:
+		txs, err := payment.PaymentTransactionsTx(tx, p)
+		if err != nil && err != payment.ErrPaymentTransactionNotFound {
+			return err
+		}
+		// refunds requested through paymentd which are still pending will be recorded
+		// by the intent
+		pending, err := payment.PaymentProviderIntentsPendingTx(tx, p.PaymentID())
+		if err != nil {
+			return err
+		}
+		// the refunded amount of the charge is the total of all refunds
+		refunded, err := paymentAmount(p, obj.AmountRefunded)
+		if err != nil {
+			return err
+		}
+		amount := refunded - txs.Refunded() - pending.Amount(payment.PaymentStatusRefunded)
+		if amount <= 0 {
+			return nil
+		}
+		paymentTx, commitIntent, err = d.paymentService.IntentProviderRefund(tx, p, amount, intentTimeout)
+		if err != nil {
+			return err
+		}
+	case EventChargeDisputeCreated:
+		var amount int64
+		amount, err = paymentAmount(p, obj.Amount)
+		if err != nil {
+			return err
+		}
+		paymentTx, commitIntent, err = d.paymentService.IntentChargeback(tx, p, amount, intentTimeout)
+		if err != nil {
+			return err
+		}
+	case EventChargeFailed:
+		paymentTx, commitIntent, err = d.paymentService.IntentFailed(p, intentTimeout)
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	stripeTx := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeEvent,
+		Data:      body,
+	}
+	if obj.chargeID() != "" {
+		stripeTx.SetStripeChargeID(obj.chargeID())
+	}
+	if obj.FailCode != "" {
+		stripeTx.SetFailureCode(obj.FailCode)
+	}
+	err = InsertTransactionTx(tx, stripeTx)
+	if err != nil {
+		return err
+	}
+	paymentTx.Comment.String, paymentTx.Comment.Valid = "Stripe Event: "+ev.ID, true
+	err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
+	if err != nil {
+		return err
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	commitIntent()
+	return nil
+}
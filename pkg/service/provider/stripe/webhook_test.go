@@ -0,0 +1,64 @@
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	Convey("Given a webhook request body", t, func() {
+		body := []byte(`{"id":"evt_test","type":"charge.refunded"}`)
+		secret := "whsec_test"
+		now := time.Now()
+		sign := func(ts int64, secret string) string {
+			mac := hmac.New(sha256.New, []byte(secret))
+			fmt.Fprintf(mac, "%d.%s", ts, body)
+			return hex.EncodeToString(mac.Sum(nil))
+		}
+
+		Convey("When the signature is valid", func() {
+			header := fmt.Sprintf("t=%d,v1=%s", now.Unix(), sign(now.Unix(), secret))
+
+			Convey("It should verify", func() {
+				So(verifySignature(header, body, secret, now), ShouldBeNil)
+			})
+		})
+
+		Convey("When one of multiple signatures is valid", func() {
+			header := fmt.Sprintf("t=%d,v1=%s,v1=%s", now.Unix(), sign(now.Unix(), "other"), sign(now.Unix(), secret))
+
+			Convey("It should verify", func() {
+				So(verifySignature(header, body, secret, now), ShouldBeNil)
+			})
+		})
+
+		Convey("When the signature was created with another secret", func() {
+			header := fmt.Sprintf("t=%d,v1=%s", now.Unix(), sign(now.Unix(), "other"))
+
+			Convey("It should fail", func() {
+				So(verifySignature(header, body, secret, now), ShouldEqual, ErrSignature)
+			})
+		})
+
+		Convey("When the signature is too old", func() {
+			ts := now.Add(-time.Hour).Unix()
+			header := fmt.Sprintf("t=%d,v1=%s", ts, sign(ts, secret))
+
+			Convey("It should fail", func() {
+				So(verifySignature(header, body, secret, now), ShouldEqual, ErrSignatureExpired)
+			})
+		})
+
+		Convey("When the header is missing", func() {
+			Convey("It should fail", func() {
+				So(verifySignature("", body, secret, now), ShouldEqual, ErrSignature)
+			})
+		})
+	})
+}
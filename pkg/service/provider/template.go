@@ -0,0 +1,46 @@
+package provider
+
+import (
+	"errors"
+	"path"
+
+	tmpl "github.com/fritzpay/paymentd/pkg/template"
+)
+
+// the default locale of the driver templates
+const templateDefaultLocale = "en_US"
+
+var (
+	ErrNoTemplates = errors.New("driver has no templates")
+)
+
+// TemplateDriver is implemented by drivers which render templates
+//
+// The templates of a driver are located in the directory of the provider template dir,
+// which is named like the driver.
+type TemplateDriver interface {
+	Driver
+
+	// TemplateBaseNames returns the templates the driver requires in every locale
+	TemplateBaseNames() []string
+}
+
+// LintTemplates checks the templates of the registered driver with the given name
+//
+// It returns ErrNoDriver if no driver with the name is registered and ErrNoTemplates
+// if the driver does not render templates.
+func LintTemplates(providerTmplDir, name string) ([]tmpl.LintIssue, error) {
+	factory, ok := driverFactory(name)
+	if !ok {
+		return nil, ErrNoDriver
+	}
+	d, ok := factory().(TemplateDriver)
+	if !ok {
+		return nil, ErrNoTemplates
+	}
+	// the static path is only known to attached drivers
+	staticPath := func() (string, error) {
+		return "", nil
+	}
+	return tmpl.Lint(path.Join(providerTmplDir, name), templateDefaultLocale, d.TemplateBaseNames(), tmpl.Funcs(staticPath))
+}
@@ -0,0 +1,261 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// CheckoutStatusPath serves the JSON checkout status of the authenticated payment
+	CheckoutStatusPath = PaymentPath + "/status"
+)
+
+const (
+	// the query parameter with the payment status known to the client
+	checkoutStatusParam = "status"
+	// the query parameter with the seconds to wait for a status change
+	checkoutWaitParam = "wait"
+
+	// the interval in which long-polling requests check the payment status
+	checkoutPollInterval = 500 * time.Millisecond
+	// the maximum wait of long-polling requests
+	checkoutMaxWait = 30 * time.Second
+)
+
+// CheckoutStatus is the JSON representation of a payment for checkout frontends
+type CheckoutStatus struct {
+	PaymentId       payment.PaymentID
+	Ident           string
+	Amount          int64 `json:",string"`
+	Subunits        int8  `json:",string"`
+	Currency        string
+	Status          payment.PaymentTransactionStatus
+	PaymentMethodId int64              `json:",string,omitempty"`
+	Methods         []selectableMethod `json:",omitempty"`
+	NextAction      *paymentService.CheckoutAction
+}
+
+// authenticateCheckoutRequest authenticates requests of checkout frontends
+//
+// Other than on the payment page, a payment token will be exchanged without a redirect.
+func (h *Handler) authenticateCheckoutRequest(w http.ResponseWriter, r *http.Request) (proceed bool) {
+	if tokenStr := r.URL.Query().Get(paymentService.PaymentTokenParam); tokenStr != "" {
+		p, ok := h.authenticatePaymentToken(w, r, tokenStr)
+		if !ok {
+			return false
+		}
+		service.SetRequestContextVar(r, PaymentAuthPaymentID, p.PaymentID().String())
+		return true
+	}
+	return h.readPaymentCookie(w, r)
+}
+
+// CheckoutStatusHandler serves the checkout status of the authenticated payment as JSON
+//
+// If the request contains the last known payment status and a wait duration in seconds,
+// the response will be delayed until the payment status changes or the wait duration
+// passed (long-polling).
+func (h *Handler) CheckoutStatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// will set the appropriate header if false
+		if !h.authenticateCheckoutRequest(w, r) {
+			return
+		}
+		log := h.log.New(log15.Ctx{"method": "CheckoutStatusHandler"})
+		paymentIDStr, ok := service.RequestContext(r).Value(PaymentAuthPaymentID).(string)
+		if !ok {
+			log.Crit("error in request context payment id", log15.Ctx{"hasType": fmt.Sprintf("%T", service.RequestContext(r).Value(PaymentAuthPaymentID))})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(paymentIDStr)
+		if err != nil {
+			log.Crit("invalid payment id", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var wait time.Duration
+		if waitStr := r.URL.Query().Get(checkoutWaitParam); waitStr != "" {
+			secs, err := strconv.Atoi(waitStr)
+			if err != nil || secs < 0 {
+				log.Info("invalid wait param", log15.Ctx{"wait": waitStr})
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			wait = time.Duration(secs) * time.Second
+			if wait > h.checkoutMaxWait {
+				wait = h.checkoutMaxWait
+			}
+		}
+
+		db := h.ctx.PaymentDB(service.ReadOnly)
+		p, err := payment.PaymentByIDDB(db, paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				log.Warn("requested payment not found")
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+		})
+		knownStatus := r.URL.Query().Get(checkoutStatusParam)
+		if wait > 0 && knownStatus == p.Status.String() {
+			p, err = h.waitStatusChange(p, wait)
+			if err != nil {
+				log.Error("error retrieving payment", log15.Ctx{"err": err})
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		status, err := h.checkoutStatus(p)
+		if err != nil {
+			log.Error("error retrieving checkout status", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(status)
+		if err != nil {
+			log.Error("error writing response", log15.Ctx{"err": err})
+		}
+	})
+}
+
+// waitStatusChange polls the payment until its status differs from the status of the
+// given payment or the wait duration passed
+//
+// It will return the most recently read payment.
+func (h *Handler) waitStatusChange(p *payment.Payment, wait time.Duration) (*payment.Payment, error) {
+	db := h.ctx.PaymentDB(service.ReadOnly)
+	timeout := time.After(wait)
+	tick := time.NewTicker(checkoutPollInterval)
+	defer tick.Stop()
+	current := p
+	for {
+		select {
+		case <-h.ctx.Done():
+			return current, nil
+		case <-timeout:
+			return current, nil
+		case <-tick.C:
+			var err error
+			current, err = payment.PaymentByIDDB(db, p.PaymentID())
+			if err != nil {
+				return nil, err
+			}
+			if current.Status != p.Status {
+				return current, nil
+			}
+		}
+	}
+}
+
+// checkoutStatus returns the checkout status of the payment
+//
+// If the next action is the payment method selection, the selectable methods will be
+// included.
+func (h *Handler) checkoutStatus(p *payment.Payment) (*CheckoutStatus, error) {
+	status := &CheckoutStatus{
+		PaymentId: h.paymentService.EncodedPaymentID(p.PaymentID()),
+		Ident:     p.Ident,
+		Amount:    p.Amount,
+		Subunits:  p.Subunits,
+		Currency:  p.Currency,
+		Status:    p.Status,
+	}
+	var err error
+	if p.Config.PaymentMethodID.Valid {
+		status.PaymentMethodId = p.Config.PaymentMethodID.Int64
+	}
+	if needsMethodSelection(p) {
+		status.Methods, err = h.selectableMethods(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	status.NextAction, err = h.nextCheckoutAction(p)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// nextCheckoutAction determines the next step of the checkout
+//
+// Open payments without a payment method need a selection. Otherwise the
+// provider driver can describe the next step. By default the end user will be
+// redirected to the payment page, which will initialize the payment with the provider.
+func (h *Handler) nextCheckoutAction(p *payment.Payment) (*paymentService.CheckoutAction, error) {
+	if action := defaultCheckoutAction(p); action != nil {
+		return action, nil
+	}
+	method, err := payment_method.PaymentMethodByIDDB(h.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, err
+	}
+	if ca, ok := h.providerService.CheckoutActioner(method); ok {
+		action, err := ca.CheckoutAction(p, method)
+		if err != nil {
+			return nil, err
+		}
+		if action != nil {
+			return action, nil
+		}
+	}
+	return &paymentService.CheckoutAction{
+		Type: paymentService.CheckoutActionRedirect,
+		URL:  PaymentPath,
+	}, nil
+}
+
+// defaultCheckoutAction returns the next step of the checkout which does not depend on
+// the provider driver
+//
+// It will return nil if the provider driver should be asked.
+func defaultCheckoutAction(p *payment.Payment) *paymentService.CheckoutAction {
+	if needsMethodSelection(p) {
+		return &paymentService.CheckoutAction{
+			Type:   paymentService.CheckoutActionForm,
+			URL:    PaymentPath,
+			Method: "GET",
+			Fields: map[string]string{
+				paymentMethodIDParam: "",
+			},
+		}
+	}
+	switch p.Status {
+	case payment.PaymentStatusNone, payment.PaymentStatusOpen:
+		return nil
+	case payment.PaymentStatusPending:
+		return &paymentService.CheckoutAction{Type: paymentService.CheckoutActionPoll}
+	default:
+		return &paymentService.CheckoutAction{Type: paymentService.CheckoutActionNone}
+	}
+}
+
+// needsMethodSelection returns true if the payment method of the payment has to be
+// selected before the checkout can continue
+func needsMethodSelection(p *payment.Payment) bool {
+	if p.Config.PaymentMethodID.Valid {
+		return false
+	}
+	return p.Status == payment.PaymentStatusNone || p.Status == payment.PaymentStatusOpen
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDefaultCheckoutAction(t *testing.T) {
+	Convey("Given an uninitialized payment", t, func() {
+		p := &payment.Payment{Status: payment.PaymentStatusNone}
+
+		Convey("When no payment method is set", func() {
+			action := defaultCheckoutAction(p)
+
+			Convey("It should ask for the payment method selection", func() {
+				So(action, ShouldNotBeNil)
+				So(action.Type, ShouldEqual, paymentService.CheckoutActionForm)
+				So(action.URL, ShouldEqual, PaymentPath)
+				_, ok := action.Fields[paymentMethodIDParam]
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("When a payment method is set", func() {
+			p.Config.SetPaymentMethodID(1)
+
+			Convey("It should leave the action to the provider driver", func() {
+				So(needsMethodSelection(p), ShouldBeFalse)
+				So(defaultCheckoutAction(p), ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given an open payment without a payment method", t, func() {
+		p := &payment.Payment{Status: payment.PaymentStatusOpen}
+
+		Convey("It should need the payment method selection", func() {
+			So(needsMethodSelection(p), ShouldBeTrue)
+			So(defaultCheckoutAction(p).Type, ShouldEqual, paymentService.CheckoutActionForm)
+		})
+	})
+
+	Convey("Given a pending payment", t, func() {
+		p := &payment.Payment{Status: payment.PaymentStatusPending}
+		p.Config.SetPaymentMethodID(1)
+
+		Convey("It should keep polling", func() {
+			So(defaultCheckoutAction(p).Type, ShouldEqual, paymentService.CheckoutActionPoll)
+		})
+	})
+
+	Convey("Given a paid payment", t, func() {
+		p := &payment.Payment{Status: payment.PaymentStatusPaid}
+		p.Config.SetPaymentMethodID(1)
+
+		Convey("It should finish the checkout", func() {
+			So(defaultCheckoutAction(p).Type, ShouldEqual, paymentService.CheckoutActionNone)
+		})
+	})
+}
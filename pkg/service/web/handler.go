@@ -12,6 +12,7 @@ import (
 	"github.com/fritzpay/paymentd/pkg/service"
 	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"github.com/fritzpay/paymentd/pkg/service/provider"
+	tmpl "github.com/fritzpay/paymentd/pkg/template"
 	"github.com/gorilla/mux"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -31,8 +32,11 @@ type Handler struct {
 	timeout time.Duration
 	router  *mux.Router
 
+	// the maximum wait of long-polling requests
+	checkoutMaxWait time.Duration
+
 	paymentService *paymentService.Service
-	templateDir    string
+	templates      *tmpl.Cache
 	keyChain       *service.Keychain
 
 	providerService *provider.Service
@@ -55,6 +59,15 @@ func NewHandler(ctx *service.Context) (*Handler, error) {
 	if err != nil {
 		return nil, err
 	}
+	// long-polling requests must finish before the write timeout
+	writeTimeout, err := cfg.Web.Service.WriteTimeout.Duration()
+	if err != nil {
+		return nil, err
+	}
+	h.checkoutMaxWait = checkoutMaxWait
+	if writeTimeout > 0 && writeTimeout-time.Second < h.checkoutMaxWait {
+		h.checkoutMaxWait = writeTimeout - time.Second
+	}
 
 	h.paymentService, err = paymentService.NewService(ctx)
 	if err != nil {
@@ -78,7 +91,7 @@ func NewHandler(ctx *service.Context) (*Handler, error) {
 	if err := h.requireDir(cfg.Web.TemplateDir); err != nil {
 		return nil, fmt.Errorf("error on template dir: %v", err)
 	}
-	h.templateDir = cfg.Web.TemplateDir
+	h.templates = tmpl.NewCache(cfg.Web.TemplateDir, defaultLocale, tmpl.Funcs(nil))
 
 	err = h.registerPayment()
 	if err != nil {
@@ -102,6 +115,15 @@ func NewHandler(ctx *service.Context) (*Handler, error) {
 	return h, nil
 }
 
+// ProviderCapabilities returns the capabilities of the provider drivers attached to the
+// web service
+//
+// Other services can use them to perform provider intents without attaching the
+// drivers again.
+func (h *Handler) ProviderCapabilities() paymentService.ProviderCapabilities {
+	return h.providerService
+}
+
 func (h *Handler) requireDir(dir string) error {
 	inf, err := os.Stat(dir)
 	if err != nil {
@@ -119,6 +141,12 @@ func (h *Handler) registerPayment() error {
 		PaymentPath,
 		h.paymentDefaultsHandler(h.ctx.RateLimitHandler(h.PaymentHandler()))).
 		Methods("GET")
+	h.router.Handle(
+		paymentService.ReturnPath,
+		h.paymentDefaultsHandler(h.ctx.RateLimitHandler(h.ReturnHandler()))).
+		Methods("GET")
+	// not rate limited, since long-polling requests are waiting most of the time
+	h.router.Handle(CheckoutStatusPath, h.CheckoutStatusHandler()).Methods("GET")
 	return nil
 }
 
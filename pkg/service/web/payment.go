@@ -7,9 +7,7 @@ import (
 	"hash"
 	"html/template"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -37,14 +35,20 @@ func (h *Handler) hashFunc() func() hash.Hash {
 func (h *Handler) authenticatePaymentRequest(w http.ResponseWriter, r *http.Request) (proceed bool) {
 	// if token present
 	if tokenStr := r.URL.Query().Get(paymentService.PaymentTokenParam); tokenStr != "" {
-		h.authenticatePaymentToken(w, r, tokenStr)
+		if _, ok := h.authenticatePaymentToken(w, r, tokenStr); ok {
+			h.redirectTokenRequest(w, r)
+		}
 		return false
 	}
 	// payment auth must be in cookie
 	return h.readPaymentCookie(w, r)
 }
 
-func (h *Handler) authenticatePaymentToken(w http.ResponseWriter, r *http.Request, tokenStr string) {
+// authenticatePaymentToken exchanges the payment token for the payment cookie
+//
+// It will return the authenticated payment. If proceed is false, the appropriate
+// header was set.
+func (h *Handler) authenticatePaymentToken(w http.ResponseWriter, r *http.Request, tokenStr string) (p *payment.Payment, proceed bool) {
 	log := h.log.New(log15.Ctx{"method": "authenticatePaymentToken"})
 
 	var tx *sql.Tx
@@ -66,29 +70,29 @@ beginTx:
 		commit = true
 		log.Crit("too many retries on tx. aborting...", log15.Ctx{"maxRetries": maxRetries})
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 	tx, err = h.ctx.PaymentDB(service.ReadOnly).Begin()
 	if err != nil {
 		commit = true
 		log.Crit("error on begin tx", log15.Ctx{"err": err})
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, false
 	}
-	p, err := h.paymentService.PaymentByToken(tx, tokenStr)
+	p, err = h.paymentService.PaymentByToken(tx, tokenStr)
 	if err != nil {
 		if err == payment.ErrPaymentNotFound {
 			w.WriteHeader(http.StatusNotFound)
-			return
+			return nil, false
 		}
 		log.Error("error retrieving payment token", log15.Ctx{"err": err})
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 	if !p.Valid() {
 		log.Crit("received invalid payment")
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 	err = h.paymentService.DeletePaymentToken(tx, tokenStr)
 	if err != nil {
@@ -99,13 +103,13 @@ beginTx:
 		}
 		log.Error("error deleting payment token", log15.Ctx{"err": err})
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 	err = h.setPaymentCookie(w, p)
 	if err != nil {
 		log.Error("error setting cookie", log15.Ctx{"err": err})
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 	err = tx.Commit()
 	if err != nil {
@@ -118,10 +122,10 @@ beginTx:
 		}
 		log.Crit("error on commit", log15.Ctx{"err": err})
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 	commit = true
-	h.redirectTokenRequest(w, r)
+	return p, true
 }
 
 func (h *Handler) redirectTokenRequest(w http.ResponseWriter, r *http.Request) {
@@ -324,6 +328,11 @@ func (h *Handler) PaymentHandler() http.Handler {
 					time.Sleep(time.Second)
 					goto beginTx
 				}
+				if err == paymentService.ErrPaymentMethodNotEligible {
+					log.Warn("payment method not eligible")
+					w.WriteHeader(http.StatusConflict)
+					return
+				}
 				log.Error("error on saving payment config", log15.Ctx{"err": err})
 				w.WriteHeader(http.StatusInternalServerError)
 				return
@@ -465,13 +474,16 @@ func (h *Handler) determinePaymentMethodID(tx *sql.Tx, p *payment.Payment, w htt
 	var paymentMethodID int64
 	if p.Config.PaymentMethodID.Valid {
 		paymentMethodID = p.Config.PaymentMethodID.Int64
-	} else if idStr := r.URL.Query().Get("paymentMethodId"); idStr != "" {
+	} else if idStr := r.URL.Query().Get(paymentMethodIDParam); idStr != "" {
 		var err error
 		paymentMethodID, err = strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return nil, fmt.Errorf("invalid payment method id: %s", idStr)
 		}
+	} else {
+		// no payment method selected yet
+		return nil, nil
 	}
 	meth, err := payment_method.PaymentMethodByIDTx(tx, paymentMethodID)
 	if err != nil {
@@ -490,6 +502,21 @@ func (h *Handler) determinePaymentMethodID(tx *sql.Tx, p *payment.Payment, w htt
 		w.WriteHeader(http.StatusConflict)
 		return nil, fmt.Errorf("invalid payment method id %d. payment method not active", paymentMethodID)
 	}
+	if !h.providerService.Available(meth) {
+		w.WriteHeader(http.StatusConflict)
+		return nil, fmt.Errorf("invalid payment method id %d. no driver for provider %s", paymentMethodID, meth.Provider.Name)
+	}
+	// the eligibility rules might have changed since the method was set
+	elig, err := payment_method.EligibilityByPaymentMethodTx(tx, meth)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, fmt.Errorf("error selecting payment method eligibility: %v", err)
+	}
+	err = elig.Check(p.Currency, p.Config.Country.String, p.Amount, p.Subunits)
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		return nil, fmt.Errorf("invalid payment method id %d. %v", paymentMethodID, err)
+	}
 	if !p.Config.PaymentMethodID.Valid {
 		p.Config.SetPaymentMethodID(meth.ID)
 		*configChanged = true
@@ -570,26 +597,28 @@ func (h *Handler) paymentDefaultsHandler(parent http.Handler) http.Handler {
 	})
 }
 
-func (h *Handler) getTemplate(t *template.Template, tmplDir, locale, baseName string) (err error) {
-	tmplFile, err := tmpl.TemplateFileName(tmplDir, locale, defaultLocale, baseName)
-	if err != nil {
-		return err
-	}
-	tmplB, err := ioutil.ReadFile(tmplFile)
-	if err != nil {
-		return err
-	}
-	tmplLocale := path.Base(path.Ext(tmplFile))
-	t.Funcs(template.FuncMap(map[string]interface{}{
-		"locale": func() string {
-			return tmplLocale
-		},
-	}))
-	_, err = t.Parse(string(tmplB))
-	if err != nil {
-		return err
-	}
-	return nil
+// TemplateBaseNames are the templates the web service requires in every locale
+var TemplateBaseNames = []string{
+	"/payment/not_found.html.tmpl",
+	"/payment/internal_error.html.tmpl",
+	"/payment/bad_request.html.tmpl",
+	"/payment/service_unavailable.html.tmpl",
+	"/payment/conflict.html.tmpl",
+	"/payment/unauthorized.html.tmpl",
+	selectPaymentMethodTemplate,
+}
+
+// LintTemplates checks the web service templates in the template dir
+func LintTemplates(tmplDir string) ([]tmpl.LintIssue, error) {
+	return tmpl.Lint(tmplDir, defaultLocale, TemplateBaseNames, tmpl.Funcs(nil))
+}
+
+// getTemplate returns the parsed template
+//
+// The project may override the template. A project ID of 0 will use the global
+// templates.
+func (h *Handler) getTemplate(projectID int64, locale, baseName string) (*template.Template, error) {
+	return h.templates.Template(projectID, locale, baseName)
 }
 
 func (h *Handler) defaultPage(base string, w http.ResponseWriter, r *http.Request) {
@@ -601,9 +630,14 @@ func (h *Handler) defaultPage(base string, w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	tmpl := template.New("page")
-
-	err := h.getTemplate(tmpl, h.templateDir, locale, base)
+	// the payment might be authenticated, so the project templates can be used
+	var projectID int64
+	if paymentIDStr, ok := service.RequestContext(r).Value(PaymentAuthPaymentID).(string); ok {
+		if paymentID, err := payment.ParsePaymentIDStr(paymentIDStr); err == nil {
+			projectID = paymentID.ProjectID
+		}
+	}
+	tmpl, err := h.getTemplate(projectID, locale, base)
 	if err != nil {
 		h.log.Error("error retrieving template", log15.Ctx{"err": err})
 		return
@@ -2,12 +2,110 @@ package web
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	tmpl "github.com/fritzpay/paymentd/pkg/template"
+	"gopkg.in/inconshreveable/log15.v2"
 )
 
+const (
+	selectPaymentMethodTemplate = "/payment/select_payment_method.html.tmpl"
+	// the query parameter which carries the selected payment method
+	paymentMethodIDParam = "paymentMethodId"
+)
+
+// selectableMethod is a payment method as shown on the payment method selection
+type selectableMethod struct {
+	ID        int64 `json:"Id,string"`
+	MethodKey string
+	Provider  string
+	Name      string
+	Logo      string `json:",omitempty"`
+}
+
+// selectableMethods returns the active payment methods of the project, which are
+// eligible for the currency, the country and the amount of the payment
+func (h *Handler) selectableMethods(p *payment.Payment) ([]selectableMethod, error) {
+	db := h.ctx.PaymentDB(service.ReadOnly)
+	methods, err := payment_method.PaymentMethodsByProjectIDAndStatusDB(db, p.ProjectID(), payment_method.PaymentMethodStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	locale := tmpl.NormalizeLocale(p.Config.Locale.String)
+	selectable := make([]selectableMethod, 0, len(methods))
+	for _, meth := range methods {
+		if !h.providerService.Available(meth) {
+			continue
+		}
+		meth.Metadata, err = payment_method.PaymentMethodMetadataDB(db, meth)
+		if err != nil {
+			return nil, err
+		}
+		elig, err := payment_method.EligibilityByPaymentMethodDB(db, meth)
+		if err != nil {
+			return nil, err
+		}
+		if elig.Check(p.Currency, p.Config.Country.String, p.Amount, p.Subunits) != nil {
+			continue
+		}
+		selectable = append(selectable, selectableMethod{
+			ID:        meth.ID,
+			MethodKey: meth.MethodKey,
+			Provider:  meth.Provider.Name,
+			Name:      meth.DisplayName(locale),
+			Logo:      meth.Metadata[payment_method.MetadataKeyLogo],
+		})
+	}
+	return selectable, nil
+}
+
+// SelectPaymentMethodHandler serves the payment method selection
+//
+// The selected payment method will be submitted to the payment handler, which will
+// set it on the payment.
 func (h *Handler) SelectPaymentMethodHandler(p *payment.Payment) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
+		log := h.log.New(log15.Ctx{
+			"method":    "SelectPaymentMethodHandler",
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+		})
+		methods, err := h.selectableMethods(p)
+		if err != nil {
+			log.Error("error retrieving payment methods", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(methods) == 0 {
+			log.Warn("no payment method available", log15.Ctx{
+				"currency": p.Currency,
+				"country":  p.Config.Country.String,
+			})
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		t, err := h.getTemplate(p.ProjectID(), p.Config.Locale.String, selectPaymentMethodTemplate)
+		if err != nil {
+			log.Error("error retrieving template", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		tmplData := map[string]interface{}{
+			"payment":   p,
+			"paymentID": h.paymentService.EncodedPaymentID(p.PaymentID()),
+			"amount":    p.DecimalRound(2),
+			"methods":   methods,
+			"actionURL": PaymentPath,
+			"paramName": paymentMethodIDParam,
+			"timestamp": time.Now().Unix(),
+		}
+		w.WriteHeader(http.StatusOK)
+		err = t.Execute(w, tmplData)
+		if err != nil {
+			log.Error("template error", log15.Ctx{"err": err})
+		}
 	})
 }
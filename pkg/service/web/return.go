@@ -0,0 +1,211 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// query parameters appended to the return URL
+const (
+	ReturnParamPaymentID = "paymentId"
+	ReturnParamIdent     = "ident"
+	ReturnParamStatus    = "status"
+	ReturnParamTimestamp = "timestamp"
+	ReturnParamNonce     = "nonce"
+	ReturnParamSignature = "signature"
+)
+
+// ReturnResult holds the signed result of a payment, which is appended to the return URL
+//
+// The signature is the hex encoded HMAC-SHA256 of the concatenated payment ID, ident,
+// status, timestamp and nonce with the secret of the callback project key.
+type ReturnResult struct {
+	PaymentId payment.PaymentID
+	Ident     string
+	Status    string
+	Timestamp int64
+	Nonce     string
+	Signature string
+}
+
+// NewReturnResult creates an unsigned result of the payment
+func NewReturnResult(encodedPaymentID payment.PaymentID, p *payment.Payment) *ReturnResult {
+	return &ReturnResult{
+		PaymentId: encodedPaymentID,
+		Ident:     p.Ident,
+		Status:    p.Status.String(),
+	}
+}
+
+func (r *ReturnResult) Sign(timestamp time.Time, nonce string, secret []byte) error {
+	r.Timestamp = timestamp.Unix()
+	r.Nonce = nonce
+	sig, err := service.Sign(r, secret)
+	if err != nil {
+		return err
+	}
+	r.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+func (r *ReturnResult) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	_, err = buf.WriteString(r.PaymentId.String())
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.WriteString(r.Ident)
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.WriteString(r.Status)
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.WriteString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *ReturnResult) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+// URL returns the return URL with the result parameters appended
+//
+// Existing query parameters of the return URL will be kept.
+func (r *ReturnResult) URL(returnURL string) (*url.URL, error) {
+	u, err := url.Parse(returnURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set(ReturnParamPaymentID, r.PaymentId.String())
+	q.Set(ReturnParamIdent, r.Ident)
+	q.Set(ReturnParamStatus, r.Status)
+	q.Set(ReturnParamTimestamp, strconv.FormatInt(r.Timestamp, 10))
+	q.Set(ReturnParamNonce, r.Nonce)
+	q.Set(ReturnParamSignature, r.Signature)
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+// ReturnHandler redirects the end user to the return URL of the authenticated payment
+//
+// The current result of the payment will be appended to the return URL, signed with
+// the callback project key. If no return URL is configured, the not found page will be
+// served.
+func (h *Handler) ReturnHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		// will set the appropriate header if false
+		if !h.authenticatePaymentRequest(w, r) {
+			return
+		}
+		log := h.log.New(log15.Ctx{"method": "ReturnHandler"})
+		paymentIDStr, ok := service.RequestContext(r).Value(PaymentAuthPaymentID).(string)
+		if !ok {
+			log.Crit("error in request context payment id", log15.Ctx{"hasType": fmt.Sprintf("%T", service.RequestContext(r).Value(PaymentAuthPaymentID))})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(paymentIDStr)
+		if err != nil {
+			log.Crit("invalid payment id", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		p, err := payment.PaymentByIDDB(h.ctx.PaymentDB(service.ReadOnly), paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				log.Warn("requested payment not found")
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+		})
+		returnURL, projectKey, err := h.paymentService.ReturnConfig(p)
+		if err != nil {
+			log.Error("error retrieving return config", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if returnURL == "" {
+			log.Warn("no return URL configured")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"returnURL":          returnURL,
+			"callbackProjectKey": projectKey,
+		})
+		if projectKey == "" {
+			log.Error("no callback project key to sign the return with")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		pk, err := project.ProjectKeyByKeyDB(h.ctx.PrincipalDB(service.ReadOnly), projectKey)
+		if err != nil {
+			log.Error("error retrieving project key", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !pk.IsValid() {
+			log.Error("cannot sign return with invalid project key")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		secret, err := pk.SecretBytes()
+		if err != nil {
+			log.Error("error retrieving secret", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		non, err := nonce.New()
+		if err != nil {
+			log.Error("error generating nonce", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res := NewReturnResult(h.paymentService.EncodedPaymentID(p.PaymentID()), p)
+		err = res.Sign(time.Now(), non.Nonce, secret)
+		if err != nil {
+			log.Error("error signing return", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		u, err := res.URL(returnURL)
+		if err != nil {
+			log.Error("invalid return URL", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	})
+}
@@ -0,0 +1,54 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReturnResult(t *testing.T) {
+	Convey("Given a payment result", t, func() {
+		p := &payment.Payment{
+			Ident:  "order-1234",
+			Status: payment.PaymentStatusPaid,
+		}
+		res := NewReturnResult(payment.PaymentID{ProjectID: 1, PaymentID: 4711}, p)
+
+		Convey("When the result is signed", func() {
+			secret := []byte("secret")
+			ts := time.Unix(1420070400, 0)
+			err := res.Sign(ts, "abcdef", secret)
+			So(err, ShouldBeNil)
+
+			Convey("It should be signed with the HMAC of the result fields", func() {
+				mac := hmac.New(sha256.New, secret)
+				mac.Write([]byte("1-4711" + "order-1234" + "paid" + "1420070400" + "abcdef"))
+				So(res.Signature, ShouldEqual, hex.EncodeToString(mac.Sum(nil)))
+			})
+
+			Convey("When creating the return URL", func() {
+				u, err := res.URL("https://shop.example.com/return?order=1234")
+				So(err, ShouldBeNil)
+
+				Convey("It should append the result parameters", func() {
+					q := u.Query()
+					So(q.Get(ReturnParamPaymentID), ShouldEqual, "1-4711")
+					So(q.Get(ReturnParamIdent), ShouldEqual, "order-1234")
+					So(q.Get(ReturnParamStatus), ShouldEqual, "paid")
+					So(q.Get(ReturnParamTimestamp), ShouldEqual, "1420070400")
+					So(q.Get(ReturnParamNonce), ShouldEqual, "abcdef")
+					So(q.Get(ReturnParamSignature), ShouldEqual, res.Signature)
+				})
+				Convey("It should keep the existing parameters", func() {
+					So(u.Query().Get("order"), ShouldEqual, "1234")
+					So(u.Host, ShouldEqual, "shop.example.com")
+				})
+			})
+		})
+	})
+}
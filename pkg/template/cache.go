@@ -0,0 +1,107 @@
+package template
+
+import (
+	"html/template"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// FuncMapFunc returns the template functions for templates of the given locale
+type FuncMapFunc func(locale string) template.FuncMap
+
+// Funcs returns the template functions of the web service and provider driver templates
+//
+// Templates can use {{locale}} to get the locale of the template. If staticPath is not
+// nil, {{staticPath}} will return the path of the static files.
+func Funcs(staticPath func() (string, error)) FuncMapFunc {
+	return func(locale string) template.FuncMap {
+		funcs := template.FuncMap{
+			"locale": func() string {
+				return locale
+			},
+		}
+		if staticPath != nil {
+			funcs["staticPath"] = staticPath
+		}
+		return funcs
+	}
+}
+
+type cacheEntry struct {
+	modTime time.Time
+	size    int64
+	tmpl    *template.Template
+}
+
+// Cache holds parsed templates of a template directory
+//
+// Templates are resolved on every lookup, so that added project overrides will be
+// picked up. A cached template will be parsed again when its file changes.
+type Cache struct {
+	tmplDir       string
+	defaultLocale string
+	funcs         FuncMapFunc
+
+	mu      sync.RWMutex
+	entries map[string]*cacheEntry
+}
+
+// NewCache creates a template cache for the given template directory
+//
+// The funcs func may be nil if the templates do not use any functions.
+func NewCache(tmplDir, defaultLocale string, funcs FuncMapFunc) *Cache {
+	return &Cache{
+		tmplDir:       tmplDir,
+		defaultLocale: defaultLocale,
+		funcs:         funcs,
+		entries:       make(map[string]*cacheEntry),
+	}
+}
+
+// Template returns the parsed template to use for the given parameters
+//
+// The template file is resolved as in ProjectTemplateFileName.
+func (c *Cache) Template(projectID int64, locale, baseName string) (*template.Template, error) {
+	tmplFile, tmplLocale, inf, err := projectTemplateFile(c.tmplDir, projectID, locale, c.defaultLocale, baseName)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	e, ok := c.entries[tmplFile]
+	c.mu.RUnlock()
+	if ok && e.modTime.Equal(inf.ModTime()) && e.size == inf.Size() {
+		return e.tmpl, nil
+	}
+	t, err := parseFile(tmplFile, baseName, c.funcMap(tmplLocale))
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[tmplFile] = &cacheEntry{
+		modTime: inf.ModTime(),
+		size:    inf.Size(),
+		tmpl:    t,
+	}
+	c.mu.Unlock()
+	return t, nil
+}
+
+func (c *Cache) funcMap(locale string) template.FuncMap {
+	if c.funcs == nil {
+		return nil
+	}
+	return c.funcs(locale)
+}
+
+func parseFile(tmplFile, name string, funcs template.FuncMap) (*template.Template, error) {
+	tmplB, err := ioutil.ReadFile(tmplFile)
+	if err != nil {
+		return nil, err
+	}
+	t := template.New(name)
+	if funcs != nil {
+		t.Funcs(funcs)
+	}
+	return t.Parse(string(tmplB))
+}
@@ -0,0 +1,76 @@
+package template
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	Convey("Given a template dir with a project override", t, func() {
+		tmplDir, err := ioutil.TempDir("", "paymentd-template")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(tmplDir)
+		})
+		writeTemplate := func(name, content string) {
+			err := os.MkdirAll(path.Dir(path.Join(tmplDir, name)), 0755)
+			So(err, ShouldBeNil)
+			err = ioutil.WriteFile(path.Join(tmplDir, name), []byte(content), 0644)
+			So(err, ShouldBeNil)
+		}
+		writeTemplate("en_US/page.html.tmpl", "global {{locale}}")
+		writeTemplate("projects/2/en_US/page.html.tmpl", "project")
+
+		c := NewCache(tmplDir, "en_US", func(locale string) template.FuncMap {
+			return template.FuncMap{
+				"locale": func() string {
+					return locale
+				},
+			}
+		})
+		execute := func(projectID int64) string {
+			t, err := c.Template(projectID, "de_DE", "page.html.tmpl")
+			So(err, ShouldBeNil)
+			buf := bytes.NewBuffer(nil)
+			So(t.Execute(buf, nil), ShouldBeNil)
+			return buf.String()
+		}
+
+		Convey("When a project does not override the template", func() {
+			Convey("It should use the global template in the default locale", func() {
+				So(execute(1), ShouldEqual, "global en_US")
+			})
+		})
+		Convey("When a project overrides the template", func() {
+			Convey("It should use the project template", func() {
+				So(execute(2), ShouldEqual, "project")
+			})
+		})
+		Convey("When the template is requested again", func() {
+			t1, err := c.Template(1, "en_US", "page.html.tmpl")
+			So(err, ShouldBeNil)
+			t2, err := c.Template(1, "en_US", "page.html.tmpl")
+			So(err, ShouldBeNil)
+
+			Convey("It should be served from the cache", func() {
+				So(t2, ShouldEqual, t1)
+			})
+		})
+		Convey("When the template file changes", func() {
+			So(execute(1), ShouldEqual, "global en_US")
+			time.Sleep(10 * time.Millisecond)
+			writeTemplate("en_US/page.html.tmpl", "changed")
+
+			Convey("It should be parsed again", func() {
+				So(execute(1), ShouldEqual, "changed")
+			})
+		})
+	})
+}
@@ -0,0 +1,143 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrMissingTemplate = errors.New("missing template")
+)
+
+// template files must have this extension
+const templateExt = ".tmpl"
+
+// locale directories are named like the normalized locale, i.e. en_US
+var localeDirName = regexp.MustCompile(`^[a-z]{2}_[A-Z]{2}$`)
+
+// LintIssue is a problem with a template file found by Lint
+type LintIssue struct {
+	File string
+	Err  error
+}
+
+func (l LintIssue) String() string {
+	return fmt.Sprintf("%s: %v", l.File, l.Err)
+}
+
+// Lint checks the templates in the template directory
+//
+// Every template file in the locale directories and the project overrides must parse
+// with the given template functions. Every locale directory of the global templates
+// must contain all the given base names. The default locale directory is required.
+//
+// Project overrides may contain only some of the templates.
+func Lint(tmplDir, defaultLocale string, baseNames []string, funcs FuncMapFunc) ([]LintIssue, error) {
+	inf, err := os.Stat(tmplDir)
+	if err != nil {
+		return nil, err
+	}
+	if !inf.IsDir() {
+		return nil, fmt.Errorf("template dir %s is not a directory", tmplDir)
+	}
+	locales, err := localeDirs(tmplDir)
+	if err != nil {
+		return nil, err
+	}
+	var hasDefault bool
+	for _, l := range locales {
+		if l == defaultLocale {
+			hasDefault = true
+		}
+	}
+	if !hasDefault {
+		locales = append(locales, defaultLocale)
+	}
+	var issues []LintIssue
+	for _, l := range locales {
+		for _, baseName := range baseNames {
+			tmplFile := path.Join(tmplDir, l, baseName)
+			_, err := os.Stat(tmplFile)
+			if err != nil {
+				if os.IsNotExist(err) {
+					err = ErrMissingTemplate
+				}
+				issues = append(issues, LintIssue{File: tmplFile, Err: err})
+			}
+		}
+	}
+	localeIssues, err := lintLocales(tmplDir, funcs)
+	if err != nil {
+		return nil, err
+	}
+	issues = append(issues, localeIssues...)
+
+	projectsDir := path.Join(tmplDir, ProjectsDir)
+	projects, err := ioutil.ReadDir(projectsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, p := range projects {
+		if !p.IsDir() {
+			continue
+		}
+		projectIssues, err := lintLocales(path.Join(projectsDir, p.Name()), funcs)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, projectIssues...)
+	}
+	return issues, nil
+}
+
+func localeDirs(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	locales := make([]string, 0, len(infos))
+	for _, inf := range infos {
+		if inf.IsDir() && localeDirName.MatchString(inf.Name()) {
+			locales = append(locales, inf.Name())
+		}
+	}
+	return locales, nil
+}
+
+// lintLocales parses all template files in the locale directories of dir
+func lintLocales(dir string, funcs FuncMapFunc) ([]LintIssue, error) {
+	locales, err := localeDirs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var issues []LintIssue
+	for _, l := range locales {
+		var funcMap map[string]interface{}
+		if funcs != nil {
+			funcMap = funcs(l)
+		}
+		err = filepath.Walk(path.Join(dir, l), func(tmplFile string, inf os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if inf.IsDir() || !strings.HasSuffix(tmplFile, templateExt) {
+				return nil
+			}
+			_, err = parseFile(tmplFile, inf.Name(), funcMap)
+			if err != nil {
+				issues = append(issues, LintIssue{File: tmplFile, Err: err})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return issues, nil
+}
@@ -4,9 +4,16 @@ import (
 	"errors"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
+// ProjectsDir is the directory in a template directory, which holds the template
+// overrides of projects
+//
+//	tmplDir/projects/projectID/locale/baseName
+const ProjectsDir = "projects"
+
 var (
 	ErrTemplateNotFound    = errors.New("template not found")
 	ErrInvalidTemplateFile = errors.New("invalid template file")
@@ -34,28 +41,63 @@ func NormalizeLocale(l string) string {
 //
 // It looks in the tmplDir directory like so:
 //
-//   tmplDir/locale/baseName
+//	tmplDir/locale/baseName
 //
 // If the file does not exist, it will try
 //
-//   tmplDir/defaultLocale/baseName
+//	tmplDir/defaultLocale/baseName
 //
 // If the default does not exist, it will fail.
 func TemplateFileName(tmplDir, locale, defaultLocale, baseName string) (string, error) {
-	tmplFile := path.Join(tmplDir, NormalizeLocale(locale), baseName)
+	tmplFile, _, _, err := templateFile(tmplDir, locale, defaultLocale, baseName)
+	return tmplFile, err
+}
+
+// ProjectTemplateFileName returns the template file name to use for the given project
+//
+// It looks in the project directory first:
+//
+//	tmplDir/projects/projectID/locale/baseName
+//	tmplDir/projects/projectID/defaultLocale/baseName
+//
+// If the project does not override the template, it will use TemplateFileName.
+// A project ID of 0 will always use the global templates.
+func ProjectTemplateFileName(tmplDir string, projectID int64, locale, defaultLocale, baseName string) (string, error) {
+	tmplFile, _, _, err := projectTemplateFile(tmplDir, projectID, locale, defaultLocale, baseName)
+	return tmplFile, err
+}
+
+// projectTemplateFile resolves the template file like ProjectTemplateFileName
+//
+// It additionally returns the locale of the resolved file and its file info.
+func projectTemplateFile(tmplDir string, projectID int64, locale, defaultLocale, baseName string) (string, string, os.FileInfo, error) {
+	if projectID != 0 {
+		projectDir := path.Join(tmplDir, ProjectsDir, strconv.FormatInt(projectID, 10))
+		tmplFile, tmplLocale, inf, err := templateFile(projectDir, locale, defaultLocale, baseName)
+		if err != ErrTemplateNotFound {
+			return tmplFile, tmplLocale, inf, err
+		}
+	}
+	return templateFile(tmplDir, locale, defaultLocale, baseName)
+}
+
+func templateFile(tmplDir, locale, defaultLocale, baseName string) (string, string, os.FileInfo, error) {
+	tmplLocale := NormalizeLocale(locale)
+	tmplFile := path.Join(tmplDir, tmplLocale, baseName)
 	inf, err := os.Stat(tmplFile)
 	if err != nil && os.IsNotExist(err) {
+		tmplLocale = defaultLocale
 		tmplFile = path.Join(tmplDir, defaultLocale, baseName)
 		inf, err = os.Stat(tmplFile)
 	}
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "", ErrTemplateNotFound
+			return "", "", nil, ErrTemplateNotFound
 		}
-		return "", err
+		return "", "", nil, err
 	}
 	if inf.IsDir() {
-		return "", ErrInvalidTemplateFile
+		return "", "", nil, ErrInvalidTemplateFile
 	}
-	return tmplFile, nil
+	return tmplFile, tmplLocale, inf, nil
 }
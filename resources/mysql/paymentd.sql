@@ -156,6 +156,69 @@ CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`payment_method_metadata` (
 ENGINE = InnoDB;
 
 
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`payment_method_eligibility`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`payment_method_eligibility` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`payment_method_eligibility` (
+  `payment_method_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`payment_method_id`, `timestamp`),
+  CONSTRAINT `fk_payment_method_eligibility_payment_method_id`
+    FOREIGN KEY (`payment_method_id`)
+    REFERENCES `fritzpay_payment`.`payment_method` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`payment_method_eligibility_currency`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`payment_method_eligibility_currency` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`payment_method_eligibility_currency` (
+  `payment_method_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `currency` VARCHAR(3) NOT NULL,
+  `subunits` TINYINT(4) UNSIGNED NOT NULL,
+  `min_amount` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  `max_amount` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  PRIMARY KEY (`payment_method_id`, `timestamp`, `currency`),
+  INDEX `fk_payment_method_eligibility_currency_currency_idx` (`currency` ASC),
+  CONSTRAINT `fk_payment_method_eligibility_currency_eligibility`
+    FOREIGN KEY (`payment_method_id` , `timestamp`)
+    REFERENCES `fritzpay_payment`.`payment_method_eligibility` (`payment_method_id` , `timestamp`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE,
+  CONSTRAINT `fk_payment_method_eligibility_currency_currency`
+    FOREIGN KEY (`currency`)
+    REFERENCES `fritzpay_payment`.`currency` (`code_iso_4217`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`payment_method_eligibility_country`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`payment_method_eligibility_country` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`payment_method_eligibility_country` (
+  `payment_method_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `country` VARCHAR(2) NOT NULL,
+  PRIMARY KEY (`payment_method_id`, `timestamp`, `country`),
+  CONSTRAINT `fk_payment_method_eligibility_country_eligibility`
+    FOREIGN KEY (`payment_method_id` , `timestamp`)
+    REFERENCES `fritzpay_payment`.`payment_method_eligibility` (`payment_method_id` , `timestamp`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
 -- -----------------------------------------------------
 -- Table `fritzpay_payment`.`payment`
 -- -----------------------------------------------------
@@ -310,6 +373,80 @@ CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`payment_transaction` (
 ENGINE = InnoDB;
 
 
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`payment_notification`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`payment_notification` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`payment_notification` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `payment_tx_timestamp` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `attempts` INT UNSIGNED NOT NULL,
+  `next_attempt` BIGINT UNSIGNED NOT NULL,
+  `error` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `payment_tx_timestamp`, `timestamp`),
+  INDEX `status_next_attempt` (`status` ASC, `next_attempt` ASC),
+  CONSTRAINT `fk_payment_notification_payment_transaction`
+    FOREIGN KEY (`project_id` , `payment_id` , `payment_tx_timestamp`)
+    REFERENCES `fritzpay_payment`.`payment_transaction` (`project_id` , `payment_id` , `timestamp`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`payment_notification_attempt`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`payment_notification_attempt` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`payment_notification_attempt` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `payment_tx_timestamp` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `url` TEXT NOT NULL,
+  `api_version` VARCHAR(32) NOT NULL,
+  `http_status_code` INT NULL,
+  `latency` BIGINT UNSIGNED NOT NULL,
+  `response_body` TEXT NULL,
+  `error` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `payment_tx_timestamp`, `timestamp`),
+  CONSTRAINT `fk_payment_notification_attempt_payment_transaction`
+    FOREIGN KEY (`project_id` , `payment_id` , `payment_tx_timestamp`)
+    REFERENCES `fritzpay_payment`.`payment_transaction` (`project_id` , `payment_id` , `timestamp`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`payment_provider_intent`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`payment_provider_intent` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`payment_provider_intent` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `intent` VARCHAR(32) NOT NULL,
+  `amount` INT NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `error` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `created`, `timestamp`),
+  INDEX `fk_payment_provider_intent_payment_id_idx` (`payment_id` ASC),
+  INDEX `payment_provider_intent_status_created_idx` (`status` ASC, `created` ASC),
+  CONSTRAINT `fk_payment_provider_intent_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `fritzpay_payment`.`payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
 -- -----------------------------------------------------
 -- Table `fritzpay_payment`.`provider_fritzpay_payment`
 -- -----------------------------------------------------
@@ -374,6 +511,7 @@ CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`provider_paypal_config` (
   `client_id` TEXT NOT NULL,
   `secret` TEXT NOT NULL,
   `type` VARCHAR(32) NOT NULL,
+  `webhook_id` VARCHAR(64) NULL,
   PRIMARY KEY (`project_id`, `method_key`, `created`),
   CONSTRAINT `fk_provider_paypal_config_project_id`
     FOREIGN KEY (`project_id`)
@@ -402,7 +540,9 @@ CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`provider_paypal_transaction` (
   `paypal_update_time` DATETIME NULL,
   `links` TEXT NULL,
   `data` TEXT NULL,
+  `event_id` VARCHAR(64) NULL,
   PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  UNIQUE INDEX `event_id` (`project_id` ASC, `payment_id` ASC, `event_id` ASC),
   INDEX `paypal_id` (`paypal_id` ASC),
   INDEX `paypal_state` (`paypal_state` ASC),
   INDEX `fk_provider_paypal_transaction_payment_id_idx` (`payment_id` ASC),
@@ -454,6 +594,141 @@ CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`provider_paypal_authorization` (
     ON UPDATE CASCADE)
 ENGINE = InnoDB;
 
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`provider_stripe_config`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`provider_stripe_config` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`provider_stripe_config` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `method_key` VARCHAR(64) NOT NULL,
+  `created` DATETIME NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `endpoint` TEXT NOT NULL,
+  `secure_key` TEXT NOT NULL,
+  `public_key` TEXT NOT NULL,
+  `webhook_secret` TEXT NOT NULL,
+  PRIMARY KEY (`project_id`, `method_key`, `created`),
+  CONSTRAINT `fk_provider_stripe_config_project_id`
+    FOREIGN KEY (`project_id`)
+    REFERENCES `fritzpay_principal`.`project` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`provider_stripe_transaction`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`provider_stripe_transaction` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`provider_stripe_transaction` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `type` VARCHAR(32) NOT NULL,
+  `stripe_charge_id` VARCHAR(64) NULL,
+  `failure_code` VARCHAR(64) NULL,
+  `data` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `stripe_charge_id` (`stripe_charge_id` ASC),
+  INDEX `fk_provider_stripe_transaction_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_provider_stripe_transaction_project_id`
+    FOREIGN KEY (`project_id`)
+    REFERENCES `fritzpay_principal`.`project` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE,
+  CONSTRAINT `fk_provider_stripe_transaction_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `fritzpay_payment`.`payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`provider_sepa_config`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`provider_sepa_config` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`provider_sepa_config` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `method_key` VARCHAR(64) NOT NULL,
+  `created` DATETIME NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `creditor_name` VARCHAR(70) NOT NULL,
+  `creditor_id` VARCHAR(35) NOT NULL,
+  `iban` VARCHAR(34) NOT NULL,
+  `bic` VARCHAR(11) NOT NULL,
+  PRIMARY KEY (`project_id`, `method_key`, `created`),
+  CONSTRAINT `fk_provider_sepa_config_project_id`
+    FOREIGN KEY (`project_id`)
+    REFERENCES `fritzpay_principal`.`project` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`provider_sepa_mandate`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`provider_sepa_mandate` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`provider_sepa_mandate` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `method_key` VARCHAR(64) NOT NULL,
+  `reference` VARCHAR(35) NOT NULL,
+  `created` DATETIME NOT NULL,
+  `account_holder` VARCHAR(70) NOT NULL,
+  `iban` TEXT NOT NULL,
+  `bic` VARCHAR(11) NULL,
+  PRIMARY KEY (`project_id`, `payment_id`),
+  UNIQUE INDEX `reference` (`reference` ASC),
+  INDEX `fk_provider_sepa_mandate_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_provider_sepa_mandate_project_id`
+    FOREIGN KEY (`project_id`)
+    REFERENCES `fritzpay_principal`.`project` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE,
+  CONSTRAINT `fk_provider_sepa_mandate_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `fritzpay_payment`.`payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `fritzpay_payment`.`provider_sepa_transaction`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_payment`.`provider_sepa_transaction` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_payment`.`provider_sepa_transaction` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `type` VARCHAR(32) NOT NULL,
+  `msg_id` VARCHAR(35) NULL,
+  `status_code` VARCHAR(8) NULL,
+  `reason_code` VARCHAR(8) NULL,
+  `data` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `msg_id` (`msg_id` ASC, `type` ASC),
+  INDEX `fk_provider_sepa_transaction_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_provider_sepa_transaction_project_id`
+    FOREIGN KEY (`project_id`)
+    REFERENCES `fritzpay_principal`.`project` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE,
+  CONSTRAINT `fk_provider_sepa_transaction_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `fritzpay_payment`.`payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
 USE `fritzpay_principal` ;
 
 -- -----------------------------------------------------
@@ -531,6 +806,7 @@ CREATE TABLE IF NOT EXISTS `fritzpay_principal`.`project_config` (
   `callback_api_version` VARCHAR(32) NULL,
   `callback_project_key` VARCHAR(64) NULL,
   `return_url` TEXT NULL,
+  `payment_expiry` INT UNSIGNED NULL,
   PRIMARY KEY (`project_id`, `timestamp`),
   INDEX `fk_project_config_project_key_idx` (`callback_project_key` ASC),
   CONSTRAINT `fk_project_config_callback_project_key`
@@ -545,6 +821,20 @@ CREATE TABLE IF NOT EXISTS `fritzpay_principal`.`project_config` (
     ON UPDATE CASCADE)
 ENGINE = InnoDB;
 
+
+-- -----------------------------------------------------
+-- Table `fritzpay_principal`.`project_key_nonce`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fritzpay_principal`.`project_key_nonce` ;
+
+CREATE TABLE IF NOT EXISTS `fritzpay_principal`.`project_key_nonce` (
+  `project_key` VARCHAR(64) NOT NULL,
+  `nonce` VARCHAR(64) NOT NULL,
+  `expires` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`project_key`, `nonce`),
+  INDEX `project_key_nonce_expires_idx` (`expires` ASC))
+ENGINE = InnoDB;
+
 SET SQL_MODE = '';
 GRANT USAGE ON *.* TO paymentd;
  DROP USER paymentd;
@@ -554,6 +844,7 @@ CREATE USER 'paymentd';
 GRANT SELECT, INSERT ON TABLE fritzpay_payment.* TO 'paymentd';
 GRANT SELECT, INSERT ON TABLE fritzpay_principal.* TO 'paymentd';
 GRANT DELETE, SELECT, INSERT ON TABLE `fritzpay_payment`.`payment_token` TO 'paymentd';
+GRANT DELETE, SELECT, INSERT ON TABLE `fritzpay_principal`.`project_key_nonce` TO 'paymentd';
 
 SET SQL_MODE=@OLD_SQL_MODE;
 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
@@ -566,6 +857,7 @@ START TRANSACTION;
 USE `fritzpay_payment`;
 INSERT INTO `fritzpay_payment`.`provider` (`name`) VALUES ('fritzpay');
 INSERT INTO `fritzpay_payment`.`provider` (`name`) VALUES ('paypal_rest');
+INSERT INTO `fritzpay_payment`.`provider` (`name`) VALUES ('sepa');
 
 COMMIT;
 
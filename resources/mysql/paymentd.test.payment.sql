@@ -110,6 +110,69 @@ CREATE TABLE IF NOT EXISTS `payment_method_metadata` (
 ENGINE = InnoDB;
 
 
+-- -----------------------------------------------------
+-- Table `payment_method_eligibility`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_method_eligibility` ;
+
+CREATE TABLE IF NOT EXISTS `payment_method_eligibility` (
+  `payment_method_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`payment_method_id`, `timestamp`),
+  CONSTRAINT `fk_payment_method_eligibility_payment_method_id`
+    FOREIGN KEY (`payment_method_id`)
+    REFERENCES `payment_method` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_method_eligibility_currency`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_method_eligibility_currency` ;
+
+CREATE TABLE IF NOT EXISTS `payment_method_eligibility_currency` (
+  `payment_method_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `currency` VARCHAR(3) NOT NULL,
+  `subunits` TINYINT(4) UNSIGNED NOT NULL,
+  `min_amount` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  `max_amount` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  PRIMARY KEY (`payment_method_id`, `timestamp`, `currency`),
+  INDEX `fk_payment_method_eligibility_currency_currency_idx` (`currency` ASC),
+  CONSTRAINT `fk_payment_method_eligibility_currency_eligibility`
+    FOREIGN KEY (`payment_method_id` , `timestamp`)
+    REFERENCES `payment_method_eligibility` (`payment_method_id` , `timestamp`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE,
+  CONSTRAINT `fk_payment_method_eligibility_currency_currency`
+    FOREIGN KEY (`currency`)
+    REFERENCES `currency` (`code_iso_4217`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_method_eligibility_country`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_method_eligibility_country` ;
+
+CREATE TABLE IF NOT EXISTS `payment_method_eligibility_country` (
+  `payment_method_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `country` VARCHAR(2) NOT NULL,
+  PRIMARY KEY (`payment_method_id`, `timestamp`, `country`),
+  CONSTRAINT `fk_payment_method_eligibility_country_eligibility`
+    FOREIGN KEY (`payment_method_id` , `timestamp`)
+    REFERENCES `payment_method_eligibility` (`payment_method_id` , `timestamp`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
 -- -----------------------------------------------------
 -- Table `payment`
 -- -----------------------------------------------------
@@ -244,6 +307,80 @@ CREATE TABLE IF NOT EXISTS `payment_transaction` (
 ENGINE = InnoDB;
 
 
+-- -----------------------------------------------------
+-- Table `payment_notification`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_notification` ;
+
+CREATE TABLE IF NOT EXISTS `payment_notification` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `payment_tx_timestamp` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `attempts` INT UNSIGNED NOT NULL,
+  `next_attempt` BIGINT UNSIGNED NOT NULL,
+  `error` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `payment_tx_timestamp`, `timestamp`),
+  INDEX `status_next_attempt` (`status` ASC, `next_attempt` ASC),
+  CONSTRAINT `fk_payment_notification_payment_transaction`
+    FOREIGN KEY (`project_id` , `payment_id` , `payment_tx_timestamp`)
+    REFERENCES `payment_transaction` (`project_id` , `payment_id` , `timestamp`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_notification_attempt`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_notification_attempt` ;
+
+CREATE TABLE IF NOT EXISTS `payment_notification_attempt` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `payment_tx_timestamp` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `url` TEXT NOT NULL,
+  `api_version` VARCHAR(32) NOT NULL,
+  `http_status_code` INT NULL,
+  `latency` BIGINT UNSIGNED NOT NULL,
+  `response_body` TEXT NULL,
+  `error` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `payment_tx_timestamp`, `timestamp`),
+  CONSTRAINT `fk_payment_notification_attempt_payment_transaction`
+    FOREIGN KEY (`project_id` , `payment_id` , `payment_tx_timestamp`)
+    REFERENCES `payment_transaction` (`project_id` , `payment_id` , `timestamp`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_provider_intent`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_provider_intent` ;
+
+CREATE TABLE IF NOT EXISTS `payment_provider_intent` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `intent` VARCHAR(32) NOT NULL,
+  `amount` INT NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `error` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `created`, `timestamp`),
+  INDEX `fk_payment_provider_intent_payment_id_idx` (`payment_id` ASC),
+  INDEX `payment_provider_intent_status_created_idx` (`status` ASC, `created` ASC),
+  CONSTRAINT `fk_payment_provider_intent_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
 -- -----------------------------------------------------
 -- Table `provider_fritzpay_payment`
 -- -----------------------------------------------------
@@ -308,7 +445,9 @@ CREATE TABLE IF NOT EXISTS `provider_paypal_transaction` (
   `paypal_update_time` DATETIME NULL,
   `links` TEXT NULL,
   `data` TEXT NULL,
+  `event_id` VARCHAR(64) NULL,
   PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  UNIQUE INDEX `event_id` (`project_id` ASC, `payment_id` ASC, `event_id` ASC),
   INDEX `paypal_id` (`paypal_id` ASC),
   INDEX `paypal_state` (`paypal_state` ASC),
   INDEX `fk_provider_paypal_transaction_payment_id_idx` (`payment_id` ASC),
@@ -350,6 +489,97 @@ CREATE TABLE IF NOT EXISTS `provider_paypal_authorization` (
     ON UPDATE CASCADE)
 ENGINE = InnoDB;
 
+
+-- -----------------------------------------------------
+-- Table `provider_stripe_transaction`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `provider_stripe_transaction` ;
+
+CREATE TABLE IF NOT EXISTS `provider_stripe_transaction` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `type` VARCHAR(32) NOT NULL,
+  `stripe_charge_id` VARCHAR(64) NULL,
+  `failure_code` VARCHAR(64) NULL,
+  `data` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `stripe_charge_id` (`stripe_charge_id` ASC),
+  INDEX `fk_provider_stripe_transaction_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_provider_stripe_transaction_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+-- -----------------------------------------------------
+-- Table `provider_sepa_config`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `provider_sepa_config` ;
+
+CREATE TABLE IF NOT EXISTS `provider_sepa_config` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `method_key` VARCHAR(64) NOT NULL,
+  `created` DATETIME NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `creditor_name` VARCHAR(70) NOT NULL,
+  `creditor_id` VARCHAR(35) NOT NULL,
+  `iban` VARCHAR(34) NOT NULL,
+  `bic` VARCHAR(11) NOT NULL,
+  PRIMARY KEY (`project_id`, `method_key`, `created`))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `provider_sepa_mandate`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `provider_sepa_mandate` ;
+
+CREATE TABLE IF NOT EXISTS `provider_sepa_mandate` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `method_key` VARCHAR(64) NOT NULL,
+  `reference` VARCHAR(35) NOT NULL,
+  `created` DATETIME NOT NULL,
+  `account_holder` VARCHAR(70) NOT NULL,
+  `iban` TEXT NOT NULL,
+  `bic` VARCHAR(11) NULL,
+  PRIMARY KEY (`project_id`, `payment_id`),
+  UNIQUE INDEX `reference` (`reference` ASC),
+  INDEX `fk_provider_sepa_mandate_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_provider_sepa_mandate_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `provider_sepa_transaction`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `provider_sepa_transaction` ;
+
+CREATE TABLE IF NOT EXISTS `provider_sepa_transaction` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `type` VARCHAR(32) NOT NULL,
+  `msg_id` VARCHAR(35) NULL,
+  `status_code` VARCHAR(8) NULL,
+  `reason_code` VARCHAR(8) NULL,
+  `data` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `msg_id` (`msg_id` ASC, `type` ASC),
+  INDEX `fk_provider_sepa_transaction_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_provider_sepa_transaction_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
 SET SQL_MODE=@OLD_SQL_MODE;
 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
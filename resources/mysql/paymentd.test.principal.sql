@@ -123,6 +123,7 @@ CREATE TABLE IF NOT EXISTS `project_config` (
   `callback_api_version` VARCHAR(32) NULL,
   `callback_project_key` VARCHAR(64) NULL,
   `return_url` TEXT NULL,
+  `payment_expiry` INT UNSIGNED NULL,
   PRIMARY KEY (`project_id`, `timestamp`),
   INDEX `fk_project_config_project_key_idx` (`callback_project_key` ASC),
   CONSTRAINT `fk_project_config_callback_project_key`
@@ -138,6 +139,20 @@ CREATE TABLE IF NOT EXISTS `project_config` (
 ENGINE = InnoDB;
 
 
+-- -----------------------------------------------------
+-- Table `project_key_nonce`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `project_key_nonce` ;
+
+CREATE TABLE IF NOT EXISTS `project_key_nonce` (
+  `project_key` VARCHAR(64) NOT NULL,
+  `nonce` VARCHAR(64) NOT NULL,
+  `expires` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`project_key`, `nonce`),
+  INDEX `project_key_nonce_expires_idx` (`expires` ASC))
+ENGINE = InnoDB;
+
+
 SET SQL_MODE=@OLD_SQL_MODE;
 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;